@@ -0,0 +1,112 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package glutil contains small OpenGL helpers shared by the renderer
+// packages.
+package glutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// NewProgram compiles and links a program from vertex and fragment shader
+// sources. The sources must be NUL-terminated.
+func NewProgram(vertexShaderSource, fragmentShaderSource string) (uint32, error) {
+	vertexShader, err := CompileShader(vertexShaderSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+
+	fragmentShader, err := CompileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return 0, err
+	}
+
+	program := gl.CreateProgram()
+
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
+	if err := linkProgram(program); err != nil {
+		return 0, err
+	}
+
+	gl.DeleteShader(vertexShader)
+	gl.DeleteShader(fragmentShader)
+
+	return program, nil
+}
+
+// NewFeedbackProgram compiles and links a program of a single vertex
+// shader, meant to be drawn with rasterization off, whose outputs named
+// varyings are captured interleaved, in that order, by transform feedback.
+// The source must be NUL-terminated.
+func NewFeedbackProgram(vertexShaderSource string, varyings ...string) (uint32, error) {
+	vertexShader, err := CompileShader(vertexShaderSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+
+	program := gl.CreateProgram()
+
+	gl.AttachShader(program, vertexShader)
+	names := make([]string, len(varyings))
+	for i, v := range varyings {
+		names[i] = v + "\x00"
+	}
+	cnames, free := gl.Strs(names...)
+	gl.TransformFeedbackVaryings(program, int32(len(names)), cnames, gl.INTERLEAVED_ATTRIBS)
+	free()
+	if err := linkProgram(program); err != nil {
+		return 0, err
+	}
+
+	gl.DeleteShader(vertexShader)
+
+	return program, nil
+}
+
+// linkProgram links program, returning its log if it fails.
+func linkProgram(program uint32) error {
+	gl.LinkProgram(program)
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+
+		return fmt.Errorf("failed to link program: %v", log)
+	}
+	return nil
+}
+
+// CompileShader compiles a single NUL-terminated shader source.
+func CompileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+
+	csources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, csources, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+
+		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+	}
+
+	return shader, nil
+}
@@ -3,7 +3,10 @@ module github.com/outblasted/gogllattice
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/go-gl/gl v0.0.0-20211210172815-726fda9656d6 // indirect
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20211213063430-748e38ca8aec // indirect
 	github.com/go-gl/mathgl v1.0.0 // indirect
+	golang.org/x/image v0.0.0-20190321063152-3fc05d484e9f
+	golang.org/x/sys v0.10.0 // indirect
 )
@@ -0,0 +1,21 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hud
+
+import "github.com/go-gl/mathgl/mgl32"
+
+var (
+	panelColor = mgl32.Vec4{1, 1, 1, 1}
+	panelBack  = mgl32.Vec4{0, 0, 0, 0.6}
+)
+
+// AddPanel queues text on a translucent background, with the top left
+// corner of the background at (x, y).
+func AddPanel(t *Text, x, y, scale float32, text string) {
+	w, h := Measure(text, scale)
+	pad := 3 * scale
+	t.AddRect(x, y, w+2*pad, h+2*pad, panelBack)
+	t.AddText(x+pad, y+pad, scale, panelColor, text)
+}
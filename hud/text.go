@@ -0,0 +1,218 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hud draws text and simple shapes on top of the rendered scene.
+package hud
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+	"golang.org/x/image/font/inconsolata"
+)
+
+// face covers Latin-1, so translated messages render without fallbacks.
+var face = inconsolata.Regular8x16
+
+const (
+	// GlyphWidth and LineHeight are the unscaled metrics of the font, in
+	// pixels.
+	GlyphWidth = 8
+	LineHeight = 17
+
+	floatsPerVertex = 8
+)
+
+// Text batches glyphs and rectangles and draws them in a single call. The
+// font atlas is the fixed 8x16 font, with an extra solid cell used to fill
+// rectangles.
+type Text struct {
+	program       uint32
+	vao           uint32
+	vbo           uint32
+	atlas         uint32
+	screenUniform int32
+
+	glyphs int // glyph cells in the atlas, excluding the solid one
+	verts  []float32
+}
+
+func NewText() (*Text, error) {
+	program, err := glutil.NewProgram(textVertexShader, textFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	t := &Text{
+		program:       program,
+		screenUniform: gl.GetUniformLocation(program, gl.Str("screen\x00")),
+	}
+
+	mask := face.Mask.Bounds()
+	cellHeight := face.Ascent + face.Descent
+	t.glyphs = mask.Dy() / cellHeight
+	img := image.NewAlpha(image.Rect(0, 0, mask.Dx(), mask.Dy()+cellHeight))
+	draw.Draw(img, mask, face.Mask, mask.Min, draw.Src)
+	draw.Draw(img, image.Rect(0, mask.Dy(), mask.Dx(), img.Rect.Dy()), image.Opaque, image.Point{}, draw.Src)
+
+	gl.GenTextures(1, &t.atlas)
+	gl.BindTexture(gl.TEXTURE_2D, t.atlas)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R8, int32(img.Rect.Dx()), int32(img.Rect.Dy()), 0, gl.RED, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	gl.GenVertexArrays(1, &t.vao)
+	gl.BindVertexArray(t.vao)
+	gl.GenBuffers(1, &t.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, t.vbo)
+
+	posAttrib := uint32(gl.GetAttribLocation(program, gl.Str("pos\x00")))
+	gl.EnableVertexAttribArray(posAttrib)
+	gl.VertexAttribPointerWithOffset(posAttrib, 2, gl.FLOAT, false, floatsPerVertex*4, 0)
+
+	uvAttrib := uint32(gl.GetAttribLocation(program, gl.Str("uv\x00")))
+	gl.EnableVertexAttribArray(uvAttrib)
+	gl.VertexAttribPointerWithOffset(uvAttrib, 2, gl.FLOAT, false, floatsPerVertex*4, 2*4)
+
+	colorAttrib := uint32(gl.GetAttribLocation(program, gl.Str("color\x00")))
+	gl.EnableVertexAttribArray(colorAttrib)
+	gl.VertexAttribPointerWithOffset(colorAttrib, 4, gl.FLOAT, false, floatsPerVertex*4, 4*4)
+	gl.BindVertexArray(0)
+
+	return t, nil
+}
+
+// Measure returns the size in pixels of text drawn at the given scale.
+// Lines are separated by '\n'.
+func Measure(text string, scale float32) (w, h float32) {
+	cols, maxCols, lines := 0, 0, 1
+	for _, r := range text {
+		if r == '\n' {
+			lines++
+			cols = 0
+			continue
+		}
+		cols++
+		if cols > maxCols {
+			maxCols = cols
+		}
+	}
+	return float32(maxCols*GlyphWidth) * scale, float32(lines*LineHeight) * scale
+}
+
+// AddText queues text with its top left corner at (x, y), in pixels from
+// the top left corner of the screen.
+func (t *Text) AddText(x, y, scale float32, color mgl32.Vec4, text string) {
+	cx := x
+	for _, r := range text {
+		if r == '\n' {
+			cx = x
+			y += LineHeight * scale
+			continue
+		}
+		t.addQuad(cx, y, float32(face.Width)*scale, LineHeight*scale, t.glyphIndex(r), color)
+		cx += GlyphWidth * scale
+	}
+}
+
+// AddRect queues a filled rectangle.
+func (t *Text) AddRect(x, y, w, h float32, color mgl32.Vec4) {
+	t.addQuad(x, y, w, h, t.glyphs, color)
+}
+
+func (t *Text) glyphIndex(r rune) int {
+	for _, rr := range face.Ranges {
+		if r >= rr.Low && r < rr.High {
+			return int(r-rr.Low) + rr.Offset
+		}
+	}
+	return int('?' - face.Ranges[0].Low)
+}
+
+func (t *Text) addQuad(x, y, w, h float32, cell int, color mgl32.Vec4) {
+	cells := float32(t.glyphs + 1)
+	v0, v1 := float32(cell)/cells, float32(cell+1)/cells
+	r, g, b, a := color[0], color[1], color[2], color[3]
+	t.verts = append(t.verts,
+		x, y, 0, v0, r, g, b, a,
+		x+w, y, 1, v0, r, g, b, a,
+		x+w, y+h, 1, v1, r, g, b, a,
+		x, y, 0, v0, r, g, b, a,
+		x+w, y+h, 1, v1, r, g, b, a,
+		x, y+h, 0, v1, r, g, b, a,
+	)
+}
+
+// Draw draws everything queued since the previous call onto a screen of
+// the given size in pixels.
+func (t *Text) Draw(width, height int) {
+	if len(t.verts) == 0 {
+		return
+	}
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	defer gl.Enable(gl.DEPTH_TEST)
+	defer gl.Disable(gl.BLEND)
+
+	gl.UseProgram(t.program)
+	gl.Uniform2f(t.screenUniform, float32(width), float32(height))
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, t.atlas)
+
+	gl.BindVertexArray(t.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, t.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(t.verts)*4, gl.Ptr(t.verts), gl.STREAM_DRAW)
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(t.verts)/floatsPerVertex))
+
+	t.verts = t.verts[:0]
+}
+
+// Delete releases the GL objects owned by t.
+func (t *Text) Delete() {
+	gl.DeleteBuffers(1, &t.vbo)
+	gl.DeleteVertexArrays(1, &t.vao)
+	gl.DeleteTextures(1, &t.atlas)
+	gl.DeleteProgram(t.program)
+}
+
+var textVertexShader = `
+#version 330
+
+uniform vec2 screen;
+
+in vec2 pos;
+in vec2 uv;
+in vec4 color;
+out vec2 fragUV;
+out vec4 fragColor;
+
+void main() {
+    gl_Position = vec4(pos.x / screen.x * 2 - 1, 1 - pos.y / screen.y * 2, 0, 1);
+    fragUV = uv;
+    fragColor = color;
+}
+` + "\x00"
+
+var textFragmentShader = `
+#version 330
+
+uniform sampler2D atlas;
+
+in vec2 fragUV;
+in vec4 fragColor;
+out vec4 outputColor;
+
+void main() {
+    outputColor = vec4(fragColor.rgb, fragColor.a * texture(atlas, fragUV).r);
+}
+` + "\x00"
@@ -0,0 +1,92 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hud
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	toastDuration = 4 * time.Second
+	toastFade     = time.Second
+	maxToasts     = 6
+)
+
+var (
+	infoColor  = mgl32.Vec4{1, 1, 1, 1}
+	errorColor = mgl32.Vec4{1, 0.4, 0.4, 1}
+	toastBack  = mgl32.Vec4{0, 0, 0, 0.6}
+)
+
+type toast struct {
+	msg   string
+	color mgl32.Vec4
+	shown time.Time
+}
+
+// Toasts is a queue of transient messages shown in the bottom left corner
+// of the screen. Messages fade out after a few seconds. It is safe to push
+// messages from any goroutine.
+type Toasts struct {
+	mu    sync.Mutex
+	items []toast
+}
+
+func (q *Toasts) Info(msg string) {
+	q.push(msg, infoColor)
+}
+
+func (q *Toasts) Error(msg string) {
+	q.push(msg, errorColor)
+}
+
+func (q *Toasts) push(msg string, color mgl32.Vec4) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, toast{msg: msg, color: color, shown: time.Now()})
+	if len(q.items) > maxToasts {
+		q.items = q.items[len(q.items)-maxToasts:]
+	}
+}
+
+// Add queues the visible messages on t for a screen of the given height,
+// newest at the bottom, and drops the expired ones.
+func (q *Toasts) Add(t *Text, height int, scale float32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	live := q.items[:0]
+	for _, item := range q.items {
+		if now.Sub(item.shown) < toastDuration {
+			live = append(live, item)
+		}
+	}
+	q.items = live
+
+	margin := 8 * scale
+	y := float32(height) - margin
+	for i := len(q.items) - 1; i >= 0; i-- {
+		item := q.items[i]
+		alpha := float32(1)
+		if left := toastDuration - now.Sub(item.shown); left < toastFade {
+			alpha = float32(left) / float32(toastFade)
+		}
+
+		w, h := Measure(item.msg, scale)
+		pad := 3 * scale
+		y -= h + 2*pad
+		back := toastBack
+		back[3] *= alpha
+		color := item.color
+		color[3] *= alpha
+		t.AddRect(margin, y, w+2*pad, h+2*pad, back)
+		t.AddText(margin+pad, y+pad, scale, color, item.msg)
+		y -= pad
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// maxAccumSamples is the number of frames after which the accumulation
+// has converged and stops rendering the lattice, showing the average.
+const maxAccumSamples = 1024
+
+// accumView is what an accumulated frame depends on besides the cells:
+// any change starts the average over.
+type accumView struct {
+	camera, projection mgl32.Mat4
+	width, height      int32
+	compare            Comparison
+	sun                mgl32.Vec3
+	shadowsOff         bool
+	lightmap           *Lightmap
+	plane              [3]int
+	overlay            CellOverlay
+	style              int
+	clip               clipPlane
+}
+
+// accumulator is the beauty mode for still pictures: while nothing moves,
+// every frame renders the lattice with the projection jittered by a
+// different subpixel offset and adds it to a running average in a float
+// buffer, which is what the window shows. Edges converge to the coverage
+// of thousands of samples per pixel.
+type accumulator struct {
+	program uint32
+	vao     uint32
+
+	// The jittered frame is rendered into scene, then blended into sum.
+	sceneFBO, sceneTex, sceneDepth uint32
+	sumFBO, sumTex                 uint32
+	width, height                  int32
+
+	samples int
+	view    accumView
+	shifts  []float32
+}
+
+func newAccumulator() (*accumulator, error) {
+	program, err := glutil.NewProgram(accumVertexShader, accumFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	a := &accumulator{program: program}
+	gl.UseProgram(program)
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("frame\x00")), 0)
+	// Like the overdraw heat map, the quad is a single triangle generated
+	// from the vertex IDs.
+	gl.GenVertexArrays(1, &a.vao)
+	gl.GenFramebuffers(1, &a.sceneFBO)
+	gl.GenTextures(1, &a.sceneTex)
+	gl.GenRenderbuffers(1, &a.sceneDepth)
+	gl.GenFramebuffers(1, &a.sumFBO)
+	gl.GenTextures(1, &a.sumTex)
+	return a, nil
+}
+
+// resize reallocates the buffers for frames of width by height, starting
+// the average over.
+func (a *accumulator) resize(width, height int32) error {
+	a.width, a.height = width, height
+	a.samples = 0
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+
+	for _, t := range []struct {
+		fbo, tex uint32
+		format   int32
+		typ      uint32
+	}{{a.sceneFBO, a.sceneTex, gl.RGBA8, gl.UNSIGNED_BYTE}, {a.sumFBO, a.sumTex, gl.RGBA32F, gl.FLOAT}} {
+		gl.BindTexture(gl.TEXTURE_2D, t.tex)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, t.format, width, height, 0, gl.RGBA, t.typ, nil)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, t.fbo)
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, t.tex, 0)
+		if t.fbo == a.sceneFBO {
+			gl.BindRenderbuffer(gl.RENDERBUFFER, a.sceneDepth)
+			gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, width, height)
+			gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+			gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, a.sceneDepth)
+		}
+		if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+			return errors.New("accumulation framebuffer is incomplete")
+		}
+	}
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return nil
+}
+
+// reset starts the average over.
+func (a *accumulator) reset() {
+	a.samples = 0
+}
+
+// update starts the average over if the view or the animation of the
+// cells changed since the previous frame.
+func (a *accumulator) update(view accumView, draws []drawCall) {
+	same := view == a.view && len(draws) == len(a.shifts)
+	for i := 0; same && i < len(draws); i++ {
+		same = draws[i].shift == a.shifts[i]
+	}
+	if same {
+		return
+	}
+	a.samples = 0
+	a.view = view
+	a.shifts = a.shifts[:0]
+	for _, d := range draws {
+		a.shifts = append(a.shifts, d.shift)
+	}
+}
+
+// halton returns element i of the Halton sequence of the given base, in
+// [0, 1). Successive jitter offsets from bases 2 and 3 cover the pixel
+// evenly however many there are.
+func halton(i, base int) float32 {
+	f, v := float32(1), float32(0)
+	for ; i > 0; i /= base {
+		f /= float32(base)
+		v += f * float32(i%base)
+	}
+	return v
+}
+
+// render adds a jittered frame to the average, unless it converged, and
+// draws the average into the viewport at x, y of the given size of the
+// bound framebuffer.
+func (a *accumulator) render(r *Renderer, x, y, width, height int32) error {
+	if width != a.width || height != a.height {
+		if err := a.resize(width, height); err != nil {
+			return err
+		}
+	}
+	a.update(accumView{
+		camera: r.camera, projection: r.projection,
+		width: width, height: height,
+		compare: r.compare, sun: r.sun, shadowsOff: r.shadowsOff, lightmap: r.lightmap,
+		plane: r.plane, overlay: r.cellOverlay, style: r.style, clip: r.clip,
+	}, r.draws)
+
+	var target int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &target)
+	if a.samples < maxAccumSamples {
+		// The first sample is the pixel center, which the average starts
+		// from.
+		jx, jy := halton(a.samples, 2)-0.5, halton(a.samples, 3)-0.5
+		if a.samples == 0 {
+			jx, jy = 0, 0
+		}
+		projection := r.projection
+		r.projection = mgl32.Translate3D(2*jx/float32(width), 2*jy/float32(height), 0).Mul4(projection)
+		gl.UseProgram(r.program)
+		gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+		gl.BindFramebuffer(gl.FRAMEBUFFER, a.sceneFBO)
+		gl.Viewport(0, 0, width, height)
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		r.renderScene(&r.compare, 0, 0, width, height)
+		r.timers.begin(postPass)
+		r.projection = projection
+		gl.UseProgram(r.program)
+		gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+
+		// Blending by 1/n keeps the running average of n samples.
+		a.samples++
+		gl.BindFramebuffer(gl.FRAMEBUFFER, a.sumFBO)
+		gl.Enable(gl.BLEND)
+		gl.BlendColor(0, 0, 0, 1/float32(a.samples))
+		gl.BlendFunc(gl.CONSTANT_ALPHA, gl.ONE_MINUS_CONSTANT_ALPHA)
+		a.draw(a.sceneTex)
+		gl.Disable(gl.BLEND)
+	}
+
+	r.timers.begin(postPass)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(target))
+	gl.Viewport(x, y, width, height)
+	a.draw(a.sumTex)
+	r.timers.end(postPass)
+	return nil
+}
+
+// draw copies the texture tex over the viewport of the bound framebuffer.
+func (a *accumulator) draw(tex uint32) {
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.UseProgram(a.program)
+	gl.BindVertexArray(a.vao)
+	gl.BindTexture(gl.TEXTURE_2D, tex)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// Delete releases the GL objects of the accumulator.
+func (a *accumulator) Delete() {
+	gl.DeleteFramebuffers(1, &a.sceneFBO)
+	gl.DeleteTextures(1, &a.sceneTex)
+	gl.DeleteRenderbuffers(1, &a.sceneDepth)
+	gl.DeleteFramebuffers(1, &a.sumFBO)
+	gl.DeleteTextures(1, &a.sumTex)
+	gl.DeleteVertexArrays(1, &a.vao)
+	gl.DeleteProgram(a.program)
+}
+
+// SetBeauty turns the beauty mode on or off.
+func (r *Renderer) SetBeauty(on bool) error {
+	if on == (r.beauty != nil) {
+		return nil
+	}
+	if !on {
+		r.beauty.Delete()
+		r.beauty = nil
+		r.Notify(MsgBeautyOff)
+		return nil
+	}
+	a, err := newAccumulator()
+	if err != nil {
+		return err
+	}
+	r.beauty = a
+	r.Notify(MsgBeautyOn, maxAccumSamples)
+	return nil
+}
+
+// ToggleBeauty turns the beauty mode on or off.
+func (r *Renderer) ToggleBeauty() {
+	if err := r.SetBeauty(r.beauty == nil); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+var accumVertexShader = `
+#version 330
+
+out vec2 fragUV;
+
+void main() {
+    vec2 pos = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2);
+    fragUV = pos;
+    gl_Position = vec4(pos * 2 - 1, 0, 1);
+}
+` + "\x00"
+
+var accumFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    outputColor = texture(frame, fragUV);
+}
+` + "\x00"
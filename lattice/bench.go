@@ -0,0 +1,266 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Benchmark settings: the frames rendered before the timing starts, the
+// time in seconds the camera takes around the lattice over all frames, and
+// the keyframes of the circle it flies along.
+const (
+	benchWarmup   = 30
+	benchPathTime = 20
+	benchKeys     = 8
+)
+
+// BenchResult are the frame times of a benchmark, in milliseconds, and
+// the triangles drawn.
+type BenchResult struct {
+	Frames    int `json:"frames"`
+	Width     int `json:"width"`
+	Height    int `json:"height"`
+	Cells     int `json:"cells"`
+	Triangles int `json:"triangles"`
+
+	MinMs float64 `json:"min_ms"`
+	AvgMs float64 `json:"avg_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+	// TrianglesPerFrame are the triangles drawn after culling, on average,
+	// and TrianglesPerSecond the rate they were drawn at.
+	TrianglesPerFrame  float64 `json:"triangles_per_frame"`
+	TrianglesPerSecond float64 `json:"triangles_per_second"`
+}
+
+// BenchGuard compares a benchmark with a baseline, a result written by an
+// earlier run, to catch performance regressions.
+type BenchGuard struct {
+	// Baseline is the JSON file of the baseline result, empty for no
+	// comparison.
+	Baseline string
+	// Threshold is the fraction by which a frame time may exceed that of
+	// the baseline before it counts as a regression, such as 0.1 for 10%,
+	// or 0 for any slowdown.
+	Threshold float64
+}
+
+// DefaultBenchThreshold is the usual regression threshold of a BenchGuard.
+const DefaultBenchThreshold = 0.1
+
+// RunBenchmark renders frames of the lattice of opts with vsync off, the
+// camera flying once around it along a fixed path, or along
+// opts.CameraPath if set, and writes the frame times to w as JSON. The
+// camera and the animation advance by a fixed step every frame, so that
+// runs draw the same frames however fast they are. With a baseline in
+// guard, the frame times are compared with it and the differences written
+// to stderr; it returns an error if any regressed beyond the threshold.
+func RunBenchmark(opts Options, frames int, guard BenchGuard, w io.Writer) error {
+	if frames <= 0 {
+		return fmt.Errorf("a benchmark needs frames, not %v", frames)
+	}
+	if guard.Threshold < 0 || math.IsNaN(guard.Threshold) {
+		return fmt.Errorf("a regression threshold must not be negative, not %v", guard.Threshold)
+	}
+	var baseline BenchResult
+	if guard.Baseline != "" {
+		var err error
+		if baseline, err = readBenchResult(guard.Baseline); err != nil {
+			return err
+		}
+	}
+	if err := glfw.Init(); err != nil {
+		return fmt.Errorf("failed to initialize glfw: %v", err)
+	}
+	defer glfw.Terminate()
+
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.Samples, opts.Samples)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	glfw.WindowHint(glfw.Resizable, glfw.False)
+	width, height := opts.Width, opts.Height
+	if width <= 0 || height <= 0 {
+		width, height = windowWidth, windowHeight
+	}
+	window, err := glfw.CreateWindow(width, height, opts.Title, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	// Nothing but the lattice of opts may change what is drawn, and the
+	// terminal carries the results.
+	opts.AutosavePeriod = 0
+	opts.Swap = SwapImmediate
+	opts.Console = nil
+	opts.Watch = ""
+	opts.Host, opts.Join = "", ""
+	opts.Quiet = true
+	r, err := NewRenderer(window, opts)
+	if err != nil {
+		return err
+	}
+	res, err := r.bench(frames)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		return err
+	}
+	if guard.Baseline == "" {
+		return nil
+	}
+	report, regressed := CompareBench(baseline, res, guard.Threshold)
+	fmt.Fprint(os.Stderr, report)
+	if regressed {
+		return fmt.Errorf("frame times regressed more than %.0f%% from %v", guard.Threshold*100, guard.Baseline)
+	}
+	return nil
+}
+
+// readBenchResult reads a result RunBenchmark wrote.
+func readBenchResult(path string) (BenchResult, error) {
+	var res BenchResult
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	if res.Frames == 0 {
+		return res, fmt.Errorf("%v holds no benchmark frames", path)
+	}
+	return res, nil
+}
+
+// CompareBench compares the fastest, average and 99th percentile frame
+// times of res with those of base. It returns a report with a line per
+// frame time, marking those more than threshold slower as a fraction of
+// the baseline, and whether any was.
+func CompareBench(base, res BenchResult, threshold float64) (string, bool) {
+	var b strings.Builder
+	if base.Width != res.Width || base.Height != res.Height || base.Cells != res.Cells {
+		fmt.Fprintf(&b, "warning: baseline drew %v cells at %vx%v, this run %v cells at %vx%v\n",
+			base.Cells, base.Width, base.Height, res.Cells, res.Width, res.Height)
+	}
+	regressed := false
+	for _, m := range []struct {
+		name      string
+		base, now float64
+	}{
+		{"min_ms", base.MinMs, res.MinMs},
+		{"avg_ms", base.AvgMs, res.AvgMs},
+		{"p99_ms", base.P99Ms, res.P99Ms},
+	} {
+		change := 0.0
+		if m.base > 0 {
+			change = (m.now - m.base) / m.base
+		}
+		mark := ""
+		if change > threshold {
+			mark = "  REGRESSION"
+			regressed = true
+		}
+		fmt.Fprintf(&b, "%-7s %8.3f -> %8.3f  %+6.1f%%%v\n", m.name, m.base, m.now, change*100, mark)
+	}
+	return b.String(), regressed
+}
+
+// benchPath returns the keyframes of a circle around the lattice, rising
+// and falling twice, over benchPathTime seconds.
+func (r *Renderer) benchPath() []cameraKey {
+	extent := r.params.World(mgl32.Vec3{float32(r.params.HalfSize), 0, 0}).Len()
+	radius := 2.5*extent + 5
+	keys := make([]cameraKey, benchKeys+1)
+	for i := range keys {
+		a := 2 * math.Pi * float64(i) / benchKeys
+		pos := mgl32.Vec3{
+			radius * float32(math.Sin(a)),
+			radius * 0.35 * float32(math.Sin(2*a)),
+			radius * float32(math.Cos(a)),
+		}
+		pitch, yaw := facing(pos.Mul(-1))
+		keys[i] = cameraKey{
+			Time:     benchPathTime * float64(i) / benchKeys,
+			Position: pos,
+			Pitch:    mgl32.RadToDeg(pitch),
+			Yaw:      mgl32.RadToDeg(yaw),
+			FOV:      defaultFOV,
+		}
+	}
+	return keys
+}
+
+// bench renders benchWarmup and then frames frames to the window, timing
+// the latter from the start of an update to the end of the swap.
+func (r *Renderer) bench(frames int) (BenchResult, error) {
+	if len(r.path.keys) < 2 {
+		r.path = cameraPath{keys: r.benchPath()}
+	}
+	r.path.playing, r.path.start = true, -1
+	total := benchWarmup + frames
+	step := r.path.duration() / float64(total)
+
+	glfw.SetTime(1)
+	r.frameTimer.OnFrame()
+	times := make([]float64, 0, frames)
+	var triangles float64
+	for i := 0; i < total && !r.w.ShouldClose(); i++ {
+		glfw.SetTime(1 + float64(i+1)*step)
+		start := time.Now()
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		r.Update(r.w)
+		r.Render()
+		r.w.SwapBuffers()
+		gl.Finish()
+		glfw.PollEvents()
+		if i >= benchWarmup {
+			times = append(times, float64(time.Since(start))/float64(time.Millisecond))
+			triangles += float64(r.drawn)
+		}
+	}
+	if len(times) == 0 {
+		return BenchResult{}, fmt.Errorf("the window closed during the warmup")
+	}
+
+	w, h := r.w.GetFramebufferSize()
+	res := BenchResult{
+		Frames:            len(times),
+		Width:             w,
+		Height:            h,
+		Cells:             r.cells.count(),
+		Triangles:         r.count,
+		TrianglesPerFrame: triangles / float64(len(times)),
+	}
+	var sum float64
+	for _, t := range times {
+		sum += t
+	}
+	sort.Float64s(times)
+	res.MinMs, res.MaxMs = times[0], times[len(times)-1]
+	res.AvgMs = sum / float64(len(times))
+	res.P99Ms = times[int(math.Ceil(0.99*float64(len(times))))-1]
+	res.TrianglesPerSecond = triangles / (sum / 1000)
+	return res, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareBench(t *testing.T) {
+	base := BenchResult{Frames: 100, Width: 640, Height: 480, Cells: 1000, MinMs: 5, AvgMs: 10, P99Ms: 20}
+	// times returns the result of a run with the given frame times.
+	times := func(min, avg, p99 float64) BenchResult {
+		res := base
+		res.MinMs, res.AvgMs, res.P99Ms = min, avg, p99
+		return res
+	}
+	tests := []struct {
+		name      string
+		res       BenchResult
+		threshold float64
+		regressed bool
+		// marked are the frame times marked as regressions.
+		marked []string
+	}{
+		{"same", base, 0.1, false, nil},
+		{"faster", times(4, 9, 15), 0.1, false, nil},
+		{"at the threshold", times(5.5, 11, 22), 0.1, false, nil},
+		{"past the threshold", times(5, 11.001, 20), 0.1, true, []string{"avg_ms"}},
+		{"p99 past the threshold", times(5, 10, 22.5), 0.1, true, []string{"p99_ms"}},
+		{"all past the threshold", times(6, 12, 24), 0.1, true, []string{"min_ms", "avg_ms", "p99_ms"}},
+		{"no tolerance, same", base, 0, false, nil},
+		{"no tolerance, slower", times(5.001, 10, 20), 0, true, []string{"min_ms"}},
+		{"no tolerance, faster", times(4.9, 9.9, 19.9), 0, false, nil},
+		{"wide threshold", times(7, 14, 28), 0.5, false, nil},
+	}
+	for _, test := range tests {
+		report, regressed := CompareBench(base, test.res, test.threshold)
+		if regressed != test.regressed {
+			t.Errorf("%v: regressed = %v, want %v\n%v", test.name, regressed, test.regressed, report)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(report), "\n") {
+			name := strings.Fields(line)[0]
+			want := false
+			for _, m := range test.marked {
+				want = want || m == name
+			}
+			if got := strings.HasSuffix(line, "REGRESSION"); got != want {
+				t.Errorf("%v: %v marked %v, want %v", test.name, name, got, want)
+			}
+		}
+		if strings.Contains(report, "warning") {
+			t.Errorf("%v: warned about a run of the same lattice:\n%v", test.name, report)
+		}
+	}
+}
+
+func TestCompareBenchOtherLattice(t *testing.T) {
+	base := BenchResult{Frames: 100, Width: 640, Height: 480, Cells: 1000, MinMs: 5, AvgMs: 10, P99Ms: 20}
+	for _, res := range []BenchResult{
+		{Frames: 100, Width: 1280, Height: 480, Cells: 1000, MinMs: 5, AvgMs: 10, P99Ms: 20},
+		{Frames: 100, Width: 640, Height: 480, Cells: 8000, MinMs: 5, AvgMs: 10, P99Ms: 20},
+	} {
+		report, regressed := CompareBench(base, res, 0.1)
+		if !strings.HasPrefix(report, "warning: ") || regressed {
+			t.Errorf("CompareBench of %+v = %q, %v, want a warning and no regression", res, report, regressed)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// Default bloom settings. Only colors above 1, which the post-processing
+// framebuffers keep, glow by default: those of emissive cells and the box
+// around the picked cell.
+const (
+	defaultBloomStrength  = 0.8
+	defaultBloomThreshold = 1
+)
+
+// bloomPasses is the number of times the highlights are blurred, each
+// pass widening the glow.
+const bloomPasses = 4
+
+// pickedGlow scales the color of the box around the picked cell while the
+// frame blooms, above the threshold so that it glows.
+const pickedGlow = 4
+
+// Bloom makes the bright parts of the frame glow: the colors above
+// Threshold are blurred at half the resolution and added back, scaled by
+// Strength.
+type Bloom struct {
+	Strength  float32 `json:"strength"`
+	Threshold float32 `json:"threshold"`
+}
+
+func (Bloom) Name() string { return "bloom" }
+
+func (Bloom) Shader() string { return bloomFragmentShader }
+
+func (b Bloom) SetUniforms(program uint32) {
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("bloom\x00")), 1)
+	gl.Uniform1f(gl.GetUniformLocation(program, gl.Str("strength\x00")), b.Strength)
+}
+
+// prepare blurs the highlights of frame into the bloom buffers of p, bound
+// to texture unit 1 for the pass of b.
+func (b Bloom) prepare(p *postChain, frame uint32) error {
+	if p.bloom == nil {
+		bb, err := newBloomBuffers()
+		if err != nil {
+			return err
+		}
+		p.bloom = bb
+	}
+	bb := p.bloom
+	width, height := (p.width+1)/2, (p.height+1)/2
+	if width != bb.width || height != bb.height {
+		if err := bb.resize(width, height); err != nil {
+			return err
+		}
+	}
+	gl.Viewport(0, 0, width, height)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, bb.fbos[0])
+	gl.UseProgram(bb.bright)
+	gl.Uniform1f(bb.thresholdUniform, b.Threshold)
+	gl.BindTexture(gl.TEXTURE_2D, frame)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	// The blur is separable: every pass blurs across into the second
+	// texture, then down back into the first.
+	gl.UseProgram(bb.blur)
+	for i := 0; i < bloomPasses; i++ {
+		for j, step := range [2][2]float32{{1 / float32(width), 0}, {0, 1 / float32(height)}} {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, bb.fbos[1-j])
+			gl.Uniform2f(bb.stepUniform, step[0], step[1])
+			gl.BindTexture(gl.TEXTURE_2D, bb.texs[j])
+			gl.DrawArrays(gl.TRIANGLES, 0, 3)
+		}
+	}
+
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, bb.texs[0])
+	gl.ActiveTexture(gl.TEXTURE0)
+	return nil
+}
+
+// bloomBuffers are the half resolution textures bloom blurs the highlights
+// in, and its programs.
+type bloomBuffers struct {
+	bright, blur     uint32
+	thresholdUniform int32
+	stepUniform      int32
+
+	width, height int32
+	fbos, texs    [2]uint32
+}
+
+func newBloomBuffers() (*bloomBuffers, error) {
+	bright, err := glutil.NewProgram(accumVertexShader, brightFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	blur, err := glutil.NewProgram(accumVertexShader, blurFragmentShader)
+	if err != nil {
+		gl.DeleteProgram(bright)
+		return nil, err
+	}
+	bb := &bloomBuffers{
+		bright:           bright,
+		blur:             blur,
+		thresholdUniform: gl.GetUniformLocation(bright, gl.Str("threshold\x00")),
+		stepUniform:      gl.GetUniformLocation(blur, gl.Str("texelStep\x00")),
+	}
+	for _, program := range []uint32{bright, blur} {
+		gl.UseProgram(program)
+		gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("frame\x00")), 0)
+	}
+	gl.GenFramebuffers(2, &bb.fbos[0])
+	gl.GenTextures(2, &bb.texs[0])
+	return bb, nil
+}
+
+// resize reallocates the textures for highlights of width by height.
+func (bb *bloomBuffers) resize(width, height int32) error {
+	bb.width, bb.height = width, height
+	for i, tex := range bb.texs {
+		gl.BindTexture(gl.TEXTURE_2D, tex)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA16F, width, height, 0, gl.RGBA, gl.FLOAT, nil)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, bb.fbos[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, tex, 0)
+		if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+			return errors.New("bloom framebuffer is incomplete")
+		}
+	}
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return nil
+}
+
+// Delete releases the GL objects of the buffers.
+func (bb *bloomBuffers) Delete() {
+	gl.DeleteFramebuffers(2, &bb.fbos[0])
+	gl.DeleteTextures(2, &bb.texs[0])
+	gl.DeleteProgram(bb.bright)
+	gl.DeleteProgram(bb.blur)
+}
+
+// pickedBoxColor returns the color of the box around the picked cell,
+// made to glow while the frame blooms.
+func (r *Renderer) pickedBoxColor() mgl32.Vec3 {
+	if r.post.has(Bloom{}.Name()) {
+		return pickedColor.Mul(pickedGlow)
+	}
+	return pickedColor
+}
+
+var brightFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+uniform float threshold;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    vec3 color = texture(frame, fragUV).rgb;
+    float peak = max(color.r, max(color.g, color.b));
+    outputColor = vec4(color * max(peak - threshold, 0) / max(peak, 1e-4), 1);
+}
+` + "\x00"
+
+var blurFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+// texelStep is the distance between texels along the blur.
+uniform vec2 texelStep;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+// The weights of a 9 tap Gaussian, from the center out.
+const float weights[5] = float[](0.2270270, 0.1945946, 0.1216216, 0.0540541, 0.0162162);
+
+void main() {
+    vec3 sum = texture(frame, fragUV).rgb * weights[0];
+    for (int i = 1; i < 5; i++) {
+        sum += texture(frame, fragUV + texelStep * float(i)).rgb * weights[i];
+        sum += texture(frame, fragUV - texelStep * float(i)).rgb * weights[i];
+    }
+    outputColor = vec4(sum, 1);
+}
+` + "\x00"
+
+var bloomFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+uniform sampler2D bloom;
+uniform float strength;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    vec4 color = texture(frame, fragUV);
+    outputColor = vec4(color.rgb + strength * texture(bloom, fragUV).rgb, color.a);
+}
+` + "\x00"
@@ -0,0 +1,176 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// LatticeType selects the arrangement of the cells. The sites of every
+// type lie on the integer grid of lattice coordinates: a conventional unit
+// cell spans a block of grid positions, of which the basis points are
+// occupied. The axes of the type then place grid positions in the world.
+type LatticeType int
+
+const (
+	// SimpleCubic occupies every grid position.
+	SimpleCubic LatticeType = iota
+	// BodyCentered adds the center of every cube of edge 2.
+	BodyCentered
+	// FaceCentered adds the center of every face of the cubes of edge 2.
+	FaceCentered
+	// Diamond is face-centered cubic with a two point basis, in cubes of
+	// edge 4.
+	Diamond
+	// Hexagonal stacks triangular layers straight on top of each other.
+	Hexagonal
+	// HexagonalClosePacked alternates two triangular layers, the second
+	// over the holes of the first, at the ideal c/a ratio.
+	HexagonalClosePacked
+)
+
+var latticeTypeNames = []string{"sc", "bcc", "fcc", "diamond", "hex", "hcp"}
+
+// unitCell describes a lattice type by its conventional cell on the grid.
+type unitCell struct {
+	// size is the extent of the cell in grid positions along each axis.
+	size [3]int
+	// basis lists the occupied grid positions within the cell.
+	basis [][3]int
+	// axes are the world vectors of a step along each grid axis.
+	axes mgl32.Mat3
+}
+
+// hexEdge is the edge a of the hexagonal lattices, the smallest that
+// keeps the unit cubes of neighboring sites from overlapping.
+const hexEdge = 1.25
+
+var (
+	// The triangular layers are horizontal, with the y axis stacking
+	// them. Hexagonal close packing needs thirds of the in-plane edge a
+	// and halves of the layer spacing c.
+	sqrt3     = float32(math.Sqrt(3))
+	idealHCP  = float32(math.Sqrt(8.0 / 3))
+	hexAxes   = mgl32.Mat3{1, 0, 0, 0, 1, 0, 0.5, 0, sqrt3 / 2}.Mul(hexEdge)
+	hcpAxes   = mgl32.Mat3{1.0 / 3, 0, 0, 0, idealHCP / 2, 0, 0.5 / 3, 0, sqrt3 / 6}.Mul(hexEdge)
+	unitCells = []unitCell{
+		SimpleCubic: {size: [3]int{1, 1, 1}, basis: [][3]int{{0, 0, 0}}, axes: mgl32.Ident3()},
+		BodyCentered: {
+			size:  [3]int{2, 2, 2},
+			basis: [][3]int{{0, 0, 0}, {1, 1, 1}},
+			axes:  mgl32.Ident3(),
+		},
+		FaceCentered: {
+			size:  [3]int{2, 2, 2},
+			basis: [][3]int{{0, 0, 0}, {1, 1, 0}, {1, 0, 1}, {0, 1, 1}},
+			axes:  mgl32.Ident3(),
+		},
+		Diamond: {
+			size: [3]int{4, 4, 4},
+			basis: [][3]int{
+				{0, 0, 0}, {2, 2, 0}, {2, 0, 2}, {0, 2, 2},
+				{1, 1, 1}, {3, 3, 1}, {3, 1, 3}, {1, 3, 3},
+			},
+			axes: mgl32.Ident3(),
+		},
+		Hexagonal: {size: [3]int{1, 1, 1}, basis: [][3]int{{0, 0, 0}}, axes: hexAxes},
+		// The second layer sits over the center of the first triangle
+		// of the rhombic cell, a third along both in-plane axes.
+		HexagonalClosePacked: {
+			size:  [3]int{3, 2, 3},
+			basis: [][3]int{{0, 0, 0}, {1, 1, 1}},
+			axes:  hcpAxes,
+		},
+	}
+)
+
+func (t LatticeType) String() string {
+	return latticeTypeNames[t]
+}
+
+// Set implements flag.Value.
+func (t *LatticeType) Set(name string) error {
+	typ, ok := ParseLatticeType(name)
+	if !ok {
+		return fmt.Errorf("unknown lattice type %q", name)
+	}
+	*t = typ
+	return nil
+}
+
+// ParseLatticeType returns the lattice type with the given name.
+func ParseLatticeType(name string) (LatticeType, bool) {
+	for i, n := range latticeTypeNames {
+		if n == name {
+			return LatticeType(i), true
+		}
+	}
+	return SimpleCubic, false
+}
+
+// LatticeTypes returns the names of the lattice types.
+func LatticeTypes() []string {
+	return append([]string(nil), latticeTypeNames...)
+}
+
+// next returns the type after t, wrapping around.
+func (t LatticeType) next() LatticeType {
+	return (t + 1) % LatticeType(len(latticeTypeNames))
+}
+
+// site reports whether the grid position x, y, z holds a cell.
+func (t LatticeType) site(x, y, z int) bool {
+	u := unitCells[t]
+	p := [3]int{x, y, z}
+	for i := range p {
+		p[i] -= floorDiv(p[i], u.size[i]) * u.size[i]
+	}
+	for _, b := range u.basis {
+		if b == p {
+			return true
+		}
+	}
+	return false
+}
+
+// gridAligned reports whether the world position of a cell is its grid
+// position, as the lightmap requires.
+func (p LatticeParams) gridAligned() bool {
+	return unitCells[p.Type].axes == mgl32.Ident3() && (p.Scale == 0 || p.Scale == 1)
+}
+
+// World returns the world position of the grid position pos.
+func (p LatticeParams) World(pos mgl32.Vec3) mgl32.Vec3 {
+	if p.Type != SimpleCubic {
+		pos = unitCells[p.Type].axes.Mul3x1(pos)
+	}
+	if p.Scale != 0 {
+		pos = pos.Mul(p.Scale)
+	}
+	return pos
+}
+
+// radius returns the radius of a sphere around the origin containing every
+// cube of the lattice.
+func (p LatticeParams) radius() float32 {
+	d := float32(p.HalfSize)
+	r := float32(0)
+	for corner := 0; corner < cornersPerCube; corner++ {
+		var c mgl32.Vec3
+		for axis := range c {
+			c[axis] = -d
+			if corner&(1<<axis) != 0 {
+				c[axis] = d
+			}
+		}
+		if l := p.World(c).Len(); l > r {
+			r = l
+		}
+	}
+	return r + sqrt3*p.CubeSize/2
+}
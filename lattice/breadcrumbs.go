@@ -0,0 +1,86 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import "github.com/go-gl/mathgl/mgl32"
+
+// Breadcrumb defaults: the distance the camera travels between crumbs, in
+// world units, the most crumbs kept, the oldest dropped first, and the
+// half size of the cross marking a crumb.
+const (
+	defaultBreadcrumbSpacing = 4
+	maxBreadcrumbs           = 2000
+	breadcrumbSize           = 0.25
+)
+
+var (
+	breadcrumbColor     = mgl32.Vec3{1, 0.8, 0.2}
+	breadcrumbPathColor = mgl32.Vec3{0.6, 0.45, 0.1}
+)
+
+// breadcrumbs are markers dropped along the path of the camera, joined by
+// a line, so that the way back out of a large lattice stays in sight.
+type breadcrumbs struct {
+	// on drops a crumb whenever the camera is spacing away from the last.
+	on      bool
+	spacing float32
+	// crumbs are the world positions of the crumbs, oldest first.
+	crumbs []mgl32.Vec3
+}
+
+// drop adds a crumb at the world position pos if crumbs are being dropped
+// and it is far enough from the last one.
+func (b *breadcrumbs) drop(pos mgl32.Vec3) {
+	if !b.on {
+		return
+	}
+	if n := len(b.crumbs); n > 0 && b.crumbs[n-1].Sub(pos).Len() < b.spacing {
+		return
+	}
+	if len(b.crumbs) == maxBreadcrumbs {
+		b.crumbs = append(b.crumbs[:0], b.crumbs[1:]...)
+	}
+	b.crumbs = append(b.crumbs, pos)
+}
+
+// lines returns the lines drawing the crumbs, a cross at each, then the
+// path joining them, and their colors.
+func (b *breadcrumbs) lines() (lines, colors []mgl32.Vec3) {
+	for _, c := range b.crumbs {
+		for axis := 0; axis < 3; axis++ {
+			var d mgl32.Vec3
+			d[axis] = breadcrumbSize
+			lines = append(lines, c.Sub(d), c.Add(d))
+			colors = append(colors, breadcrumbColor)
+		}
+	}
+	for i := 1; i < len(b.crumbs); i++ {
+		lines = append(lines, b.crumbs[i-1], b.crumbs[i])
+		colors = append(colors, breadcrumbPathColor)
+	}
+	return lines, colors
+}
+
+// SetBreadcrumbs starts dropping breadcrumbs every spacing world units, or
+// the default spacing if it is 0, or stops if on is false. The crumbs
+// dropped stay until cleared.
+func (r *Renderer) SetBreadcrumbs(on bool, spacing float32) {
+	if spacing <= 0 {
+		spacing = defaultBreadcrumbSpacing
+	}
+	r.crumbs.on = on
+	r.crumbs.spacing = spacing
+	if on {
+		r.Notify(MsgBreadcrumbs, spacing)
+	} else {
+		r.Notify(MsgBreadcrumbsOff, len(r.crumbs.crumbs))
+	}
+}
+
+// ClearBreadcrumbs removes the breadcrumbs dropped so far.
+func (r *Renderer) ClearBreadcrumbs() {
+	r.crumbs.crumbs = nil
+	r.Notify(MsgBreadcrumbsCleared)
+}
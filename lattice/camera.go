@@ -0,0 +1,230 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// defaultFlightTime is how long goto takes without an explicit duration,
+// in seconds.
+const defaultFlightTime = 2
+
+// cameraFlight moves the camera from one position to another, easing in
+// and out.
+type cameraFlight struct {
+	from, to mgl32.Vec3
+	start    float64
+	duration float64
+}
+
+// at returns the camera position at time t and whether the flight is over.
+func (f *cameraFlight) at(t float64) (mgl32.Vec3, bool) {
+	s := (t - f.start) / f.duration
+	if s >= 1 || f.duration <= 0 {
+		return f.to, true
+	}
+	s = math.Max(s, 0)
+	ease := float32(s * s * (3 - 2*s))
+	return f.from.Add(f.to.Sub(f.from).Mul(ease)), false
+}
+
+// Orbit distance limits and the factor a scroll wheel step zooms by.
+const (
+	minOrbitDistance = 1
+	maxOrbitDistance = 1000
+	orbitZoomStep    = 0.9
+)
+
+// Vertical field of view limits in degrees, and the factor a scroll wheel
+// step with Ctrl held narrows it by.
+const (
+	defaultFOV  = 45
+	minFOV      = 5
+	maxFOV      = 120
+	fovZoomStep = 0.9
+)
+
+// dollyStep is how far a scroll wheel step moves the free camera forwards,
+// in cells.
+const dollyStep = 2
+
+// SetCamera places the camera at pos with the given pitch and yaw in
+// degrees, stopping any flight and leaving orbit mode.
+func (r *Renderer) SetCamera(pos mgl32.Vec3, pitch, yaw float32) {
+	r.flight = nil
+	r.orbiting = false
+	r.camPos = pos
+	r.roll = 0
+	r.pitch = mgl32.Clamp(mgl32.DegToRad(pitch), -math.Pi/2, math.Pi/2)
+	r.yaw = normAngle(mgl32.DegToRad(yaw))
+}
+
+// FlyTo moves the camera to pos over the given number of seconds, keeping
+// its angles so the view can still be turned on the way. It leaves orbit
+// mode.
+func (r *Renderer) FlyTo(pos mgl32.Vec3, seconds float64) {
+	r.orbiting = false
+	r.flight = &cameraFlight{from: r.camPos, to: pos, start: r.frameTimer.prevTime, duration: seconds}
+}
+
+// updateFlight moves the camera along the current flight, if any.
+func (r *Renderer) updateFlight() {
+	if r.flight == nil {
+		return
+	}
+	pos, done := r.flight.at(r.frameTimer.prevTime)
+	r.camPos = pos
+	if done {
+		r.flight = nil
+	}
+}
+
+// ToggleOrbit switches between flying freely and orbiting the lattice
+// center. Orbiting starts at the current distance, turning the camera
+// towards the center.
+func (r *Renderer) ToggleOrbit() {
+	r.orbiting = !r.orbiting
+	if !r.orbiting {
+		r.Notify(MsgFreeCamera)
+		return
+	}
+	r.flight = nil
+	r.camSpeed = mgl32.Vec3{}
+	toCenter := r.camPos.Mul(-1)
+	r.orbitDistance = mgl32.Clamp(toCenter.Len(), minOrbitDistance, maxOrbitDistance)
+	if toCenter.Len() > 0 {
+		r.pitch, r.yaw = facing(toCenter)
+	}
+	r.Notify(MsgOrbitCamera)
+}
+
+// facing returns the pitch and yaw in radians that turn the camera to look
+// along dir, which must not be zero.
+func facing(dir mgl32.Vec3) (pitch, yaw float32) {
+	// The camera looks along -z, turned by pitch about x and then by yaw
+	// about y.
+	f := dir.Normalize()
+	return float32(math.Asin(float64(f[1]))), float32(math.Atan2(float64(-f[0]), float64(-f[2])))
+}
+
+// OnScroll zooms with the scroll wheel.
+func (r *Renderer) OnScroll(w *glfw.Window, xoff, yoff float64) {
+	r.scroll(yoff, ctrlHeld(w))
+}
+
+// ctrlHeld reports whether either Ctrl key of w is held.
+func ctrlHeld(w *glfw.Window) bool {
+	return w.GetKey(glfw.KeyLeftControl) == glfw.Press || w.GetKey(glfw.KeyRightControl) == glfw.Press
+}
+
+// scroll zooms by yoff steps of the scroll wheel: with Ctrl held it
+// narrows or widens the field of view, otherwise it moves the free camera
+// forwards or backwards, or the orbit camera closer to or further from the
+// center.
+func (r *Renderer) scroll(yoff float64, ctrl bool) {
+	switch {
+	case ctrl:
+		r.SetFOV(r.fov * float32(math.Pow(fovZoomStep, yoff)))
+	case r.orbiting:
+		d := r.orbitDistance * float32(math.Pow(orbitZoomStep, yoff))
+		r.orbitDistance = mgl32.Clamp(d, minOrbitDistance, maxOrbitDistance)
+	default:
+		// A flight would override the move on the next frame.
+		r.flight = nil
+		forward := r.orientation().Rotate(mgl32.Vec3{0, 0, -1})
+		r.camPos = r.camPos.Add(forward.Mul(dollyStep * float32(yoff)))
+	}
+}
+
+// SetFOV sets the vertical field of view in degrees.
+func (r *Renderer) SetFOV(fov float32) {
+	r.fov = mgl32.Clamp(fov, minFOV, maxFOV)
+	r.updateViewport()
+}
+
+// updateOrbit places the orbit camera at its distance from the lattice
+// center, behind the center as seen along the camera direction.
+func (r *Renderer) updateOrbit() {
+	if !r.orbiting {
+		return
+	}
+	r.camPos = r.orientation().Rotate(mgl32.Vec3{0, 0, r.orbitDistance})
+}
+
+// Camera shake defaults: the largest angle the camera turns by at full
+// trauma, in degrees, and the trauma lost per second. shakeFrequency is
+// the rate of the noise driving the shake, in Hz.
+const (
+	defaultShakeAmplitude = 5
+	defaultShakeDecay     = 1
+	shakeFrequency        = 12
+)
+
+// cameraShake turns the camera by an amount following its trauma, which
+// Shake adds to and which decays linearly. The shake grows with the square
+// of the trauma, so that light hits barely move the camera and heavy ones
+// stand out, and follows smooth noise so that it sways rather than
+// jitters.
+type cameraShake struct {
+	trauma float64
+	// amplitude is in degrees, decay in trauma per second.
+	amplitude float32
+	decay     float64
+	last      float64
+}
+
+// rotation decays the trauma to time t and returns the rotation of the
+// view, in camera space.
+func (s *cameraShake) rotation(t float64) mgl32.Quat {
+	if s.trauma > 0 && t > s.last {
+		s.trauma = math.Max(0, s.trauma-s.decay*(t-s.last))
+	}
+	s.last = t
+	if s.trauma == 0 {
+		return mgl32.QuatIdent()
+	}
+	shake := float32(s.trauma*s.trauma) * mgl32.DegToRad(s.amplitude)
+	x := t * shakeFrequency
+	return mgl32.AnglesToQuat(
+		shake*valueNoise(0, x), shake*valueNoise(1, x), shake*valueNoise(2, x), mgl32.ZYX)
+}
+
+// valueNoise returns smooth noise in [-1, 1] at x, a different sequence for
+// every seed, interpolating random values at the integers.
+func valueNoise(seed uint32, x float64) float32 {
+	i := math.Floor(x)
+	f := float32(x - i)
+	f = f * f * (3 - 2*f)
+	a, b := noiseValue(seed, int64(i)), noiseValue(seed, int64(i)+1)
+	return a + (b-a)*f
+}
+
+// noiseValue hashes seed and i into a value in [-1, 1].
+func noiseValue(seed uint32, i int64) float32 {
+	h := uint32(i)*0x9e3779b1 ^ seed*0x85ebca6b
+	h ^= h >> 15
+	h *= 0x2c1b3c6d
+	h ^= h >> 12
+	h *= 0x297a2d39
+	h ^= h >> 15
+	return float32(h)/math.MaxUint32*2 - 1
+}
+
+// Shake adds trauma, from 0 to 1, to the camera shake. Full trauma shakes
+// the camera by the amplitude set with SetShake.
+func (r *Renderer) Shake(trauma float64) {
+	r.shake.trauma = math.Min(1, math.Max(0, r.shake.trauma+trauma))
+}
+
+// SetShake sets the largest angle of the camera shake in degrees, and the
+// trauma it loses per second.
+func (r *Renderer) SetShake(amplitude float32, decay float64) {
+	r.shake.amplitude = amplitude
+	r.shake.decay = decay
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// defaultKeyGap is the time in seconds from a keyframe to the one added
+// after it, unless told otherwise.
+const defaultKeyGap = 2
+
+// cameraKey is a keyframe of a camera path: the pose of the camera at a
+// time in seconds from the start of the path, with angles in degrees.
+type cameraKey struct {
+	Time     float64    `json:"time"`
+	Position mgl32.Vec3 `json:"position"`
+	Pitch    float32    `json:"pitch"`
+	Yaw      float32    `json:"yaw"`
+	FOV      float32    `json:"fov"`
+	// Amplitude and Frequency are those of the displacement of the cells,
+	// animated along the path if the keyframes were added with the cells
+	// displaced.
+	Amplitude float32 `json:"amplitude,omitempty"`
+	Frequency float32 `json:"frequency,omitempty"`
+}
+
+// cameraPath flies the camera through keyframes along Catmull-Rom splines,
+// for fly-throughs that play the same every time.
+type cameraPath struct {
+	// keys are the keyframes in order of time.
+	keys    []cameraKey
+	playing bool
+	// start is the frame time playback started at, or negative until the
+	// first frame played.
+	start float64
+}
+
+// duration returns the time from the first keyframe to the last.
+func (p *cameraPath) duration() float64 {
+	if len(p.keys) == 0 {
+		return 0
+	}
+	return p.keys[len(p.keys)-1].Time - p.keys[0].Time
+}
+
+// at returns the pose at time t from the start of the path, and whether
+// the path is over. The path must have a keyframe.
+func (p *cameraPath) at(t float64) (cameraKey, bool) {
+	keys := p.keys
+	n := len(keys)
+	t += keys[0].Time
+	if t >= keys[n-1].Time {
+		return keys[n-1], true
+	}
+	if t <= keys[0].Time {
+		return keys[0], false
+	}
+	i := sort.Search(n, func(i int) bool { return keys[i].Time > t }) - 1
+	// The keyframes around the segment, the ends repeated.
+	k0, k1, k2, k3 := keys[i], keys[i], keys[i+1], keys[i+1]
+	if i > 0 {
+		k0 = keys[i-1]
+	}
+	if i+2 < n {
+		k3 = keys[i+2]
+	}
+	spline := func(v0, v1, v2, v3 float32) float32 {
+		return catmullRom(v0, v1, v2, v3, k0.Time, k1.Time, k2.Time, k3.Time, t)
+	}
+	// The yaw turns the short way round between keyframes.
+	yaw := func(k cameraKey) float32 { return k1.Yaw + wrapDegrees(k.Yaw-k1.Yaw) }
+	var k cameraKey
+	k.Time = t - keys[0].Time
+	for axis := range k.Position {
+		k.Position[axis] = spline(k0.Position[axis], k1.Position[axis], k2.Position[axis], k3.Position[axis])
+	}
+	k.Pitch = spline(k0.Pitch, k1.Pitch, k2.Pitch, k3.Pitch)
+	k.Yaw = spline(yaw(k0), k1.Yaw, yaw(k2), yaw(k3))
+	k.FOV = spline(k0.FOV, k1.FOV, k2.FOV, k3.FOV)
+	k.Amplitude = spline(k0.Amplitude, k1.Amplitude, k2.Amplitude, k3.Amplitude)
+	k.Frequency = spline(k0.Frequency, k1.Frequency, k2.Frequency, k3.Frequency)
+	return k, false
+}
+
+// displaces reports whether the path animates the displacement of the
+// cells, having a keyframe added with the cells displaced.
+func (p *cameraPath) displaces() bool {
+	for _, k := range p.keys {
+		if k.Frequency > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// catmullRom interpolates between v1 at t1 and v2 at t2 at time t, with
+// the tangents of a Catmull-Rom spline through v0 at t0 and v3 at t3 for
+// keyframes unevenly spaced in time. t0 < t2 and t1 < t3 must hold.
+func catmullRom(v0, v1, v2, v3 float32, t0, t1, t2, t3, t float64) float32 {
+	d := t2 - t1
+	s := float32((t - t1) / d)
+	m1 := (v2 - v0) * float32(d/(t2-t0))
+	m2 := (v3 - v1) * float32(d/(t3-t1))
+	s2, s3 := s*s, s*s*s
+	return (2*s3-3*s2+1)*v1 + (s3-2*s2+s)*m1 + (-2*s3+3*s2)*v2 + (s3-s2)*m2
+}
+
+// wrapDegrees returns the angle a in degrees wrapped to [-180, 180).
+func wrapDegrees(a float32) float32 {
+	a = mgl32.RadToDeg(normAngle(mgl32.DegToRad(a)))
+	if a >= 180 {
+		a -= 360
+	}
+	return a
+}
+
+// AddKeyframe adds the current camera pose to the camera path, gap seconds
+// after its last keyframe.
+func (r *Renderer) AddKeyframe(gap float64) {
+	k := cameraKey{
+		Position: r.camPos,
+		Pitch:    mgl32.RadToDeg(r.pitch),
+		Yaw:      mgl32.RadToDeg(r.yaw),
+		FOV:      r.fov,
+	}
+	if r.displacement.Amplitude != 0 {
+		k.Amplitude, k.Frequency = r.displacement.Amplitude, r.displacement.Frequency
+	}
+	if n := len(r.path.keys); n > 0 {
+		k.Time = r.path.keys[n-1].Time + gap
+	}
+	r.path.keys = append(r.path.keys, k)
+	r.Notify(MsgKeyframe, len(r.path.keys), k.Time)
+}
+
+// PlayPath flies the camera along the camera path from its start.
+func (r *Renderer) PlayPath() error {
+	if len(r.path.keys) < 2 {
+		return r.locale.Errorf(MsgPathTooShort)
+	}
+	r.path.playing = true
+	r.path.start = -1
+	r.Notify(MsgPathPlaying, len(r.path.keys), r.path.duration())
+	return nil
+}
+
+// StopPath stops playing the camera path, leaving the camera where it is.
+func (r *Renderer) StopPath() {
+	r.path.playing = false
+	r.Notify(MsgPathStopped)
+}
+
+// TogglePath plays the camera path, or stops it while it plays.
+func (r *Renderer) TogglePath() {
+	if r.path.playing {
+		r.StopPath()
+	} else if err := r.PlayPath(); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+// ClearPath removes all keyframes.
+func (r *Renderer) ClearPath() {
+	r.path = cameraPath{}
+	r.Notify(MsgPathCleared)
+}
+
+// updatePath moves the camera along the camera path while it plays.
+func (r *Renderer) updatePath() {
+	if !r.path.playing {
+		return
+	}
+	now := r.frameTimer.prevTime
+	if r.path.start < 0 {
+		r.path.start = now
+	}
+	k, done := r.path.at(now - r.path.start)
+	r.flight = nil
+	r.orbiting = false
+	r.camPos = k.Position
+	r.pitch = mgl32.Clamp(mgl32.DegToRad(k.Pitch), -math.Pi/2, math.Pi/2)
+	r.yaw = normAngle(mgl32.DegToRad(k.Yaw))
+	if k.FOV > 0 {
+		r.fov = mgl32.Clamp(k.FOV, minFOV, maxFOV)
+	}
+	if r.path.displaces() {
+		r.displacement.Amplitude = float32(math.Max(float64(k.Amplitude), 0))
+		if k.Frequency > 0 {
+			r.displacement.Frequency = k.Frequency
+		}
+		if r.displacement.Speed == 0 {
+			r.displacement.Speed = defaultDisplaceSpeed
+		}
+	}
+	if done {
+		r.path.playing = false
+	}
+}
+
+// SavePath writes the keyframes of the camera path to path as JSON.
+func (r *Renderer) SavePath(path string) error {
+	data, err := json.MarshalIndent(r.path.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadPath replaces the camera path by the keyframes of the JSON file
+// path, as SavePath writes it.
+func (r *Renderer) LoadPath(path string) error {
+	keys, err := loadCameraKeys(path)
+	if err != nil {
+		return err
+	}
+	r.path = cameraPath{keys: keys}
+	return nil
+}
+
+// loadCameraKeys reads the keyframes of a camera path file, whose times
+// must increase.
+func loadCameraKeys(path string) ([]cameraKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []cameraKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i].Time <= keys[i-1].Time {
+			return nil, fmt.Errorf("%v: keyframe %v is not later than the one before", path, i+1)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Lattice files are CSV files with a header row and one cell per row:
+//
+//	x,y,z,r,g,b,value,size
+//
+// Coordinates are integer lattice positions, the remaining columns are
+// floats. The value and size columns are optional, a missing size drawing
+// the full cube. Rows off the grid are sub-cells of a cell refined 2 or 4
+// times along each axis, for data of adaptive resolution: their size is
+// 1/2 or 1/4 and their coordinates are their centers, such as 0.25 or
+// -0.375, within the cube of the cell around them.
+var cellFileHeader = []string{"x", "y", "z", "r", "g", "b", "value", "size"}
+
+// SaveCells writes cells to path as a lattice file.
+func SaveCells(path string, cells []cell) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(cellFileHeader)
+	for _, c := range cells {
+		w.Write([]string{
+			formatFloat(c.pos[0]), formatFloat(c.pos[1]), formatFloat(c.pos[2]),
+			formatFloat(c.color[0]), formatFloat(c.color[1]), formatFloat(c.color[2]),
+			formatFloat(c.value), formatFloat(c.scale()),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCells reads a lattice file. The returned params have the cube size
+// and color period of p, and are grown so that every cell fits.
+func LoadCells(path string, p LatticeParams) ([]cell, LatticeParams, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, p, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	if _, err := r.Read(); err != nil {
+		return nil, p, fmt.Errorf("%v: missing header: %v", path, err)
+	}
+
+	var cells []cell
+	// factors are the refinement factors of the cells with sub-cells.
+	factors := make(map[[3]int]int)
+	p.HalfSize = 0
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, p, err
+		}
+		if len(record) < 6 {
+			return nil, p, fmt.Errorf("%v:%v: expected at least 6 columns", path, line)
+		}
+
+		var pos mgl32.Vec3
+		for i := range pos {
+			v, err := strconv.ParseFloat(record[i], 32)
+			if err != nil {
+				return nil, p, fmt.Errorf("%v:%v: %v", path, line, err)
+			}
+			pos[i] = float32(v)
+			if math.Abs(v) > MaxHalfSize+0.5 {
+				p.HalfSize = int(math.Min(math.Abs(math.Round(v)), math.MaxInt32))
+				return nil, p, fmt.Errorf("%v:%v: %v", path, line, p.checkSize(fallbackLocale))
+			}
+			if a := abs(int(math.Round(v))); a > p.HalfSize {
+				p.HalfSize = a
+			}
+		}
+		var floats [5]float32
+		for i := 3; i < len(record) && i < 8; i++ {
+			v, err := strconv.ParseFloat(record[i], 32)
+			if err != nil {
+				return nil, p, fmt.Errorf("%v:%v: %v", path, line, err)
+			}
+			floats[i-3] = float32(v)
+		}
+
+		c := cell{
+			pos:   pos,
+			color: mgl32.Vec3{floats[0], floats[1], floats[2]},
+			value: floats[3],
+			size:  floats[4],
+		}
+		if isSubcell(c) {
+			f, ok := subcellFactor(c)
+			if !ok {
+				return nil, p, fmt.Errorf("%v:%v: sub-cell at %v, %v, %v of size %v is not on a subgrid of 2 or 4 cells a side", path, line, pos[0], pos[1], pos[2], c.scale())
+			}
+			g := gridPos(c)
+			if prev, ok := factors[g]; ok && prev != f {
+				return nil, p, fmt.Errorf("%v:%v: cell %v, %v, %v is refined by both %v and %v", path, line, g[0], g[1], g[2], prev, f)
+			}
+			factors[g] = f
+		}
+		cells = append(cells, c)
+	}
+
+	// IDs can only be assigned once the lattice extent is known.
+	assignIDs(cells, p)
+	return cells, p, nil
+}
+
+// assignIDs sets the cell IDs from their positions within p.
+func assignIDs(cells []cell, p LatticeParams) {
+	for i := range cells {
+		pos := gridPos(cells[i])
+		cells[i].id, _ = p.CellID(pos[0], pos[1], pos[2])
+	}
+}
+
+var (
+	diffAddedColor   = mgl32.Vec3{0, 1, 0}
+	diffRemovedColor = mgl32.Vec3{1, 0, 0}
+	diffChangedColor = mgl32.Vec3{1, 1, 0}
+)
+
+// diffEpsilon is the largest color or value difference still considered
+// equal, to absorb rounding in the CSV round trip.
+const diffEpsilon = 1e-4
+
+// DiffStats counts the cells of each kind found by diffCells.
+type DiffStats struct {
+	Added, Removed, Changed, Unchanged int
+}
+
+// diffCells compares two lattices cell by cell and returns the cells that
+// differ, colored green if only in b, red if only in a and yellow if
+// their color or value changed. Unchanged cells are left out. The value
+// of a returned cell is the value difference b - a. Both lattices must
+// have their IDs assigned from the same params.
+func diffCells(a, b []cell) ([]cell, DiffStats) {
+	var stats DiffStats
+	before := make(map[uint32]cell, len(a))
+	for _, c := range a {
+		before[c.id] = c
+	}
+
+	var diff []cell
+	for _, c := range b {
+		old, ok := before[c.id]
+		switch {
+		case !ok:
+			stats.Added++
+			c.color = diffAddedColor
+			diff = append(diff, c)
+		case !vecEqual(old.color, c.color) || !floatEqual(old.value, c.value):
+			stats.Changed++
+			c.value -= old.value
+			c.color = diffChangedColor
+			diff = append(diff, c)
+		default:
+			stats.Unchanged++
+		}
+		delete(before, c.id)
+	}
+	for _, c := range a {
+		if _, ok := before[c.id]; ok {
+			stats.Removed++
+			c.value = -c.value
+			c.color = diffRemovedColor
+			diff = append(diff, c)
+		}
+	}
+	return diff, stats
+}
+
+func vecEqual(a, b mgl32.Vec3) bool {
+	return floatEqual(a[0], b[0]) && floatEqual(a[1], b[1]) && floatEqual(a[2], b[2])
+}
+
+func floatEqual(a, b float32) bool {
+	return mgl32.Abs(a-b) <= diffEpsilon
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// CellOverlay selects the unit cell edges drawn over the lattice.
+type CellOverlay int
+
+const (
+	// NoCellOverlay draws no edges.
+	NoCellOverlay CellOverlay = iota
+	// UnitCellOverlay draws the edges of the unit cell at the origin.
+	UnitCellOverlay
+	// SupercellOverlay draws the edges of every unit cell of the lattice,
+	// the boundaries at which it repeats, with the unit cell highlighted.
+	SupercellOverlay
+)
+
+var cellOverlayNames = []string{"off", "unit", "super"}
+
+func (o CellOverlay) String() string {
+	return cellOverlayNames[o]
+}
+
+// Set implements flag.Value.
+func (o *CellOverlay) Set(name string) error {
+	overlay, ok := ParseCellOverlay(name)
+	if !ok {
+		return fmt.Errorf("unknown cell overlay %q", name)
+	}
+	*o = overlay
+	return nil
+}
+
+// ParseCellOverlay returns the cell overlay with the given name.
+func ParseCellOverlay(name string) (CellOverlay, bool) {
+	for i, n := range cellOverlayNames {
+		if n == name {
+			return CellOverlay(i), true
+		}
+	}
+	return NoCellOverlay, false
+}
+
+// next returns the overlay after o, wrapping around.
+func (o CellOverlay) next() CellOverlay {
+	return (o + 1) % CellOverlay(len(cellOverlayNames))
+}
+
+// cellFrame is a block of count unit cells in the world: origin is the
+// corner of the first one and axes are the edges of a cell. home is the
+// index of the unit cell to highlight.
+type cellFrame struct {
+	origin mgl32.Vec3
+	axes   [3]mgl32.Vec3
+	count  [3]int
+	home   [3]int
+}
+
+// empty reports whether the frame has no cells.
+func (f cellFrame) empty() bool {
+	return f.count[0] == 0 || f.count[1] == 0 || f.count[2] == 0
+}
+
+// unit returns the home cell of f.
+func (f cellFrame) unit() cellFrame {
+	for axis, i := range f.home {
+		f.origin = f.origin.Add(f.axes[axis].Mul(float32(i)))
+	}
+	f.count, f.home = [3]int{1, 1, 1}, [3]int{}
+	return f
+}
+
+// cellFrame returns the unit cells the lattice of p repeats: the supercell
+// of an imported crystal, homed at its first cell, or the whole unit cells
+// of the lattice type within the generated lattice, at least the one at
+// the origin, which is its home. Other imported structures have no unit
+// cell and return an empty frame.
+func (p LatticeParams) cellFrame() cellFrame {
+	if !p.crystal.empty() {
+		return p.crystal
+	}
+	if p.Scale != 0 && p.Scale != 1 {
+		return cellFrame{}
+	}
+	var f cellFrame
+	var first mgl32.Vec3
+	for axis := range f.axes {
+		size := unitCells[p.Type].size[axis]
+		var edge mgl32.Vec3
+		edge[axis] = float32(size)
+		f.axes[axis] = p.World(edge)
+
+		lo := -floorDiv(p.HalfSize, size)
+		f.count[axis] = floorDiv(p.HalfSize, size) - lo
+		if f.count[axis] < 1 {
+			lo, f.count[axis] = 0, 1
+		}
+		f.home[axis] = -lo
+		first[axis] = float32(lo * size)
+	}
+	f.origin = p.World(first)
+	return f
+}
+
+// lines returns the line vertices of the edges of every cell of f.
+func (f cellFrame) lines() []float32 {
+	var verts []float32
+	for axis := range f.axes {
+		u, v := (axis+1)%3, (axis+2)%3
+		for i := 0; i <= f.count[u]; i++ {
+			for j := 0; j <= f.count[v]; j++ {
+				start := f.origin.Add(f.axes[u].Mul(float32(i))).Add(f.axes[v].Mul(float32(j)))
+				end := start.Add(f.axes[axis].Mul(float32(f.count[axis])))
+				verts = append(verts, start[0], start[1], start[2], end[0], end[1], end[2])
+			}
+		}
+	}
+	return verts
+}
+
+// Colors of the cell overlay.
+var (
+	unitCellColor  = mgl32.Vec3{1, 0.8, 0.2}
+	supercellColor = mgl32.Vec3{0.45, 0.45, 0.5}
+)
+
+// cellFrameRenderer draws the edges of unit cells as lines over the
+// lattice, without depth testing so that the boundaries inside it show.
+type cellFrameRenderer struct {
+	program uint32
+	vao     uint32
+	vbo     uint32
+
+	projectionUniform int32
+	cameraUniform     int32
+	colorUniform      int32
+}
+
+func newCellFrameRenderer() (*cellFrameRenderer, error) {
+	program, err := glutil.NewProgram(cellFrameVertexShader, cellFrameFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	c := &cellFrameRenderer{
+		program:           program,
+		projectionUniform: gl.GetUniformLocation(program, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(program, gl.Str("camera\x00")),
+		colorUniform:      gl.GetUniformLocation(program, gl.Str("color\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &c.vao)
+	gl.BindVertexArray(c.vao)
+	gl.GenBuffers(1, &c.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, c.vbo)
+	vert := uint32(gl.GetAttribLocation(program, gl.Str("vert\x00")))
+	gl.EnableVertexAttribArray(vert)
+	gl.VertexAttribPointerWithOffset(vert, 3, gl.FLOAT, false, 3*4, 0)
+	gl.BindVertexArray(uint32(prevVAO))
+	return c, nil
+}
+
+// Render draws the overlay o of the unit cells of f as seen through
+// projection and camera.
+func (c *cellFrameRenderer) Render(projection, camera mgl32.Mat4, f cellFrame, o CellOverlay) {
+	if o == NoCellOverlay || f.empty() {
+		return
+	}
+	unit := f.unit().lines()
+	verts := unit
+	if o == SupercellOverlay {
+		verts = append(f.lines(), unit...)
+	}
+	defer c.bind(projection, camera, verts)()
+	superCount := int32((len(verts) - len(unit)) / 3)
+	if superCount > 0 {
+		gl.Uniform3fv(c.colorUniform, 1, &supercellColor[0])
+		gl.DrawArrays(gl.LINES, 0, superCount)
+	}
+	gl.Uniform3fv(c.colorUniform, 1, &unitCellColor[0])
+	gl.DrawArrays(gl.LINES, superCount, int32(len(unit)/3))
+}
+
+// RenderBox draws the edges of the cells of f in color.
+func (c *cellFrameRenderer) RenderBox(projection, camera mgl32.Mat4, f cellFrame, color mgl32.Vec3) {
+	verts := f.lines()
+	defer c.bind(projection, camera, verts)()
+	gl.Uniform3fv(c.colorUniform, 1, &color[0])
+	gl.DrawArrays(gl.LINES, 0, int32(len(verts)/3))
+}
+
+// bind prepares drawing the line vertices verts as seen through projection
+// and camera. The returned function restores the previous state.
+func (c *cellFrameRenderer) bind(projection, camera mgl32.Mat4, verts []float32) func() {
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.UseProgram(c.program)
+	gl.UniformMatrix4fv(c.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(c.cameraUniform, 1, false, &camera[0])
+	gl.BindVertexArray(c.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, c.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(verts)*4, gl.Ptr(verts), gl.STREAM_DRAW)
+	return func() {
+		gl.Enable(gl.DEPTH_TEST)
+		gl.BindVertexArray(uint32(prevVAO))
+		gl.UseProgram(uint32(prevProgram))
+	}
+}
+
+// Delete releases the GL objects of the renderer.
+func (c *cellFrameRenderer) Delete() {
+	gl.DeleteBuffers(1, &c.vbo)
+	gl.DeleteVertexArrays(1, &c.vao)
+	gl.DeleteProgram(c.program)
+}
+
+// SetCellOverlay draws the unit cell edges o over the lattice.
+func (r *Renderer) SetCellOverlay(o CellOverlay) error {
+	if o != NoCellOverlay && r.cellFrames == nil {
+		c, err := newCellFrameRenderer()
+		if err != nil {
+			return err
+		}
+		r.cellFrames = c
+	}
+	r.cellOverlay = o
+	if o != NoCellOverlay && r.params.cellFrame().empty() {
+		r.Notify(MsgNoUnitCell)
+		return nil
+	}
+	r.Notify(MsgCellOverlay, o)
+	return nil
+}
+
+// CycleCellOverlay switches to the next cell overlay.
+func (r *Renderer) CycleCellOverlay() {
+	if err := r.SetCellOverlay(r.cellOverlay.next()); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+var cellFrameVertexShader = `
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+
+in vec3 vert;
+
+void main() {
+    gl_Position = projection * camera * vec4(vert, 1);
+}
+` + "\x00"
+
+var cellFrameFragmentShader = `
+#version 330
+
+uniform vec3 color;
+
+out vec4 outputColor;
+
+void main() {
+    outputColor = vec4(color, 1);
+}
+` + "\x00"
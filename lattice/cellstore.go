@@ -0,0 +1,326 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// cellsPerChunk is the number of lattice positions in a chunk.
+const cellsPerChunk = chunkSize * chunkSize * chunkSize
+
+// noCellData marks the runs of positions without a cell.
+const noCellData = math.MaxUint16
+
+// cellData is what a cell stores besides its position, from which its ID
+// follows.
+type cellData struct {
+	color mgl32.Vec3
+	value float32
+	size  float32
+}
+
+// cellRun repeats one palette entry over consecutive positions of a chunk.
+type cellRun struct {
+	length uint16
+	index  uint16
+}
+
+// packedChunk holds the cells of a chunk as runs of indices into a palette
+// of distinct cell data, in x, y, z order. Uniform chunks shrink to a
+// single run.
+type packedChunk struct {
+	palette []cellData
+	runs    []cellRun
+	count   int
+}
+
+// cellStore keeps the cells of a lattice compressed by chunk. The mesh
+// unpacks one chunk at a time, so only the packed cells stay in memory.
+type cellStore struct {
+	params LatticeParams
+	keys   []chunkKey
+	chunks map[chunkKey]*packedChunk
+	cells  int
+	// packed is the approximate size of the chunks in bytes.
+	packed int
+	// subgrids are the refined cells by ID, and extra the instances their
+	// sub-cells add to every chunk beyond one per cell.
+	subgrids map[uint32]*subgrid
+	extra    map[chunkKey]int
+	// generated is set for the cells of a generated lattice, whose color
+	// gradient cycles over time, and the stores edited from them.
+	generated bool
+}
+
+// newCellStore packs cells, whose IDs must follow from their positions
+// within p. Sub-cells refine the cells they are in.
+func newCellStore(cells []cell, p LatticeParams) *cellStore {
+	cells, subgrids := splitSubcells(cells)
+	byChunk := make(map[chunkKey][]cell)
+	for _, c := range cells {
+		k := chunkOf(c)
+		byChunk[k] = append(byChunk[k], c)
+	}
+	s := &cellStore{params: p, chunks: make(map[chunkKey]*packedChunk, len(byChunk))}
+	for k, cells := range byChunk {
+		s.add(k, cells)
+	}
+	s.sortKeys()
+	s.setSubgrids(subgrids)
+	return s
+}
+
+// generateStore lays out the lattice cells described by p, one chunk at a
+// time so the unpacked lattice never has to fit in memory.
+func generateStore(p LatticeParams) *cellStore {
+	g := newCellGenerator(p)
+	s := &cellStore{params: p, chunks: make(map[chunkKey]*packedChunk), generated: true}
+	d := p.HalfSize
+	lo, hi := floorDiv(-d, chunkSize), floorDiv(d, chunkSize)
+	cells := make([]cell, 0, cellsPerChunk)
+	for kx := lo; kx <= hi; kx++ {
+		for ky := lo; ky <= hi; ky++ {
+			for kz := lo; kz <= hi; kz++ {
+				k := chunkKey{kx, ky, kz}
+				cells = cells[:0]
+				for i := 0; i < cellsPerChunk; i++ {
+					x, y, z := k.pos(i)
+					if abs(x) <= d && abs(y) <= d && abs(z) <= d && p.Type.site(x, y, z) {
+						cells = append(cells, g.at(x, y, z))
+					}
+				}
+				s.add(k, cells)
+			}
+		}
+	}
+	s.sortKeys()
+	return s
+}
+
+func floorDiv(a, b int) int {
+	return int(math.Floor(float64(a) / float64(b)))
+}
+
+// pos returns the lattice coordinates of position i of chunk k.
+func (k chunkKey) pos(i int) (x, y, z int) {
+	return k[0]*chunkSize + i/(chunkSize*chunkSize),
+		k[1]*chunkSize + i/chunkSize%chunkSize,
+		k[2]*chunkSize + i%chunkSize
+}
+
+// chunkIndex returns the position of c within its chunk.
+func chunkIndex(c cell) int {
+	p := gridPos(c)
+	for i := range p {
+		p[i] -= floorDiv(p[i], chunkSize) * chunkSize
+	}
+	return (p[0]*chunkSize+p[1])*chunkSize + p[2]
+}
+
+// add packs the cells of chunk k.
+func (s *cellStore) add(k chunkKey, cells []cell) {
+	if len(cells) == 0 {
+		return
+	}
+	var slots [cellsPerChunk]uint16
+	for i := range slots {
+		slots[i] = noCellData
+	}
+	ch := &packedChunk{count: len(cells)}
+	palette := make(map[cellData]uint16)
+	for _, c := range cells {
+		data := cellData{c.color, c.value, c.size}
+		index, ok := palette[data]
+		if !ok {
+			index = uint16(len(ch.palette))
+			palette[data] = index
+			ch.palette = append(ch.palette, data)
+		}
+		slots[chunkIndex(c)] = index
+	}
+	for i, index := range slots {
+		if i > 0 && ch.runs[len(ch.runs)-1].index == index {
+			ch.runs[len(ch.runs)-1].length++
+			continue
+		}
+		ch.runs = append(ch.runs, cellRun{length: 1, index: index})
+	}
+	s.keys = append(s.keys, k)
+	s.chunks[k] = ch
+	s.cells += len(cells)
+	s.packed += ch.bytes()
+}
+
+// bytes returns the approximate size of ch and its key in bytes.
+func (ch *packedChunk) bytes() int {
+	return int(unsafe.Sizeof(*ch)+unsafe.Sizeof(chunkKey{})) +
+		len(ch.palette)*int(unsafe.Sizeof(cellData{})) +
+		len(ch.runs)*int(unsafe.Sizeof(cellRun{}))
+}
+
+// withChunk returns a store of the cells of s with those of chunk k
+// replaced by cells. The other chunks are shared with s.
+func (s *cellStore) withChunk(k chunkKey, cells []cell) *cellStore {
+	n := &cellStore{params: s.params, chunks: make(map[chunkKey]*packedChunk, len(s.chunks)+1), generated: s.generated}
+	for key, ch := range s.chunks {
+		if key == k {
+			continue
+		}
+		n.keys = append(n.keys, key)
+		n.chunks[key] = ch
+		n.cells += ch.count
+		n.packed += ch.bytes()
+	}
+	n.add(k, cells)
+	n.sortKeys()
+	if s.subgrids != nil {
+		// Refined cells removed from chunk k lose their sub-cells.
+		present := make(map[uint32]bool, len(cells))
+		for _, c := range cells {
+			present[c.id] = true
+		}
+		subgrids := make(map[uint32]*subgrid, len(s.subgrids))
+		for id, g := range s.subgrids {
+			if ck, _ := s.chunkOfID(id); ck != k || present[id] {
+				subgrids[id] = g
+			}
+		}
+		n.setSubgrids(subgrids)
+	}
+	return n
+}
+
+func (s *cellStore) sortKeys() {
+	sort.Slice(s.keys, func(i, j int) bool { return s.keys[i].less(s.keys[j]) })
+}
+
+// changed returns the chunks whose cells differ between s and o, which
+// must be in the same lattice.
+func (s *cellStore) changed(o *cellStore) chunkSet {
+	set := make(chunkSet)
+	for k, ch := range s.chunks {
+		if !ch.equal(o.chunks[k]) {
+			set[k] = true
+		}
+	}
+	for k := range o.chunks {
+		if s.chunks[k] == nil {
+			set[k] = true
+		}
+	}
+	for _, pair := range [][2]*cellStore{{s, o}, {o, s}} {
+		for id, g := range pair[0].subgrids {
+			if !g.equal(pair[1].subgrids[id]) {
+				if k, ok := s.chunkOfID(id); ok {
+					set[k] = true
+				}
+			}
+		}
+	}
+	return set
+}
+
+// equal reports whether ch and o hold the same cells. Their palettes may
+// list the cell data in different orders.
+func (ch *packedChunk) equal(o *packedChunk) bool {
+	if o == nil || ch.count != o.count || len(ch.runs) != len(o.runs) {
+		return false
+	}
+	for i, run := range ch.runs {
+		other := o.runs[i]
+		if run.length != other.length || (run.index == noCellData) != (other.index == noCellData) {
+			return false
+		}
+		if run.index != noCellData && ch.palette[run.index] != o.palette[other.index] {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns the number of cells.
+func (s *cellStore) count() int {
+	return s.cells
+}
+
+// chunkCount returns the number of cells in chunk k.
+func (s *cellStore) chunkCount(k chunkKey) int {
+	if ch := s.chunks[k]; ch != nil {
+		return ch.count
+	}
+	return 0
+}
+
+// chunkOfID returns the chunk that would hold the cell id.
+func (s *cellStore) chunkOfID(id uint32) (chunkKey, bool) {
+	x, y, z, ok := s.params.CellPos(id)
+	if !ok {
+		return chunkKey{}, false
+	}
+	return chunkOf(cell{pos: mgl32.Vec3{float32(x), float32(y), float32(z)}}), true
+}
+
+// find returns the cell id, and false if there is none.
+func (s *cellStore) find(id uint32) (cell, bool) {
+	k, ok := s.chunkOfID(id)
+	if !ok {
+		return cell{}, false
+	}
+	for _, c := range s.chunk(k) {
+		if c.id == id {
+			return c, true
+		}
+	}
+	return cell{}, false
+}
+
+// chunk unpacks the cells of chunk k in x, y, z order.
+func (s *cellStore) chunk(k chunkKey) []cell {
+	ch := s.chunks[k]
+	if ch == nil {
+		return nil
+	}
+	cells := make([]cell, 0, ch.count)
+	i := 0
+	for _, run := range ch.runs {
+		if run.index == noCellData {
+			i += int(run.length)
+			continue
+		}
+		data := ch.palette[run.index]
+		for end := i + int(run.length); i < end; i++ {
+			x, y, z := k.pos(i)
+			id, _ := s.params.CellID(x, y, z)
+			cells = append(cells, cell{
+				id:    id,
+				pos:   mgl32.Vec3{float32(x), float32(y), float32(z)},
+				color: data.color,
+				value: data.value,
+				size:  data.size,
+			})
+		}
+	}
+	return cells
+}
+
+// unpack returns all cells, chunk by chunk.
+func (s *cellStore) unpack() []cell {
+	cells := make([]cell, 0, s.cells)
+	for _, k := range s.keys {
+		cells = append(cells, s.chunk(k)...)
+	}
+	return cells
+}
+
+// memory returns the approximate bytes taken by the packed cells and by
+// the same cells unpacked.
+func (s *cellStore) memory() (packed, unpacked int) {
+	return s.packed, s.cells * int(unsafe.Sizeof(cell{}))
+}
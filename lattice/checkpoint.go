@@ -0,0 +1,81 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointJSON is a checkpoint of a time-lapse: the step the simulation
+// reached, with a copy of its state file next to it. The state file must
+// hold the whole state of the simulation for it to resume.
+type checkpointJSON struct {
+	Time  time.Time `json:"time"`
+	Step  int       `json:"step"`
+	Steps int       `json:"steps"`
+	// State is the name of the copy of the state file, relative to the
+	// checkpoint file.
+	State string `json:"state"`
+}
+
+// checkpointName returns the name of the checkpoint files of step, without
+// the extension, so that they list in order of steps.
+func checkpointName(step int) string {
+	return fmt.Sprintf("step%08d", step)
+}
+
+// saveCheckpoint copies the state file of tl to the checkpoint directory
+// and records that step was reached. It returns the path of the
+// checkpoint file.
+func saveCheckpoint(tl TimeLapse, step int) (string, error) {
+	if err := os.MkdirAll(tl.Checkpoints, 0755); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(tl.State)
+	if err != nil {
+		return "", err
+	}
+	name := checkpointName(step)
+	state := name + filepath.Ext(tl.State)
+	err = replaceFile(filepath.Join(tl.Checkpoints, state), func(path string) error {
+		return os.WriteFile(path, data, 0644)
+	})
+	if err != nil {
+		return "", err
+	}
+	cp, err := json.MarshalIndent(checkpointJSON{Time: time.Now(), Step: step, Steps: tl.Steps, State: state}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(tl.Checkpoints, name+".json")
+	return path, replaceFile(path, func(path string) error {
+		return os.WriteFile(path, cp, 0644)
+	})
+}
+
+// resumeCheckpoint restores the state file of tl from the checkpoint file
+// path and returns the step it was taken at.
+func resumeCheckpoint(tl TimeLapse, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var cp checkpointJSON
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return 0, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	state, err := os.ReadFile(filepath.Join(filepath.Dir(path), cp.State))
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(tl.State, state, 0644); err != nil {
+		return 0, err
+	}
+	return cp.Step, nil
+}
@@ -0,0 +1,748 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// defaultSupercell is the default Options.Supercell.
+var defaultSupercell = [3]int{2, 2, 2}
+
+// cifGridSteps is the number of grid positions per world unit that the
+// atoms of a structure are placed on.
+const cifGridSteps = 4
+
+// cifSpacing is the world distance between the two closest atoms of a
+// structure. At √3 the unit cubes of two atoms never overlap.
+var cifSpacing = math.Sqrt(3)
+
+// cifSymmetryTolerance is the largest difference of fractional coordinates
+// under which two symmetry equivalent atoms are the same.
+const cifSymmetryTolerance = 1e-3
+
+// cifStructure is a crystal structure read from a CIF file: the unit cell,
+// the atoms of its asymmetric unit and the symmetry operations generating
+// the others.
+type cifStructure struct {
+	lengths [3]float64
+	// angles are alpha, beta and gamma in degrees.
+	angles [3]float64
+	atoms  []cifAtom
+	ops    []symOp
+}
+
+type cifAtom struct {
+	element string
+	frac    [3]float64
+}
+
+// symOp maps fractional coordinates f to rot·f + trans.
+type symOp struct {
+	rot   [3][3]float64
+	trans [3]float64
+}
+
+func (op symOp) apply(f [3]float64) [3]float64 {
+	var out [3]float64
+	for i := range out {
+		out[i] = op.trans[i]
+		for j := range f {
+			out[i] += op.rot[i][j] * f[j]
+		}
+	}
+	return out
+}
+
+// crystal is a crystal structure expanded into a supercell, kept so that
+// the supercell can be resized.
+type crystal struct {
+	structure *cifStructure
+	// first is the first unit cell of the supercell along a, b and c, and
+	// size the number of unit cells.
+	first, size [3]int
+	// center is the point, in fractional coordinates, placed at the origin.
+	// It stays put as the supercell is resized, so the unit cells in both
+	// supercells keep their positions.
+	center [3]float64
+}
+
+// LoadCIF reads the crystal structure of a CIF file and expands it into a
+// supercell of the given number of unit cells along a, b and c, centered
+// on the origin.
+func LoadCIF(path string, supercell [3]int) (*crystal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := parseCIF(f, path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := data.structure()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	c := &crystal{structure: s}
+	for i, n := range supercell {
+		if n < 1 {
+			n = 1
+		}
+		c.center[i] = float64(n) / 2
+	}
+	return c.resized(supercell), nil
+}
+
+// resized returns c with a supercell of the given number of unit cells
+// along a, b and c, as evenly around the center as whole cells allow.
+func (c *crystal) resized(supercell [3]int) *crystal {
+	resized := *c
+	for i, n := range supercell {
+		if n < 1 {
+			n = 1
+		}
+		resized.first[i] = int(math.Floor(c.center[i] - float64(n)/2 + 0.5))
+		resized.size[i] = n
+	}
+	return &resized
+}
+
+// SetSupercell resizes the supercell of the loaded crystal to the given
+// number of unit cells along a, b and c. The unit cells in both supercells
+// stay where they are, so unless the lattice has to grow to hold the new
+// cells or their look depends on all of them, only the chunks of the mesh gaining or
+// losing atoms and their neighbors are re-meshed.
+func (r *Renderer) SetSupercell(supercell [3]int) error {
+	if r.crystal == nil {
+		return r.locale.Errorf(MsgNoCrystal)
+	}
+	c := r.crystal.resized(supercell)
+	cells, params, err := c.cells(r.params)
+	if err != nil {
+		return r.locale.Errorf(MsgLatticeTooLarge, params.Side(), 2*MaxHalfSize+1)
+	}
+	if params.HalfSize > r.params.HalfSize {
+		// Room for a chunk more on every side spares the next resizes
+		// renumbering every cell, where the lattice has room for it.
+		params.HalfSize = (params.HalfSize/chunkSize + 1) * chunkSize
+		if params.HalfSize > MaxHalfSize {
+			params.HalfSize = MaxHalfSize
+		}
+	} else {
+		params.HalfSize = r.params.HalfSize
+	}
+	assignIDs(cells, params)
+	s := newCellStore(cells, params)
+	// Looks normalized over all cells change with the supercell.
+	if params.HalfSize != r.params.HalfSize || r.lookOfAll() {
+		r.setStore(s)
+	} else {
+		changed := r.cells.changed(s)
+		r.cells, r.params = s, params
+		r.pristine = nil
+		r.mesh.Update(s, r.groups, changed)
+		r.meshChanged()
+	}
+	r.crystal = c
+	r.Notify(MsgSupercell, c.size[0], c.size[1], c.size[2], s.count())
+	return nil
+}
+
+// GrowSupercell adds d unit cells to the supercell of the loaded crystal
+// along every axis, or removes them if d is negative.
+func (r *Renderer) GrowSupercell(d int) {
+	var size [3]int
+	if r.crystal != nil {
+		size = r.crystal.size
+	}
+	for i := range size {
+		size[i] += d
+	}
+	if err := r.SetSupercell(size); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+// initialStore returns the cells shown at startup: the watched lattice
+// file of opts, the structure of its CIF file or its molecule if there is
+// one, the lattice of opts otherwise. The crystal is that of the CIF file.
+func initialStore(opts Options) (*cellStore, *crystal, error) {
+	var (
+		cells []cell
+		p     LatticeParams
+		c     *crystal
+		err   error
+	)
+	switch {
+	case opts.Watch != "":
+		cells, p, err = LoadCells(opts.Watch, opts.Lattice)
+	case opts.CIF != "":
+		if c, err = LoadCIF(opts.CIF, opts.Supercell); err == nil {
+			cells, p, err = c.cells(opts.Lattice)
+		}
+	case opts.Molecule != "":
+		cells, p, err = LoadMolecule(opts.Molecule, opts.Lattice)
+	default:
+		return generateStore(opts.Lattice), nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return newCellStore(cells, p), c, nil
+}
+
+// cifData holds the items of the first data block of a CIF file, tags
+// lowercased.
+type cifData struct {
+	items map[string]string
+	loops []cifLoop
+}
+
+type cifLoop struct {
+	tags []string
+	// values holds the rows of the loop one after the other.
+	values []string
+}
+
+// column returns the values of the first of tags found in the loop, or nil.
+func (l *cifLoop) column(tags ...string) []string {
+	for _, tag := range tags {
+		for i, t := range l.tags {
+			if t != tag {
+				continue
+			}
+			var col []string
+			for j := i; j < len(l.values); j += len(l.tags) {
+				col = append(col, l.values[j])
+			}
+			return col
+		}
+	}
+	return nil
+}
+
+// find returns the first loop with one of tags.
+func (d *cifData) find(tags ...string) *cifLoop {
+	for i := range d.loops {
+		if d.loops[i].column(tags...) != nil {
+			return &d.loops[i]
+		}
+	}
+	return nil
+}
+
+type cifToken struct {
+	text string
+	// value is set for quoted strings and text fields, which are never
+	// tags or keywords.
+	value bool
+	line  int
+}
+
+// parseCIF reads the first data block of a CIF file. Values keep their
+// standard uncertainties, such as the (3) of 1.234(3).
+func parseCIF(f io.Reader, path string) (*cifData, error) {
+	tokens, err := tokenizeCIF(f, path)
+	if err != nil {
+		return nil, err
+	}
+	d := &cifData{items: make(map[string]string)}
+	isTag := func(t cifToken) bool { return !t.value && strings.HasPrefix(t.text, "_") }
+	isKeyword := func(t cifToken, word string) bool {
+		return !t.value && strings.HasPrefix(strings.ToLower(t.text), word)
+	}
+	blocks := 0
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case isKeyword(t, "data_"):
+			blocks++
+			if blocks > 1 {
+				return d, nil
+			}
+			i++
+		case isKeyword(t, "loop_"):
+			i++
+			var l cifLoop
+			for i < len(tokens) && isTag(tokens[i]) {
+				l.tags = append(l.tags, strings.ToLower(tokens[i].text))
+				i++
+			}
+			for i < len(tokens) && !isTag(tokens[i]) &&
+				!isKeyword(tokens[i], "loop_") && !isKeyword(tokens[i], "data_") {
+				l.values = append(l.values, tokens[i].text)
+				i++
+			}
+			if len(l.tags) == 0 || len(l.values)%len(l.tags) != 0 {
+				return nil, fmt.Errorf("%v:%v: loop values do not fill its rows", path, t.line)
+			}
+			d.loops = append(d.loops, l)
+		case isTag(t):
+			if i+1 >= len(tokens) || isTag(tokens[i+1]) {
+				return nil, fmt.Errorf("%v:%v: %v has no value", path, t.line, t.text)
+			}
+			d.items[strings.ToLower(t.text)] = tokens[i+1].text
+			i += 2
+		default:
+			// Global blocks, save frames and stray values are skipped.
+			i++
+		}
+	}
+	if blocks == 0 {
+		return nil, fmt.Errorf("%v: no data block", path)
+	}
+	return d, nil
+}
+
+// tokenizeCIF splits a CIF file into tags, keywords and values.
+func tokenizeCIF(f io.Reader, path string) ([]cifToken, error) {
+	var tokens []cifToken
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	var text *strings.Builder
+	textLine := 0
+	for line := 1; scanner.Scan(); line++ {
+		s := scanner.Text()
+		// Text fields run between lines starting with a semicolon.
+		if strings.HasPrefix(s, ";") {
+			if text == nil {
+				text = &strings.Builder{}
+				text.WriteString(s[1:])
+				textLine = line
+			} else {
+				tokens = append(tokens, cifToken{text: text.String(), value: true, line: textLine})
+				text = nil
+			}
+			continue
+		}
+		if text != nil {
+			text.WriteString("\n" + s)
+			continue
+		}
+
+		for i := 0; i < len(s); {
+			c := s[i]
+			switch {
+			case c == ' ' || c == '\t':
+				i++
+			case c == '#':
+				i = len(s)
+			case c == '\'' || c == '"':
+				// A quote only closes the string when followed by
+				// whitespace or the end of the line.
+				end := i + 1
+				for end < len(s) && !(s[end] == c && (end+1 == len(s) || s[end+1] == ' ' || s[end+1] == '\t')) {
+					end++
+				}
+				if end == len(s) {
+					return nil, fmt.Errorf("%v:%v: unterminated string", path, line)
+				}
+				tokens = append(tokens, cifToken{text: s[i+1 : end], value: true, line: line})
+				i = end + 1
+			default:
+				end := i
+				for end < len(s) && s[end] != ' ' && s[end] != '\t' {
+					end++
+				}
+				tokens = append(tokens, cifToken{text: s[i:end], line: line})
+				i = end
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if text != nil {
+		return nil, fmt.Errorf("%v:%v: unterminated text field", path, textLine)
+	}
+	return tokens, nil
+}
+
+// cifNumber parses a numeric value, dropping its standard uncertainty.
+func cifNumber(s string) (float64, error) {
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// structure extracts the unit cell, atoms and symmetry operations.
+func (d *cifData) structure() (*cifStructure, error) {
+	s := &cifStructure{}
+	for i, axis := range []string{"a", "b", "c"} {
+		tag := "_cell_length_" + axis
+		v, ok := d.items[tag]
+		if !ok {
+			return nil, fmt.Errorf("missing %v", tag)
+		}
+		var err error
+		if s.lengths[i], err = cifNumber(v); err != nil || s.lengths[i] <= 0 {
+			return nil, fmt.Errorf("invalid %v %q", tag, v)
+		}
+	}
+	for i, angle := range []string{"alpha", "beta", "gamma"} {
+		tag := "_cell_angle_" + angle
+		s.angles[i] = 90
+		if v, ok := d.items[tag]; ok {
+			var err error
+			if s.angles[i], err = cifNumber(v); err != nil || s.angles[i] <= 0 || s.angles[i] >= 180 {
+				return nil, fmt.Errorf("invalid %v %q", tag, v)
+			}
+		}
+	}
+
+	atoms := d.find("_atom_site_fract_x")
+	if atoms == nil {
+		return nil, fmt.Errorf("no _atom_site_fract_x loop")
+	}
+	var coords [3][]string
+	for i, axis := range []string{"x", "y", "z"} {
+		if coords[i] = atoms.column("_atom_site_fract_" + axis); coords[i] == nil {
+			return nil, fmt.Errorf("missing _atom_site_fract_%v", axis)
+		}
+	}
+	elements := atoms.column("_atom_site_type_symbol", "_atom_site_label")
+	if elements == nil {
+		return nil, fmt.Errorf("missing _atom_site_type_symbol or _atom_site_label")
+	}
+	for i := range elements {
+		a := cifAtom{element: elementSymbol(elements[i])}
+		for axis := range a.frac {
+			v, err := cifNumber(coords[axis][i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid coordinate %q of atom %v", coords[axis][i], i+1)
+			}
+			a.frac[axis] = v
+		}
+		s.atoms = append(s.atoms, a)
+	}
+
+	opTags := []string{"_space_group_symop_operation_xyz", "_symmetry_equiv_pos_as_xyz"}
+	if ops := d.find(opTags...); ops != nil {
+		for _, text := range ops.column(opTags...) {
+			op, err := parseSymOp(text)
+			if err != nil {
+				return nil, err
+			}
+			s.ops = append(s.ops, op)
+		}
+	}
+	if len(s.ops) == 0 {
+		s.ops = []symOp{{rot: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}}
+	}
+	return s, nil
+}
+
+// parseSymOp parses a symmetry operation such as -x+1/2,y,-z+1/2.
+func parseSymOp(text string) (symOp, error) {
+	var op symOp
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(text, " ", "")), ",")
+	if len(parts) != 3 {
+		return op, fmt.Errorf("invalid symmetry operation %q", text)
+	}
+	for i, part := range parts {
+		if part == "" {
+			return op, fmt.Errorf("invalid symmetry operation %q", text)
+		}
+		for len(part) > 0 {
+			sign := 1.0
+			switch part[0] {
+			case '-':
+				sign = -1
+				fallthrough
+			case '+':
+				part = part[1:]
+			}
+			end := strings.IndexAny(part, "+-")
+			if end < 0 {
+				end = len(part)
+			}
+			term := part[:end]
+			part = part[end:]
+			axis := strings.IndexAny(term, "xyz")
+			if axis >= 0 {
+				coef := 1.0
+				if prefix := strings.TrimSuffix(term[:axis], "*"); prefix != "" {
+					v, err := parseFraction(prefix)
+					if err != nil {
+						return op, fmt.Errorf("invalid symmetry operation %q", text)
+					}
+					coef = v
+				}
+				op.rot[i][term[axis]-'x'] += sign * coef
+				continue
+			}
+			v, err := parseFraction(term)
+			if err != nil {
+				return op, fmt.Errorf("invalid symmetry operation %q", text)
+			}
+			op.trans[i] += sign * v
+		}
+	}
+	return op, nil
+}
+
+// parseFraction parses a number such as 0.25 or 1/4.
+func parseFraction(s string) (float64, error) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, err
+		}
+		d, err := strconv.ParseFloat(s[i+1:], 64)
+		if err != nil || d == 0 {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+		return n / d, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// unitCell returns the atoms of the whole unit cell, with fractional
+// coordinates in [0, 1).
+func (s *cifStructure) unitCell() []cifAtom {
+	var out []cifAtom
+	wrap := func(v float64) float64 {
+		v -= math.Floor(v)
+		if v > 1-cifSymmetryTolerance {
+			v = 0
+		}
+		return v
+	}
+	for _, a := range s.atoms {
+	ops:
+		for _, op := range s.ops {
+			f := op.apply(a.frac)
+			for i := range f {
+				f[i] = wrap(f[i])
+			}
+			for _, o := range out {
+				same := true
+				for i := range f {
+					d := math.Abs(f[i] - o.frac[i])
+					if math.Min(d, 1-d) > cifSymmetryTolerance {
+						same = false
+					}
+				}
+				if same {
+					continue ops
+				}
+			}
+			out = append(out, cifAtom{element: a.element, frac: f})
+		}
+	}
+	return out
+}
+
+// basis returns the Cartesian cell vectors, a along x and b in the xy
+// plane.
+func (s *cifStructure) basis() [3][3]float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	ca, cb, cg := math.Cos(rad(s.angles[0])), math.Cos(rad(s.angles[1])), math.Cos(rad(s.angles[2]))
+	sg := math.Sin(rad(s.angles[2]))
+	a, b, c := s.lengths[0], s.lengths[1], s.lengths[2]
+	cy := (ca - cb*cg) / sg
+	cz := math.Sqrt(math.Max(0, 1-cb*cb-cy*cy))
+	return [3][3]float64{
+		{a, 0, 0},
+		{b * cg, b * sg, 0},
+		{c * cb, c * cy, c * cz},
+	}
+}
+
+// cartesian returns the Cartesian position of the fractional coordinates
+// f in basis.
+func cartesian(basis [3][3]float64, f [3]float64) [3]float64 {
+	var p [3]float64
+	for i := range basis {
+		for j := range p {
+			p[j] += f[i] * basis[i][j]
+		}
+	}
+	return p
+}
+
+// minDistance returns the smallest distance between two atoms of the
+// periodic structure.
+func minDistance(basis [3][3]float64, atoms []cifAtom) float64 {
+	min := math.Inf(1)
+	for i, a := range atoms {
+		for _, b := range atoms[i:] {
+			for n := 0; n < 27; n++ {
+				shift := [3]float64{float64(n%3 - 1), float64(n/3%3 - 1), float64(n/9 - 1)}
+				var f [3]float64
+				for k := range f {
+					f[k] = b.frac[k] + shift[k] - a.frac[k]
+				}
+				p := cartesian(basis, f)
+				if d := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2]); d > 1e-6 && d < min {
+					min = d
+				}
+			}
+		}
+	}
+	return min
+}
+
+// cells places the atoms of the supercell of c on the grid, with the
+// center of c at the origin, scaled so that the closest atoms are
+// cifSpacing apart. The c axis of the crystal points up, along y. Atoms are
+// colored by element, with the atomic number as their value. It fails if
+// the supercell does not fit the largest lattice.
+func (c *crystal) cells(p LatticeParams) ([]cell, LatticeParams, error) {
+	s := c.structure
+	basis := s.basis()
+	atoms := s.unitCell()
+	scale := cifSpacing / minDistance(basis, atoms)
+	if math.IsInf(scale, 0) || scale == 0 {
+		scale = 1
+	}
+	center := cartesian(basis, c.center)
+	first := cartesian(basis, [3]float64{float64(c.first[0]), float64(c.first[1]), float64(c.first[2])})
+
+	p.Type = SimpleCubic
+	p.Scale = 1.0 / cifGridSteps
+	p.HalfSize = 0
+	p.crystal = cellFrame{
+		count:  c.size,
+		origin: cifWorld([3]float64{first[0] - center[0], first[1] - center[1], first[2] - center[2]}, scale),
+	}
+	for axis := range basis {
+		p.crystal.axes[axis] = cifWorld(basis[axis], scale)
+		// The home cell is the first of the supercell loaded.
+		p.crystal.home[axis] = clampInt(-c.first[axis], 0, c.size[axis]-1)
+	}
+	var cells []cell
+	for i := c.first[0]; i < c.first[0]+c.size[0]; i++ {
+		for j := c.first[1]; j < c.first[1]+c.size[1]; j++ {
+			for k := c.first[2]; k < c.first[2]+c.size[2]; k++ {
+				for _, a := range atoms {
+					f := [3]float64{a.frac[0] + float64(i), a.frac[1] + float64(j), a.frac[2] + float64(k)}
+					pos := cartesian(basis, f)
+					// Rotating the crystal rather than swapping axes
+					// keeps chiral structures from being mirrored.
+					world := [3]float64{pos[0] - center[0], pos[2] - center[2], -(pos[1] - center[1])}
+					var grid mgl32.Vec3
+					for axis := range grid {
+						g := math.Round(world[axis] * scale * cifGridSteps)
+						if math.Abs(g) > MaxHalfSize {
+							p.HalfSize = int(math.Min(math.Abs(g), math.MaxInt32))
+							return nil, p, p.checkSize(fallbackLocale)
+						}
+						if abs(int(g)) > p.HalfSize {
+							p.HalfSize = abs(int(g))
+						}
+						grid[axis] = float32(g)
+					}
+					z, color := elementInfo(a.element)
+					cells = append(cells, cell{pos: grid, color: color, value: float32(z)})
+				}
+			}
+		}
+	}
+	assignIDs(cells, p)
+	return cells, p, nil
+}
+
+// cifWorld returns the world vector of the Cartesian vector v of a crystal
+// scaled by scale, with the c axis pointing up as in cells.
+func cifWorld(v [3]float64, scale float64) mgl32.Vec3 {
+	return mgl32.Vec3{float32(v[0] * scale), float32(v[2] * scale), float32(-v[1] * scale)}
+}
+
+// elementSymbol returns the element of an atom type symbol or label, such
+// as Fe of Fe2+ or O of O1.
+func elementSymbol(s string) string {
+	letters := 0
+	for letters < len(s) && letters < 2 && unicode.IsLetter(rune(s[letters])) {
+		letters++
+	}
+	if letters == 0 {
+		return s
+	}
+	symbol := strings.ToUpper(s[:1]) + strings.ToLower(s[1:letters])
+	if _, ok := elementNumbers[symbol]; !ok && letters == 2 {
+		symbol = symbol[:1]
+	}
+	return symbol
+}
+
+// elementInfo returns the atomic number of an element, 0 if unknown, and
+// its color in the Jmol scheme.
+func elementInfo(symbol string) (int, mgl32.Vec3) {
+	color, ok := elementColors[symbol]
+	if !ok {
+		color = 0xff1493
+	}
+	return elementNumbers[symbol], mgl32.Vec3{
+		float32(color>>16) / 255,
+		float32(color>>8&0xff) / 255,
+		float32(color&0xff) / 255,
+	}
+}
+
+var elementNumbers = func() map[string]int {
+	symbols := strings.Fields(`
+		H He Li Be B C N O F Ne Na Mg Al Si P S Cl Ar K Ca Sc Ti V Cr Mn Fe Co
+		Ni Cu Zn Ga Ge As Se Br Kr Rb Sr Y Zr Nb Mo Tc Ru Rh Pd Ag Cd In Sn Sb
+		Te I Xe Cs Ba La Ce Pr Nd Pm Sm Eu Gd Tb Dy Ho Er Tm Yb Lu Hf Ta W Re
+		Os Ir Pt Au Hg Tl Pb Bi Po At Rn Fr Ra Ac Th Pa U Np Pu Am Cm Bk Cf Es
+		Fm Md No Lr Rf Db Sg Bh Hs Mt Ds Rg Cn Nh Fl Mc Lv Ts Og`)
+	numbers := make(map[string]int, len(symbols))
+	for i, s := range symbols {
+		numbers[s] = i + 1
+	}
+	return numbers
+}()
+
+// elementColors are the Jmol colors of the elements, as 0xRRGGBB.
+var elementColors = map[string]uint32{
+	"H": 0xffffff, "He": 0xd9ffff, "Li": 0xcc80ff, "Be": 0xc2ff00, "B": 0xffb5b5,
+	"C": 0x909090, "N": 0x3050f8, "O": 0xff0d0d, "F": 0x90e050, "Ne": 0xb3e3f5,
+	"Na": 0xab5cf2, "Mg": 0x8aff00, "Al": 0xbfa6a6, "Si": 0xf0c8a0, "P": 0xff8000,
+	"S": 0xffff30, "Cl": 0x1ff01f, "Ar": 0x80d1e3, "K": 0x8f40d4, "Ca": 0x3dff00,
+	"Sc": 0xe6e6e6, "Ti": 0xbfc2c7, "V": 0xa6a6ab, "Cr": 0x8a99c7, "Mn": 0x9c7ac7,
+	"Fe": 0xe06633, "Co": 0xf090a0, "Ni": 0x50d050, "Cu": 0xc88033, "Zn": 0x7d80b0,
+	"Ga": 0xc28f8f, "Ge": 0x668f8f, "As": 0xbd80e3, "Se": 0xffa100, "Br": 0xa62929,
+	"Kr": 0x5cb8d1, "Rb": 0x702eb0, "Sr": 0x00ff00, "Y": 0x94ffff, "Zr": 0x94e0e0,
+	"Nb": 0x73c2c9, "Mo": 0x54b5b5, "Tc": 0x3b9e9e, "Ru": 0x248f8f, "Rh": 0x0a7d8c,
+	"Pd": 0x006985, "Ag": 0xc0c0c0, "Cd": 0xffd98f, "In": 0xa67573, "Sn": 0x668080,
+	"Sb": 0x9e63b5, "Te": 0xd47a00, "I": 0x940094, "Xe": 0x429eb0, "Cs": 0x57178f,
+	"Ba": 0x00c900, "La": 0x70d4ff, "Ce": 0xffffc7, "Nd": 0xc7ffc7, "Gd": 0x45ffc7,
+	"Hf": 0x4dc2ff, "Ta": 0x4da6ff, "W": 0x2194d6, "Re": 0x267dab, "Os": 0x266696,
+	"Ir": 0x175487, "Pt": 0xd0d0e0, "Au": 0xffd123, "Hg": 0xb8b8d0, "Tl": 0xa6544d,
+	"Pb": 0x575961, "Bi": 0x9e4fb5, "Th": 0x00baff, "U": 0x008fff, "Pu": 0x006bff,
+}
+
+// ParseSupercell parses a supercell size such as 3, the same along every
+// axis, or 2x2x4.
+func ParseSupercell(s string) ([3]int, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) == 1 {
+		parts = []string{parts[0], parts[0], parts[0]}
+	}
+	var n [3]int
+	if len(parts) != 3 {
+		return n, fmt.Errorf("invalid supercell %q", s)
+	}
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 1 {
+			return n, fmt.Errorf("invalid supercell %q", s)
+		}
+		n[i] = v
+	}
+	return n, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testCIF = `# A made-up structure.
+data_test
+_cell_length_a 5.43(1)
+_cell_angle_gamma 120
+_title 'a b'
+_note
+;multi
+line
+;
+loop_
+_atom_site_label
+_atom_site_fract_x
+_atom_site_fract_y
+_atom_site_fract_z
+Si1 0 0 0
+O1 0.25 0.25 "0.5"
+data_other
+_cell_length_a 1
+`
+
+func TestParseCIF(t *testing.T) {
+	d, err := parseCIF(strings.NewReader(testCIF), "test.cif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := map[string]string{
+		"_cell_length_a":    "5.43(1)",
+		"_cell_angle_gamma": "120",
+		"_title":            "a b",
+		"_note":             "multi\nline",
+	}
+	if !reflect.DeepEqual(d.items, items) {
+		t.Errorf("items = %q, want %q", d.items, items)
+	}
+	if len(d.loops) != 1 {
+		t.Fatalf("got %v loops, want 1", len(d.loops))
+	}
+	l := d.find("_atom_site_fract_z")
+	if l == nil {
+		t.Fatal("no loop with _atom_site_fract_z")
+	}
+	if got, want := l.column("_atom_site_type_symbol", "_atom_site_label"), []string{"Si1", "O1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+	if got, want := l.column("_atom_site_fract_z"), []string{"0", "0.5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("z = %q, want %q", got, want)
+	}
+}
+
+func TestParseCIFMalformed(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"no data block", "_cell_length_a 1\n", "test.cif: no data block"},
+		{"empty", "", "test.cif: no data block"},
+		{"short loop", "data_x\nloop_\n_a\n_b\n1 2 3\n", "test.cif:2: loop values do not fill its rows"},
+		{"loop without tags", "data_x\nloop_\n1 2\n", "test.cif:2: loop values do not fill its rows"},
+		{"tag at end", "data_x\n_a\n", "test.cif:2: _a has no value"},
+		{"tag before tag", "data_x\n_a\n_b 1\n", "test.cif:2: _a has no value"},
+		{"unterminated string", "data_x\n_a 'b c\n", "test.cif:2: unterminated string"},
+		{"unterminated text field", "data_x\n_a\n;text\n", "test.cif:3: unterminated text field"},
+	}
+	for _, test := range tests {
+		_, err := parseCIF(strings.NewReader(test.src), "test.cif")
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v: parseCIF error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestParseSymOp(t *testing.T) {
+	tests := []struct {
+		text string
+		want symOp
+	}{
+		{"x,y,z", symOp{rot: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}},
+		{"-x+1/2,y,-z+1/2", symOp{
+			rot:   [3][3]float64{{-1, 0, 0}, {0, 1, 0}, {0, 0, -1}},
+			trans: [3]float64{0.5, 0, 0.5},
+		}},
+		{"Y-X, -X, Z+0.25", symOp{
+			rot:   [3][3]float64{{-1, 1, 0}, {-1, 0, 0}, {0, 0, 1}},
+			trans: [3]float64{0, 0, 0.25},
+		}},
+		{"1/2-x,2x,1/2*z", symOp{
+			rot:   [3][3]float64{{-1, 0, 0}, {2, 0, 0}, {0, 0, 0.5}},
+			trans: [3]float64{0.5, 0, 0},
+		}},
+	}
+	for _, test := range tests {
+		op, err := parseSymOp(test.text)
+		if err != nil {
+			t.Errorf("parseSymOp(%q): %v", test.text, err)
+			continue
+		}
+		if op != test.want {
+			t.Errorf("parseSymOp(%q) = %+v, want %+v", test.text, op, test.want)
+		}
+	}
+}
+
+func TestParseSymOpMalformed(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"x,y",
+		"x,y,z,x",
+		"x,y,",
+		"x,y,a",
+		"x,y,z+",
+		"x,y,--z",
+		"x,y,z+1/0",
+		"x,y,q*z",
+	} {
+		if op, err := parseSymOp(text); err == nil {
+			t.Errorf("parseSymOp(%q) = %+v, want an error", text, op)
+		}
+	}
+}
+
+func TestParseSupercell(t *testing.T) {
+	tests := []struct {
+		s    string
+		want [3]int
+		ok   bool
+	}{
+		{"3", [3]int{3, 3, 3}, true},
+		{"2x2x4", [3]int{2, 2, 4}, true},
+		{"1X3x2", [3]int{1, 3, 2}, true},
+		{"", [3]int{}, false},
+		{"0", [3]int{}, false},
+		{"2x2", [3]int{}, false},
+		{"2x2x2x2", [3]int{}, false},
+		{"2x2x0", [3]int{}, false},
+		{"-1x2x2", [3]int{}, false},
+		{"axbxc", [3]int{}, false},
+		{" 3", [3]int{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseSupercell(test.s)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseSupercell(%q) error = %v, want ok %v", test.s, err, test.ok)
+			continue
+		}
+		if test.ok && got != test.want {
+			t.Errorf("ParseSupercell(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// defaultCinemaAspect is the default Options.CinemaAspect, the widescreen
+// aspect of anamorphic cinema.
+const defaultCinemaAspect = 2.39
+
+// cinema is the capture mode for recordings: the lattice is drawn at a
+// fixed aspect between black bars, without the HUD, at a frame rate locked
+// to that of recordings.
+type cinema struct {
+	on     bool
+	aspect float32
+	// next is when the next frame is due while on.
+	next time.Time
+}
+
+// ParseAspect parses an aspect ratio such as 2.39, 2.39:1 or 16:9.
+func ParseAspect(s string) (float32, error) {
+	num, den := s, "1"
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(num, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aspect %q", s)
+	}
+	d, err := strconv.ParseFloat(den, 32)
+	if err != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid aspect %q", s)
+	}
+	return float32(n / d), nil
+}
+
+// letterbox returns the largest rectangle of the given aspect centered in
+// a framebuffer of width by height, with bars above and below it, or on
+// the sides if the framebuffer is narrower.
+func letterbox(width, height int32, aspect float32) (x, y, w, h int32) {
+	w, h = width, int32(float32(width)/aspect+0.5)
+	if h > height {
+		w, h = int32(float32(height)*aspect+0.5), height
+	}
+	return (width - w) / 2, (height - h) / 2, w, h
+}
+
+// viewport returns the part of the framebuffer the lattice is drawn in:
+// all of it, or the picture between the bars in cinema mode.
+func (r *Renderer) viewport() (x, y, width, height int32) {
+	w, h := r.w.GetFramebufferSize()
+	if !r.cinema.on {
+		return 0, 0, int32(w), int32(h)
+	}
+	return letterbox(int32(w), int32(h), r.cinema.aspect)
+}
+
+// updateViewport fits the viewport and the projection to the part of the
+// framebuffer the lattice is drawn in.
+func (r *Renderer) updateViewport() {
+	x, y, w, h := r.viewport()
+	if w == 0 || h == 0 {
+		return
+	}
+	gl.Viewport(x, y, w, h)
+	r.projection = r.projectionFor(w, h)
+	gl.UseProgram(r.program)
+	gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+}
+
+// SetCinema turns cinema mode on at the given aspect, or off. Recordings
+// then capture only the picture between the bars, so the mode cannot
+// change while recording.
+func (r *Renderer) SetCinema(on bool, aspect float32) error {
+	if r.recorder != nil {
+		return r.locale.Errorf(MsgCinemaRecording)
+	}
+	if aspect > 0 {
+		r.cinema.aspect = aspect
+	}
+	r.cinema.on = on
+	r.cinema.next = time.Now()
+	r.updateViewport()
+	if on {
+		r.Notify(MsgCinemaOn, r.cinema.aspect, r.recordFrameRate)
+	} else {
+		r.Notify(MsgCinemaOff)
+	}
+	return nil
+}
+
+// ToggleCinema turns cinema mode on or off.
+func (r *Renderer) ToggleCinema() {
+	if err := r.SetCinema(!r.cinema.on, 0); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+// lockFrameRate waits until the next frame is due in cinema mode, so that
+// frames follow each other at the recording frame rate. A late frame
+// moves the schedule rather than rushing the frames after it.
+func (r *Renderer) lockFrameRate() {
+	if !r.cinema.on {
+		return
+	}
+	period := time.Second / time.Duration(r.recordFrameRate)
+	r.cinema.next = r.cinema.next.Add(period)
+	if wait := time.Until(r.cinema.next); wait > 0 {
+		time.Sleep(wait)
+	} else {
+		r.cinema.next = time.Now()
+	}
+}
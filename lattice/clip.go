@@ -0,0 +1,120 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// clipAxes are the normals the clipping plane cycles through.
+var clipAxes = []mgl32.Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {-1, 0, 0}, {0, -1, 0}, {0, 0, -1}}
+
+// clipPlane cuts away the part of the lattice in front of a plane, so that
+// the layers inside can be seen.
+type clipPlane struct {
+	on bool
+	// normal is the unit normal of the plane, pointing to the part cut
+	// away, and offset the distance of the plane from the origin along it.
+	normal mgl32.Vec3
+	offset float32
+}
+
+// String describes the plane by its normal and offset.
+func (c clipPlane) String() string {
+	return fmt.Sprintf("(%.2f, %.2f, %.2f) %+.1f", c.normal[0], c.normal[1], c.normal[2], c.offset)
+}
+
+// equation returns the plane as the coefficients whose dot product with a
+// world position (x, y, z, 1) is the clip distance of the position, which
+// is negative in front of the plane. A plane that is off keeps everything.
+func (c clipPlane) equation() mgl32.Vec4 {
+	if !c.on {
+		return mgl32.Vec4{}
+	}
+	return c.normal.Mul(-1).Vec4(c.offset)
+}
+
+// enableClipping makes the lattice programs, which write the distance from
+// the clipping plane, cut at it until the returned function is called.
+func enableClipping() func() {
+	gl.Enable(gl.CLIP_DISTANCE0)
+	return func() { gl.Disable(gl.CLIP_DISTANCE0) }
+}
+
+// clipStep returns the distance the clipping plane moves by: a grid step,
+// or a tenth of one if fine is set.
+func (r *Renderer) clipStep(fine bool) float32 {
+	step := r.params.World(mgl32.Vec3{1, 0, 0}).Len()
+	if fine {
+		step /= 10
+	}
+	return step
+}
+
+// SetClipPlane cuts away the lattice in front of the plane through the
+// point at offset from the origin along normal, which points to the part cut
+// away.
+func (r *Renderer) SetClipPlane(normal mgl32.Vec3, offset float32) {
+	if normal.Len() == 0 {
+		return
+	}
+	r.clip = clipPlane{on: true, normal: normal.Normalize(), offset: offset}
+	r.Notify(MsgClip, r.clip)
+}
+
+// ClipOff shows the whole lattice again, keeping the plane for the next
+// time clipping is turned on.
+func (r *Renderer) ClipOff() {
+	r.clip.on = false
+	r.Notify(MsgClipOff)
+}
+
+// ToggleClip turns clipping on or off. The first time, the plane faces the
+// camera through the origin, cutting away the nearer half of the lattice.
+func (r *Renderer) ToggleClip() {
+	switch {
+	case r.clip.on:
+		r.ClipOff()
+	case r.clip.normal.Len() == 0:
+		r.ClipToView()
+	default:
+		r.SetClipPlane(r.clip.normal, r.clip.offset)
+	}
+}
+
+// ClipToView turns the clipping plane to face the camera, keeping the
+// distance it is moved by.
+func (r *Renderer) ClipToView() {
+	r.SetClipPlane(r.viewNormal(), r.clip.offset)
+}
+
+// viewNormal returns the direction from the view towards the camera.
+func (r *Renderer) viewNormal() mgl32.Vec3 {
+	return r.camera.Inv().Mul4x1(mgl32.Vec4{0, 0, 1, 0}).Vec3()
+}
+
+// NextClipAxis turns the clipping plane to the next of the lattice axes,
+// in both directions.
+func (r *Renderer) NextClipAxis() {
+	next := 0
+	for i, a := range clipAxes {
+		if a == r.clip.normal {
+			next = (i + 1) % len(clipAxes)
+		}
+	}
+	r.SetClipPlane(clipAxes[next], r.clip.offset)
+}
+
+// MoveClip moves the clipping plane by d along its normal, turning it on.
+func (r *Renderer) MoveClip(d float32) {
+	normal := r.clip.normal
+	if normal.Len() == 0 {
+		normal = r.viewNormal()
+	}
+	r.SetClipPlane(normal, r.clip.offset+d)
+}
@@ -0,0 +1,743 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// collabCameraPeriod is how often the camera is sent to the session.
+	collabCameraPeriod = 100 * time.Millisecond
+	// collabGhostTimeout drops the ghost of a client that stopped sending
+	// its camera.
+	collabGhostTimeout = 5 * time.Second
+	// collabWriteTimeout drops a peer that does not keep up.
+	collabWriteTimeout = time.Second
+	// collabJoinTimeout drops a connection that does not join in time.
+	collabJoinTimeout = 10 * time.Second
+	// collabRejoinPeriod is how long after a client was announced to the
+	// session joining again under its name is not announced, so that a
+	// client reconnecting in a loop does not flood the others.
+	collabRejoinPeriod = 10 * time.Second
+	// collabJoinLine is the longest line read from a connection the host
+	// has not admitted yet, collabLine that from any other: groups of many
+	// cells make long lines, but only once a peer has joined.
+	collabJoinLine = 4 << 10
+	collabLine     = 64 << 20
+	// collabClockSkew is the farthest the clock of a group edit may be
+	// ahead of the latest the host has seen. Every edit advances a clock by
+	// one, so an honest client is never far ahead; a clock near the top
+	// would wrap the clocks of the session and make every later edit lose.
+	collabClockSkew = 1 << 20
+)
+
+// CollabAuth secures a collaborative session. A host with neither token
+// set admits every client with write access. The session is the only
+// network service the viewer offers; any other, such as an HTTP or gRPC
+// API, would have to check the same tokens and scopes.
+type CollabAuth struct {
+	// Token grants write access to a hosted session, ReadToken read-only
+	// access: read-only clients see the session but their group edits
+	// are dropped. Joining clients present Token.
+	Token     string
+	ReadToken string
+	// CertFile and KeyFile, if set, are the PEM certificate and key a
+	// session is hosted with over TLS.
+	CertFile, KeyFile string
+	// TLS joins a session over TLS, trusting the system roots and CAFile,
+	// if set.
+	TLS    bool
+	CAFile string
+}
+
+// open reports whether a hosted session admits anyone.
+func (a CollabAuth) open() bool {
+	return a.Token == "" && a.ReadToken == ""
+}
+
+// scope returns the access token grants to a hosted session.
+func (a CollabAuth) scope(token string) (write, ok bool) {
+	match := func(want string) bool {
+		return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+	}
+	switch {
+	case a.open(), match(a.Token):
+		return true, true
+	case match(a.ReadToken):
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// collabMessage is one line of the session protocol, a JSON object per
+// line over TCP. The host relays every message to the other clients.
+type collabMessage struct {
+	// Client names the sender, unique within the session.
+	Client string `json:"client"`
+	// Joined announces a new client with its Token, which the host checks
+	// and does not relay. Left announces one that disconnected; a Left
+	// message without a client reports the loss of the host.
+	Joined bool   `json:"joined,omitempty"`
+	Token  string `json:"token,omitempty"`
+	Left   bool   `json:"left,omitempty"`
+	// Denied tells a client the host refused its token, ReadOnly that its
+	// group edits are dropped.
+	Denied   bool `json:"denied,omitempty"`
+	ReadOnly bool `json:"read_only,omitempty"`
+	// rejoin marks on the host the join of a client announced less than
+	// collabRejoinPeriod ago, which is neither relayed nor announced.
+	rejoin bool
+
+	Camera *collabCamera `json:"camera,omitempty"`
+
+	// Group is the new state of a group, or only its name if Removed is
+	// set. Clock orders the edits of a group for last-write-wins.
+	Group   *groupJSON `json:"group,omitempty"`
+	Removed bool       `json:"removed,omitempty"`
+	Clock   uint64     `json:"clock,omitempty"`
+}
+
+// collabCamera is the view of a client, drawn as a ghost by the others.
+type collabCamera struct {
+	Pos    mgl32.Vec3 `json:"pos"`
+	Pitch  float32    `json:"pitch"`
+	Yaw    float32    `json:"yaw"`
+	FOV    float32    `json:"fov"`
+	Aspect float32    `json:"aspect"`
+}
+
+// collabSession is the network side of a session: the connection to the
+// host for a client, the connections to all clients for the host.
+// Received messages are delivered to incoming, to be handled on the render
+// thread.
+type collabSession struct {
+	name     string
+	hosting  bool
+	auth     CollabAuth
+	listener net.Listener
+	incoming chan collabMessage
+	closed   chan struct{}
+	close    sync.Once
+
+	mu    sync.Mutex
+	peers map[*collabPeer]struct{}
+	// clock is the latest clock of the group edits sent or relayed.
+	clock uint64
+	// announced is when the host last announced the join of each client,
+	// for collabRejoinPeriod.
+	announced map[string]time.Time
+}
+
+// collabPeer is a connection of a session.
+type collabPeer struct {
+	conn net.Conn
+	mu   sync.Mutex
+	enc  *json.Encoder
+	// name is the client at the other end of a connection to the host,
+	// once it joined, and canWrite whether it may edit groups.
+	name     string
+	canWrite bool
+}
+
+func newCollabSession(name string) *collabSession {
+	return &collabSession{
+		name:      name,
+		incoming:  make(chan collabMessage, 256),
+		closed:    make(chan struct{}),
+		peers:     make(map[*collabPeer]struct{}),
+		announced: make(map[string]time.Time),
+	}
+}
+
+func newCollabPeer(conn net.Conn) *collabPeer {
+	return &collabPeer{conn: conn, enc: json.NewEncoder(conn)}
+}
+
+// hostSession starts a session that clients join at addr.
+func hostSession(addr, name string, auth CollabAuth) (*collabSession, error) {
+	var config *tls.Config
+	if auth.CertFile != "" || auth.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(auth.CertFile, auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+	s := newCollabSession(name)
+	s.hosting = true
+	s.auth = auth
+	s.listener = l
+	go s.accept()
+	return s, nil
+}
+
+// joinSession connects to the session hosted at addr.
+func joinSession(addr, name string, auth CollabAuth) (*collabSession, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if auth.TLS || auth.CAFile != "" {
+		config, configErr := joinTLSConfig(auth.CAFile)
+		if configErr != nil {
+			return nil, configErr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := newCollabSession(name)
+	p := newCollabPeer(conn)
+	s.add(p)
+	go s.read(p)
+	s.send(collabMessage{Client: name, Joined: true, Token: auth.Token})
+	return s, nil
+}
+
+// joinTLSConfig trusts the system roots and the certificates of caFile.
+func joinTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %v", caFile)
+	}
+	config.RootCAs = roots
+	return config, nil
+}
+
+// defaultCollabName returns a client name unique on the network.
+func defaultCollabName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "lattice"
+	}
+	return fmt.Sprintf("%v-%v", host, os.Getpid())
+}
+
+// accept reads the connections of clients. They only receive the session
+// once they joined with a valid token.
+func (s *collabSession) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(collabJoinTimeout))
+		go s.read(newCollabPeer(conn))
+	}
+}
+
+func (s *collabSession) add(p *collabPeer) {
+	s.mu.Lock()
+	s.peers[p] = struct{}{}
+	s.mu.Unlock()
+}
+
+// admit checks the first message of a client connected to the host.
+func (s *collabSession) admit(p *collabPeer, m collabMessage) error {
+	if !m.Joined || m.Client == "" {
+		return errors.New("did not join")
+	}
+	write, ok := s.auth.scope(m.Token)
+	if !ok {
+		p.write(collabMessage{Client: s.name, Denied: true})
+		return fmt.Errorf("%v presented an invalid token", m.Client)
+	}
+	if !s.register(p, m.Client, write) {
+		p.write(collabMessage{Client: s.name, Denied: true})
+		return fmt.Errorf("the name %v is taken", m.Client)
+	}
+	p.conn.SetReadDeadline(time.Time{})
+	if !write {
+		p.write(collabMessage{Client: s.name, ReadOnly: true})
+	}
+	return nil
+}
+
+// register adds p to the peers as the client name unless the name is the
+// host's or that of another client, and reports whether it did. Checking
+// and adding in one step admits only one of two clients joining with the
+// same name at once.
+func (s *collabSession) register(p *collabPeer, name string, write bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == s.name {
+		return false
+	}
+	for q := range s.peers {
+		if q.name == name {
+			return false
+		}
+	}
+	p.name, p.canWrite = name, write
+	s.peers[p] = struct{}{}
+	return true
+}
+
+// announce reports whether the join of the client name at now is announced
+// to the session, which it is unless the client was announced less than
+// collabRejoinPeriod before.
+func (s *collabSession) announce(name string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, t := range s.announced {
+		if now.Sub(t) >= collabRejoinPeriod {
+			delete(s.announced, n)
+		}
+	}
+	if _, ok := s.announced[name]; ok {
+		return false
+	}
+	s.announced[name] = now
+	return true
+}
+
+// read delivers the messages of p until its connection closes. The host
+// first admits p, and stamps its messages with the name it joined with.
+func (s *collabSession) read(p *collabPeer) {
+	scanner := bufio.NewScanner(p.conn)
+	scanner.Buffer(nil, collabLine)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if s.hosting && p.name == "" && (len(token) > collabJoinLine || token == nil && len(data) > collabJoinLine) {
+			return 0, nil, bufio.ErrTooLong
+		}
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		var m collabMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			log.Printf("session: %v: %v", p.conn.RemoteAddr(), err)
+			continue
+		}
+		if s.hosting {
+			if p.name == "" {
+				if err := s.admit(p, m); err != nil {
+					log.Printf("session: %v: %v", p.conn.RemoteAddr(), err)
+					break
+				}
+				m.rejoin = !s.announce(p.name, time.Now())
+			} else if m.Joined {
+				// A client joins once; joining again would make the host
+				// send it the session again.
+				continue
+			}
+			// Only the host denies access or restricts it.
+			m.Client, m.Token = p.name, ""
+			m.Denied, m.ReadOnly = false, false
+			if m.Group != nil && !p.canWrite {
+				continue
+			}
+			if m.Group != nil && s.farAhead(m.Clock) {
+				log.Printf("session: %v: dropped the edit of %v stamped %v, far ahead of the session", p.name, m.Group.Name, m.Clock)
+				continue
+			}
+			if !m.rejoin {
+				s.relay(p, m)
+			}
+		} else if m.Client == "" || m.Client == s.name {
+			continue
+		}
+		s.deliver(m)
+	}
+
+	s.mu.Lock()
+	delete(s.peers, p)
+	s.mu.Unlock()
+	p.conn.Close()
+	if !s.hosting {
+		s.deliver(collabMessage{Left: true})
+	} else if p.name != "" {
+		m := collabMessage{Client: p.name, Left: true}
+		s.relay(p, m)
+		s.deliver(m)
+	}
+}
+
+// deliver hands m to the render thread, unless the session was closed.
+func (s *collabSession) deliver(m collabMessage) {
+	select {
+	case s.incoming <- m:
+	case <-s.closed:
+	}
+}
+
+// farAhead reports whether clock is too far ahead of the group edits seen
+// to be relayed.
+func (s *collabSession) farAhead(clock uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return clock > s.clock+collabClockSkew
+}
+
+// send sends m to all peers.
+func (s *collabSession) send(m collabMessage) {
+	s.relay(nil, m)
+}
+
+// sendTo sends m to the client name only, if it is still connected.
+func (s *collabSession) sendTo(name string, m collabMessage) {
+	s.mu.Lock()
+	var to *collabPeer
+	for p := range s.peers {
+		if p.name == name {
+			to = p
+			break
+		}
+	}
+	s.mu.Unlock()
+	if to != nil {
+		to.write(m)
+	}
+}
+
+// relay sends m to all peers but from.
+func (s *collabSession) relay(from *collabPeer, m collabMessage) {
+	s.mu.Lock()
+	if m.Group != nil && m.Clock > s.clock {
+		s.clock = m.Clock
+	}
+	peers := make([]*collabPeer, 0, len(s.peers))
+	for p := range s.peers {
+		if p != from {
+			peers = append(peers, p)
+		}
+	}
+	s.mu.Unlock()
+	for _, p := range peers {
+		p.write(m)
+	}
+}
+
+func (p *collabPeer) write(m collabMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(collabWriteTimeout))
+	if err := p.enc.Encode(m); err != nil {
+		// Closing makes read report the peer as gone.
+		p.conn.Close()
+	}
+}
+
+// Close disconnects from the session. Closing it again does nothing.
+func (s *collabSession) Close() {
+	s.close.Do(func() {
+		close(s.closed)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for p := range s.peers {
+			p.conn.Close()
+		}
+	})
+}
+
+// collabStamp orders the writes to a group. Ties between clients that
+// wrote with the same clock go to the greater name.
+type collabStamp struct {
+	clock  uint64
+	client string
+}
+
+func (a collabStamp) after(b collabStamp) bool {
+	if a.clock != b.clock {
+		return a.clock > b.clock
+	}
+	return a.client > b.client
+}
+
+// collabGhost is the last camera received from a client.
+type collabGhost struct {
+	name   string
+	camera collabCamera
+	seen   time.Time
+}
+
+// collabState is the renderer side of a session. Group edits are resolved
+// per group name, the last write winning, with a Lamport clock ordering
+// the writes of different clients.
+type collabState struct {
+	session *collabSession
+	clock   uint64
+	// stamps holds the last write applied to each group, removals
+	// included.
+	stamps map[string]collabStamp
+	// shared holds each group as last sent or received, encoded, to tell
+	// local edits apart.
+	shared map[string]string
+	ghosts map[string]*collabGhost
+	// readOnly is set when the host drops the group edits of this client,
+	// which are then not sent.
+	readOnly bool
+	// nextCamera is when the camera is sent next.
+	nextCamera time.Time
+}
+
+// StartCollab hosts a session at addr, or joins the one hosted there, under
+// the given client name. Other clients see the camera of this one as a
+// ghost and share its group edits.
+func (r *Renderer) StartCollab(addr, name string, host bool) error {
+	if r.collab != nil {
+		r.collab.session.Close()
+		r.collab = nil
+	}
+	if name == "" {
+		name = defaultCollabName()
+	}
+	var session *collabSession
+	var err error
+	if host {
+		session, err = hostSession(addr, name, r.collabAuth)
+	} else {
+		session, err = joinSession(addr, name, r.collabAuth)
+	}
+	if err != nil {
+		return err
+	}
+	if r.ghosts == nil {
+		if r.ghosts, err = newGhostRenderer(); err != nil {
+			session.Close()
+			return err
+		}
+	}
+
+	c := &collabState{
+		session: session,
+		stamps:  make(map[string]collabStamp),
+		shared:  make(map[string]string),
+		ghosts:  make(map[string]*collabGhost),
+	}
+	// The groups of the host win over those of joining clients, which
+	// have not been edited in the session yet.
+	if host {
+		c.clock = 1
+	}
+	for _, g := range r.groups.All() {
+		c.stamps[g.Name] = collabStamp{c.clock, name}
+		c.shared[g.Name] = r.encodeGroup(g)
+	}
+	r.collab = c
+	if host {
+		r.Notify(MsgCollabHosting, session.listener.Addr())
+	} else {
+		r.Notify(MsgCollabJoined, addr, name)
+	}
+	return nil
+}
+
+// StopCollab leaves the session, if any.
+func (r *Renderer) StopCollab() {
+	if r.collab != nil {
+		r.collab.session.Close()
+		r.collab = nil
+	}
+}
+
+func (r *Renderer) encodeGroup(g *Group) string {
+	data, _ := json.Marshal(newGroupJSON(g, r.params))
+	return string(data)
+}
+
+// shareGroups sends the groups edited locally since they were last shared.
+func (r *Renderer) shareGroups() {
+	c := r.collab
+	if c == nil || c.readOnly {
+		return
+	}
+	name := c.session.name
+	present := make(map[string]bool)
+	for _, g := range r.groups.All() {
+		present[g.Name] = true
+		encoded := r.encodeGroup(g)
+		if c.shared[g.Name] == encoded {
+			continue
+		}
+		c.clock++
+		c.stamps[g.Name] = collabStamp{c.clock, name}
+		c.shared[g.Name] = encoded
+		gj := newGroupJSON(g, r.params)
+		c.session.send(collabMessage{Client: name, Group: &gj, Clock: c.clock})
+	}
+	for shared := range c.shared {
+		if present[shared] {
+			continue
+		}
+		c.clock++
+		c.stamps[shared] = collabStamp{c.clock, name}
+		delete(c.shared, shared)
+		c.session.send(collabMessage{Client: name, Group: &groupJSON{Name: shared}, Removed: true, Clock: c.clock})
+	}
+}
+
+// pollCollab handles the messages received from the session and sends the
+// camera.
+func (r *Renderer) pollCollab() {
+	c := r.collab
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	for {
+		select {
+		case m := <-c.session.incoming:
+			r.handleCollab(m, now)
+			if r.collab == nil {
+				return
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	for name, g := range c.ghosts {
+		if now.Sub(g.seen) > collabGhostTimeout {
+			delete(c.ghosts, name)
+		}
+	}
+	if now.After(c.nextCamera) {
+		c.nextCamera = now.Add(collabCameraPeriod)
+		w, h := r.w.GetFramebufferSize()
+		aspect := float32(1)
+		if w > 0 && h > 0 {
+			aspect = float32(w) / float32(h)
+		}
+		c.session.send(collabMessage{Client: c.session.name, Camera: &collabCamera{
+			Pos: r.camPos, Pitch: r.pitch, Yaw: r.yaw, FOV: r.fov, Aspect: aspect,
+		}})
+	}
+}
+
+func (r *Renderer) handleCollab(m collabMessage, now time.Time) {
+	c := r.collab
+	switch {
+	case m.Denied:
+		r.NotifyError(r.locale.Errorf(MsgCollabDenied))
+		r.StopCollab()
+	case m.ReadOnly:
+		c.readOnly = true
+		r.Notify(MsgCollabReadOnly)
+	case m.Left && m.Client == "":
+		r.NotifyError(r.locale.Errorf(MsgCollabLost))
+		r.StopCollab()
+	case m.Left:
+		delete(c.ghosts, m.Client)
+		r.Notify(MsgPeerLeft, m.Client)
+	case m.Joined:
+		if !m.rejoin {
+			r.Notify(MsgPeerJoined, m.Client)
+		}
+		if c.session.hosting {
+			r.sendSessionGroups(m.Client)
+		}
+	case m.Camera != nil:
+		c.ghosts[m.Client] = &collabGhost{name: m.Client, camera: *m.Camera, seen: now}
+	case m.Group != nil:
+		r.applyCollabGroup(m)
+	}
+}
+
+// sendSessionGroups sends every group with the stamp of its last write to
+// the client that joined as name, so that it catches up. The others have
+// them already.
+func (r *Renderer) sendSessionGroups(name string) {
+	c := r.collab
+	for _, g := range r.groups.All() {
+		stamp := c.stamps[g.Name]
+		gj := newGroupJSON(g, r.params)
+		c.session.sendTo(name, collabMessage{Client: stamp.client, Group: &gj, Clock: stamp.clock})
+	}
+}
+
+// applyCollabGroup applies a remote group edit unless a later write to the
+// group was already applied.
+func (r *Renderer) applyCollabGroup(m collabMessage) {
+	c := r.collab
+	name := m.Group.Name
+	if m.Clock > c.clock {
+		c.clock = m.Clock
+	}
+	stamp := collabStamp{m.Clock, m.Client}
+	if !stamp.after(c.stamps[name]) {
+		return
+	}
+	c.stamps[name] = stamp
+
+	changed := NewSelection()
+	g := r.groups.Get(name)
+	if g != nil {
+		for id := range g.Cells {
+			changed.Add(id)
+		}
+	}
+	if m.Removed {
+		if g == nil {
+			return
+		}
+		r.groups.Remove(name)
+		delete(c.shared, name)
+	} else {
+		sel := m.Group.selection(r.params)
+		if g == nil {
+			g, _ = r.groups.Add(name, sel)
+		} else {
+			g.Cells = sel
+		}
+		m.Group.apply(g)
+		for id := range sel {
+			changed.Add(id)
+		}
+		c.shared[name] = r.encodeGroup(g)
+	}
+	// The group is shared already, so groupsChanged finds nothing to send.
+	r.groupsChanged(changed)
+}
+
+// ghostList returns the ghosts of the other clients in name order.
+func (c *collabState) ghostList() []*collabGhost {
+	ghosts := make([]*collabGhost, 0, len(c.ghosts))
+	for _, g := range c.ghosts {
+		ghosts = append(ghosts, g)
+	}
+	sort.Slice(ghosts, func(i, j int) bool { return ghosts[i].name < ghosts[j].name })
+	return ghosts
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollabRegister(t *testing.T) {
+	s := newCollabSession("host")
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"alice", true},
+		{"bob", true},
+		{"alice", false},
+		{"host", false},
+	}
+	for _, test := range tests {
+		if got := s.register(&collabPeer{}, test.name, true); got != test.want {
+			t.Errorf("register(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Of clients joining at once with the same name, only one is admitted.
+	s = newCollabSession("host")
+	var wg sync.WaitGroup
+	admitted := make(chan bool, 16)
+	for i := 0; i < cap(admitted); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			admitted <- s.register(&collabPeer{}, "alice", true)
+		}()
+	}
+	wg.Wait()
+	close(admitted)
+	n := 0
+	for ok := range admitted {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 || len(s.peers) != 1 {
+		t.Errorf("%v clients and %v peers admitted as alice, want 1", n, len(s.peers))
+	}
+}
+
+func TestCollabAnnounce(t *testing.T) {
+	s := newCollabSession("host")
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  bool
+	}{
+		{"alice", 0, true},
+		{"bob", time.Second, true},
+		{"alice", 2 * time.Second, false},
+		{"alice", collabRejoinPeriod - time.Second, false},
+		{"alice", collabRejoinPeriod, true},
+		{"bob", collabRejoinPeriod, false},
+		{"alice", collabRejoinPeriod + time.Second, false},
+	}
+	for _, test := range tests {
+		if got := s.announce(test.name, start.Add(test.after)); got != test.want {
+			t.Errorf("announce(%q) after %v = %v, want %v", test.name, test.after, got, test.want)
+		}
+	}
+}
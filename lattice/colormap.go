@@ -0,0 +1,425 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Colormap maps scalars in [0, 1] to colors.
+type Colormap int
+
+const (
+	// NoColormap keeps the colors of the cells.
+	NoColormap Colormap = iota
+	// Viridis runs from dark blue through green to yellow, perceptually
+	// uniform and readable by the color blind.
+	Viridis
+	// Plasma runs from dark blue through magenta to yellow.
+	Plasma
+	// Grayscale runs from black to white.
+	Grayscale
+)
+
+var colormapNames = []string{"off", "viridis", "plasma", "grayscale"}
+
+func (m Colormap) String() string {
+	return colormapNames[m]
+}
+
+// Set implements flag.Value.
+func (m *Colormap) Set(name string) error {
+	colormap, ok := ParseColormap(name)
+	if !ok {
+		return fmt.Errorf("unknown colormap %q", name)
+	}
+	*m = colormap
+	return nil
+}
+
+// ParseColormap returns the colormap with the given name.
+func ParseColormap(name string) (Colormap, bool) {
+	for i, n := range colormapNames {
+		if n == name {
+			return Colormap(i), true
+		}
+	}
+	return NoColormap, false
+}
+
+// colormapStops are colors evenly spaced along the colormaps, sampled from
+// those of matplotlib.
+var colormapStops = [][]uint32{
+	Viridis:   {0x440154, 0x472d7b, 0x3b528b, 0x2c728e, 0x21918c, 0x28ae80, 0x5ec962, 0xaddc30, 0xfde725},
+	Plasma:    {0x0d0887, 0x4c02a1, 0x7e03a8, 0xa92395, 0xcc4778, 0xe56b5d, 0xf89441, 0xfdc328, 0xf0f921},
+	Grayscale: {0x000000, 0xffffff},
+}
+
+// at returns the color of m at v, clamped to [0, 1], interpolating
+// linearly between its stops.
+func (m Colormap) at(v float32) mgl32.Vec3 {
+	stops := colormapStops[m]
+	x := mgl32.Clamp(v, 0, 1) * float32(len(stops)-1)
+	i := int(x)
+	if i >= len(stops)-1 {
+		i = len(stops) - 2
+	}
+	a, b := hexColor(stops[i]), hexColor(stops[i+1])
+	return a.Add(b.Sub(a).Mul(x - float32(i)))
+}
+
+// hexColor returns the color of a 0xrrggbb value.
+func hexColor(c uint32) mgl32.Vec3 {
+	return mgl32.Vec3{float32(c>>16&0xff) / 255, float32(c>>8&0xff) / 255, float32(c&0xff) / 255}
+}
+
+// ScalarField is the scalar of each cell a colormap colors it by.
+type ScalarField int
+
+const (
+	// DistanceField is the distance of a cell from the origin.
+	DistanceField ScalarField = iota
+	// IndexField is the index of the position of a cell in the lattice,
+	// counting along z, then y, then x.
+	IndexField
+	// ValueField is the value a cell carries.
+	ValueField
+	// FileField is a value per grid position loaded from a file.
+	FileField
+)
+
+var scalarFieldNames = []string{"distance", "index", "value", "file"}
+
+func (f ScalarField) String() string {
+	return scalarFieldNames[f]
+}
+
+// ColorRange is how the scalars of the cells are spread over a colormap.
+type ColorRange int
+
+const (
+	// LinearRange maps the scalars linearly, the smallest to the start of
+	// the colormap and the largest to its end.
+	LinearRange ColorRange = iota
+	// EqualizedRange maps every scalar to the fraction of the cells with
+	// smaller ones, so that the colors are used evenly however the scalars
+	// are distributed.
+	EqualizedRange
+)
+
+var colorRangeNames = []string{"linear", "equalize"}
+
+func (c ColorRange) String() string {
+	return colorRangeNames[c]
+}
+
+// Set implements flag.Value.
+func (c *ColorRange) Set(name string) error {
+	rng, ok := ParseColorRange(name)
+	if !ok {
+		return fmt.Errorf("unknown color range %q", name)
+	}
+	*c = rng
+	return nil
+}
+
+// ParseColorRange returns the color range with the given name.
+func ParseColorRange(name string) (ColorRange, bool) {
+	for i, n := range colorRangeNames {
+		if n == name {
+			return ColorRange(i), true
+		}
+	}
+	return LinearRange, false
+}
+
+// scalarSource gives every cell a scalar of a field.
+type scalarSource struct {
+	field ScalarField
+	// values are the scalars of FileField by grid position, loaded from
+	// path.
+	values map[[3]int]float32
+	path   string
+	// nearest resamples FileField at positions without a value from the
+	// nearest value, rather than blending the values around.
+	nearest bool
+}
+
+// newScalarSource returns the source of the scalars of field: distance,
+// index, value or the name of a file of values, as LoadScalarField reads
+// it. An empty field is the distance.
+func newScalarSource(field string) (scalarSource, error) {
+	var src scalarSource
+	switch field {
+	case "", "distance":
+		src.field = DistanceField
+	case "index":
+		src.field = IndexField
+	case "value":
+		src.field = ValueField
+	default:
+		values, err := LoadScalarField(field)
+		if err != nil {
+			return src, err
+		}
+		src.field, src.values, src.path = FileField, values, field
+	}
+	return src, nil
+}
+
+// String describes the field.
+func (src scalarSource) String() string {
+	if src.field == FileField {
+		return src.path
+	}
+	return src.field.String()
+}
+
+// scalar returns the scalar of cell x in the lattice of p, and false if it
+// has none.
+func (src scalarSource) scalar(x cell, p LatticeParams) (float32, bool) {
+	switch src.field {
+	case DistanceField:
+		return p.World(x.pos).Len(), true
+	case IndexField:
+		return float32(x.id), true
+	case ValueField:
+		return x.value, true
+	}
+	pos := gridPos(x)
+	if v, ok := src.values[pos]; ok {
+		return v, true
+	}
+	return src.resample(pos)
+}
+
+// resample returns the scalar of the grid position pos, which has no value
+// of its own, from the values within a grid step of it, so that fields
+// sampled more coarsely than the lattice cover it: the nearest value if
+// nearest is set, as labels must not blend, or else their mean weighted by
+// inverse squared distance. It returns false if there are none.
+func (src scalarSource) resample(pos [3]int) (float32, bool) {
+	var sum, weights, nearest float32
+	nearestDist := 4
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dz := -1; dz <= 1; dz++ {
+				v, ok := src.values[[3]int{pos[0] + dx, pos[1] + dy, pos[2] + dz}]
+				if !ok {
+					continue
+				}
+				d := dx*dx + dy*dy + dz*dz
+				if d < nearestDist {
+					nearest, nearestDist = v, d
+				}
+				sum += v / float32(d)
+				weights += 1 / float32(d)
+			}
+		}
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	if src.nearest {
+		return nearest, true
+	}
+	return sum / weights, true
+}
+
+// scalars returns the scalars of the cells of s, in no particular order.
+func (src scalarSource) scalars(s *cellStore) []float32 {
+	var scalars []float32
+	for _, k := range s.keys {
+		for _, x := range s.chunk(k) {
+			if v, ok := src.scalar(x, s.params); ok {
+				scalars = append(scalars, v)
+			}
+		}
+	}
+	return scalars
+}
+
+// cellColoring colors cells by a scalar field through a colormap.
+type cellColoring struct {
+	colormap Colormap
+	scalarSource
+	// rng spreads the scalars over the colormap after the percentage clip
+	// of the cells with the smallest and with the largest scalars are
+	// clipped to its ends.
+	rng  ColorRange
+	clip float32
+	// kind is whether the scalars are continuous or categorical.
+	kind DataKind
+}
+
+// newCellColoring returns the coloring by field through m, with field as
+// newScalarSource reads it.
+func newCellColoring(m Colormap, field string) (cellColoring, error) {
+	src, err := newScalarSource(field)
+	return cellColoring{colormap: m, scalarSource: src}, err
+}
+
+// categorical reports whether c colors categories: as declared, or for
+// AutoKind if the scalars of s, or the values of a file, look like labels.
+func (c cellColoring) categorical(s *cellStore) bool {
+	switch c.kind {
+	case ContinuousKind:
+		return false
+	case CategoricalKind:
+		return true
+	}
+	if c.field != FileField {
+		return isCategorical(c.scalars(s))
+	}
+	values := make([]float32, 0, len(c.values))
+	for _, v := range c.values {
+		values = append(values, v)
+	}
+	return isCategorical(values)
+}
+
+// look returns the look coloring the cells of s and its legend, or nil if
+// the colormap is off. Continuous scalars are spread over the colormap by
+// the range of c among all of s, and categories colored from the palette.
+// Cells without a scalar keep their own color.
+func (c cellColoring) look(s *cellStore) (cellLook, *colorLegend) {
+	if c.colormap == NoColormap {
+		return nil, nil
+	}
+	c.nearest = c.categorical(s)
+	scalars := c.scalars(s)
+	if len(scalars) == 0 {
+		return nil, nil
+	}
+	sort.Slice(scalars, func(i, j int) bool { return scalars[i] < scalars[j] })
+	legend := &colorLegend{field: c.scalarSource.String(), colormap: c.colormap}
+	if c.nearest {
+		category := make(map[float32]int)
+		for _, v := range scalars {
+			if _, ok := category[v]; !ok {
+				category[v] = len(legend.categories)
+				legend.categories = append(legend.categories, v)
+			}
+		}
+		return func(x cell) cell {
+			if v, ok := c.scalar(x, s.params); ok {
+				x.color = categoryColor(category[v])
+			}
+			return x
+		}, legend
+	}
+	// The clipped cells are left out at either end.
+	clipped := int(float32(len(scalars)) * mgl32.Clamp(c.clip, 0, 49) / 100)
+	scalars = scalars[clipped : len(scalars)-clipped]
+	lo, hi := scalars[0], scalars[len(scalars)-1]
+	legend.lo, legend.hi = lo, hi
+
+	normalize := func(v float32) float32 {
+		if hi <= lo {
+			return 0
+		}
+		return (v - lo) / (hi - lo)
+	}
+	if c.rng == EqualizedRange && len(scalars) > 1 {
+		// Equal scalars share the middle of their ranks.
+		normalize = func(v float32) float32 {
+			below := sort.Search(len(scalars), func(i int) bool { return scalars[i] >= v })
+			upTo := sort.Search(len(scalars), func(i int) bool { return scalars[i] > v })
+			return (float32(below+upTo-1) / 2) / float32(len(scalars)-1)
+		}
+	}
+	return func(x cell) cell {
+		if v, ok := c.scalar(x, s.params); ok {
+			x.color = c.colormap.at(normalize(v))
+		}
+		return x
+	}, legend
+}
+
+// LoadScalarField reads values by grid position from a NumPy .npy file, as
+// readNPY does, or from a CSV file of x,y,z,value rows, which may start
+// with a header row.
+func LoadScalarField(path string) (map[[3]int]float32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".npy") {
+		values, err := readNPY(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return values, nil
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 4
+	values := make(map[[3]int]float32)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var pos [3]int
+		var v float64
+		for i := range pos {
+			if pos[i], err = strconv.Atoi(strings.TrimSpace(record[i])); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			v, err = strconv.ParseFloat(strings.TrimSpace(record[3]), 32)
+		}
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%v:%d: %v", path, line, err)
+		}
+		values[pos] = float32(v)
+	}
+}
+
+// SetColormap colors the cells by field through m, as newCellColoring
+// reads it, or gives them back their own colors if m is NoColormap.
+func (r *Renderer) SetColormap(m Colormap, field string) error {
+	c, err := newCellColoring(m, field)
+	if err != nil {
+		return err
+	}
+	c.rng, c.clip, c.kind = r.coloring.rng, r.coloring.clip, r.coloring.kind
+	r.coloring = c
+	r.UploadMesh()
+	if m == NoColormap {
+		r.Notify(MsgColormapOff)
+		return nil
+	}
+	r.Notify(MsgColormap, c, m)
+	return nil
+}
+
+// SetColorRange spreads the scalars over the colormap by rng, clipping the
+// percentage clip of the cells at either end of the scalars to the ends of
+// the colormap, so that outliers do not squeeze the colors of the rest.
+func (r *Renderer) SetColorRange(rng ColorRange, clip float32) {
+	r.coloring.rng, r.coloring.clip = rng, mgl32.Clamp(clip, 0, 49)
+	if r.coloring.colormap != NoColormap {
+		r.UploadMesh()
+	}
+	r.Notify(MsgColorRange, rng, r.coloring.clip)
+}
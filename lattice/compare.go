@@ -0,0 +1,136 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// RenderSettings bundles the options that can be compared side by side.
+type RenderSettings struct {
+	Name        string
+	Multisample bool
+	Wireframe   bool
+	Animate     bool
+	// Occlusion darkens cube corners surrounded by other cells.
+	Occlusion bool
+	// Shading lights the faces by their angle to the sun.
+	Shading bool
+	// Shadows removes the sunlight from faces other cubes hide from the
+	// sun.
+	Shadows bool
+}
+
+// renderPresets are the settings bundles selectable for comparison. The
+// first one is used when comparison is off.
+var renderPresets = []RenderSettings{
+	{Name: "default", Multisample: true, Animate: true, Occlusion: true, Shading: true, Shadows: true},
+	{Name: "no-msaa", Animate: true, Occlusion: true, Shading: true, Shadows: true},
+	{Name: "wireframe", Multisample: true, Wireframe: true, Animate: true, Occlusion: true, Shading: true, Shadows: true},
+	{Name: "static", Multisample: true, Occlusion: true, Shading: true, Shadows: true},
+	{Name: "no-ao", Multisample: true, Animate: true, Shading: true, Shadows: true},
+	{Name: "flat", Multisample: true, Animate: true, Occlusion: true},
+	{Name: "no-shadows", Multisample: true, Animate: true, Occlusion: true, Shading: true},
+}
+
+func findPreset(name string) (int, bool) {
+	for i, p := range renderPresets {
+		if p.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+type CompareMode int
+
+const (
+	CompareOff CompareMode = iota
+	// CompareSplit draws the full view twice, divided by a movable line.
+	CompareSplit
+	// CompareSideBySide draws two half-width views next to each other.
+	CompareSideBySide
+)
+
+var compareModeNames = []string{"off", "split", "side"}
+
+func (m CompareMode) String() string {
+	return compareModeNames[m]
+}
+
+// Comparison renders the same camera with two settings bundles.
+type Comparison struct {
+	Mode CompareMode
+	A, B int
+	// Split is the position of the split line as a fraction of the width.
+	Split float32
+
+	dragging bool
+}
+
+func NewComparison() Comparison {
+	return Comparison{A: 0, B: 1, Split: 0.5}
+}
+
+func (c *Comparison) String() string {
+	return fmt.Sprintf("%v (%v | %v)", c.Mode, renderPresets[c.A].Name, renderPresets[c.B].Name)
+}
+
+func (c *Comparison) NextMode() {
+	c.Mode = (c.Mode + 1) % CompareMode(len(compareModeNames))
+}
+
+func (c *Comparison) NextB() {
+	c.B = (c.B + 1) % len(renderPresets)
+}
+
+// Drag moves the split line by dx window pixels.
+func (c *Comparison) Drag(dx float64, width int) {
+	if width == 0 {
+		return
+	}
+	c.Split = mgl32.Clamp(c.Split+float32(dx/float64(width)), 0, 1)
+}
+
+// render draws the lattice into the viewport at x, y of the given size
+// according to the comparison mode.
+func (c *Comparison) render(r *Renderer, x, y, width, height int32) {
+	a, b := renderPresets[c.A], renderPresets[c.B]
+	switch c.Mode {
+	case CompareOff:
+		r.drawLattice(a)
+
+	case CompareSplit:
+		split := int32(c.Split * float32(width))
+		gl.Enable(gl.SCISSOR_TEST)
+		gl.Scissor(x, y, split, height)
+		r.drawLattice(a)
+		gl.Scissor(x+split, y, width-split, height)
+		r.drawLattice(b)
+
+		gl.Scissor(x+split-1, y, 2, height)
+		gl.ClearColor(1, 1, 1, 1)
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+		r.styles[r.style].clearColor()
+		gl.Disable(gl.SCISSOR_TEST)
+
+	case CompareSideBySide:
+		half := width / 2
+		projection := r.projectionFor(half, height)
+		gl.UniformMatrix4fv(r.projectionUniform, 1, false, &projection[0])
+		gl.Viewport(x, y, half, height)
+		r.drawLattice(a)
+		gl.Viewport(x+half, y, width-half, height)
+		r.drawLattice(b)
+
+		gl.Viewport(x, y, width, height)
+		gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+	}
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.Enable(gl.MULTISAMPLE)
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+// compassRadius is the radius of the compass in HUD pixels before
+// scaling.
+const compassRadius = 36
+
+var (
+	compassBack = mgl32.Vec4{0, 0, 0, 0.35}
+	// compassColors are the colors of the x, y and z axes.
+	compassColors = [3]mgl32.Vec3{{1, 0.3, 0.3}, {0.3, 1, 0.3}, {0.4, 0.6, 1}}
+	// compassDim darkens the negative halves of the axes.
+	compassDim = float32(0.4)
+)
+
+var compassLabels = [3]string{"X", "Y", "Z"}
+
+// lineRenderer draws colored lines, such as the axes of the compass and
+// the breadcrumbs of the camera.
+type lineRenderer struct {
+	program uint32
+	vao     uint32
+	vbo     uint32
+
+	projectionUniform int32
+	cameraUniform     int32
+	colorUniform      int32
+}
+
+func newLineRenderer() (*lineRenderer, error) {
+	program, err := glutil.NewProgram(ghostVertexShader, ghostFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	l := &lineRenderer{
+		program:           program,
+		projectionUniform: gl.GetUniformLocation(program, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(program, gl.Str("camera\x00")),
+		colorUniform:      gl.GetUniformLocation(program, gl.Str("color\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &l.vao)
+	gl.BindVertexArray(l.vao)
+	gl.GenBuffers(1, &l.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, l.vbo)
+	vert := uint32(gl.GetAttribLocation(program, gl.Str("vert\x00")))
+	gl.EnableVertexAttribArray(vert)
+	gl.VertexAttribPointerWithOffset(vert, 3, gl.FLOAT, false, 3*4, 0)
+	gl.BindVertexArray(uint32(prevVAO))
+	return l, nil
+}
+
+// Render draws the lines between pairs of points of lines in the colors
+// of colors, one per line, as seen through projection and camera.
+func (l *lineRenderer) Render(projection, camera mgl32.Mat4, lines []mgl32.Vec3, colors []mgl32.Vec3) {
+	if len(lines) == 0 {
+		return
+	}
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.UseProgram(l.program)
+	gl.UniformMatrix4fv(l.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(l.cameraUniform, 1, false, &camera[0])
+	gl.BindVertexArray(l.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, l.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(lines)*3*4, gl.Ptr(lines), gl.STREAM_DRAW)
+	// Runs of lines of one color are drawn together.
+	for i := 0; i < len(colors); {
+		j := i + 1
+		for j < len(colors) && colors[j] == colors[i] {
+			j++
+		}
+		gl.Uniform3fv(l.colorUniform, 1, &colors[i][0])
+		gl.DrawArrays(gl.LINES, int32(2*i), int32(2*(j-i)))
+		i = j
+	}
+}
+
+// Delete releases the GL objects of the renderer.
+func (l *lineRenderer) Delete() {
+	gl.DeleteBuffers(1, &l.vbo)
+	gl.DeleteVertexArrays(1, &l.vao)
+	gl.DeleteProgram(l.program)
+}
+
+// ToggleCompass shows or hides the compass.
+func (r *Renderer) ToggleCompass() {
+	r.showCompass = !r.showCompass
+}
+
+// compassCenter returns the center of the compass in HUD coordinates, at
+// the top of a screen of the given width, and its radius.
+func compassCenter(width int, scale float32) (mgl32.Vec2, float32) {
+	radius := compassRadius * scale
+	return mgl32.Vec2{float32(width) / 2, 8*scale + radius}, radius
+}
+
+// compassRotation returns the rotation of the camera, which turns the axes
+// of the data as the view does.
+func (r *Renderer) compassRotation() mgl32.Mat4 {
+	rot := r.camera
+	rot.SetCol(3, mgl32.Vec4{0, 0, 0, 1})
+	return rot
+}
+
+// addCompass queues the backdrop and the axis labels of the compass at
+// the top center of a screen of the given width. The axes point where
+// those of the data point in the view, labeled at their positive ends.
+func (r *Renderer) addCompass(t *hud.Text, width int, scale float32) {
+	if !r.showCompass {
+		return
+	}
+	c, radius := compassCenter(width, scale)
+	t.AddRect(c[0]-radius, c[1]-radius, 2*radius, 2*radius, compassBack)
+	rot := r.compassRotation()
+	for axis, label := range compassLabels {
+		var tip mgl32.Vec3
+		tip[axis] = 0.95
+		p := mgl32.TransformCoordinate(tip, rot)
+		w, h := hud.Measure(label, scale)
+		// HUD coordinates run down the screen.
+		x := c[0] + p[0]*(radius-w) - w/2
+		y := c[1] - p[1]*(radius-h) - h/2
+		color := compassColors[axis]
+		t.AddText(x, y, scale, color.Vec4(1), label)
+	}
+}
+
+// drawCompass draws the axes of the compass over the HUD backdrop queued
+// by addCompass, on a screen of the given size.
+func (r *Renderer) drawCompass(width, height int, scale float32) {
+	if !r.showCompass {
+		return
+	}
+	c, radius := compassCenter(width, scale)
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+	size := int32(2 * radius)
+	gl.Viewport(int32(c[0]-radius), int32(height)-int32(c[1]+radius), size, size)
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+
+	var lines, colors []mgl32.Vec3
+	for axis, color := range compassColors {
+		var tip mgl32.Vec3
+		tip[axis] = 0.7
+		lines = append(lines, mgl32.Vec3{}, tip, mgl32.Vec3{}, tip.Mul(-1))
+		colors = append(colors, color, color.Mul(compassDim))
+	}
+	// The view looks down -z, so the axes are projected flat.
+	projection := mgl32.Ortho(-1, 1, -1, 1, -1, 1)
+	r.lines.Render(projection, r.compassRotation(), lines, colors)
+}
@@ -0,0 +1,1158 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+type command struct {
+	usage string
+	run   func(r *Renderer, args string) error
+}
+
+// Console executes text commands. Lines are read on a separate goroutine
+// but commands run on the render thread from Poll, since most of them
+// touch GL state.
+type Console struct {
+	commands map[string]command
+	lines    chan string
+	// scripts are the scripts running, each started by the one before.
+	scripts []*script
+}
+
+func NewConsole() *Console {
+	c := &Console{
+		commands: make(map[string]command),
+		lines:    make(chan string, 16),
+	}
+	c.Register("help", "help", func(*Renderer, string) error {
+		c.printHelp()
+		return nil
+	})
+	c.Register("locale", "locale <name>", func(r *Renderer, args string) error {
+		return r.SetLocale(args)
+	})
+	registerSelectionCommands(c)
+	registerFileCommands(c)
+	registerRenderCommands(c)
+	registerViewCommands(c)
+	registerExportCommands(c)
+	registerCollabCommands(c)
+	registerScriptCommands(c)
+	registerLayerCommands(c)
+	registerHookCommands(c)
+	registerMaterialCommands(c)
+	registerDisplaceCommands(c)
+	return c
+}
+
+// Register adds a command. usage is printed by help and on errors.
+func (c *Console) Register(name, usage string, run func(r *Renderer, args string) error) {
+	c.commands[name] = command{usage: usage, run: run}
+}
+
+// Listen reads commands from r, one per line, until EOF.
+func (c *Console) Listen(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		c.lines <- scanner.Text()
+	}
+}
+
+// Poll runs the commands received since the previous call, and the lines
+// of the running scripts that are due.
+func (c *Console) Poll(r *Renderer) {
+	c.pollScripts(r)
+	for {
+		select {
+		case line := <-c.lines:
+			if err := c.Exec(r, line); err != nil {
+				r.NotifyError(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Console) Exec(r *Renderer, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	r.recordCommand(line)
+	name, args := line, ""
+	if i := strings.IndexFunc(line, isSpace); i >= 0 {
+		name, args = line[:i], strings.TrimSpace(line[i:])
+	}
+	cmd, ok := c.commands[name]
+	if !ok {
+		return r.locale.Errorf(MsgUnknownCommand, name)
+	}
+	if err := cmd.run(r, args); err != nil {
+		return r.locale.Errorf(MsgCommandFailed, name, err, cmd.usage)
+	}
+	return nil
+}
+
+func (c *Console) printHelp() {
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(" ", c.commands[name].usage)
+	}
+}
+
+func registerSelectionCommands(c *Console) {
+	c.Register("select", "select <query>", func(r *Renderer, args string) error {
+		q, err := ParseQuery(args)
+		if err != nil {
+			return err
+		}
+		r.selection = q.Select(r.cells)
+		r.Notify(MsgCellsSelected, len(r.selection))
+		return nil
+	})
+	c.Register("deselect", "deselect", func(r *Renderer, args string) error {
+		r.selection = NewSelection()
+		return nil
+	})
+	c.Register("group", "group <name>", func(r *Renderer, args string) error {
+		if args == "" {
+			return r.locale.Errorf(MsgMissingGroupName)
+		}
+		if _, err := r.groups.Add(args, r.selection); err != nil {
+			return err
+		}
+		changed := r.selection
+		r.selection = NewSelection()
+		r.groupsChanged(changed)
+		return nil
+	})
+	c.Register("ungroup", "ungroup <name>", func(r *Renderer, args string) error {
+		g := r.groups.Get(args)
+		if g == nil {
+			return r.locale.Errorf(MsgNoGroup, args)
+		}
+		r.groups.Remove(args)
+		r.groupsChanged(g.Cells)
+		return nil
+	})
+	c.Register("groups", "groups", func(r *Renderer, args string) error {
+		for _, g := range r.groups.All() {
+			fmt.Printf("  %v: %v cells, hidden: %v\n", g.Name, len(g.Cells), g.Hidden)
+		}
+		return nil
+	})
+	c.Register("hide", "hide <group>", func(r *Renderer, args string) error {
+		return r.updateGroup(args, func(g *Group) { g.Hidden = true })
+	})
+	c.Register("show", "show <group>", func(r *Renderer, args string) error {
+		return r.updateGroup(args, func(g *Group) { g.Hidden = false })
+	})
+	c.Register("color", "color <group> [<r> <g> <b>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 {
+			return r.updateGroup(fields[0], func(g *Group) { g.Color = nil })
+		}
+		if len(fields) != 4 {
+			return r.locale.Errorf(MsgExpectedColor)
+		}
+		var color mgl32.Vec3
+		for i := range color {
+			v, err := strconv.ParseFloat(fields[i+1], 32)
+			if err != nil {
+				return err
+			}
+			color[i] = float32(v)
+		}
+		return r.updateGroup(fields[0], func(g *Group) { g.Color = &color })
+	})
+	c.Register("animate", "animate <group> <speed> [<phase>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) < 2 || len(fields) > 3 {
+			return r.locale.Errorf(MsgExpectedSpeed)
+		}
+		var anim Animation
+		var err error
+		if anim.Speed, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return err
+		}
+		if len(fields) == 3 {
+			if anim.Phase, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return err
+			}
+		}
+		return r.updateGroup(fields[0], func(g *Group) { g.Animation = anim })
+	})
+	c.Register("export", "export <group> <file.csv>", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return r.locale.Errorf(MsgExpectedGroupFile)
+		}
+		g := r.groups.Get(fields[0])
+		if g == nil {
+			return r.locale.Errorf(MsgNoGroup, fields[0])
+		}
+		if err := g.Export(fields[1], r.cells); err != nil {
+			return err
+		}
+		r.Notify(MsgGroupExported, g.Name, fields[1])
+		return nil
+	})
+}
+
+func registerFileCommands(c *Console) {
+	c.Register("save", "save <file.csv|scene.json>", func(r *Renderer, args string) error {
+		if args == "" {
+			return r.locale.Errorf(MsgMissingFileName)
+		}
+		if isSceneFile(args) {
+			if err := r.SaveScene(args); err != nil {
+				return err
+			}
+			r.source = args
+			r.Notify(MsgSceneSaved, args)
+			return nil
+		}
+		if err := SaveCells(args, r.cells.unpackRefined()); err != nil {
+			return err
+		}
+		r.source = args
+		r.Notify(MsgCellsSaved, r.cells.count(), args)
+		return nil
+	})
+	c.Register("load", "load <file.csv|scene.json>", func(r *Renderer, args string) error {
+		if args == "" {
+			return r.locale.Errorf(MsgMissingFileName)
+		}
+		return r.LoadFile(args)
+	})
+	c.Register("watch", "watch <file.csv>|off", func(r *Renderer, args string) error {
+		switch args {
+		case "":
+			return r.locale.Errorf(MsgMissingFileName)
+		case "off":
+			if r.watch == nil {
+				return r.locale.Errorf(MsgNotWatching)
+			}
+			path := r.watch.path
+			r.StopWatching()
+			r.Notify(MsgWatchStopped, path)
+			return nil
+		}
+		return r.Watch(args)
+	})
+	c.Register("cif", "cif <file.cif> [<n>|<na>x<nb>x<nc>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			return r.locale.Errorf(MsgMissingFileName)
+		}
+		supercell := defaultSupercell
+		if len(fields) == 2 {
+			var err error
+			if supercell, err = ParseSupercell(fields[1]); err != nil {
+				return r.locale.Errorf(MsgExpectedSupercell)
+			}
+		}
+		c, err := LoadCIF(fields[0], supercell)
+		if err != nil {
+			return err
+		}
+		cells, params, err := c.cells(r.params)
+		if err != nil {
+			return r.locale.Errorf(MsgLatticeTooLarge, params.Side(), 2*MaxHalfSize+1)
+		}
+		r.SetCells(cells, params)
+		r.crystal = c
+		r.Notify(MsgCIFLoaded, len(cells), fields[0])
+		return nil
+	})
+	c.Register("supercell", "supercell <n>|<na>x<nb>x<nc>", func(r *Renderer, args string) error {
+		supercell, err := ParseSupercell(strings.TrimSpace(args))
+		if err != nil {
+			return r.locale.Errorf(MsgExpectedSupercell)
+		}
+		return r.SetSupercell(supercell)
+	})
+	c.Register("molecule", "molecule <file.xyz|file.pdb>", func(r *Renderer, args string) error {
+		if args == "" {
+			return r.locale.Errorf(MsgMissingFileName)
+		}
+		cells, params, err := LoadMolecule(args, r.params)
+		if err != nil {
+			return err
+		}
+		r.SetCells(cells, params)
+		r.Notify(MsgMoleculeLoaded, len(cells), args)
+		return nil
+	})
+	c.Register("diff", "diff <a.csv> <b.csv>", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return r.locale.Errorf(MsgExpectedTwoFiles)
+		}
+		a, pa, err := LoadCells(fields[0], r.params)
+		if err != nil {
+			return err
+		}
+		b, pb, err := LoadCells(fields[1], r.params)
+		if err != nil {
+			return err
+		}
+		params := pa
+		if pb.HalfSize > params.HalfSize {
+			params = pb
+		}
+		assignIDs(a, params)
+		assignIDs(b, params)
+
+		diff, stats := diffCells(a, b)
+		r.SetCells(diff, params)
+		r.Notify(MsgDiffStats, stats.Added, stats.Removed, stats.Changed, stats.Unchanged)
+		return nil
+	})
+}
+
+func registerExportCommands(c *Console) {
+	c.Register("usd", "usd start <file.usda> [changes] | usd stop", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "stop":
+			return r.StopUSD()
+		case len(fields) == 2 && fields[0] == "start":
+			r.StartUSD(fields[1], false)
+		case len(fields) == 3 && fields[0] == "start" && fields[2] == "changes":
+			r.StartUSD(fields[1], true)
+		default:
+			return r.locale.Errorf(MsgExpectedUSD)
+		}
+		return nil
+	})
+	c.Register("record", "record start [motion]|stop", func(r *Renderer, args string) error {
+		switch args {
+		case "start", "start motion":
+			if r.recorder != nil {
+				return nil
+			}
+			// Motion asked for here is for this recording only.
+			motion := r.recordMotion
+			r.recordMotion = motion || args == "start motion"
+			err := r.StartRecording()
+			r.recordMotion = motion
+			return err
+		case "stop":
+			r.StopRecording()
+		default:
+			return r.locale.Errorf(MsgExpectedRecord)
+		}
+		return nil
+	})
+	c.Register("cinema", "cinema on|off [<aspect>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 || (fields[0] != "on" && fields[0] != "off") {
+			return r.locale.Errorf(MsgExpectedCinema)
+		}
+		var aspect float32
+		if len(fields) == 2 {
+			var err error
+			if aspect, err = ParseAspect(fields[1]); err != nil {
+				return err
+			}
+		}
+		return r.SetCinema(fields[0] == "on", aspect)
+	})
+	c.Register("beauty", "beauty on|off", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "on":
+			return r.SetBeauty(true)
+		case "off":
+			return r.SetBeauty(false)
+		}
+		return r.locale.Errorf(MsgExpectedBeauty)
+	})
+	c.Register("demo", "demo record <file> [<fps>]|play <file>|stop", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 2 && fields[0] == "record":
+			return r.StartDemo(fields[1], defaultDemoRate)
+		case len(fields) == 3 && fields[0] == "record":
+			rate, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return r.locale.Errorf(MsgExpectedDemo)
+			}
+			return r.StartDemo(fields[1], rate)
+		case len(fields) == 2 && fields[0] == "play":
+			return r.PlayDemo(fields[1])
+		case len(fields) == 1 && fields[0] == "stop":
+			if !r.demo.active() {
+				return r.locale.Errorf(MsgNoDemo)
+			}
+			r.StopDemo()
+		default:
+			return r.locale.Errorf(MsgExpectedDemo)
+		}
+		return nil
+	})
+	c.Register("screenshot", "screenshot [transparent]", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "":
+			r.screenshotTransparent = false
+		case "transparent":
+			r.screenshotTransparent = true
+		default:
+			return r.locale.Errorf(MsgExpectedScreenshot)
+		}
+		r.screenshotPending = true
+		return nil
+	})
+}
+
+func registerViewCommands(c *Console) {
+	c.Register("wireframe", "wireframe on|off", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "on":
+			r.SetWireframe(true)
+			return nil
+		case "off":
+			r.SetWireframe(false)
+			return nil
+		}
+		return r.locale.Errorf(MsgExpectedWireframe)
+	})
+	c.Register("axes", "axes y|z [right|left]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			return r.locale.Errorf(MsgExpectedAxes)
+		}
+		up, ok := ParseUpAxis(fields[0])
+		if !ok {
+			return r.locale.Errorf(MsgExpectedAxes)
+		}
+		hand := r.handedness
+		if len(fields) == 2 {
+			if hand, ok = ParseHandedness(fields[1]); !ok {
+				return r.locale.Errorf(MsgExpectedAxes)
+			}
+		}
+		r.SetAxes(up, hand)
+		return nil
+	})
+	c.Register("units", "units <length per cell>[<unit>]|off", func(r *Renderer, args string) error {
+		args = strings.TrimSpace(args)
+		if args == "off" {
+			r.SetUnits(Units{})
+			return nil
+		}
+		u, ok := ParseUnits(args)
+		if !ok || u.PerCell == 0 {
+			return r.locale.Errorf(MsgExpectedUnits)
+		}
+		r.SetUnits(u)
+		return nil
+	})
+	c.Register("measure", "measure", func(r *Renderer, args string) error {
+		return r.Measure()
+	})
+	c.Register("alpha", "alpha <opacity from 0 to 1>", func(r *Renderer, args string) error {
+		alpha, err := strconv.ParseFloat(strings.TrimSpace(args), 32)
+		if err != nil {
+			return r.locale.Errorf(MsgExpectedAlpha)
+		}
+		return r.SetAlpha(float32(alpha))
+	})
+	c.Register("fog", "fog <density> [<r> <g> <b>]|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 && fields[0] == "off" {
+			r.SetFog(Fog{})
+			return nil
+		}
+		if len(fields) != 1 && len(fields) != 4 {
+			return r.locale.Errorf(MsgExpectedFog)
+		}
+		v, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		if v[0] < 0 {
+			return r.locale.Errorf(MsgExpectedFog)
+		}
+		f := Fog{Density: v[0]}
+		if len(v) == 4 {
+			f.Color = &mgl32.Vec3{v[1], v[2], v[3]}
+		}
+		r.SetFog(f)
+		return nil
+	})
+	c.Register("compass", "compass on|off", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "on":
+			r.showCompass = true
+			return nil
+		case "off":
+			r.showCompass = false
+			return nil
+		}
+		return r.locale.Errorf(MsgExpectedCompass)
+	})
+	c.Register("minimap", "minimap on|off", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "on":
+			r.showMinimap = true
+			return nil
+		case "off":
+			r.showMinimap = false
+			return nil
+		}
+		return r.locale.Errorf(MsgExpectedMinimap)
+	})
+	c.Register("breadcrumbs", "breadcrumbs on [<spacing>]|off|clear", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "off":
+			r.SetBreadcrumbs(false, r.crumbs.spacing)
+			return nil
+		case len(fields) == 1 && fields[0] == "clear":
+			r.ClearBreadcrumbs()
+			return nil
+		case len(fields) == 1 && fields[0] == "on":
+			r.SetBreadcrumbs(true, r.crumbs.spacing)
+			return nil
+		case len(fields) == 2 && fields[0] == "on":
+			spacing, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil || spacing <= 0 {
+				return r.locale.Errorf(MsgExpectedBreadcrumbs)
+			}
+			r.SetBreadcrumbs(true, float32(spacing))
+			return nil
+		}
+		return r.locale.Errorf(MsgExpectedBreadcrumbs)
+	})
+	c.Register("fit", "fit", func(r *Renderer, args string) error {
+		r.FitView()
+		return nil
+	})
+	c.Register("frustum", "frustum [<near> <far>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			r.Notify(MsgDepthRange, r.near, r.far)
+			return nil
+		}
+		if len(fields) != 2 {
+			return r.locale.Errorf(MsgExpectedDepthRange)
+		}
+		v, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		return r.SetDepthRange(v[0], v[1])
+	})
+	c.Register("link", "link [<uri|json>]", func(r *Renderer, args string) error {
+		if args == "" {
+			r.CopyViewLink()
+			return nil
+		}
+		l, err := ParseViewLink(args)
+		if err != nil {
+			return err
+		}
+		return r.OpenViewLink(l)
+	})
+	c.Register("post", "post gamma <gamma>|vignette <strength> [<radius>]|bloom [<strength> [<threshold>]]|ssao [<strength> [<radius>]]|fxaa|remove <effect>|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "off":
+			r.ClearPostEffects()
+			return nil
+		case len(fields) == 2 && fields[0] == "remove":
+			return r.RemovePostEffect(fields[1])
+		case len(fields) == 0:
+			return r.locale.Errorf(MsgExpectedPost)
+		}
+		if len(fields) == 1 && fields[0] == "fxaa" {
+			return r.SetPostEffect(FXAA{})
+		}
+		v, err := parseFloats(fields[1:])
+		if err != nil {
+			return err
+		}
+		switch {
+		case fields[0] == "gamma" && len(v) == 1 && v[0] > 0:
+			return r.SetPostEffect(Gamma{Gamma: v[0]})
+		case fields[0] == "vignette" && len(v) == 1:
+			return r.SetPostEffect(Vignette{Strength: v[0]})
+		case fields[0] == "vignette" && len(v) == 2 && v[1] > 0 && v[1] < 1:
+			return r.SetPostEffect(Vignette{Strength: v[0], Radius: v[1]})
+		case fields[0] == "bloom" && len(v) <= 2:
+			b := Bloom{Strength: defaultBloomStrength, Threshold: defaultBloomThreshold}
+			if len(v) > 0 {
+				b.Strength = v[0]
+			}
+			if len(v) > 1 {
+				b.Threshold = v[1]
+			}
+			return r.SetPostEffect(b)
+		case fields[0] == "ssao" && len(v) <= 2 && (len(v) < 2 || v[1] > 0):
+			s := SSAO{Strength: defaultSSAOStrength, Radius: defaultSSAORadius}
+			if len(v) > 0 {
+				s.Strength = v[0]
+			}
+			if len(v) > 1 {
+				s.Radius = v[1]
+			}
+			return r.SetPostEffect(s)
+		}
+		return r.locale.Errorf(MsgExpectedPost)
+	})
+	c.Register("edit", "edit on|off", func(r *Renderer, args string) error {
+		switch strings.TrimSpace(args) {
+		case "on":
+			r.SetEditing(true)
+			return nil
+		case "off":
+			r.SetEditing(false)
+			return nil
+		}
+		return r.locale.Errorf(MsgExpectedEdit)
+	})
+}
+
+func registerCollabCommands(c *Console) {
+	c.Register("host", "host <address> [<name>]", func(r *Renderer, args string) error {
+		return r.collabCommand(args, true)
+	})
+	c.Register("join", "join <address> [<name>]", func(r *Renderer, args string) error {
+		return r.collabCommand(args, false)
+	})
+	c.Register("leave", "leave", func(r *Renderer, args string) error {
+		if r.collab == nil {
+			return r.locale.Errorf(MsgNoCollab)
+		}
+		r.StopCollab()
+		r.Notify(MsgCollabLeft)
+		return nil
+	})
+}
+
+func (r *Renderer) collabCommand(args string, host bool) error {
+	fields := strings.Fields(args)
+	if len(fields) < 1 || len(fields) > 2 {
+		return r.locale.Errorf(MsgExpectedAddress)
+	}
+	name := ""
+	if len(fields) == 2 {
+		name = fields[1]
+	}
+	if err := r.StartCollab(fields[0], name, host); err != nil {
+		return r.locale.Errorf(MsgCollabFailed, err)
+	}
+	return nil
+}
+
+func registerRenderCommands(c *Console) {
+	c.Register("camera", "camera [<x> <y> <z> [<pitch> <yaw>]]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			pos := r.worldPos(r.camPos)
+			r.Notify(MsgCamera, pos[0], pos[1], pos[2], mgl32.RadToDeg(r.pitch), mgl32.RadToDeg(r.yaw))
+			return nil
+		}
+		if len(fields) != 3 && len(fields) != 5 {
+			return r.locale.Errorf(MsgExpectedCamera)
+		}
+		v, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		pitch, yaw := mgl32.RadToDeg(r.pitch), mgl32.RadToDeg(r.yaw)
+		if len(v) == 5 {
+			pitch, yaw = v[3], v[4]
+		}
+		r.SetCamera(r.displayPos(mgl32.Vec3{v[0], v[1], v[2]}), pitch, yaw)
+		return nil
+	})
+	c.Register("path", "path add [<seconds>]|play|stop|clear|save <file.json>|load <file.json>", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "add":
+			r.AddKeyframe(defaultKeyGap)
+		case len(fields) == 2 && fields[0] == "add":
+			gap, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || gap <= 0 {
+				return r.locale.Errorf(MsgExpectedPath)
+			}
+			r.AddKeyframe(gap)
+		case len(fields) == 1 && fields[0] == "play":
+			return r.PlayPath()
+		case len(fields) == 1 && fields[0] == "stop":
+			r.StopPath()
+		case len(fields) == 1 && fields[0] == "clear":
+			r.ClearPath()
+		case len(fields) == 2 && fields[0] == "save":
+			if err := r.SavePath(fields[1]); err != nil {
+				return err
+			}
+			r.Notify(MsgPathSaved, len(r.path.keys), fields[1])
+		case len(fields) == 2 && fields[0] == "load":
+			if err := r.LoadPath(fields[1]); err != nil {
+				return err
+			}
+			r.Notify(MsgPathLoaded, len(r.path.keys), fields[1])
+		default:
+			return r.locale.Errorf(MsgExpectedPath)
+		}
+		return nil
+	})
+	c.Register("goto", "goto <x> <y> <z> [<seconds>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) != 3 && len(fields) != 4 {
+			return r.locale.Errorf(MsgExpectedGoto)
+		}
+		v, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		seconds := float64(defaultFlightTime)
+		if len(v) == 4 {
+			seconds = float64(v[3])
+		}
+		r.FlyTo(r.displayPos(mgl32.Vec3{v[0], v[1], v[2]}), seconds)
+		return nil
+	})
+	c.Register("shake", "shake <trauma> [<degrees> [<decay>]]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 3 {
+			return r.locale.Errorf(MsgExpectedShake)
+		}
+		v, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		if v[0] < 0 || v[0] > 1 {
+			return r.locale.Errorf(MsgExpectedShake)
+		}
+		amplitude, decay := r.shake.amplitude, r.shake.decay
+		if len(v) > 1 {
+			amplitude = v[1]
+		}
+		if len(v) > 2 {
+			decay = float64(v[2])
+		}
+		if amplitude < 0 || decay <= 0 {
+			return r.locale.Errorf(MsgExpectedShake)
+		}
+		r.SetShake(amplitude, decay)
+		r.Shake(float64(v[0]))
+		return nil
+	})
+	c.Register("compare", "compare off|split|side [<preset a> <preset b>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) != 1 && len(fields) != 3 {
+			return r.locale.Errorf(MsgExpectedCompare)
+		}
+		mode := -1
+		for i, name := range compareModeNames {
+			if name == fields[0] {
+				mode = i
+			}
+		}
+		if mode < 0 {
+			return r.locale.Errorf(MsgUnknownMode, fields[0])
+		}
+		if len(fields) == 3 {
+			a, ok := findPreset(fields[1])
+			if !ok {
+				return r.locale.Errorf(MsgUnknownPreset, fields[1])
+			}
+			b, ok := findPreset(fields[2])
+			if !ok {
+				return r.locale.Errorf(MsgUnknownPreset, fields[2])
+			}
+			r.compare.A, r.compare.B = a, b
+		}
+		r.compare.Mode = CompareMode(mode)
+		return nil
+	})
+	c.Register("size", "size <cells on each side>", func(r *Renderer, args string) error {
+		d, err := strconv.Atoi(args)
+		if err != nil || d < 0 {
+			return r.locale.Errorf(MsgExpectedSize)
+		}
+		return r.rebuildLattice(d)
+	})
+	c.Register("lattice", "lattice "+strings.Join(LatticeTypes(), "|"), func(r *Renderer, args string) error {
+		t, ok := ParseLatticeType(strings.TrimSpace(args))
+		if !ok {
+			return r.locale.Errorf(MsgUnknownLatticeType, args, strings.Join(LatticeTypes(), ", "))
+		}
+		r.SetLatticeType(t)
+		return nil
+	})
+	c.Register("shape", "shape cube|sphere", func(r *Renderer, args string) error {
+		s, ok := ParseCellShape(strings.TrimSpace(args))
+		if !ok {
+			return r.locale.Errorf(MsgUnknownShape, args)
+		}
+		r.SetShape(s)
+		return nil
+	})
+	c.Register("defects", "defects <vacancies> [<interstitials> [<seed>]]|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 && fields[0] == "off" {
+			r.SetDefects(Defects{})
+			return nil
+		}
+		if len(fields) == 0 || len(fields) > 3 {
+			return r.locale.Errorf(MsgExpectedDefects)
+		}
+		var d Defects
+		var err error
+		if d.Vacancies, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return r.locale.Errorf(MsgExpectedDefects)
+		}
+		if len(fields) > 1 {
+			if d.Interstitials, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return r.locale.Errorf(MsgExpectedDefects)
+			}
+		}
+		if len(fields) > 2 {
+			if d.Seed, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return r.locale.Errorf(MsgExpectedDefects)
+			}
+		}
+		r.SetDefects(d)
+		return nil
+	})
+	c.Register("colormap", "colormap viridis|plasma|grayscale|off [distance|index|value|<file.csv|file.npy>]", func(r *Renderer, args string) error {
+		name, field := args, ""
+		if i := strings.IndexByte(args, ' '); i >= 0 {
+			name, field = args[:i], strings.TrimSpace(args[i+1:])
+		}
+		m, ok := ParseColormap(name)
+		if !ok {
+			return r.locale.Errorf(MsgUnknownColormap, name)
+		}
+		return r.SetColormap(m, field)
+	})
+	c.Register("fade", "fade <radius> [<strength>]|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 && fields[0] == "off" {
+			r.SetFade(DensityFade{})
+			return nil
+		}
+		if len(fields) == 0 || len(fields) > 2 {
+			return r.locale.Errorf(MsgExpectedFade)
+		}
+		f := DensityFade{Strength: defaultFadeStrength}
+		var err error
+		if f.Radius, err = strconv.Atoi(fields[0]); err != nil || f.Radius < 1 {
+			return r.locale.Errorf(MsgExpectedFade)
+		}
+		if len(fields) > 1 {
+			strength, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil {
+				return r.locale.Errorf(MsgExpectedFade)
+			}
+			f.Strength = float32(strength)
+		}
+		r.SetFade(f)
+		return nil
+	})
+	c.Register("clip", "clip <x> <y> <z> [<offset>]|view|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "off":
+			r.ClipOff()
+			return nil
+		case len(fields) == 1 && fields[0] == "view":
+			r.ClipToView()
+			return nil
+		case len(fields) != 3 && len(fields) != 4:
+			return r.locale.Errorf(MsgExpectedClip)
+		}
+		var v [4]float32
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(f, 32)
+			if err != nil {
+				return r.locale.Errorf(MsgExpectedClip)
+			}
+			v[i] = float32(x)
+		}
+		normal := mgl32.Vec3{v[0], v[1], v[2]}
+		if normal.Len() == 0 {
+			return r.locale.Errorf(MsgExpectedClip)
+		}
+		r.SetClipPlane(normal, v[3])
+		return nil
+	})
+	c.Register("trails", "trails <length>|off", func(r *Renderer, args string) error {
+		args = strings.TrimSpace(args)
+		if args == "off" {
+			r.SetTrails(0)
+			return nil
+		}
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 0 {
+			return r.locale.Errorf(MsgExpectedTrails)
+		}
+		r.SetTrails(n)
+		return nil
+	})
+	c.Register("refine", "refine 2|4|off", func(r *Renderer, args string) error {
+		args = strings.TrimSpace(args)
+		if args == "off" {
+			return r.Refine(1)
+		}
+		factor, err := strconv.Atoi(args)
+		if err != nil {
+			return r.locale.Errorf(MsgExpectedRefine)
+		}
+		return r.Refine(factor)
+	})
+	c.Register("gpusim", "gpusim off|life|grayscott [<side> [<steps per frame>]]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 3 {
+			return r.locale.Errorf(MsgExpectedGPUSim)
+		}
+		kind, ok := ParseGPUSim(fields[0])
+		if !ok {
+			return r.locale.Errorf(MsgExpectedGPUSim)
+		}
+		var n [2]int
+		for i, f := range fields[1:] {
+			var err error
+			if n[i], err = strconv.Atoi(f); err != nil || n[i] <= 0 {
+				return r.locale.Errorf(MsgExpectedGPUSim)
+			}
+		}
+		return r.SetGPUSim(kind, n[0], n[1])
+	})
+	c.Register("colorrange", "colorrange linear|equalize [<clip percent>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			return r.locale.Errorf(MsgExpectedColorRange)
+		}
+		rng, ok := ParseColorRange(fields[0])
+		if !ok {
+			return r.locale.Errorf(MsgExpectedColorRange)
+		}
+		clip := r.coloring.clip
+		if len(fields) > 1 {
+			v, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil {
+				return r.locale.Errorf(MsgExpectedColorRange)
+			}
+			clip = float32(v)
+		}
+		r.SetColorRange(rng, clip)
+		return nil
+	})
+	c.Register("datakind", "datakind auto|continuous|categorical", func(r *Renderer, args string) error {
+		kind, ok := ParseDataKind(args)
+		if !ok {
+			return r.locale.Errorf(MsgExpectedDataKind)
+		}
+		r.SetDataKind(kind)
+		return nil
+	})
+	c.Register("sizeby", "sizeby distance|index|value|<file.csv|file.npy>|off [<min size>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			return r.locale.Errorf(MsgExpectedSizeField)
+		}
+		min := float64(defaultMinSize)
+		if len(fields) > 1 {
+			var err error
+			if min, err = strconv.ParseFloat(fields[1], 32); err != nil || min <= 0 || min > 1 {
+				return r.locale.Errorf(MsgExpectedSizeField)
+			}
+		}
+		return r.SetSizeField(fields[0], float32(min))
+	})
+	c.Register("style", "style <name>", func(r *Renderer, args string) error {
+		return r.SetStyleByName(strings.TrimSpace(args))
+	})
+	c.Register("cell", "cell off|unit|super", func(r *Renderer, args string) error {
+		o, ok := ParseCellOverlay(strings.TrimSpace(args))
+		if !ok {
+			return r.locale.Errorf(MsgUnknownOverlay, args)
+		}
+		return r.SetCellOverlay(o)
+	})
+	c.Register("plane", "plane <h> <k> <l>|off", func(r *Renderer, args string) error {
+		if strings.TrimSpace(args) == "off" {
+			r.HidePlane()
+			return nil
+		}
+		hkl, err := ParseMiller(args)
+		if err != nil {
+			return r.locale.Errorf(MsgExpectedMiller)
+		}
+		return r.ShowPlane(hkl)
+	})
+	c.Register("light", "light bake|off|save <file>|load <file> | light sun <x> <y> <z>", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 4 && fields[0] == "sun":
+			var dir mgl32.Vec3
+			for i := range dir {
+				v, err := strconv.ParseFloat(fields[i+1], 32)
+				if err != nil {
+					return err
+				}
+				dir[i] = float32(v)
+			}
+			if dir.Len() == 0 {
+				return r.locale.Errorf(MsgExpectedLight)
+			}
+			r.SetSun(dir)
+		case len(fields) == 1 && fields[0] == "bake":
+			if !r.params.gridAligned() {
+				return r.locale.Errorf(MsgLightmapGrid)
+			}
+			lp := DefaultLightParams()
+			lp.Sun = r.sun
+			r.BakeLight(lp)
+		case len(fields) == 1 && fields[0] == "off":
+			r.SetLightmap(nil)
+		case len(fields) == 2 && fields[0] == "save":
+			if r.lightmap == nil {
+				return r.locale.Errorf(MsgNoLightmap)
+			}
+			return r.lightmap.Save(fields[1])
+		case len(fields) == 2 && fields[0] == "load":
+			data, side, err := loadLightmapData(fields[1])
+			if err != nil {
+				return err
+			}
+			if side != r.params.Side() {
+				return r.locale.Errorf(MsgLightmapSize, side, r.params.Side())
+			}
+			if !r.params.gridAligned() {
+				return r.locale.Errorf(MsgLightmapGrid)
+			}
+			r.SetLightmap(NewLightmap(data, side))
+		default:
+			return r.locale.Errorf(MsgExpectedLight)
+		}
+		return nil
+	})
+	c.Register("decal", "decal image|text <name> <x> <y> <z> <size> <file|text> | decal remove <name>", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 2 && fields[0] == "remove" {
+			if !r.decals.Remove(fields[1]) {
+				return r.locale.Errorf(MsgNoDecal, fields[1])
+			}
+			return nil
+		}
+		if len(fields) < 7 || (fields[0] != "image" && fields[0] != "text") {
+			return r.locale.Errorf(MsgExpectedDecal)
+		}
+		var v [4]float32
+		for i := range v {
+			f, err := strconv.ParseFloat(fields[i+2], 32)
+			if err != nil {
+				return err
+			}
+			v[i] = float32(f)
+		}
+		var img image.Image
+		if fields[0] == "image" {
+			var err error
+			if img, err = loadDecalImage(fields[6]); err != nil {
+				return err
+			}
+		} else {
+			img = textDecal(strings.Join(fields[6:], " "))
+		}
+		return r.AddDecal(fields[1], img, mgl32.Vec3{v[0], v[1], v[2]}, v[3])
+	})
+	c.Register("texture", "texture <file>...|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "off":
+			return r.SetCellTextures(nil)
+		case len(fields) == 0:
+			return r.locale.Errorf(MsgExpectedTexture)
+		}
+		return r.SetCellTextures(fields)
+	})
+	c.Register("shadow", "shadow off|on|<size> [<bias>]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1 && fields[0] == "off":
+			r.shadowsOff = true
+			return nil
+		case len(fields) == 1 && fields[0] == "on":
+			r.shadowsOff = false
+			return nil
+		case len(fields) == 0 || len(fields) > 2:
+			return r.locale.Errorf(MsgExpectedShadow)
+		}
+		size, err := strconv.Atoi(fields[0])
+		if err != nil || size < 1 {
+			return r.locale.Errorf(MsgExpectedShadow)
+		}
+		if len(fields) == 2 {
+			bias, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil {
+				return err
+			}
+			r.shadow.Bias = float32(bias)
+		}
+		r.shadowsOff = false
+		return r.shadow.SetSize(size)
+	})
+	c.Register("overdraw", "overdraw off|<fragments shown in red>", func(r *Renderer, args string) error {
+		if args == "off" {
+			r.ShowOverdraw(false)
+			return nil
+		}
+		layers, err := strconv.Atoi(args)
+		if err != nil || layers < 1 {
+			return r.locale.Errorf(MsgExpectedOverdraw)
+		}
+		r.overdraw.Layers = layers
+		r.ShowOverdraw(true)
+		return nil
+	})
+	c.Register("vsync", "vsync off|on|adaptive", func(r *Renderer, args string) error {
+		mode, ok := ParseSwapMode(args)
+		if !ok {
+			return r.locale.Errorf(MsgUnknownSwapMode, args)
+		}
+		r.SetSwapMode(mode)
+		return nil
+	})
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// parseFloats parses every field as a float32.
+func parseFloats(fields []string) ([]float32, error) {
+	v := make([]float32, len(fields))
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 32)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = float32(x)
+	}
+	return v, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// hasResetStatus reports whether the current context tells when it is
+// lost, created robust with the ARB_robustness extension.
+func hasResetStatus() bool {
+	var strategy int32
+	gl.GetIntegerv(gl.RESET_NOTIFICATION_STRATEGY_ARB, &strategy)
+	// An unknown query leaves an error behind.
+	if gl.GetError() != gl.NO_ERROR || strategy != gl.LOSE_CONTEXT_ON_RESET_ARB {
+		return false
+	}
+	var n int32
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &n)
+	for i := int32(0); i < n; i++ {
+		if gl.GoStr(gl.GetStringi(gl.EXTENSIONS, uint32(i))) == "GL_ARB_robustness" {
+			return true
+		}
+	}
+	return false
+}
+
+// resetStatusNames name the reasons a context is lost.
+var resetStatusNames = map[uint32]string{
+	gl.GUILTY_CONTEXT_RESET_ARB:   "caused by this context",
+	gl.INNOCENT_CONTEXT_RESET_ARB: "caused by another context",
+	gl.UNKNOWN_CONTEXT_RESET_ARB:  "of unknown cause",
+}
+
+// checkContext reports whether the GL context was lost, such as when the
+// GPU driver reset, and if so ends Run so that the renderer is rebuilt in
+// a new context. Nothing drawn in a lost context shows again.
+func (r *Renderer) checkContext() bool {
+	if !r.resetStatus {
+		return false
+	}
+	status := gl.GetGraphicsResetStatusARB()
+	if status == gl.NO_ERROR {
+		return false
+	}
+	log.Printf("GL context lost, reset %v", resetStatusNames[status])
+	r.contextLost = true
+	return true
+}
+
+// carriedSession is the state of a renderer carried over to the one that
+// replaces it after its context is lost. None of it lives in GL objects:
+// the programs, buffers and textures are all built again from it.
+type carriedSession struct {
+	scene    sceneJSON
+	groups   *Groups
+	snapshot sessionSnapshot
+	source   string
+	console  *Console
+	reloader *reloader
+	locale   Locale
+	// x, y, width and height are the bounds of the window.
+	x, y, width, height int
+}
+
+// carry takes the state of r to rebuild it from.
+func (r *Renderer) carry() carriedSession {
+	s := carriedSession{
+		scene:    r.scene(),
+		groups:   r.groups,
+		snapshot: r.snapshot(),
+		source:   r.source,
+		console:  r.console,
+		reloader: r.reloader,
+		locale:   r.locale,
+	}
+	s.x, s.y = r.w.GetPos()
+	s.width, s.height = r.w.GetSize()
+	return s
+}
+
+// rebuild replaces r, whose context was lost, by a renderer in a new
+// window and context with the same session. opts are those r was created
+// with; the actions they take once at start, such as running a script or
+// playing a demo, are not repeated. If the new renderer cannot be created
+// the session is left in the recovery directory for the next start.
+func (r *Renderer) rebuild(opts Options) (*Renderer, error) {
+	s := r.carry()
+	recoveryDir := ""
+	if r.autosaver != nil {
+		recoveryDir = r.autosaver.dir
+	}
+	opts.Console, opts.Script, opts.Scene, opts.View = nil, "", "", nil
+	opts.Demo, opts.CameraPath, opts.Fit = "", "", false
+	opts.Styles, opts.Style = r.styles, ""
+	opts.Near, opts.Far = r.near, r.far
+	opts.Alpha, opts.Fog = r.alpha, r.fog
+	opts.Up, opts.Handedness, opts.Units = r.up, r.handedness, r.units
+	opts.Compass, opts.Minimap = r.showCompass, r.showMinimap
+	opts.PostEffects = r.post.effects
+	opts.Displacement = r.displacement
+	if !opts.Fullscreen {
+		opts.Width, opts.Height = s.width, s.height
+	}
+	if err := r.Close(); err != nil {
+		log.Println(err)
+	}
+	r.w.Destroy()
+
+	nr, err := newWindowRenderer(opts)
+	if err == nil {
+		if !opts.Fullscreen {
+			nr.w.SetPos(s.x, s.y)
+		}
+		nr.console, nr.locale, nr.groups = s.console, s.locale, s.groups
+		if s.reloader != nil {
+			nr.reloader = newReloader(s.reloader.script)
+			nr.reloader.scene, nr.reloader.applied = s.reloader.scene, s.reloader.applied
+		}
+		err = nr.applyScene(s.scene, "")
+		nr.source = s.source
+	}
+	if err != nil {
+		if recoveryDir != "" {
+			if err := writeRecovery(recoveryDir, s.snapshot, true); err != nil {
+				log.Println(err)
+			}
+		}
+		return nil, fmt.Errorf("failed to rebuild the renderer after the GL context was lost: %v", err)
+	}
+	nr.Notify(MsgContextRestored)
+	return nr, nil
+}
+
+// newWindowRenderer opens a window for opts and sets up rendering into
+// it.
+func newWindowRenderer(opts Options) (*Renderer, error) {
+	window, err := openWindow(opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRenderer(window, opts)
+	if err != nil {
+		window.Destroy()
+		return nil, err
+	}
+	return r, nil
+}
+
+// openWindow opens the window of opts, with a context that reports being
+// lost where the driver supports it.
+func openWindow(opts Options) (*glfw.Window, error) {
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.Samples, opts.Samples)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	// Resize the window with the content scale of the monitor it is on, so
+	// moving it to a screen with another DPI resizes the framebuffer and
+	// with it the viewport and projection.
+	glfw.WindowHint(glfw.ScaleToMonitor, glfw.True)
+	// Ask for 10 bits a color channel if asked to, which spares gradients
+	// banding on deep color displays, leaving 2 bits of alpha. Where there
+	// is no such format the closest one, usually 8 bits, is used instead.
+	if opts.DeepColor {
+		glfw.WindowHint(glfw.RedBits, 10)
+		glfw.WindowHint(glfw.GreenBits, 10)
+		glfw.WindowHint(glfw.BlueBits, 10)
+		glfw.WindowHint(glfw.AlphaBits, 2)
+	} else {
+		glfw.WindowHint(glfw.RedBits, 8)
+		glfw.WindowHint(glfw.GreenBits, 8)
+		glfw.WindowHint(glfw.BlueBits, 8)
+		glfw.WindowHint(glfw.AlphaBits, 8)
+	}
+	m := glfw.GetPrimaryMonitor()
+	vm := m.GetVideoMode()
+	width, height := opts.Width, opts.Height
+	if width == 0 || height == 0 {
+		width, height = windowWidth, windowHeight
+		if opts.Fullscreen {
+			width, height = vm.Width, vm.Height
+		}
+	}
+	glfw.WindowHint(glfw.ContextRobustness, glfw.LoseContextOnReset)
+	window, err := glfw.CreateWindow(width, height, opts.Title, nil, nil)
+	if err != nil {
+		// Without robustness a lost context goes unnoticed, but everything
+		// else works.
+		glfw.WindowHint(glfw.ContextRobustness, glfw.NoRobustness)
+		window, err = glfw.CreateWindow(width, height, opts.Title, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Fullscreen {
+		window.SetMonitor(m, 0, 0, width, height, vm.RefreshRate)
+	}
+	return window, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// maxDecals is the number of decals the lattice shader blends, the
+	// length of its decal arrays.
+	maxDecals = 8
+	// decalSize is the width and height of the texture layer each decal
+	// image is scaled into.
+	decalSize = 256
+)
+
+// decalBackground is drawn behind text decals so they stay readable on
+// any cell color, matching the HUD panels.
+var decalBackground = color.RGBA{0, 0, 0, 153}
+
+// Decal is an image projected onto the lattice surfaces inside a box.
+type Decal struct {
+	Name  string
+	layer int
+	// toBox maps world positions into the unit cube of the box, with x and
+	// y across the image and z along the projection.
+	toBox mgl32.Mat4
+}
+
+// Decals holds the decal images in the layers of a texture array.
+type Decals struct {
+	tex  uint32
+	list []Decal
+}
+
+func NewDecals() *Decals {
+	d := &Decals{}
+	gl.GenTextures(1, &d.tex)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, d.tex)
+	gl.TexImage3D(gl.TEXTURE_2D_ARRAY, 0, gl.RGBA8, decalSize, decalSize, maxDecals, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, 0)
+	return d
+}
+
+// Add projects img along the view direction of orientation onto the
+// surfaces inside a cube of side size centered on center, replacing the
+// decal with the same name. It reports false if all layers are taken.
+func (d *Decals) Add(name string, img image.Image, center mgl32.Vec3, orientation mgl32.Quat, size float32) bool {
+	layer := -1
+	for i, dc := range d.list {
+		if dc.Name == name {
+			layer = dc.layer
+			d.list = append(d.list[:i], d.list[i+1:]...)
+			break
+		}
+	}
+	if layer < 0 {
+		layer = d.freeLayer()
+	}
+	if layer < 0 {
+		return false
+	}
+
+	box := mgl32.Translate3D(center[0], center[1], center[2]).
+		Mul4(orientation.Mat4()).
+		Mul4(mgl32.Scale3D(size, size, size))
+	toBox := mgl32.Translate3D(0.5, 0.5, 0.5).Mul4(box.Inv())
+	d.list = append(d.list, Decal{Name: name, layer: layer, toBox: toBox})
+
+	pixels := fitDecal(img)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, d.tex)
+	gl.TexSubImage3D(gl.TEXTURE_2D_ARRAY, 0, 0, 0, int32(layer), decalSize, decalSize, 1, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pixels.Pix))
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, 0)
+	return true
+}
+
+// freeLayer returns a texture layer no decal uses, or -1.
+func (d *Decals) freeLayer() int {
+	var used [maxDecals]bool
+	for _, dc := range d.list {
+		used[dc.layer] = true
+	}
+	for i, u := range used {
+		if !u {
+			return i
+		}
+	}
+	return -1
+}
+
+// Remove deletes the decal with the given name and reports whether it
+// existed.
+func (d *Decals) Remove(name string) bool {
+	for i, dc := range d.list {
+		if dc.Name == name {
+			d.list = append(d.list[:i], d.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// All returns the decals in the order they were added.
+func (d *Decals) All() []Decal {
+	return d.list
+}
+
+// Delete releases the texture of the decals.
+func (d *Decals) Delete() {
+	gl.DeleteTextures(1, &d.tex)
+}
+
+// fitDecal scales img to fit a decal layer, keeping its aspect ratio and
+// leaving the rest of the layer transparent.
+func fitDecal(img image.Image) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, decalSize, decalSize))
+	b := img.Bounds()
+	if b.Empty() {
+		return dst
+	}
+	w, h := decalSize, decalSize
+	if b.Dx() > b.Dy() {
+		h = decalSize * b.Dy() / b.Dx()
+	} else {
+		w = decalSize * b.Dx() / b.Dy()
+	}
+	x, y := (decalSize-w)/2, (decalSize-h)/2
+	draw.ApproxBiLinear.Scale(dst, image.Rect(x, y, x+w, y+h), img, b, draw.Src, nil)
+	return dst
+}
+
+// loadDecalImage reads a PNG or JPEG image.
+func loadDecalImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// textDecal renders text as a single line of white glyphs on a dark
+// background.
+func textDecal(text string) image.Image {
+	face := inconsolata.Regular8x16
+	const pad = 2
+	width := font.MeasureString(face, text).Ceil()
+	metrics := face.Metrics()
+	img := image.NewRGBA(image.Rect(0, 0, width+2*pad, metrics.Height.Ceil()+2*pad))
+	draw.Draw(img, img.Bounds(), image.NewUniform(decalBackground), image.Point{}, draw.Src)
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(pad, pad+metrics.Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+	return img
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Interstitial atoms are drawn smaller than the lattice sites and in a
+// color of their own.
+var (
+	interstitialColor = mgl32.Vec3{1, 0.2, 0.9}
+	interstitialSize  = float32(0.6)
+)
+
+// Defects describes randomly injected point defects.
+type Defects struct {
+	// Vacancies is the fraction of the cells removed, Interstitials the
+	// number of atoms added between the sites as a fraction of the cells.
+	Vacancies     float64
+	Interstitials float64
+	// Seed seeds the random placement, so that the same seed places the
+	// same defects. Zero picks a seed from the clock.
+	Seed int64
+}
+
+// empty reports whether d injects no defects.
+func (d Defects) empty() bool {
+	return d.Vacancies <= 0 && d.Interstitials <= 0
+}
+
+// inject returns the cells of s with the defects d. Vacancies are drawn
+// from the cells of s, interstitials from the grid positions between them
+// within their bounding box, which simple cubic lattices have none of. It
+// also returns the numbers of vacancies and interstitials.
+func (d Defects) inject(s *cellStore) (*cellStore, int, int) {
+	rng := rand.New(rand.NewSource(d.Seed))
+	cells := s.unpack()
+	occupied := make(occupancy, len(cells))
+	var lo, hi [3]int
+	for i, c := range cells {
+		occupied.add(c)
+		pos := gridPos(c)
+		for axis := range pos {
+			if i == 0 || pos[axis] < lo[axis] {
+				lo[axis] = pos[axis]
+			}
+			if i == 0 || pos[axis] > hi[axis] {
+				hi[axis] = pos[axis]
+			}
+		}
+	}
+
+	vacancies := int(math.Round(math.Min(math.Max(d.Vacancies, 0), 1) * float64(len(cells))))
+	// A partial shuffle moves the vacancies to the front.
+	for i := 0; i < vacancies; i++ {
+		j := i + rng.Intn(len(cells)-i)
+		cells[i], cells[j] = cells[j], cells[i]
+	}
+	kept := cells[vacancies:]
+
+	p := s.params
+	var size [3]int
+	free := 1
+	for axis := range size {
+		size[axis] = hi[axis] - lo[axis] + 1
+		free *= size[axis]
+	}
+	free -= len(occupied)
+	want := int(math.Round(math.Max(d.Interstitials, 0) * float64(len(cells))))
+	if want > free {
+		want = free
+	}
+	var interstitials []cell
+	// Rejection sampling gives up on crowded lattices rather than
+	// searching for the last free positions.
+	for attempts := 0; len(interstitials) < want && attempts < 64*want+1024; attempts++ {
+		x, y, z := lo[0]+rng.Intn(size[0]), lo[1]+rng.Intn(size[1]), lo[2]+rng.Intn(size[2])
+		pos := [3]int{x, y, z}
+		if occupied[pos] {
+			continue
+		}
+		occupied[pos] = true
+		id, _ := p.CellID(x, y, z)
+		interstitials = append(interstitials, cell{
+			id:    id,
+			pos:   mgl32.Vec3{float32(x), float32(y), float32(z)},
+			color: interstitialColor,
+			size:  interstitialSize,
+		})
+	}
+	return newCellStore(append(kept, interstitials...), p), vacancies, len(interstitials)
+}
+
+// SetDefects injects the point defects d into the cells shown, replacing
+// any injected before. Empty defects restore the cells without any.
+func (r *Renderer) SetDefects(d Defects) {
+	pristine := r.pristine
+	if pristine == nil {
+		pristine = r.cells
+	}
+	crystal := r.crystal
+	if d.empty() {
+		if r.pristine != nil {
+			r.setStore(pristine)
+			r.crystal = crystal
+		}
+		r.Notify(MsgDefectsOff)
+		return
+	}
+	if d.Seed == 0 {
+		d.Seed = time.Now().UnixNano()
+	}
+	s, vacancies, interstitials := d.inject(pristine)
+	r.setStore(s)
+	r.crystal = crystal
+	r.pristine = pristine
+	r.Notify(MsgDefects, vacancies, interstitials, d.Seed)
+}
@@ -0,0 +1,332 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// demoVersion is the version of the demo format written, bumped whenever
+// an older player would replay a demo differently.
+const demoVersion = 1
+
+// defaultDemoRate is the default number of fixed time steps a second of a
+// demo.
+const defaultDemoRate = 60
+
+// Kinds of demo events. Their arguments are those of the window callback
+// of the same name.
+const (
+	demoKey     = "key"     // key, action, mods
+	demoChar    = "char"    // rune
+	demoButton  = "button"  // button, action, mods
+	demoCursor  = "cursor"  // x, y
+	demoScroll  = "scroll"  // y offset, 1 if Ctrl is held
+	demoEnter   = "enter"   // 1 if entered
+	demoFocus   = "focus"   // 1 if focused
+	demoGamepad = "gamepad" // move x, y, z, pitch, yaw
+	demoCommand = "command" // the console line in Line
+)
+
+// demoFile is a demo: the state the session started from and the input of
+// every frame after it. Frames are advanced by a fixed time step, so that
+// the session replays the same frame for frame however fast the machine
+// draws them.
+type demoFile struct {
+	Version int `json:"version"`
+	// Step is the time step of a frame in seconds, and Time the clock at
+	// the first frame, which animations depend on.
+	Step float64 `json:"step"`
+	Time float64 `json:"time"`
+	// Frames is the number of frames of the demo.
+	Frames int `json:"frames"`
+	// Width and Height are the size of the window, which picking and
+	// dragging depend on.
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	Scene  sceneJSON `json:"scene"`
+	// Pointing is whether the cursor was freed to point at cells.
+	Pointing bool        `json:"pointing,omitempty"`
+	Events   []demoEvent `json:"events"`
+}
+
+// demoEvent is an input event applied before the frame of its number.
+// Keys are kept short as a demo holds an event for most frames.
+type demoEvent struct {
+	Frame int       `json:"f"`
+	Kind  string    `json:"k"`
+	Args  []float64 `json:"a,omitempty"`
+	Line  string    `json:"l,omitempty"`
+}
+
+// demo is the demo being recorded or played, if any.
+type demo struct {
+	file *demoFile
+	path string
+	// playing is set while file is played rather than recorded, and next is
+	// the index of its next event then.
+	playing bool
+	next    int
+	// frame is the number of the frame being drawn, 0 until the first
+	// frame of the demo.
+	frame int
+	// pad is the gamepad input last recorded or played.
+	pad gamepadInput
+}
+
+// active reports whether a demo is recorded or played.
+func (d *demo) active() bool {
+	return d.file != nil
+}
+
+// recording reports whether a demo is recorded, and from the first frame
+// on, so that input between starting it and that frame is left out.
+func (d *demo) recording() bool {
+	return d.file != nil && !d.playing && d.frame > 0
+}
+
+// StartDemo records the session to path from the next frame on, stopping
+// any demo recorded or played. rate is the number of frames a second.
+func (r *Renderer) StartDemo(path string, rate int) error {
+	if rate <= 0 {
+		return r.locale.Errorf(MsgExpectedDemo)
+	}
+	r.StopDemo()
+	width, height := r.w.GetSize()
+	r.demo = demo{
+		file: &demoFile{
+			Version:  demoVersion,
+			Step:     1 / float64(rate),
+			Width:    width,
+			Height:   height,
+			Scene:    r.scene(),
+			Pointing: r.pointing,
+		},
+		path: path,
+	}
+	r.resetDemoInput()
+	r.Notify(MsgDemoRecording, path)
+	return nil
+}
+
+// PlayDemo replays the demo file path from the next frame on, stopping any
+// demo recorded or played. Input of the window is ignored until it ends.
+func (r *Renderer) PlayDemo(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f demoFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	if f.Version != demoVersion {
+		return fmt.Errorf("%v: demo version %v, expected %v", path, f.Version, demoVersion)
+	}
+	if f.Step <= 0 {
+		return fmt.Errorf("%v: time step %v, expected above 0", path, f.Step)
+	}
+	r.StopDemo()
+	if err := r.applyScene(f.Scene, path); err != nil {
+		return err
+	}
+	if f.Width > 0 && f.Height > 0 {
+		r.w.SetSize(f.Width, f.Height)
+	}
+	if r.pointing != f.Pointing {
+		r.TogglePointing()
+	}
+	r.demo = demo{file: &f, path: path, playing: true}
+	r.resetDemoInput()
+	r.Notify(MsgDemoPlaying, path, f.Frames)
+	return nil
+}
+
+// StopDemo saves the demo recorded, or ends the one played.
+func (r *Renderer) StopDemo() {
+	if !r.demo.active() {
+		return
+	}
+	d := r.demo
+	r.demo = demo{}
+	if d.playing {
+		r.Notify(MsgDemoEnded, d.path)
+		return
+	}
+	// The frame drawn has not been updated yet.
+	d.file.Frames = d.frame - 1
+	if d.file.Frames < 0 {
+		d.file.Frames = 0
+	}
+	data, err := json.Marshal(d.file)
+	if err == nil {
+		err = os.WriteFile(d.path, data, 0644)
+	}
+	if err != nil {
+		r.NotifyError(r.locale.Errorf(MsgDemoFailed, err))
+		return
+	}
+	r.Notify(MsgDemoSaved, d.file.Frames, d.path)
+}
+
+// resetDemoInput forgets the keys held and the movement of the cursor, so
+// that the demo starts with the camera at rest.
+func (r *Renderer) resetDemoInput() {
+	r.camSpeed = zero
+	r.rotationSpeed = mgl32.Vec2{}
+	r.cursor.deltas()
+	r.cursor.reanchor()
+}
+
+// stepDemo starts the next frame of the demo, if any: it sets the clock
+// to the time of the frame and, while playing, applies the input recorded
+// for it, ending the demo after its last frame.
+func (r *Renderer) stepDemo() {
+	d := &r.demo
+	if !d.active() {
+		return
+	}
+	d.frame++
+	if d.playing && d.frame > d.file.Frames {
+		r.StopDemo()
+		return
+	}
+	if d.frame == 1 {
+		if d.playing {
+			r.frameTimer.prevTime = d.file.Time
+		} else {
+			d.file.Time = r.frameTimer.prevTime
+		}
+	}
+	glfw.SetTime(d.file.Time + float64(d.frame)*d.file.Step)
+	if !d.playing {
+		return
+	}
+	for ; d.next < len(d.file.Events) && d.file.Events[d.next].Frame <= d.frame; d.next++ {
+		r.applyInput(d.file.Events[d.next])
+	}
+}
+
+// input handles an input event of the window: it is recorded while a demo
+// is, ignored while one plays, as the demo drives the input then, and
+// applied otherwise. Escape ends a demo played instead of closing the
+// window. Events arrive after a frame is drawn, so they belong to the
+// next.
+func (r *Renderer) input(e demoEvent) {
+	if r.demo.playing {
+		if e.Kind == demoKey && glfw.Key(e.Args[0]) == glfw.KeyEscape && glfw.Action(e.Args[1]) == glfw.Press {
+			r.StopDemo()
+		}
+		return
+	}
+	if r.demo.active() {
+		e.Frame = r.demo.frame + 1
+		r.demo.file.Events = append(r.demo.file.Events, e)
+	}
+	r.applyInput(e)
+}
+
+// recordCommand records a console line run by the frame being drawn.
+// Commands that control demos are left out, so that playing a demo does
+// not start or stop another.
+func (r *Renderer) recordCommand(line string) {
+	if !r.demo.recording() || strings.HasPrefix(line, "demo") {
+		return
+	}
+	r.demo.file.Events = append(r.demo.file.Events, demoEvent{Frame: r.demo.frame, Kind: demoCommand, Line: line})
+}
+
+// applyInput applies an input event of the window or a demo.
+func (r *Renderer) applyInput(e demoEvent) {
+	arg := func(i int) float64 {
+		if i >= len(e.Args) {
+			return 0
+		}
+		return e.Args[i]
+	}
+	switch e.Kind {
+	case demoKey:
+		r.OnKey(r.w, glfw.Key(arg(0)), 0, glfw.Action(arg(1)), glfw.ModifierKey(arg(2)))
+	case demoChar:
+		r.OnChar(r.w, rune(arg(0)))
+	case demoButton:
+		r.OnMouseButton(r.w, glfw.MouseButton(arg(0)), glfw.Action(arg(1)), glfw.ModifierKey(arg(2)))
+	case demoCursor:
+		r.OnCursorPos(r.w, arg(0), arg(1))
+	case demoScroll:
+		r.scroll(arg(0), arg(1) != 0)
+	case demoEnter:
+		r.OnCursorEnter(r.w, arg(0) != 0)
+	case demoFocus:
+		r.OnFocus(r.w, arg(0) != 0)
+	case demoGamepad:
+		r.demo.pad = gamepadInput{
+			move:  mgl32.Vec3{float32(arg(0)), float32(arg(1)), float32(arg(2))},
+			pitch: float32(arg(3)),
+			yaw:   float32(arg(4)),
+		}
+	case demoCommand:
+		if err := r.console.Exec(r, e.Line); err != nil {
+			r.NotifyError(err)
+		}
+	}
+}
+
+// pollGamepad returns the camera motion of the gamepad for this frame,
+// that of the demo while one plays. A demo records it whenever it changes.
+func (r *Renderer) pollGamepad() gamepadInput {
+	d := &r.demo
+	if d.playing || (d.active() && d.frame == 0) {
+		return d.pad
+	}
+	pad, _ := r.gamepad.poll()
+	if d.recording() && pad != d.pad {
+		d.pad = pad
+		d.file.Events = append(d.file.Events, demoEvent{
+			Frame: d.frame,
+			Kind:  demoGamepad,
+			Args:  []float64{float64(pad.move[0]), float64(pad.move[1]), float64(pad.move[2]), float64(pad.pitch), float64(pad.yaw)},
+		})
+	}
+	return pad
+}
+
+// setInputCallbacks passes the input of window to r through input, so
+// that demos can record and replace it.
+func (r *Renderer) setInputCallbacks(window *glfw.Window) {
+	flag := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		r.input(demoEvent{Kind: demoKey, Args: []float64{float64(key), float64(action), float64(mods)}})
+	})
+	window.SetCharCallback(func(w *glfw.Window, char rune) {
+		r.input(demoEvent{Kind: demoChar, Args: []float64{float64(char)}})
+	})
+	window.SetMouseButtonCallback(func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		r.input(demoEvent{Kind: demoButton, Args: []float64{float64(button), float64(action), float64(mods)}})
+	})
+	window.SetCursorPosCallback(func(w *glfw.Window, x, y float64) {
+		r.input(demoEvent{Kind: demoCursor, Args: []float64{x, y}})
+	})
+	window.SetScrollCallback(func(w *glfw.Window, xoff, yoff float64) {
+		r.input(demoEvent{Kind: demoScroll, Args: []float64{yoff, flag(ctrlHeld(w))}})
+	})
+	window.SetCursorEnterCallback(func(w *glfw.Window, entered bool) {
+		r.input(demoEvent{Kind: demoEnter, Args: []float64{flag(entered)}})
+	})
+	window.SetFocusCallback(func(w *glfw.Window, focused bool) {
+		r.input(demoEvent{Kind: demoFocus, Args: []float64{flag(focused)}})
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	// displaceUnit is the texture unit of the displacement map in the
+	// lattice program.
+	displaceUnit = 6
+	// noiseSize is the number of texels along each side of the noise
+	// displacement map, which repeats beyond them.
+	noiseSize = 32
+
+	defaultDisplaceFrequency = 0.02
+	defaultDisplaceSpeed     = 0.05
+)
+
+// Displacement moves each cell by a map sampled in the vertex shader at the
+// position of the cell, drifting through the map over time, so that the
+// lattice ripples without the cells being uploaded again. The red, green
+// and blue of the map move the cell along x, y and z, from -Amplitude at 0
+// to Amplitude at 1. The map is repeating 3D noise, or the image Image
+// repeated along z.
+type Displacement struct {
+	// Amplitude is the farthest a cell moves, 0 for no displacement.
+	Amplitude float32
+	// Frequency is how many times the map repeats per unit of distance,
+	// Speed how many times the cells drift across it per second.
+	Frequency, Speed float32
+	// Image, if set, is a PNG or JPEG image sampled instead of the noise.
+	Image string
+}
+
+// displaceMap is the 3D texture displacing the cells.
+type displaceMap struct {
+	tex   uint32
+	image string
+}
+
+// newDisplaceMap builds the displacement map of the image path, or of
+// smoothly filtered noise, the same every time, if path is empty.
+func newDisplaceMap(path string) (*displaceMap, error) {
+	width, height, depth := noiseSize, noiseSize, noiseSize
+	var pix []uint8
+	if path == "" {
+		rng := rand.New(rand.NewSource(1))
+		pix = make([]uint8, width*height*depth*4)
+		for i := range pix {
+			pix[i] = uint8(rng.Intn(256))
+		}
+	} else {
+		img, err := loadDecalImage(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load displacement map %v: %v", path, err)
+		}
+		rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		width, height, depth, pix = rgba.Rect.Dx(), rgba.Rect.Dy(), 1, rgba.Pix
+	}
+	m := &displaceMap{image: path}
+	gl.GenTextures(1, &m.tex)
+	gl.BindTexture(gl.TEXTURE_3D, m.tex)
+	gl.TexImage3D(gl.TEXTURE_3D, 0, gl.RGBA8, int32(width), int32(height), int32(depth), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pix))
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_S, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_T, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_R, gl.REPEAT)
+	gl.BindTexture(gl.TEXTURE_3D, 0)
+	return m, nil
+}
+
+// Delete releases the texture.
+func (m *displaceMap) Delete() {
+	gl.DeleteTextures(1, &m.tex)
+}
+
+// displaceReach returns the farthest the cells may be moved from their
+// place along any axis, by the displacement and the displace hook, or
+// true if the hook declares no bound.
+func (r *Renderer) displaceReach() (float32, bool) {
+	reach := r.displacement.Amplitude
+	if reach < 0 {
+		reach = -reach
+	}
+	if r.hooks.Displace != "" {
+		if r.hooks.Bound <= 0 {
+			return 0, true
+		}
+		reach += r.hooks.Bound
+	}
+	return reach, false
+}
+
+// displaceUniforms are the locations of the displacement uniforms of the
+// lattice program.
+type displaceUniforms struct {
+	amplitude, frequency, speed int32
+}
+
+// bindDisplacement sets the displacement uniforms and binds the map, built
+// when first needed, such as when a camera path turns the displacement on.
+func (r *Renderer) bindDisplacement() {
+	d := r.displacement
+	gl.Uniform1f(r.displaceUniforms.amplitude, d.Amplitude)
+	if d.Amplitude == 0 {
+		return
+	}
+	if r.displaceMap == nil {
+		m, err := newDisplaceMap(d.Image)
+		if err != nil {
+			r.displacement.Amplitude = 0
+			gl.Uniform1f(r.displaceUniforms.amplitude, 0)
+			r.NotifyError(err)
+			return
+		}
+		r.displaceMap = m
+	}
+	gl.Uniform1f(r.displaceUniforms.frequency, d.Frequency)
+	gl.Uniform1f(r.displaceUniforms.speed, d.Speed)
+	gl.ActiveTexture(gl.TEXTURE0 + displaceUnit)
+	gl.BindTexture(gl.TEXTURE_3D, r.displaceMap.tex)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// SetDisplacement displaces the cells by d, or stops displacing them if
+// its amplitude is 0. Zero frequency and speed take their defaults.
+func (r *Renderer) SetDisplacement(d Displacement) error {
+	if d.Frequency == 0 {
+		d.Frequency = defaultDisplaceFrequency
+	}
+	if d.Speed == 0 {
+		d.Speed = defaultDisplaceSpeed
+	}
+	if r.displaceMap != nil && (d.Amplitude == 0 || d.Image != r.displaceMap.image) {
+		r.displaceMap.Delete()
+		r.displaceMap = nil
+	}
+	if d.Amplitude != 0 && r.displaceMap == nil {
+		m, err := newDisplaceMap(d.Image)
+		if err != nil {
+			return err
+		}
+		r.displaceMap = m
+	}
+	r.displacement = d
+	if d.Amplitude == 0 {
+		r.Notify(MsgDisplaceOff)
+	} else {
+		r.Notify(MsgDisplace, d.Amplitude, d.Frequency, d.Speed)
+	}
+	return nil
+}
+
+func registerDisplaceCommands(c *Console) {
+	c.Register("displace", "displace <amplitude> [<frequency> [<speed> [<image>]]]|off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 && fields[0] == "off" {
+			return r.SetDisplacement(Displacement{})
+		}
+		if len(fields) == 0 || len(fields) > 4 {
+			return r.locale.Errorf(MsgExpectedDisplace)
+		}
+		var v [3]float32
+		for i := 0; i < len(fields) && i < len(v); i++ {
+			f, err := strconv.ParseFloat(fields[i], 32)
+			if err != nil || f < 0 {
+				return r.locale.Errorf(MsgExpectedDisplace)
+			}
+			v[i] = float32(f)
+		}
+		d := Displacement{Amplitude: v[0], Frequency: v[1], Speed: v[2]}
+		if len(fields) == 4 {
+			d.Image = fields[3]
+		}
+		return r.SetDisplacement(d)
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// gridSteps are the offsets to the grid positions next to a cell.
+var gridSteps = [][3]int{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}}
+
+// SetEditing turns the edit mode on or off. While editing, the left mouse
+// button removes the cube clicked and the right one places a cube on the
+// face clicked.
+func (r *Renderer) SetEditing(on bool) {
+	r.editing = on
+	if on {
+		r.Notify(MsgEditing)
+		return
+	}
+	r.Notify(MsgEditingOff)
+}
+
+// clickPos returns the window position clicks pick at: the cursor while
+// it points, the middle of the window, where the camera is aimed,
+// otherwise.
+func (r *Renderer) clickPos() (x, y float64) {
+	if r.pointing {
+		return r.cursor.x, r.cursor.y
+	}
+	w, h := r.w.GetSize()
+	return float64(w) / 2, float64(h) / 2
+}
+
+// RemoveCellAt removes the cell under the window position (x, y).
+func (r *Renderer) RemoveCellAt(x, y float64) {
+	id, ok := r.PickAt(x, y)
+	if !ok {
+		return
+	}
+	c, ok := r.cells.find(id)
+	if !ok {
+		return
+	}
+	k := chunkOf(c)
+	var kept []cell
+	for _, o := range r.cells.chunk(k) {
+		if o.id != id {
+			kept = append(kept, o)
+		}
+	}
+	r.editCells(r.cells.withChunk(k, kept), k)
+	pos := gridPos(c)
+	r.Notify(MsgRemovedCell, pos[0], pos[1], pos[2])
+}
+
+// PlaceCellAt places a cell like the one under the window position (x, y)
+// next to it, on the side of the face under the position.
+func (r *Renderer) PlaceCellAt(x, y float64) {
+	id, ok := r.PickAt(x, y)
+	if !ok {
+		return
+	}
+	c, ok := r.cells.find(id)
+	if !ok {
+		return
+	}
+	normal, ok := r.faceAt(x, y, c)
+	if !ok {
+		return
+	}
+	// The grid step that best matches the face normal in the world, which
+	// only differs from it for lattices with other than cubic axes.
+	var step [3]int
+	best := float32(-2)
+	for _, s := range gridSteps {
+		dir := r.params.World(mgl32.Vec3{float32(s[0]), float32(s[1]), float32(s[2])}).Normalize()
+		if d := dir.Dot(normal); d > best {
+			step, best = s, d
+		}
+	}
+	pos := gridPos(c)
+	for axis := range pos {
+		pos[axis] += step[axis]
+	}
+	n := c
+	n.pos = mgl32.Vec3{float32(pos[0]), float32(pos[1]), float32(pos[2])}
+	if n.id, ok = r.params.CellID(pos[0], pos[1], pos[2]); !ok {
+		r.Notify(MsgOutsideLattice, pos[0], pos[1], pos[2])
+		return
+	}
+	if _, taken := r.cells.find(n.id); taken {
+		return
+	}
+	k := chunkOf(n)
+	r.editCells(r.cells.withChunk(k, append(r.cells.chunk(k), n)), k)
+	r.Notify(MsgPlacedCell, pos[0], pos[1], pos[2])
+}
+
+// editCells shows the cells s, which differ from the cells shown only in
+// chunk k. Only the instance data that changed is uploaded, unless the
+// look of the cells depends on all of them.
+func (r *Renderer) editCells(s *cellStore, k chunkKey) {
+	r.cells = s
+	r.crystal = nil
+	r.pristine = nil
+	r.picked = nil
+	if r.lookOfAll() {
+		r.UploadMesh()
+		return
+	}
+	r.mesh.Edit(s, r.groups, chunkSet{k: true})
+	r.meshChanged()
+}
+
+// faceAt returns the normal of the face of the cube of c seen at the window
+// position (x, y), and false if the ray through the position misses it.
+func (r *Renderer) faceAt(x, y float64, c cell) (mgl32.Vec3, bool) {
+	w, h := r.w.GetSize()
+	if w == 0 || h == 0 {
+		return mgl32.Vec3{}, false
+	}
+	nx, ny := float32(2*x/float64(w)-1), float32(1-2*y/float64(h))
+	inv := r.projection.Mul4(r.camera).Mul4(r.model).Inv()
+	near := mgl32.TransformCoordinate(mgl32.Vec3{nx, ny, -1}, inv)
+	far := mgl32.TransformCoordinate(mgl32.Vec3{nx, ny, 1}, inv)
+	dir := far.Sub(near)
+
+	// The slab method: the ray enters the cube through the face of the
+	// axis along which it enters last.
+	center := r.params.World(c.pos)
+	half := r.params.CubeSize * c.scale() / 2
+	enter, exit := float32(math.Inf(-1)), float32(math.Inf(1))
+	var normal mgl32.Vec3
+	for axis := 0; axis < 3; axis++ {
+		if dir[axis] == 0 {
+			if d := near[axis] - center[axis]; d < -half || d > half {
+				return mgl32.Vec3{}, false
+			}
+			continue
+		}
+		t0 := (center[axis] - half - near[axis]) / dir[axis]
+		t1 := (center[axis] + half - near[axis]) / dir[axis]
+		sign := float32(-1)
+		if t0 > t1 {
+			t0, t1 = t1, t0
+			sign = 1
+		}
+		if t0 > enter {
+			enter = t0
+			normal = mgl32.Vec3{}
+			normal[axis] = sign
+		}
+		if t1 < exit {
+			exit = t1
+		}
+	}
+	return normal, enter <= exit && normal != mgl32.Vec3{}
+}
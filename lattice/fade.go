@@ -0,0 +1,133 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+// defaultFadeStrength is how much the densest cells fade unless told
+// otherwise.
+const defaultFadeStrength = 0.8
+
+// minFadeScale keeps the most faded cells visible as specks.
+const minFadeScale = 0.15
+
+// DensityFade shrinks and dims cells in dense regions of the lattice so that
+// sparse regions and isolated outliers stand out. The density of a cell is
+// the number of other cells within Radius grid steps along every axis.
+type DensityFade struct {
+	// Radius is the half width of the cube of grid positions counted
+	// around each cell, 0 to turn the fading off.
+	Radius int
+	// Strength is how much the densest cells fade, in [0, 1]: their size
+	// and brightness are scaled by 1 - Strength, those of the sparsest
+	// cells kept.
+	Strength float32
+}
+
+// look returns the look fading the cells of s by their density, normalized
+// between the sparsest and the densest cell of s, or nil if the fading is
+// off. Densities are counted with a summed volume table over the bounding
+// box of the cells, so that the cost does not grow with the radius.
+func (f DensityFade) look(s *cellStore) cellLook {
+	if f.Radius <= 0 || len(s.keys) == 0 {
+		return nil
+	}
+	var lo, hi [3]int
+	first := true
+	for _, k := range s.keys {
+		for _, c := range s.chunk(k) {
+			pos := gridPos(c)
+			for axis := range pos {
+				if first || pos[axis] < lo[axis] {
+					lo[axis] = pos[axis]
+				}
+				if first || pos[axis] > hi[axis] {
+					hi[axis] = pos[axis]
+				}
+			}
+			first = false
+		}
+	}
+
+	// sum[i, j, k] counts the cells at grid offsets below i, j, k from lo,
+	// with a border of zeros at index 0.
+	var n [3]int
+	for axis := range n {
+		n[axis] = hi[axis] - lo[axis] + 2
+	}
+	sum := make([]int32, n[0]*n[1]*n[2])
+	index := func(i, j, k int) int { return (i*n[1]+j)*n[2] + k }
+	for _, k := range s.keys {
+		for _, c := range s.chunk(k) {
+			pos := gridPos(c)
+			sum[index(pos[0]-lo[0]+1, pos[1]-lo[1]+1, pos[2]-lo[2]+1)]++
+		}
+	}
+	for i := 1; i < n[0]; i++ {
+		for j := 1; j < n[1]; j++ {
+			for k := 1; k < n[2]; k++ {
+				sum[index(i, j, k)] += sum[index(i-1, j, k)] + sum[index(i, j-1, k)] + sum[index(i, j, k-1)] -
+					sum[index(i-1, j-1, k)] - sum[index(i-1, j, k-1)] - sum[index(i, j-1, k-1)] +
+					sum[index(i-1, j-1, k-1)]
+			}
+		}
+	}
+	// density returns the number of cells other than c around it.
+	density := func(c cell) int32 {
+		pos := gridPos(c)
+		var a, b [3]int
+		for axis := range pos {
+			a[axis] = clampInt(pos[axis]-lo[axis]-f.Radius, 0, n[axis]-1)
+			b[axis] = clampInt(pos[axis]-lo[axis]+f.Radius+1, 0, n[axis]-1)
+		}
+		return sum[index(b[0], b[1], b[2])] - sum[index(a[0], b[1], b[2])] - sum[index(b[0], a[1], b[2])] -
+			sum[index(b[0], b[1], a[2])] + sum[index(a[0], a[1], b[2])] + sum[index(a[0], b[1], a[2])] +
+			sum[index(b[0], a[1], a[2])] - sum[index(a[0], a[1], a[2])] - 1
+	}
+
+	least, most := int32(-1), int32(-1)
+	for _, k := range s.keys {
+		for _, c := range s.chunk(k) {
+			d := density(c)
+			if least < 0 || d < least {
+				least = d
+			}
+			if d > most {
+				most = d
+			}
+		}
+	}
+	if most == least {
+		return nil
+	}
+	scale := f.Strength / float32(most-least)
+	return func(c cell) cell {
+		fade := 1 - float32(density(c)-least)*scale
+		c.size = c.scale() * fade
+		if c.size < minFadeScale {
+			c.size = minFadeScale
+		}
+		c.color = c.color.Mul(fade)
+		return c
+	}
+}
+
+// SetFade fades the cells by their density within the given radius, or
+// turns the fading off if the radius is 0.
+func (r *Renderer) SetFade(f DensityFade) {
+	if f.Radius < 0 {
+		f.Radius = 0
+	}
+	if f.Strength < 0 {
+		f.Strength = 0
+	} else if f.Strength > 1 {
+		f.Strength = 1
+	}
+	r.fade = f
+	r.UploadMesh()
+	if f.Radius == 0 {
+		r.Notify(MsgFadeOff)
+		return
+	}
+	r.Notify(MsgFade, f.Radius, 100*f.Strength)
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Default distances of the near and far planes of the projection, in
+// world units.
+const (
+	defaultNear = 0.01
+	defaultFar  = 500
+)
+
+// Fitting the view keeps the far plane fitFarFactor times as far as the
+// far side of the lattice, so that the camera can back away from it, and
+// the near plane at fitDepthRatio of the far plane, as close as the depth
+// buffer resolves the default planes.
+const (
+	fitFarFactor  = 2
+	fitDepthRatio = defaultNear / defaultFar
+)
+
+// SetDepthRange moves the near and far planes of the projection to near
+// and far world units from the camera.
+func (r *Renderer) SetDepthRange(near, far float32) error {
+	if near <= 0 || far <= near {
+		return r.locale.Errorf(MsgExpectedDepthRange)
+	}
+	r.near, r.far = near, far
+	r.updateViewport()
+	r.Notify(MsgDepthRange, near, far)
+	return nil
+}
+
+// boundingSphere returns the center and radius of a sphere around the
+// cubes of the mesh, or around the whole grid if there are none.
+func (r *Renderer) boundingSphere() (mgl32.Vec3, float32) {
+	lo, hi, ok := r.meshBounds()
+	if !ok {
+		return mgl32.Vec3{}, r.params.radius()
+	}
+	return lo.Add(hi).Mul(0.5), hi.Sub(lo).Len() / 2
+}
+
+// meshBounds returns the corners of the box around the cubes of the mesh,
+// and false if it has none.
+func (r *Renderer) meshBounds() (lo, hi mgl32.Vec3, ok bool) {
+	if len(r.mesh.ranges) == 0 {
+		return lo, hi, false
+	}
+	lo, hi = r.mesh.ranges[0].min, r.mesh.ranges[0].max
+	for _, mr := range r.mesh.ranges[1:] {
+		for axis := range lo {
+			if mr.min[axis] < lo[axis] {
+				lo[axis] = mr.min[axis]
+			}
+			if mr.max[axis] > hi[axis] {
+				hi[axis] = mr.max[axis]
+			}
+		}
+	}
+	return lo, hi, true
+}
+
+// FitView frames the lattice: the camera backs away from the center of its
+// bounding sphere, looking the way it does, until the sphere fits the
+// narrower field of view, and the near and far planes are moved to take
+// it in whole. It leaves orbit mode.
+func (r *Renderer) FitView() {
+	center, radius := r.boundingSphere()
+	_, _, w, h := r.viewport()
+	fov := float64(mgl32.DegToRad(r.fov))
+	if w > 0 && h > 0 && w < h {
+		fov = 2 * math.Atan(math.Tan(fov/2)*float64(w)/float64(h))
+	}
+	dist := radius / float32(math.Sin(fov/2))
+
+	r.flight = nil
+	r.orbiting = false
+	forward := r.orientation().Rotate(mgl32.Vec3{0, 0, -1})
+	r.camPos = r.displayPos(center).Sub(forward.Mul(dist))
+	r.far = fitFarFactor * (dist + radius)
+	r.near = r.far * fitDepthRatio
+	r.updateViewport()
+	r.Notify(MsgFitted, r.formatLength(2*radius), r.near, r.far)
+}
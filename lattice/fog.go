@@ -0,0 +1,57 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Fog fades the cubes into a color by their depth from the camera, keeping
+// exp(-Density*depth) of their own color, so that the far side of a large
+// lattice fades out instead of being cut off by the far plane.
+type Fog struct {
+	// Density is how fast the fog thickens per world unit of depth, 0 for
+	// no fog.
+	Density float32
+	// Color is the color of the fog, nil for the background of the style
+	// so that the cubes fade into it.
+	Color *mgl32.Vec3
+}
+
+type fogUniforms struct {
+	density, color int32
+}
+
+func newFogUniforms(program uint32) fogUniforms {
+	return fogUniforms{
+		density: gl.GetUniformLocation(program, gl.Str("fogDensity\x00")),
+		color:   gl.GetUniformLocation(program, gl.Str("fogColor\x00")),
+	}
+}
+
+// bindFog sets the fog of r for the lattice program, in the background of
+// style s unless it has its own color.
+func (r *Renderer) bindFog(s Style) {
+	c := s.Background
+	if r.fog.Color != nil {
+		c = *r.fog.Color
+	}
+	gl.Uniform1f(r.fogUniforms.density, r.fog.Density)
+	gl.Uniform3f(r.fogUniforms.color, c[0], c[1], c[2])
+}
+
+// SetFog changes the fog, turning it off for a density of 0.
+func (r *Renderer) SetFog(f Fog) {
+	r.fog = f
+	if r.beauty != nil {
+		r.beauty.reset()
+	}
+	if f.Density <= 0 {
+		r.Notify(MsgFogOff)
+		return
+	}
+	r.Notify(MsgFog, f.Density)
+}
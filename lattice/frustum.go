@@ -0,0 +1,40 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import "github.com/go-gl/mathgl/mgl32"
+
+// frustum holds the six clip planes of a view, as (a, b, c, d) with
+// a*x + b*y + c*z + d >= 0 on the inner side.
+type frustum [6]mgl32.Vec4
+
+// newFrustum extracts the clip planes of the combined projection, camera
+// and model matrix m.
+func newFrustum(m mgl32.Mat4) frustum {
+	r0, r1, r2, r3 := m.Row(0), m.Row(1), m.Row(2), m.Row(3)
+	return frustum{
+		r3.Add(r0), r3.Sub(r0),
+		r3.Add(r1), r3.Sub(r1),
+		r3.Add(r2), r3.Sub(r2),
+	}
+}
+
+// intersectsBox reports whether the axis-aligned box may be visible. Boxes
+// near the frustum corners can be reported visible although they are not.
+func (f *frustum) intersectsBox(min, max mgl32.Vec3) bool {
+	for _, p := range f {
+		// Test the box corner furthest along the plane normal.
+		v := min
+		for i := 0; i < 3; i++ {
+			if p[i] > 0 {
+				v[i] = max[i]
+			}
+		}
+		if p[0]*v[0]+p[1]*v[1]+p[2]*v[2]+p[3] < 0 {
+			return false
+		}
+	}
+	return true
+}
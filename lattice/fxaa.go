@@ -0,0 +1,97 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// FXAA smooths the edges of the frame by blurring along them where the
+// luminance changes sharply, in a single pass. It is cheaper than
+// multisampling and stands in for it where the driver does not provide it,
+// at the price of softening fine detail.
+type FXAA struct{}
+
+func (FXAA) Name() string { return "fxaa" }
+
+func (FXAA) Shader() string { return fxaaFragmentShader }
+
+func (FXAA) SetUniforms(program uint32) {}
+
+// obtainedSamples returns the number of multisampling samples of the
+// default framebuffer of the current context, which may be fewer than the
+// window asked for, or 0 where the driver ignored the request.
+func obtainedSamples() int {
+	var samples int32
+	gl.GetIntegerv(gl.SAMPLES, &samples)
+	return int(samples)
+}
+
+// setupAntialiasing reports how many of the requested multisampling
+// samples were obtained and, if multisampling was asked for but not
+// obtained, smooths the edges with FXAA instead. It returns the number
+// of samples for the post-processing framebuffers, which follow the
+// window so that the frame looks the same with and without effects.
+func (r *Renderer) setupAntialiasing(requested int) int32 {
+	samples := obtainedSamples()
+	if requested > 0 {
+		fmt.Printf("Multisampling: %v of %v samples\n", samples, requested)
+	}
+	if requested > 1 && samples < 2 {
+		samples = 0
+		if err := r.post.set(FXAA{}); err != nil {
+			r.NotifyError(err)
+		} else {
+			r.Notify(MsgFXAAFallback, requested)
+		}
+	}
+	return int32(samples)
+}
+
+var fxaaFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+const float reduceMin = 1.0 / 128;
+const float reduceMul = 1.0 / 8;
+const float spanMax = 8;
+
+float luma(vec3 color) {
+    return dot(clamp(color, 0, 1), vec3(0.299, 0.587, 0.114));
+}
+
+void main() {
+    vec2 texel = 1.0 / vec2(textureSize(frame, 0));
+    vec4 center = texture(frame, fragUV);
+    float lumaNW = luma(texture(frame, fragUV + vec2(-1, -1) * texel).rgb);
+    float lumaNE = luma(texture(frame, fragUV + vec2(1, -1) * texel).rgb);
+    float lumaSW = luma(texture(frame, fragUV + vec2(-1, 1) * texel).rgb);
+    float lumaSE = luma(texture(frame, fragUV + vec2(1, 1) * texel).rgb);
+    float lumaM = luma(center.rgb);
+    float lumaMin = min(lumaM, min(min(lumaNW, lumaNE), min(lumaSW, lumaSE)));
+    float lumaMax = max(lumaM, max(max(lumaNW, lumaNE), max(lumaSW, lumaSE)));
+
+    // The blur runs along the edge, across the gradient of the luminance.
+    vec2 dir = vec2(-((lumaNW + lumaNE) - (lumaSW + lumaSE)), (lumaNW + lumaSW) - (lumaNE + lumaSE));
+    float reduce = max((lumaNW + lumaNE + lumaSW + lumaSE) * 0.25 * reduceMul, reduceMin);
+    float scale = 1 / (min(abs(dir.x), abs(dir.y)) + reduce);
+    dir = clamp(dir * scale, -spanMax, spanMax) * texel;
+
+    vec3 a = 0.5 * (texture(frame, fragUV + dir * (1.0 / 3 - 0.5)).rgb +
+                    texture(frame, fragUV + dir * (2.0 / 3 - 0.5)).rgb);
+    vec3 b = a * 0.5 + 0.25 * (texture(frame, fragUV - dir * 0.5).rgb +
+                               texture(frame, fragUV + dir * 0.5).rgb);
+    // The wider blur is kept unless it reaches past the local contrast,
+    // which means it crossed another edge.
+    float lumaB = luma(b);
+    outputColor = vec4(lumaB < lumaMin || lumaB > lumaMax ? a : b, center.a);
+}
+` + "\x00"
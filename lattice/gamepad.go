@@ -0,0 +1,133 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// GamepadMapping assigns joystick axes to camera motion. Axes are indices
+// into the standard gamepad layout of GLFW when the joystick has a known
+// gamepad mapping, and into its raw axes otherwise.
+type GamepadMapping struct {
+	// Joystick is the GLFW joystick to read, 0 for the first one.
+	Joystick int `json:"joystick"`
+	// StrafeX and StrafeY move the camera sideways and forwards.
+	StrafeX int `json:"strafe_x"`
+	StrafeY int `json:"strafe_y"`
+	// LookX and LookY turn the camera.
+	LookX int `json:"look_x"`
+	LookY int `json:"look_y"`
+	// Up and Down are triggers, resting at -1, that move the camera
+	// vertically.
+	Up   int `json:"up"`
+	Down int `json:"down"`
+	// DeadZone is the deflection below which a stick or trigger counts as
+	// centered. Larger deflections are rescaled to start from zero.
+	DeadZone float32 `json:"dead_zone"`
+	// MoveSpeed is the camera speed at full deflection in cells per
+	// second, LookSpeed the turn rate in degrees per second.
+	MoveSpeed float32 `json:"move_speed"`
+	LookSpeed float32 `json:"look_speed"`
+	InvertY   bool    `json:"invert_y"`
+}
+
+func DefaultGamepadMapping() GamepadMapping {
+	return GamepadMapping{
+		StrafeX:   int(glfw.AxisLeftX),
+		StrafeY:   int(glfw.AxisLeftY),
+		LookX:     int(glfw.AxisRightX),
+		LookY:     int(glfw.AxisRightY),
+		Up:        int(glfw.AxisRightTrigger),
+		Down:      int(glfw.AxisLeftTrigger),
+		DeadZone:  0.15,
+		MoveSpeed: 10,
+		LookSpeed: 120,
+	}
+}
+
+// LoadGamepadMapping reads a mapping from a JSON file. Fields missing from
+// the file keep their default values.
+func LoadGamepadMapping(path string) (GamepadMapping, error) {
+	m := DefaultGamepadMapping()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	return m, nil
+}
+
+// gamepadInput is the camera motion requested by a gamepad in one frame.
+type gamepadInput struct {
+	// move is the camera velocity in camera space, in cells per second.
+	move mgl32.Vec3
+	// pitch and yaw are turn rates in radians per second.
+	pitch, yaw float32
+}
+
+// poll reads the joystick of m. It returns false if none is connected.
+func (m GamepadMapping) poll() (gamepadInput, bool) {
+	joy := glfw.Joystick1 + glfw.Joystick(m.Joystick)
+	if !joy.Present() {
+		return gamepadInput{}, false
+	}
+	var axes []float32
+	if joy.IsGamepad() {
+		state := joy.GetGamepadState()
+		axes = state.Axes[:]
+	} else {
+		axes = joy.GetAxes()
+	}
+	axis := func(i int) float32 {
+		if i < 0 || i >= len(axes) {
+			return 0
+		}
+		return axes[i]
+	}
+
+	strafe := m.stick(axis(m.StrafeX), axis(m.StrafeY))
+	look := m.stick(axis(m.LookX), axis(m.LookY))
+	vertical := m.trigger(axis(m.Up)) - m.trigger(axis(m.Down))
+	if m.InvertY {
+		look[1] = -look[1]
+	}
+
+	lookSpeed := mgl32.DegToRad(m.LookSpeed)
+	return gamepadInput{
+		// Stick y grows downwards, the camera looks along -z.
+		move:  mgl32.Vec3{strafe[0], vertical, strafe[1]}.Mul(m.MoveSpeed),
+		pitch: -look[1] * lookSpeed,
+		yaw:   -look[0] * lookSpeed,
+	}, true
+}
+
+// stick applies the dead zone to a stick, radially so that diagonals are
+// not cut off.
+func (m GamepadMapping) stick(x, y float32) mgl32.Vec2 {
+	v := mgl32.Vec2{x, y}
+	l := v.Len()
+	if l <= m.DeadZone {
+		return mgl32.Vec2{}
+	}
+	scaled := mgl32.Clamp((l-m.DeadZone)/(1-m.DeadZone), 0, 1)
+	return v.Mul(scaled / l)
+}
+
+// trigger maps a trigger from [-1, 1] to [0, 1] and applies the dead zone.
+func (m GamepadMapping) trigger(v float32) float32 {
+	t := (v + 1) / 2
+	if t <= m.DeadZone {
+		return 0
+	}
+	return mgl32.Clamp((t-m.DeadZone)/(1-m.DeadZone), 0, 1)
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"hash/fnv"
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// ghostDepth is the length of the frustum drawn for another client's
+// camera, in cells.
+const ghostDepth = 3
+
+// linesPerGhost covers the four edges from the camera to the corners of
+// the frustum base, the four edges of the base and a tick marking its top.
+const linesPerGhost = 9
+
+// ghostRenderer draws the cameras of the other clients of a session as
+// wireframe frustums.
+type ghostRenderer struct {
+	program uint32
+	vao     uint32
+	vbo     uint32
+
+	projectionUniform int32
+	cameraUniform     int32
+	colorUniform      int32
+}
+
+func newGhostRenderer() (*ghostRenderer, error) {
+	program, err := glutil.NewProgram(ghostVertexShader, ghostFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	g := &ghostRenderer{
+		program:           program,
+		projectionUniform: gl.GetUniformLocation(program, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(program, gl.Str("camera\x00")),
+		colorUniform:      gl.GetUniformLocation(program, gl.Str("color\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &g.vao)
+	gl.BindVertexArray(g.vao)
+	gl.GenBuffers(1, &g.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, g.vbo)
+	vert := uint32(gl.GetAttribLocation(program, gl.Str("vert\x00")))
+	gl.EnableVertexAttribArray(vert)
+	gl.VertexAttribPointerWithOffset(vert, 3, gl.FLOAT, false, 3*4, 0)
+	gl.BindVertexArray(uint32(prevVAO))
+	return g, nil
+}
+
+// Render draws ghosts as seen through projection and camera.
+func (g *ghostRenderer) Render(projection, camera mgl32.Mat4, ghosts []*collabGhost) {
+	if len(ghosts) == 0 {
+		return
+	}
+	verts := make([]float32, 0, len(ghosts)*linesPerGhost*2*3)
+	for _, ghost := range ghosts {
+		verts = append(verts, frustumLines(ghost.camera, ghostDepth)...)
+	}
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.UseProgram(g.program)
+	gl.UniformMatrix4fv(g.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(g.cameraUniform, 1, false, &camera[0])
+	gl.BindVertexArray(g.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, g.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(verts)*4, gl.Ptr(verts), gl.STREAM_DRAW)
+	for i, ghost := range ghosts {
+		color := ghostColor(ghost.name)
+		gl.Uniform3fv(g.colorUniform, 1, &color[0])
+		gl.DrawArrays(gl.LINES, int32(i*linesPerGhost*2), linesPerGhost*2)
+	}
+}
+
+// frustumLines returns the line vertices of the frustum of c, cut off at
+// depth.
+func frustumLines(c collabCamera, depth float32) []float32 {
+	q := mgl32.AnglesToQuat(0, c.Yaw, c.Pitch, mgl32.ZYX)
+	forward := q.Rotate(mgl32.Vec3{0, 0, -1})
+	up := q.Rotate(mgl32.Vec3{0, 1, 0})
+	right := q.Rotate(mgl32.Vec3{1, 0, 0})
+
+	h := depth * float32(math.Tan(float64(mgl32.DegToRad(c.FOV))/2))
+	w := h * c.Aspect
+	center := c.Pos.Add(forward.Mul(depth))
+	corner := func(x, y float32) mgl32.Vec3 {
+		return center.Add(right.Mul(x * w)).Add(up.Mul(y * h))
+	}
+	corners := [4]mgl32.Vec3{corner(-1, -1), corner(1, -1), corner(1, 1), corner(-1, 1)}
+
+	var lines []mgl32.Vec3
+	for i, p := range corners {
+		lines = append(lines, c.Pos, p, p, corners[(i+1)%4])
+	}
+	lines = append(lines, corner(0, 1), corner(0, 1.3))
+
+	verts := make([]float32, 0, len(lines)*3)
+	for _, p := range lines {
+		verts = append(verts, p[0], p[1], p[2])
+	}
+	return verts
+}
+
+// ghostColor picks a bright color for a client, the same on every machine.
+func ghostColor(name string) mgl32.Vec3 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hue := float64(h.Sum32()%360) / 60
+	x := float32(1 - math.Abs(math.Mod(hue, 2)-1))
+	switch int(hue) {
+	case 0:
+		return mgl32.Vec3{1, x, 0}
+	case 1:
+		return mgl32.Vec3{x, 1, 0}
+	case 2:
+		return mgl32.Vec3{0, 1, x}
+	case 3:
+		return mgl32.Vec3{0, x, 1}
+	case 4:
+		return mgl32.Vec3{x, 0, 1}
+	default:
+		return mgl32.Vec3{1, 0, x}
+	}
+}
+
+// Delete releases the GL objects of the renderer.
+func (g *ghostRenderer) Delete() {
+	gl.DeleteBuffers(1, &g.vbo)
+	gl.DeleteVertexArrays(1, &g.vao)
+	gl.DeleteProgram(g.program)
+}
+
+var ghostVertexShader = `
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+
+in vec3 vert;
+
+void main() {
+    gl_Position = projection * camera * vec4(vert, 1);
+}
+` + "\x00"
+
+var ghostFragmentShader = `
+#version 330
+
+uniform vec3 color;
+
+out vec4 outputColor;
+
+void main() {
+    outputColor = vec4(color, 1);
+}
+` + "\x00"
@@ -0,0 +1,478 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// GPUSim selects a simulation run entirely on the GPU.
+type GPUSim int
+
+const (
+	// NoGPUSim runs no simulation.
+	NoGPUSim GPUSim = iota
+	// LifeSim is Bays' 3D Game of Life 4555: a live cell stays alive with
+	// 4 or 5 of its 26 neighbors alive, and a dead one comes alive with 5.
+	LifeSim
+	// GrayScottSim is Gray-Scott reaction-diffusion of a chemical U fed
+	// into the grid and a chemical V feeding on it, with the cells drawn
+	// where V is concentrated.
+	GrayScottSim
+)
+
+var gpuSimNames = []string{"off", "life", "grayscott"}
+
+func (s GPUSim) String() string {
+	return gpuSimNames[s]
+}
+
+// Set implements flag.Value.
+func (s *GPUSim) Set(name string) error {
+	sim, ok := ParseGPUSim(name)
+	if !ok {
+		return fmt.Errorf("unknown GPU simulation %q", name)
+	}
+	*s = sim
+	return nil
+}
+
+// ParseGPUSim returns the GPU simulation with the given name.
+func ParseGPUSim(name string) (GPUSim, bool) {
+	for i, n := range gpuSimNames {
+		if n == name {
+			return GPUSim(i), true
+		}
+	}
+	return NoGPUSim, false
+}
+
+// defaultSteps returns the steps s runs per frame unless told otherwise:
+// reaction-diffusion takes many small steps to form patterns.
+func (s GPUSim) defaultSteps() int {
+	if s == GrayScottSim {
+		return 8
+	}
+	return 1
+}
+
+// GPU simulation grid limits and defaults. The side of the grid is a
+// power of two, so that the top of the mipmap chain of the state is its
+// exact mean.
+const (
+	defaultGPUSimSide = 64
+	minGPUSimSide     = 8
+	maxGPUSimSide     = 256
+	// gpuSimRampStops are the colormap colors the cells are colored by.
+	gpuSimRampStops = 8
+)
+
+// gpuSim runs a simulation on a cubic grid whose state, two channels per
+// cell, lives in a pair of 3D textures on the GPU, every step reading one
+// and writing the other. The instance data of the cells is written from
+// the state by transform feedback in the layout of the mesh's, so that the
+// lattice program draws them, and only the means of the state are read
+// back, two frames late, so that the pipeline never stalls on them.
+type gpuSim struct {
+	kind  GPUSim
+	side  int32
+	steps int
+	// step is the number of steps run.
+	step int
+
+	state [2]uint32
+	cur   int
+	fbo   uint32
+
+	stepProgram  uint32
+	stepVAO      uint32
+	layerUniform int32
+
+	cellProgram  uint32
+	cellVAO      uint32
+	instances    uint32
+	basisUniform int32
+	rampUniform  int32
+
+	// pack are the buffers the means are read back into, in turns, and
+	// fences mark when the GPU is done writing them.
+	pack   [2]uint32
+	fences [2]uintptr
+	packed int
+	// means are the means of the two channels of the state, as last read
+	// back.
+	means [2]float32
+}
+
+func newGPUSim(kind GPUSim, side int32, steps int) (*gpuSim, error) {
+	if side < minGPUSimSide || side > maxGPUSimSide || side&(side-1) != 0 {
+		return nil, fmt.Errorf("the side of a GPU simulation must be a power of two from %v to %v, not %v", minGPUSimSide, maxGPUSimSide, side)
+	}
+	stepProgram, err := glutil.NewProgram(gpuSimStepVertexShader, gpuSimStepFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	cellProgram, err := glutil.NewFeedbackProgram(gpuSimCellVertexShader, "offset", "color", "cellID", "ao", "size")
+	if err != nil {
+		gl.DeleteProgram(stepProgram)
+		return nil, err
+	}
+	s := &gpuSim{
+		kind:         kind,
+		side:         side,
+		steps:        steps,
+		stepProgram:  stepProgram,
+		cellProgram:  cellProgram,
+		layerUniform: gl.GetUniformLocation(stepProgram, gl.Str("layer\x00")),
+		basisUniform: gl.GetUniformLocation(cellProgram, gl.Str("basis\x00")),
+		rampUniform:  gl.GetUniformLocation(cellProgram, gl.Str("ramp\x00")),
+	}
+	for _, p := range []uint32{stepProgram, cellProgram} {
+		gl.UseProgram(p)
+		gl.Uniform1i(gl.GetUniformLocation(p, gl.Str("state\x00")), 0)
+		gl.Uniform1i(gl.GetUniformLocation(p, gl.Str("kind\x00")), int32(kind))
+	}
+	// Both programs generate their vertices from the vertex IDs, but core
+	// profiles still require a bound VAO.
+	gl.GenVertexArrays(1, &s.stepVAO)
+	gl.GenVertexArrays(1, &s.cellVAO)
+
+	seed := s.seed()
+	gl.GenTextures(2, &s.state[0])
+	for _, tex := range s.state {
+		gl.BindTexture(gl.TEXTURE_3D, tex)
+		gl.TexImage3D(gl.TEXTURE_3D, 0, gl.RG32F, side, side, side, 0, gl.RG, gl.FLOAT, gl.Ptr(seed))
+		gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	}
+	gl.BindTexture(gl.TEXTURE_3D, 0)
+
+	gl.GenFramebuffers(1, &s.fbo)
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, s.fbo)
+	gl.FramebufferTextureLayer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, s.state[1], 0, 0)
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		s.Delete()
+		return nil, errors.New("GPU simulation framebuffer is incomplete")
+	}
+
+	gl.GenBuffers(1, &s.instances)
+	gl.BindBuffer(gl.ARRAY_BUFFER, s.instances)
+	gl.BufferData(gl.ARRAY_BUFFER, int(s.cells())*floatsPerInstance*4, nil, gl.DYNAMIC_COPY)
+	gl.GenBuffers(2, &s.pack[0])
+	for _, b := range s.pack {
+		gl.BindBuffer(gl.PIXEL_PACK_BUFFER, b)
+		gl.BufferData(gl.PIXEL_PACK_BUFFER, 2*4, nil, gl.STREAM_READ)
+	}
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+	return s, nil
+}
+
+// cells returns the number of cells of the grid.
+func (s *gpuSim) cells() int32 {
+	return s.side * s.side * s.side
+}
+
+// seed returns the initial state: random cells in the middle half of the
+// grid, the same every run, alive for Life, or spots of V in U everywhere
+// for Gray-Scott.
+func (s *gpuSim) seed() []float32 {
+	n := int(s.side)
+	data := make([]float32, 2*n*n*n)
+	if s.kind == GrayScottSim {
+		for i := 0; i < len(data); i += 2 {
+			data[i] = 1
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for z := n / 4; z < 3*n/4; z++ {
+		for y := n / 4; y < 3*n/4; y++ {
+			for x := n / 4; x < 3*n/4; x++ {
+				i := 2 * ((z*n+y)*n + x)
+				switch {
+				case s.kind == LifeSim && rng.Float32() < 0.3:
+					data[i] = 1
+				case s.kind == GrayScottSim && rng.Float32() < 0.05:
+					data[i], data[i+1] = 0.5, 0.25
+				}
+			}
+		}
+	}
+	return data
+}
+
+// run advances the simulation by its steps, writes the instance data of
+// the cells colored by colormap, and reads back the means of the state.
+// The grid is placed in the world by p.
+func (s *gpuSim) run(p LatticeParams, colormap Colormap) {
+	var prevProgram, prevVAO, prevFBO int32
+	var viewport [4]int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	// Every step draws the layers of the next state one at a time.
+	gl.UseProgram(s.stepProgram)
+	gl.BindVertexArray(s.stepVAO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, s.fbo)
+	gl.Viewport(0, 0, s.side, s.side)
+	for i := 0; i < s.steps; i++ {
+		gl.BindTexture(gl.TEXTURE_3D, s.state[s.cur])
+		next := 1 - s.cur
+		for z := int32(0); z < s.side; z++ {
+			gl.FramebufferTextureLayer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, s.state[next], 0, z)
+			gl.Uniform1i(s.layerUniform, z)
+			gl.DrawArrays(gl.TRIANGLES, 0, 3)
+		}
+		s.cur = next
+		s.step++
+	}
+
+	gl.UseProgram(s.cellProgram)
+	gl.BindVertexArray(s.cellVAO)
+	gl.BindTexture(gl.TEXTURE_3D, s.state[s.cur])
+	var basis mgl32.Mat3
+	for axis := 0; axis < 3; axis++ {
+		var unit mgl32.Vec3
+		unit[axis] = 1
+		basis.SetCol(axis, p.World(unit))
+	}
+	gl.UniformMatrix3fv(s.basisUniform, 1, false, &basis[0])
+	if colormap == NoColormap {
+		colormap = Viridis
+	}
+	var ramp [gpuSimRampStops]mgl32.Vec3
+	for i := range ramp {
+		ramp[i] = colormap.at(float32(i) / (gpuSimRampStops - 1))
+	}
+	gl.Uniform3fv(s.rampUniform, gpuSimRampStops, &ramp[0][0])
+	gl.Enable(gl.RASTERIZER_DISCARD)
+	gl.BindBufferBase(gl.TRANSFORM_FEEDBACK_BUFFER, 0, s.instances)
+	gl.BeginTransformFeedback(gl.POINTS)
+	gl.DrawArrays(gl.POINTS, 0, s.cells())
+	gl.EndTransformFeedback()
+	gl.BindBufferBase(gl.TRANSFORM_FEEDBACK_BUFFER, 0, 0)
+	gl.Disable(gl.RASTERIZER_DISCARD)
+
+	s.readMeans()
+	gl.BindTexture(gl.TEXTURE_3D, 0)
+}
+
+// readMeans reads the means of the state read back two frames ago, if the
+// GPU is done with them, and starts reading back those of the current
+// state, the single texel at the top of its mipmap chain.
+func (s *gpuSim) readMeans() {
+	i := s.packed
+	s.packed = 1 - i
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, s.pack[i])
+	defer gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+	if f := s.fences[i]; f != 0 {
+		status := gl.ClientWaitSync(f, 0, 0)
+		if status == gl.ALREADY_SIGNALED || status == gl.CONDITION_SATISFIED {
+			means := (*[2]float32)(gl.MapBufferRange(gl.PIXEL_PACK_BUFFER, 0, 2*4, gl.MAP_READ_BIT))
+			s.means = *means
+			gl.UnmapBuffer(gl.PIXEL_PACK_BUFFER)
+		}
+		gl.DeleteSync(f)
+	}
+	top := int32(0)
+	for side := s.side; side > 1; side /= 2 {
+		top++
+	}
+	gl.GenerateMipmap(gl.TEXTURE_3D)
+	gl.GetTexImage(gl.TEXTURE_3D, top, gl.RG, gl.FLOAT, nil)
+	s.fences[i] = gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0)
+}
+
+// stats describes the step and the means of the state for the HUD.
+func (s *gpuSim) stats(l Locale) string {
+	if s.kind == LifeSim {
+		return l.Sprintf(MsgLifeStats, s.step, s.side, 100*s.means[0])
+	}
+	return l.Sprintf(MsgGrayScottStats, s.step, s.side, s.means[0], s.means[1])
+}
+
+// Delete releases the textures, buffers and programs of the simulation.
+func (s *gpuSim) Delete() {
+	for i, f := range s.fences {
+		if f != 0 {
+			gl.DeleteSync(f)
+			s.fences[i] = 0
+		}
+	}
+	gl.DeleteBuffers(2, &s.pack[0])
+	gl.DeleteBuffers(1, &s.instances)
+	gl.DeleteFramebuffers(1, &s.fbo)
+	gl.DeleteTextures(2, &s.state[0])
+	gl.DeleteVertexArrays(1, &s.stepVAO)
+	gl.DeleteVertexArrays(1, &s.cellVAO)
+	gl.DeleteProgram(s.stepProgram)
+	gl.DeleteProgram(s.cellProgram)
+}
+
+// SetGPUSim replaces the lattice in the view by a simulation of kind kind
+// run on the GPU, on a grid of side cells a side, stepped steps times per
+// frame, 0 for the defaults. NoGPUSim brings the lattice back.
+func (r *Renderer) SetGPUSim(kind GPUSim, side, steps int) error {
+	if kind == NoGPUSim {
+		if r.gpuSim != nil {
+			r.gpuSim.Delete()
+			r.gpuSim = nil
+		}
+		r.Notify(MsgGPUSimOff)
+		return nil
+	}
+	if side <= 0 {
+		side = defaultGPUSimSide
+	}
+	if steps <= 0 {
+		steps = kind.defaultSteps()
+	}
+	sim, err := newGPUSim(kind, int32(side), steps)
+	if err != nil {
+		return err
+	}
+	if r.gpuSim != nil {
+		r.gpuSim.Delete()
+	}
+	r.gpuSim = sim
+	r.Notify(MsgGPUSim, kind, side, steps)
+	return nil
+}
+
+// runGPUSim advances the GPU simulation, if any, by a frame.
+func (r *Renderer) runGPUSim() {
+	if r.gpuSim == nil {
+		return
+	}
+	r.timers.begin(simPass)
+	r.gpuSim.run(r.params, r.coloring.colormap)
+	r.timers.end(simPass)
+}
+
+// drawGPUSim draws the cells of the GPU simulation with the lattice
+// program. They cast no shadows and cannot be picked. Without a shift the
+// cubes of empty cells, of size 0, have no area.
+func (r *Renderer) drawGPUSim() {
+	gl.Uniform1f(r.shiftUniform, 0)
+	r.mesh.DrawFrom(r.attribs, r.gpuSim.instances, 0, r.gpuSim.cells())
+}
+
+var gpuSimStepVertexShader = `
+#version 330
+
+void main() {
+    vec2 pos = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2);
+    gl_Position = vec4(pos * 2 - 1, 0, 1);
+}
+` + "\x00"
+
+var gpuSimStepFragmentShader = `
+#version 330
+
+// state holds the current state, which wraps around the grid, and layer
+// is the layer of the next state being drawn.
+uniform sampler3D state;
+uniform int layer;
+uniform int kind;
+
+out vec2 next;
+
+ivec3 side;
+
+vec2 at(ivec3 p) {
+    return texelFetch(state, (p + side) % side, 0).rg;
+}
+
+void main() {
+    side = textureSize(state, 0);
+    ivec3 p = ivec3(ivec2(gl_FragCoord.xy), layer);
+    vec2 c = at(p);
+    if (kind == 1) {
+        int n = 0;
+        for (int z = -1; z <= 1; z++) {
+            for (int y = -1; y <= 1; y++) {
+                for (int x = -1; x <= 1; x++) {
+                    if (x != 0 || y != 0 || z != 0) {
+                        n += int(at(p + ivec3(x, y, z)).r > 0.5);
+                    }
+                }
+            }
+        }
+        bool alive = c.r > 0.5;
+        next = vec2(alive && (n == 4 || n == 5) || !alive && n == 5 ? 1 : 0, 0);
+        return;
+    }
+
+    // Diffusion rates of U and V, the rate U is fed in and the rate V is
+    // removed, in a step of time 1.
+    const vec2 diffusion = vec2(0.16, 0.08);
+    const float feed = 0.037;
+    const float kill = 0.06;
+    vec2 laplacian = at(p + ivec3(1, 0, 0)) + at(p - ivec3(1, 0, 0)) +
+        at(p + ivec3(0, 1, 0)) + at(p - ivec3(0, 1, 0)) +
+        at(p + ivec3(0, 0, 1)) + at(p - ivec3(0, 0, 1)) - 6 * c;
+    float reaction = c.r * c.g * c.g;
+    next = clamp(c + diffusion * laplacian + vec2(feed * (1 - c.r) - reaction, reaction - (feed + kill) * c.g), 0, 1);
+}
+` + "\x00"
+
+var gpuSimCellVertexShader = `
+#version 330
+
+uniform sampler3D state;
+uniform int kind;
+// basis maps grid positions, centered on the origin, to the world.
+uniform mat3 basis;
+uniform vec3 ramp[8];
+
+// The instance data of a cell, as the mesh lays it out.
+out vec3 offset;
+out vec3 color;
+flat out uint cellID;
+flat out uint ao;
+out float size;
+
+void main() {
+    ivec3 side = textureSize(state, 0);
+    ivec3 p = ivec3(gl_VertexID % side.x, gl_VertexID / side.x % side.y, gl_VertexID / (side.x * side.y));
+    vec3 pos = vec3(p - side / 2);
+    vec2 s = texelFetch(state, p, 0).rg;
+    // Live cells are colored by their distance from the center, as in
+    // generated lattices, and cells of V by its concentration.
+    float v;
+    if (kind == 1) {
+        size = s.r;
+        v = length(pos) / length(vec3(side / 2));
+    } else {
+        size = smoothstep(0.15, 0.3, s.g);
+        v = clamp(s.g * 2, 0, 1);
+    }
+    float x = v * 7;
+    int i = min(int(x), 6);
+    color = mix(ramp[i], ramp[i + 1], x - float(i));
+    offset = basis * pos;
+    cellID = 0u;
+    ao = 0u;
+}
+` + "\x00"
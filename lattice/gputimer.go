@@ -0,0 +1,92 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import "github.com/go-gl/gl/v4.1-core/gl"
+
+// gpuPass is a pass of a frame timed on the GPU.
+type gpuPass int
+
+const (
+	simPass gpuPass = iota
+	shadowPass
+	latticePass
+	postPass
+	hudPass
+	gpuPasses
+
+	// noPass is the pass running while none is.
+	noPass gpuPass = -1
+)
+
+// gpuTimers times the passes of a frame on the GPU with GL_TIME_ELAPSED
+// queries. Every pass has a query for even frames and one for odd frames,
+// read back two frames later, before it is reused, and only if the GPU is
+// done with it, so that timing never stalls the pipeline.
+type gpuTimers struct {
+	queries [2][gpuPasses]uint32
+	// issued marks the queries that ran in the last frame of their set.
+	issued [2][gpuPasses]bool
+	set    int
+	// running is the pass being timed. Elapsed time queries cannot nest,
+	// so a pass begun while another runs is not timed.
+	running gpuPass
+	// ms are the latest times of the passes in milliseconds, 0 for passes
+	// that did not run.
+	ms [gpuPasses]float32
+}
+
+func newGPUTimers() *gpuTimers {
+	t := &gpuTimers{running: noPass}
+	gl.GenQueries(2*int32(gpuPasses), &t.queries[0][0])
+	return t
+}
+
+// frame starts timing a frame, reading the times of the passes of the
+// frame before last whose results are available.
+func (t *gpuTimers) frame() {
+	t.end(t.running)
+	t.set = 1 - t.set
+	for p := range t.ms {
+		if !t.issued[t.set][p] {
+			t.ms[p] = 0
+			continue
+		}
+		t.issued[t.set][p] = false
+		q := t.queries[t.set][p]
+		var available int32
+		gl.GetQueryObjectiv(q, gl.QUERY_RESULT_AVAILABLE, &available)
+		if available == 0 {
+			continue
+		}
+		var ns uint64
+		gl.GetQueryObjectui64v(q, gl.QUERY_RESULT, &ns)
+		t.ms[p] = float32(ns) / 1e6
+	}
+}
+
+// begin starts timing pass p unless a pass is being timed. A pass timed
+// more than once in a frame keeps the last time.
+func (t *gpuTimers) begin(p gpuPass) {
+	if t.running != noPass {
+		return
+	}
+	gl.BeginQuery(gl.TIME_ELAPSED, t.queries[t.set][p])
+	t.issued[t.set][p] = true
+	t.running = p
+}
+
+// end stops timing pass p if it is being timed.
+func (t *gpuTimers) end(p gpuPass) {
+	if p == noPass || t.running != p {
+		return
+	}
+	gl.EndQuery(gl.TIME_ELAPSED)
+	t.running = noPass
+}
+
+func (t *gpuTimers) Delete() {
+	gl.DeleteQueries(2*int32(gpuPasses), &t.queries[0][0])
+}
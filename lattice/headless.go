@@ -0,0 +1,209 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// headlessFrameRate is the frame rate the animation of headless frames
+// advances at, whatever the time they take to render.
+const headlessFrameRate = 30
+
+// RunHeadless renders frames of the lattice in a hidden window, for
+// servers and CI, and writes them as PNGs. Frame i is written to
+// fmt.Sprintf(pattern, i); a pattern without a verb is only valid for a
+// single frame. The images have the window size of opts, or windowWidth
+// by windowHeight if it is unset, unless opts.Panorama asks for stereo
+// panoramas.
+func RunHeadless(opts Options, frames int, pattern string) error {
+	if frames > 1 && !strings.Contains(pattern, "%") {
+		return fmt.Errorf("%v frames need a pattern with a frame number verb such as %%04d, not %v", frames, pattern)
+	}
+	if err := glfw.Init(); err != nil {
+		return fmt.Errorf("failed to initialize glfw: %v", err)
+	}
+	defer glfw.Terminate()
+
+	r, err := newHeadlessRenderer(opts)
+	if err != nil {
+		return err
+	}
+	err = r.renderFrames(frames, pattern, int32(opts.Samples), opts.Panorama)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newHeadlessRenderer sets up rendering of the lattice of opts into a
+// hidden window of the window size of opts, or windowWidth by windowHeight
+// if it is unset. GLFW must be initialized.
+func newHeadlessRenderer(opts Options) (*Renderer, error) {
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	glfw.WindowHint(glfw.Visible, glfw.False)
+	width, height := opts.Width, opts.Height
+	if width <= 0 || height <= 0 {
+		width, height = windowWidth, windowHeight
+	}
+	window, err := glfw.CreateWindow(width, height, opts.Title, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// A headless run starts from the lattice of opts and must not leave a
+	// session to recover.
+	opts.AutosavePeriod = 0
+	return NewRenderer(window, opts)
+}
+
+// renderFrames renders frames into an offscreen framebuffer of the size of
+// the window's, or stereo panoramas panoramaWidth pixels wide if not zero,
+// writing frame i to the file named by pattern.
+func (r *Renderer) renderFrames(frames int, pattern string, samples int32, panoramaWidth int) error {
+	var target *offscreen
+	var pano *panorama
+	var err error
+	// The frames are all captured, so only the captured layers are drawn.
+	r.layers.shown = r.layers.captured
+	r.layers.drawing = r.layers.shown
+	if panoramaWidth > 0 {
+		pano, err = newPanorama(panoramaWidth, samples)
+		if err != nil {
+			return err
+		}
+		defer pano.Delete()
+	} else {
+		width, height := r.w.GetFramebufferSize()
+		target, err = newOffscreen(int32(width), int32(height), samples)
+		if err != nil {
+			return err
+		}
+		defer target.Delete()
+	}
+
+	// The time is set for every frame so that the animation does not
+	// depend on how long frames take. The first frame only starts the
+	// frame timer.
+	glfw.SetTime(1)
+	r.frameTimer.OnFrame()
+	for i := 0; i < frames && !r.w.ShouldClose(); i++ {
+		glfw.SetTime(1 + float64(i+1)/headlessFrameRate)
+		r.console.Poll(r)
+		r.pollCollab()
+		r.pollWatch()
+
+		r.Update(r.w)
+		var img *image.RGBA
+		if pano != nil {
+			img = r.renderPanorama(pano)
+		} else {
+			img = r.renderOffscreen(target)
+		}
+
+		path := pattern
+		if strings.Contains(pattern, "%") {
+			path = fmt.Sprintf(pattern, i)
+		}
+		if err := writePNG(path, img); err != nil {
+			return err
+		}
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	return nil
+}
+
+// renderOffscreen renders a frame into target and reads it back.
+func (r *Renderer) renderOffscreen(target *offscreen) *image.RGBA {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, target.fbo)
+	r.Render()
+	return target.read()
+}
+
+// offscreen is a framebuffer with color and depth renderbuffers. A
+// multisampled one is resolved into a second, single sampled framebuffer
+// for reading.
+type offscreen struct {
+	width, height int32
+
+	fbo          uint32
+	color, depth uint32
+
+	resolveFBO   uint32
+	resolveColor uint32
+}
+
+// newOffscreen creates an offscreen framebuffer of the given size, with
+// samples samples per pixel, 0 to disable multisampling.
+func newOffscreen(width, height, samples int32) (*offscreen, error) {
+	o := &offscreen{width: width, height: height}
+
+	gl.GenRenderbuffers(1, &o.color)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, o.color)
+	gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, samples, gl.RGBA8, width, height)
+	gl.GenRenderbuffers(1, &o.depth)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, o.depth)
+	gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, samples, gl.DEPTH_COMPONENT24, width, height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.GenFramebuffers(1, &o.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, o.fbo)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, o.color)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, o.depth)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		o.Delete()
+		return nil, errors.New("offscreen framebuffer is incomplete")
+	}
+	if samples == 0 {
+		return o, nil
+	}
+
+	gl.GenRenderbuffers(1, &o.resolveColor)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, o.resolveColor)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.RGBA8, width, height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+	gl.GenFramebuffers(1, &o.resolveFBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, o.resolveFBO)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, o.resolveColor)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		o.Delete()
+		return nil, errors.New("offscreen resolve framebuffer is incomplete")
+	}
+	return o, nil
+}
+
+// read returns the color buffer as an image.
+func (o *offscreen) read() *image.RGBA {
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, o.fbo)
+	if o.resolveFBO != 0 {
+		gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, o.resolveFBO)
+		gl.BlitFramebuffer(0, 0, o.width, o.height, 0, 0, o.width, o.height, gl.COLOR_BUFFER_BIT, gl.NEAREST)
+		gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, o.fbo)
+		gl.BindFramebuffer(gl.READ_FRAMEBUFFER, o.resolveFBO)
+	}
+	gl.ReadBuffer(gl.COLOR_ATTACHMENT0)
+	img := readPixels(0, 0, int(o.width), int(o.height))
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, o.fbo)
+	return img
+}
+
+// Delete releases the GL objects of the framebuffer.
+func (o *offscreen) Delete() {
+	gl.DeleteFramebuffers(1, &o.fbo)
+	gl.DeleteFramebuffers(1, &o.resolveFBO)
+	gl.DeleteRenderbuffers(1, &o.color)
+	gl.DeleteRenderbuffers(1, &o.depth)
+	gl.DeleteRenderbuffers(1, &o.resolveColor)
+}
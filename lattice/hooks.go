@@ -0,0 +1,227 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// ShaderHooks are files of GLSL that replace functions of the lattice
+// shaders at their hook points, so that a scene can color and shape its
+// cells without changing the shaders. Color defines
+//
+//	vec3 shadeColor(vec3 color, vec3 worldPos, vec3 normal, vec2 uv, float time)
+//
+// returning the color of a face from its lit and textured color, before the
+// decals, edges and fog. Displace defines
+//
+//	vec3 displace(vec3 pos, vec3 normal, vec3 cell, float time)
+//
+// returning the world position of a vertex of the cell at cell. Either may
+// define helper functions and uniforms besides. An empty path keeps the
+// built-in function, which changes nothing.
+type ShaderHooks struct {
+	Color    string `json:"color,omitempty"`
+	Displace string `json:"displace,omitempty"`
+	// Bound is the farthest Displace moves a vertex along any axis, by
+	// which the cells are culled. Without a bound no cells are culled.
+	Bound float32 `json:"bound,omitempty"`
+}
+
+// String lists the hooks set, or returns "none".
+func (h ShaderHooks) String() string {
+	var hooks []string
+	for _, p := range shaderHookPoints {
+		if path := p.path(h); path != "" {
+			hooks = append(hooks, p.name+" "+path)
+		}
+	}
+	if len(hooks) == 0 {
+		return "none"
+	}
+	if h.Displace != "" && h.Bound > 0 {
+		hooks = append(hooks, fmt.Sprintf("bound %v", h.Bound))
+	}
+	return strings.Join(hooks, ", ")
+}
+
+// resolve returns h with its relative paths taken relative to dir.
+func (h ShaderHooks) resolve(dir string) ShaderHooks {
+	join := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	return ShaderHooks{Color: join(h.Color), Displace: join(h.Displace), Bound: h.Bound}
+}
+
+// shaderHookPoint is a function of a lattice shader that a hook replaces.
+// It is defined between the lines "// HOOK <name>" and "// END HOOK".
+type shaderHookPoint struct {
+	name string
+	// function is the name of the function the hook must define, signature
+	// its declaration.
+	function, signature string
+	file                string
+	// source numbers the hook in the GLSL compile log, where the shader
+	// itself is source 0.
+	source int
+	path   func(ShaderHooks) string
+}
+
+var shaderHookPoints = []shaderHookPoint{
+	{
+		name:      "color",
+		function:  "shadeColor",
+		signature: "vec3 shadeColor(vec3 color, vec3 worldPos, vec3 normal, vec2 uv, float time)",
+		file:      fragmentShaderFile,
+		source:    1,
+		path:      func(h ShaderHooks) string { return h.Color },
+	},
+	{
+		name:      "displace",
+		function:  "displace",
+		signature: "vec3 displace(vec3 pos, vec3 normal, vec3 cell, float time)",
+		file:      vertexShaderFile,
+		source:    2,
+		path:      func(h ShaderHooks) string { return h.Displace },
+	},
+}
+
+const hookEnd = "// END HOOK\n"
+
+// inject replaces the hook point in src, the source of its shader, by the
+// GLSL of the hook. #line directives number the lines of the hook from 1
+// in its own source, so that the compile log points into the hook file.
+func (p shaderHookPoint) inject(src, hook string) (string, error) {
+	begin := strings.Index(src, "// HOOK "+p.name+"\n")
+	if begin < 0 {
+		return "", fmt.Errorf("%v has no %v hook point", p.file, p.name)
+	}
+	n := strings.Index(src[begin:], hookEnd)
+	if n < 0 {
+		return "", fmt.Errorf("%v: %v hook point has no %q line", p.file, p.name, strings.TrimSpace(hookEnd))
+	}
+	end := begin + n + len(hookEnd)
+	line := strings.Count(src[:end], "\n") + 1
+	return fmt.Sprintf("%v#line 1 %v\n%v\n#line %v 0\n%v", src[:begin], p.source, hook, line, src[end:]), nil
+}
+
+// hookSource reads the hook file path of p and checks that it defines the
+// function of p.
+func (p shaderHookPoint) hookSource(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%v hook: %v", p.name, err)
+	}
+	hook := string(data)
+	if !strings.Contains(hook, p.function+"(") {
+		return "", fmt.Errorf("%v hook %v does not define %v", p.name, path, p.signature)
+	}
+	return hook, nil
+}
+
+// buildProgram builds the lattice program from the shaders of the shader
+// directory with hooks injected. A compile error names the hooks by their
+// source number in the compile log.
+func (r *Renderer) buildProgram(hooks ShaderHooks) (uint32, error) {
+	vsrc, fsrc, sources, err := r.hookedShaders(hooks)
+	if err != nil {
+		return 0, err
+	}
+	program, err := glutil.NewProgram(vsrc, fsrc)
+	if err != nil && len(sources) > 0 {
+		return 0, fmt.Errorf("%v (%v)", err, strings.Join(sources, ", "))
+	}
+	return program, err
+}
+
+// hookedShaders returns the shaders of the shader directory with hooks
+// injected, and the hooks by their source number in the compile log.
+func (r *Renderer) hookedShaders(hooks ShaderHooks) (vsrc, fsrc string, sources []string, err error) {
+	vsrc, fsrc, err = loadShaders(r.shaderDir)
+	if err != nil {
+		return "", "", nil, err
+	}
+	for _, p := range shaderHookPoints {
+		path := p.path(hooks)
+		if path == "" {
+			continue
+		}
+		hook, err := p.hookSource(path)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if p.file == vertexShaderFile {
+			vsrc, err = p.inject(vsrc, hook)
+		} else {
+			fsrc, err = p.inject(fsrc, hook)
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+		sources = append(sources, fmt.Sprintf("source %v is the %v hook %v", p.source, p.name, path))
+	}
+	return vsrc, fsrc, sources, nil
+}
+
+// SetShaderHooks rebuilds the lattice program with hooks. The program is
+// kept as it was if the hooks fail to load or compile.
+func (r *Renderer) SetShaderHooks(hooks ShaderHooks) error {
+	program, err := r.buildProgram(hooks)
+	if err != nil {
+		return err
+	}
+	gl.DeleteProgram(r.program)
+	r.setProgram(program)
+	r.hooks = hooks
+	r.Notify(MsgShaderHooks, hooks)
+	return nil
+}
+
+func registerHookCommands(c *Console) {
+	c.Register("hook", "hook [color <file>|off, displace <file> [<bound>]|off]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			r.Notify(MsgShaderHooks, r.hooks)
+			return nil
+		}
+		if len(fields) == 1 && fields[0] == "off" {
+			return r.SetShaderHooks(ShaderHooks{})
+		}
+		if len(fields) != 2 && (len(fields) != 3 || fields[0] != "displace") {
+			return r.locale.Errorf(MsgExpectedHook)
+		}
+		path := fields[1]
+		if path == "off" {
+			path = ""
+		}
+		hooks := r.hooks
+		switch fields[0] {
+		case "color":
+			hooks.Color = path
+		case "displace":
+			hooks.Displace, hooks.Bound = path, 0
+			if len(fields) == 3 {
+				bound, err := strconv.ParseFloat(fields[2], 32)
+				if err != nil || bound < 0 {
+					return r.locale.Errorf(MsgExpectedHook)
+				}
+				hooks.Bound = float32(bound)
+			}
+		default:
+			return r.locale.Errorf(MsgExpectedHook)
+		}
+		return r.SetShaderHooks(hooks)
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+// cursor accumulates the cursor movement of a window between frames.
+//
+// The cursor position can jump without the mouse moving: after the window
+// regains focus, when it changes monitor or size, or when the cursor mode
+// changes. The next position after such an event only re-anchors the
+// cursor instead of being counted as movement.
+type cursor struct {
+	// enabled is set while the window has focus or the cursor is in it.
+	// Movement is ignored otherwise.
+	enabled bool
+
+	x, y     float64
+	anchored bool
+
+	dx, dy float64
+}
+
+// reanchor makes the next position the new anchor.
+func (c *cursor) reanchor() {
+	c.anchored = false
+}
+
+// setEnabled starts or stops counting movement.
+func (c *cursor) setEnabled(enabled bool) {
+	c.enabled = enabled
+	c.reanchor()
+}
+
+// move records a new cursor position and returns the movement since the
+// previous one, zero if the cursor was re-anchored or is disabled.
+func (c *cursor) move(x, y float64) (dx, dy float64) {
+	if c.anchored && c.enabled {
+		dx, dy = x-c.x, y-c.y
+	}
+	c.x, c.y = x, y
+	c.anchored = true
+	return dx, dy
+}
+
+// add adds movement to the deltas of the frame.
+func (c *cursor) add(dx, dy float64) {
+	c.dx += dx
+	c.dy += dy
+}
+
+// deltas returns the movement accumulated since the previous call.
+func (c *cursor) deltas() (dx, dy float64) {
+	dx, dy = c.dx, c.dy
+	c.dx, c.dy = 0, 0
+	return dx, dy
+}
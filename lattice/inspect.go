@@ -0,0 +1,92 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+// pickedColor is the color of the box drawn around the picked cell.
+var pickedColor = mgl32.Vec3{1, 1, 1}
+
+// pickedMargin is how much larger than the cube the box around the picked
+// cell is, as a fraction of its edge, so that it shows around the cube.
+const pickedMargin = 0.08
+
+// PickCell picks the cell under the window position (x, y), as reported by
+// the cursor callbacks: it is highlighted and its lattice coordinates,
+// color, value and distance from the cell picked before are shown in the
+// HUD. Picking where no cell is drawn clears the pick.
+func (r *Renderer) PickCell(x, y float64) {
+	before := r.picked
+	r.picked = nil
+	id, ok := r.PickAt(x, y)
+	if !ok {
+		return
+	}
+	c, ok := r.cells.find(id)
+	if !ok {
+		return
+	}
+	if r.cellFrames == nil {
+		f, err := newCellFrameRenderer()
+		if err != nil {
+			r.NotifyError(err)
+			return
+		}
+		r.cellFrames = f
+	}
+	r.picked = &c
+	r.pickedBefore = before
+}
+
+// TogglePointing frees the cursor to point at cells to pick instead of
+// turning the camera, or captures it again.
+func (r *Renderer) TogglePointing() {
+	r.pointing = !r.pointing
+	if r.pointing {
+		r.setCursorMode(glfw.CursorNormal)
+		r.Notify(MsgPointing)
+		return
+	}
+	r.setCursorMode(glfw.CursorDisabled)
+	r.Notify(MsgPointingOff)
+}
+
+// pickedBox returns the box around the picked cell.
+func (r *Renderer) pickedBox() cellFrame {
+	c := r.picked
+	edge := r.params.CubeSize * c.scale() * (1 + pickedMargin)
+	f := cellFrame{
+		origin: r.params.World(c.pos).Sub(mgl32.Vec3{edge, edge, edge}.Mul(0.5)),
+		count:  [3]int{1, 1, 1},
+	}
+	for axis := range f.axes {
+		f.axes[axis][axis] = edge
+	}
+	return f
+}
+
+// addPicked queues the description of the picked cell, if any, in the top
+// right corner of a screen of the given width.
+func (r *Renderer) addPicked(t *hud.Text, width int, scale float32) {
+	c := r.picked
+	if c == nil {
+		return
+	}
+	pos := gridPos(*c)
+	rgb := func(v float32) int { return int(mgl32.Clamp(v, 0, 1)*255 + 0.5) }
+	text := r.locale.Sprintf(MsgPickedCell, pos[0], pos[1], pos[2], rgb(c.color[0]), rgb(c.color[1]), rgb(c.color[2]), c.value)
+	world := r.params.World(c.pos)
+	text += "\n" + r.locale.Sprintf(MsgPickedAt, r.formatPos(world))
+	if b := r.pickedBefore; b != nil {
+		text += "\n" + r.locale.Sprintf(MsgPickedDistance, r.formatLength(world.Sub(r.params.World(b.pos)).Len()))
+	}
+	w, _ := hud.Measure(text, scale)
+	// The panel pads the text by 3 on either side.
+	hud.AddPanel(t, float32(width)-w-14*scale, 8*scale, scale, text)
+}
@@ -0,0 +1,205 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Layer is a render layer: a part of the frame that is shown and captured
+// independently of the others. Layers are composited in the order of
+// their values, each drawn over those before it.
+type Layer int
+
+const (
+	// BackgroundLayer is the background color of the style. Without it the
+	// frame is cleared to black.
+	BackgroundLayer Layer = iota
+	// LatticeLayer is the cells.
+	LatticeLayer
+	// OverlayLayer is what is drawn to help read the lattice: the
+	// crystallographic plane, the cell overlay, the box around the picked
+	// cell, the collaborators and the breadcrumbs in the scene, and the
+	// picked cell, legend, compass and minimap on the HUD.
+	OverlayLayer
+	// DebugLayer is what is drawn to work the viewer: the stats panel, the
+	// notifications, the console prompt and the overdraw view.
+	DebugLayer
+	numLayers
+)
+
+var layerNames = []string{"background", "lattice", "overlays", "debug"}
+
+func (l Layer) String() string {
+	return layerNames[l]
+}
+
+// ParseLayer returns the layer with the given name.
+func ParseLayer(name string) (Layer, bool) {
+	for i, n := range layerNames {
+		if n == name {
+			return Layer(i), true
+		}
+	}
+	return BackgroundLayer, false
+}
+
+// Layers is a set of render layers.
+type Layers uint8
+
+const (
+	// AllLayers are all the layers, the layers shown by default.
+	AllLayers Layers = 1<<numLayers - 1
+	// DefaultCaptureLayers are the layers captured by default: all but the
+	// debug layer, so that recordings and screenshots show the lattice
+	// rather than the workings of the viewer.
+	DefaultCaptureLayers = AllLayers &^ (1 << DebugLayer)
+)
+
+// Has reports whether s holds l.
+func (s Layers) Has(l Layer) bool {
+	return s&(1<<l) != 0
+}
+
+// With returns s with l added, or removed if on is false.
+func (s Layers) With(l Layer, on bool) Layers {
+	if on {
+		return s | 1<<l
+	}
+	return s &^ (1 << l)
+}
+
+// String lists the names of the layers of s, separated by commas, or
+// returns "none".
+func (s Layers) String() string {
+	var names []string
+	for l := Layer(0); l < numLayers; l++ {
+		if s.Has(l) {
+			names = append(names, l.String())
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Set implements flag.Value, parsing a list of layer names separated by
+// commas.
+func (s *Layers) Set(list string) error {
+	var set Layers
+	for _, name := range strings.Split(list, ",") {
+		l, ok := ParseLayer(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown layer %q", name)
+		}
+		set = set.With(l, true)
+	}
+	*s = set
+	return nil
+}
+
+// layerState is which layers are shown in the window and which are
+// captured by screenshots and recordings.
+type layerState struct {
+	shown, captured Layers
+	// drawing are the layers of the frame being drawn.
+	drawing Layers
+}
+
+// SetLayerShown shows or hides the layer l in the window.
+func (r *Renderer) SetLayerShown(l Layer, on bool) {
+	r.layers.shown = r.layers.shown.With(l, on)
+	r.layers.drawing = r.layers.shown
+	r.Notify(MsgLayers, r.layers.shown, r.layers.captured)
+}
+
+// SetLayerCaptured sets whether screenshots and recordings capture the
+// layer l, whether or not it is shown.
+func (r *Renderer) SetLayerCaptured(l Layer, on bool) {
+	r.layers.captured = r.layers.captured.With(l, on)
+	r.Notify(MsgLayers, r.layers.shown, r.layers.captured)
+}
+
+// renderFrame draws the shown layers of a frame, and captures it for a
+// screenshot or the recording, if any, with the captured layers. Where
+// these differ the frame is drawn twice, unless only layers above all the
+// captured ones are shown besides them, which are then drawn over the
+// captured frame.
+func (r *Renderer) renderFrame() {
+	shown, captured := r.layers.shown, r.layers.captured
+	if (!r.screenshotPending && r.recorder == nil) || shown == captured {
+		r.Render()
+		r.captureFrame()
+		return
+	}
+	r.timers.frame()
+	r.runGPUSim()
+	r.renderLayers(captured, true)
+	r.captureFrame()
+	rest := shown &^ captured
+	if captured&^shown == 0 && rest.above(captured) {
+		r.renderLayers(rest, false)
+	} else {
+		r.renderLayers(shown, true)
+	}
+}
+
+// above reports whether all the layers of s are above those of t.
+func (s Layers) above(t Layers) bool {
+	seen := false
+	for l := Layer(0); l < numLayers; l++ {
+		if seen && t.Has(l) {
+			return false
+		}
+		seen = seen || s.Has(l)
+	}
+	return true
+}
+
+// captureFrame takes a pending screenshot and records the frame, if
+// recording.
+func (r *Renderer) captureFrame() {
+	// A transparent screenshot draws the frame anew.
+	r.layers.drawing = r.layers.captured
+	defer func() { r.layers.drawing = r.layers.shown }()
+	if r.screenshotPending {
+		r.screenshotPending = false
+		if path, err := r.Screenshot(r.screenshotTransparent); err != nil {
+			r.NotifyError(r.locale.Errorf(MsgScreenshotFailed, err))
+		} else {
+			r.Notify(MsgScreenshot, path)
+		}
+	}
+	r.recordFrame()
+}
+
+func registerLayerCommands(c *Console) {
+	c.Register("layer", "layer [<layer> on|off|capture on|off]", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			r.Notify(MsgLayers, r.layers.shown, r.layers.captured)
+			return nil
+		}
+		l, ok := ParseLayer(fields[0])
+		if !ok {
+			return r.locale.Errorf(MsgExpectedLayer)
+		}
+		capture := len(fields) == 3 && fields[1] == "capture"
+		if capture {
+			fields = fields[1:]
+		}
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			return r.locale.Errorf(MsgExpectedLayer)
+		}
+		if capture {
+			r.SetLayerCaptured(l, fields[1] == "on")
+		} else {
+			r.SetLayerShown(l, fields[1] == "on")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+// DataKind is whether the scalars a colormap colors by measure a
+// continuous quantity or label categories.
+type DataKind int
+
+const (
+	// AutoKind takes a field of a few whole numbers, such as phases or
+	// cluster labels, for categories and anything else for a quantity.
+	AutoKind DataKind = iota
+	// ContinuousKind spreads the scalars over the colormap, with a
+	// colorbar for a legend.
+	ContinuousKind
+	// CategoricalKind gives every distinct scalar a color of its own from
+	// a discrete palette, with a legend listing them.
+	CategoricalKind
+)
+
+var dataKindNames = []string{"auto", "continuous", "categorical"}
+
+func (k DataKind) String() string {
+	return dataKindNames[k]
+}
+
+// Set implements flag.Value.
+func (k *DataKind) Set(name string) error {
+	kind, ok := ParseDataKind(name)
+	if !ok {
+		return fmt.Errorf("unknown data kind %q", name)
+	}
+	*k = kind
+	return nil
+}
+
+// ParseDataKind returns the data kind with the given name.
+func ParseDataKind(name string) (DataKind, bool) {
+	for i, n := range dataKindNames {
+		if n == name {
+			return DataKind(i), true
+		}
+	}
+	return AutoKind, false
+}
+
+// categoryPalette are the colors of categories, those of matplotlib's
+// tab10, repeated for more categories than colors.
+var categoryPalette = []uint32{0x1f77b4, 0xff7f0e, 0x2ca02c, 0xd62728, 0x9467bd, 0x8c564b, 0xe377c2, 0x7f7f7f, 0xbcbd22, 0x17becf}
+
+// maxCategories is the most distinct scalars AutoKind takes for
+// categories, as many as the palette has colors.
+var maxCategories = len(categoryPalette)
+
+// categoryColor returns the color of category i.
+func categoryColor(i int) mgl32.Vec3 {
+	return hexColor(categoryPalette[i%len(categoryPalette)])
+}
+
+// isCategorical reports whether values look like category labels: whole
+// numbers with at most maxCategories distinct ones.
+func isCategorical(values []float32) bool {
+	if len(values) == 0 {
+		return false
+	}
+	distinct := make(map[float32]bool)
+	for _, v := range values {
+		if v != float32(math.Trunc(float64(v))) {
+			return false
+		}
+		if distinct[v] = true; len(distinct) > maxCategories {
+			return false
+		}
+	}
+	return true
+}
+
+// colorLegend describes how the colormap colors the cells, for the legend
+// drawn in the HUD.
+type colorLegend struct {
+	field string
+	// colormap is the colormap of continuous data and lo and hi the
+	// scalars at its ends.
+	colormap Colormap
+	lo, hi   float32
+	// categories are the distinct scalars of categorical data in
+	// ascending order, category i colored by categoryColor(i).
+	categories []float32
+}
+
+// Legend layout, in unscaled pixels: the size of the colorbar, the number
+// of steps it is drawn in and the most categories listed.
+const (
+	colorbarWidth  = 160
+	colorbarHeight = 10
+	colorbarSteps  = 64
+	maxLegendRows  = 12
+)
+
+var (
+	legendColor = mgl32.Vec4{1, 1, 1, 1}
+	legendBack  = mgl32.Vec4{0, 0, 0, 0.6}
+)
+
+// addLegend queues the legend of the colormap, if it is on, in the bottom
+// right corner of a screen of the given size: a colorbar for continuous
+// data, or the colors of the categories.
+func (r *Renderer) addLegend(t *hud.Text, width, height int, scale float32) {
+	l := r.legend
+	if l == nil {
+		return
+	}
+	pad, line := 3*scale, hud.LineHeight*scale
+	w, _ := hud.Measure(l.field, scale)
+	var h float32
+	var rows []string
+	if l.categories == nil {
+		w = float32(math.Max(float64(w), colorbarWidth*float64(scale)))
+		h = 2*line + colorbarHeight*scale
+	} else {
+		for i, v := range l.categories {
+			if i == maxLegendRows-1 && len(l.categories) > maxLegendRows {
+				rows = append(rows, r.locale.Sprintf(MsgMoreCategories, len(l.categories)-i))
+				break
+			}
+			rows = append(rows, fmt.Sprintf("%g", v))
+		}
+		for _, row := range rows {
+			rw, _ := hud.Measure(row, scale)
+			w = float32(math.Max(float64(w), float64(rw+line)))
+		}
+		h = line * float32(1+len(rows))
+	}
+	x := float32(width) - w - 2*pad - 8*scale
+	y := float32(height) - h - 2*pad - 8*scale
+	t.AddRect(x, y, w+2*pad, h+2*pad, legendBack)
+	x, y = x+pad, y+pad
+	t.AddText(x, y, scale, legendColor, l.field)
+	y += line
+
+	if l.categories == nil {
+		step := w / colorbarSteps
+		for i := 0; i < colorbarSteps; i++ {
+			c := l.colormap.at((float32(i) + 0.5) / colorbarSteps)
+			t.AddRect(x+float32(i)*step, y, step+0.5, colorbarHeight*scale, c.Vec4(1))
+		}
+		y += colorbarHeight * scale
+		lo, hi := fmt.Sprintf("%.3g", l.lo), fmt.Sprintf("%.3g", l.hi)
+		hw, _ := hud.Measure(hi, scale)
+		t.AddText(x, y, scale, legendColor, lo)
+		t.AddText(x+w-hw, y, scale, legendColor, hi)
+		return
+	}
+	swatch := line * 0.6
+	for i, row := range rows {
+		// The last row counts the categories left out, if any.
+		if len(rows) == len(l.categories) || i < len(rows)-1 {
+			t.AddRect(x, y+(line-swatch)/2, swatch, swatch, categoryColor(i).Vec4(1))
+		}
+		t.AddText(x+line, y, scale, legendColor, row)
+		y += line
+	}
+}
+
+// SetDataKind colors the cells as data of kind k: continuous or
+// categorical, or whichever the scalars look like.
+func (r *Renderer) SetDataKind(k DataKind) {
+	r.coloring.kind = k
+	if r.coloring.colormap != NoColormap {
+		r.UploadMesh()
+	}
+	r.Notify(MsgDataKind, k)
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// LightParams describes the static lighting baked into a lightmap.
+type LightParams struct {
+	// Sun points towards the sun.
+	Sun          mgl32.Vec3
+	SunIntensity float32
+	// SkyIntensity is the light received by a cell open to the whole
+	// upper hemisphere, estimated with SkySamples rays.
+	SkyIntensity float32
+	SkySamples   int
+	// Bounce scales the light reflected once by neighboring cells, tinted
+	// by their color.
+	Bounce float32
+}
+
+// DefaultLightParams returns a high sun and a dim sky.
+func DefaultLightParams() LightParams {
+	return LightParams{
+		Sun:          mgl32.Vec3{0.4, 1, 0.3}.Normalize(),
+		SunIntensity: 0.6,
+		SkyIntensity: 0.4,
+		SkySamples:   16,
+		Bounce:       0.3,
+	}
+}
+
+// lightmapMagic starts lightmap files.
+const lightmapMagic = "LMAP"
+
+// bakeLight computes the light received by every cell of the lattice from
+// the sun, the sky and one bounce off neighboring cells. Occupied cells
+// block light. The result holds three bytes per cell, in the texel order
+// of a Side^3 texture whose x axis varies fastest.
+func bakeLight(instances []float32, p LatticeParams, lp LightParams) []uint8 {
+	n := p.Side()
+	d := p.HalfSize
+	occupied := make([]bool, n*n*n)
+	albedo := make([]mgl32.Vec3, n*n*n)
+	index := func(x, y, z int) (int, bool) {
+		x, y, z = x+d, y+d, z+d
+		if x < 0 || y < 0 || z < 0 || x >= n || y >= n || z >= n {
+			return 0, false
+		}
+		return (z*n+y)*n + x, true
+	}
+	for i := 0; i+floatsPerInstance <= len(instances); i += floatsPerInstance {
+		v := instances[i : i+floatsPerInstance]
+		if j, ok := index(roundInt(v[0]), roundInt(v[1]), roundInt(v[2])); ok {
+			occupied[j] = true
+			albedo[j] = mgl32.Vec3{v[3], v[4], v[5]}
+		}
+	}
+
+	// visible marches from the cell at pos along dir until it leaves the
+	// lattice, reporting whether no other cell is in the way.
+	visible := func(pos, dir mgl32.Vec3) bool {
+		for t := float32(1); t < float32(2*n); t += 0.5 {
+			q := pos.Add(dir.Mul(t))
+			j, ok := index(roundInt(q[0]), roundInt(q[1]), roundInt(q[2]))
+			if !ok {
+				return true
+			}
+			if occupied[j] {
+				return false
+			}
+		}
+		return true
+	}
+
+	sky := hemisphere(lp.SkySamples)
+	direct := make([]float32, n*n*n)
+	forEachSlice(n, func(z int) {
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				pos := mgl32.Vec3{float32(x - d), float32(y - d), float32(z - d)}
+				light := float32(0)
+				if visible(pos, lp.Sun) {
+					light += lp.SunIntensity
+				}
+				open := 0
+				for _, dir := range sky {
+					if visible(pos, dir) {
+						open++
+					}
+				}
+				if len(sky) > 0 {
+					light += lp.SkyIntensity * float32(open) / float32(len(sky))
+				}
+				direct[(z*n+y)*n+x] = light
+			}
+		}
+	})
+
+	neighbors := [6][3]int{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}}
+	out := make([]uint8, 3*n*n*n)
+	forEachSlice(n, func(z int) {
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				i := (z*n+y)*n + x
+				light := mgl32.Vec3{direct[i], direct[i], direct[i]}
+				var bounce mgl32.Vec3
+				for _, o := range neighbors {
+					j, ok := index(x-d+o[0], y-d+o[1], z-d+o[2])
+					if ok && occupied[j] {
+						bounce = bounce.Add(albedo[j].Mul(direct[j]))
+					}
+				}
+				light = light.Add(bounce.Mul(lp.Bounce / float32(len(neighbors))))
+				for c := 0; c < 3; c++ {
+					out[3*i+c] = unitByte(light[c])
+				}
+			}
+		}
+	})
+	return out
+}
+
+// hemisphere returns n directions spread evenly over the upper hemisphere.
+func hemisphere(n int) []mgl32.Vec3 {
+	dirs := make([]mgl32.Vec3, n)
+	golden := math.Pi * (3 - math.Sqrt(5))
+	for i := range dirs {
+		y := 1 - (float64(i)+0.5)/float64(n)
+		r := math.Sqrt(1 - y*y)
+		phi := golden * float64(i)
+		dirs[i] = mgl32.Vec3{float32(r * math.Cos(phi)), float32(y), float32(r * math.Sin(phi))}
+	}
+	return dirs
+}
+
+// forEachSlice calls f for every z in [0, n), spread over the CPUs.
+func forEachSlice(n int, f func(z int)) {
+	var wg sync.WaitGroup
+	next := make(chan int, n)
+	for z := 0; z < n; z++ {
+		next <- z
+	}
+	close(next)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for z := range next {
+				f(z)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func roundInt(v float32) int {
+	return int(math.Round(float64(v)))
+}
+
+// Lightmap is baked lighting stored in a 3D texture with one texel per
+// lattice cell.
+type Lightmap struct {
+	tex  uint32
+	side int
+	data []uint8
+}
+
+// NewLightmap uploads baked light for a lattice with side cells along each
+// axis.
+func NewLightmap(data []uint8, side int) *Lightmap {
+	l := &Lightmap{side: side, data: data}
+	gl.GenTextures(1, &l.tex)
+	gl.BindTexture(gl.TEXTURE_3D, l.tex)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage3D(gl.TEXTURE_3D, 0, gl.RGB8, int32(side), int32(side), int32(side), 0, gl.RGB, gl.UNSIGNED_BYTE, gl.Ptr(data))
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_3D, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_3D, 0)
+	return l
+}
+
+// Save writes the baked light to path.
+func (l *Lightmap) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(lightmapMagic)
+	binary.Write(w, binary.LittleEndian, uint32(l.side))
+	w.Write(l.data)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadLightmapData reads baked light written by Lightmap.Save.
+func loadLightmapData(path string) ([]uint8, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(lightmapMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != lightmapMagic {
+		return nil, 0, fmt.Errorf("%v: not a lightmap", path)
+	}
+	var side uint32
+	if err := binary.Read(r, binary.LittleEndian, &side); err != nil {
+		return nil, 0, fmt.Errorf("%v: %v", path, err)
+	}
+	if side == 0 || side > 1024 {
+		return nil, 0, fmt.Errorf("%v: bad size %v", path, side)
+	}
+	data := make([]uint8, 3*int(side)*int(side)*int(side))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errors.New("truncated")
+		}
+		return nil, 0, fmt.Errorf("%v: %v", path, err)
+	}
+	return data, int(side), nil
+}
+
+// Delete releases the texture of the lightmap.
+func (l *Lightmap) Delete() {
+	gl.DeleteTextures(1, &l.tex)
+}
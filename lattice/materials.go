@@ -0,0 +1,159 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const (
+	// materialTableWidth is the width of the material table, which wraps
+	// the cell IDs into rows to stay within the texture size limits.
+	materialTableWidth = 4096
+	// cellMaterialUnit is the texture unit of the material table in the
+	// lattice program.
+	cellMaterialUnit = 5
+)
+
+// materialRule gives the cells it matches the cell texture layer.
+type materialRule struct {
+	layer int
+	// ids, if not nil, are the cells matched. Otherwise value matches the
+	// cells of that value, such as the atomic number of the element of an
+	// imported atom.
+	ids   Selection
+	value float32
+}
+
+func (m materialRule) matches(c cell) bool {
+	if m.ids != nil {
+		return m.ids.Contains(c.id)
+	}
+	return c.value == m.value
+}
+
+// cellMaterials assign the lattice sites their cell textures by rules, so
+// that sites of different kinds, such as the atoms of different elements,
+// look different. The material of each cell is looked up by its ID in a
+// table of texture layers. Cells no rule matches take the textures in
+// turn.
+type cellMaterials struct {
+	// rules are applied in order, later ones overriding earlier ones.
+	rules []materialRule
+	// table holds the layer of each cell ID plus 1, 0 for none.
+	table uint32
+	// dirty is set when the table no longer matches the cells.
+	dirty bool
+}
+
+// update fills the table with the materials of cells, with IDs up to n.
+func (m *cellMaterials) update(cells []cell, n int) {
+	m.dirty = false
+	rows := (n + materialTableWidth - 1) / materialTableWidth
+	data := make([]uint8, rows*materialTableWidth)
+	for _, c := range cells {
+		if int(c.id) >= len(data) {
+			continue
+		}
+		for _, rule := range m.rules {
+			if rule.matches(c) {
+				data[c.id] = uint8(rule.layer + 1)
+			}
+		}
+	}
+	if m.table == 0 {
+		gl.GenTextures(1, &m.table)
+		gl.BindTexture(gl.TEXTURE_2D, m.table)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	} else {
+		gl.BindTexture(gl.TEXTURE_2D, m.table)
+	}
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R8UI, materialTableWidth, int32(rows), 0, gl.RED_INTEGER, gl.UNSIGNED_BYTE, gl.Ptr(data))
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// Delete releases the table.
+func (m *cellMaterials) Delete() {
+	if m.table != 0 {
+		gl.DeleteTextures(1, &m.table)
+		m.table = 0
+	}
+}
+
+// bindMaterials binds the material table, brought up to date with the
+// cells, if there are any rules.
+func (r *Renderer) bindMaterials() {
+	gl.Uniform1i(r.textureUniforms.materials, int32(len(r.materials.rules)))
+	if len(r.materials.rules) == 0 {
+		return
+	}
+	if r.materials.dirty || r.materials.table == 0 {
+		n := r.params.Side()
+		r.materials.update(r.cells.unpackRefined(), n*n*n+1)
+	}
+	gl.ActiveTexture(gl.TEXTURE0 + cellMaterialUnit)
+	gl.BindTexture(gl.TEXTURE_2D, r.materials.table)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// AddMaterial gives the cells rule matches the cell texture layer of the
+// rule, over any material they had.
+func (r *Renderer) AddMaterial(rule materialRule) {
+	r.materials.rules = append(r.materials.rules, rule)
+	r.materials.dirty = true
+	r.Notify(MsgMaterials, len(r.materials.rules))
+}
+
+// ClearMaterials removes the materials, leaving the sites to take the cell
+// textures in turn.
+func (r *Renderer) ClearMaterials() {
+	r.materials.rules = nil
+	r.materials.Delete()
+	r.Notify(MsgMaterialsOff)
+}
+
+func registerMaterialCommands(c *Console) {
+	c.Register("material", "material <layer> element <symbol>|value <value>|selection, material off", func(r *Renderer, args string) error {
+		fields := strings.Fields(args)
+		if len(fields) == 1 && fields[0] == "off" {
+			r.ClearMaterials()
+			return nil
+		}
+		if len(fields) < 2 {
+			return r.locale.Errorf(MsgExpectedMaterial)
+		}
+		layer, err := strconv.Atoi(fields[0])
+		if err != nil || layer < 0 || layer >= maxCellTextures {
+			return r.locale.Errorf(MsgExpectedMaterial)
+		}
+		rule := materialRule{layer: layer}
+		switch {
+		case fields[1] == "selection" && len(fields) == 2:
+			rule.ids = NewSelection(r.selection.IDs()...)
+		case fields[1] == "element" && len(fields) == 3:
+			z, _ := elementInfo(elementSymbol(fields[2]))
+			if z == 0 {
+				return r.locale.Errorf(MsgUnknownElement, fields[2])
+			}
+			rule.value = float32(z)
+		case fields[1] == "value" && len(fields) == 3:
+			v, err := strconv.ParseFloat(fields[2], 32)
+			if err != nil {
+				return r.locale.Errorf(MsgExpectedMaterial)
+			}
+			rule.value = float32(v)
+		default:
+			return r.locale.Errorf(MsgExpectedMaterial)
+		}
+		r.AddMaterial(rule)
+		return nil
+	})
+}
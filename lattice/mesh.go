@@ -0,0 +1,997 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// LatticeParams describes the lattice generated by generateStore.
+type LatticeParams struct {
+	// Type selects the arrangement of the cells within the grid.
+	Type LatticeType
+	// HalfSize is the number of grid positions on each side of the origin,
+	// so the lattice spans 2*HalfSize+1 positions along every axis.
+	HalfSize int
+	// CubeSize is the edge length of a single cube.
+	CubeSize float32
+	// Scale is the world distance between neighboring grid positions, 0
+	// for 1. Imported structures place their atoms on a grid finer than
+	// the cubes.
+	Scale float32
+	// ColorPeriod is the time in seconds it takes the colors of a generated
+	// lattice to cycle once, 0 for colors that stand still. The cycle is
+	// applied in the shader every frame, so the cells are not generated
+	// again.
+	ColorPeriod float64
+
+	// crystal is the supercell of an imported crystal structure, whose
+	// unit cell is not that of Type, or has no cells if there is none.
+	crystal cellFrame
+}
+
+func DefaultLatticeParams() LatticeParams {
+	return LatticeParams{
+		HalfSize:    30,
+		CubeSize:    1,
+		ColorPeriod: 20,
+	}
+}
+
+// MaxHalfSize is the largest HalfSize of a lattice. Cell IDs number the
+// grid positions from 1 in 32 bits, as the picking buffer holds them, so a
+// lattice has fewer than math.MaxUint32 positions.
+const MaxHalfSize = 812
+
+// checkSize returns an error in the language of l if p has too many grid
+// positions for the cells to be told apart by their IDs.
+func (p LatticeParams) checkSize(l Locale) error {
+	if p.HalfSize > MaxHalfSize {
+		return l.Errorf(MsgLatticeTooLarge, p.Side(), 2*MaxHalfSize+1)
+	}
+	return nil
+}
+
+// Side returns the number of cells along one axis.
+func (p LatticeParams) Side() int {
+	return 2*p.HalfSize + 1
+}
+
+// CellCount returns the total number of cells in the lattice.
+func (p LatticeParams) CellCount() int {
+	n := p.Side()
+	return n * n * n
+}
+
+// CellPos returns the lattice coordinates of the cell with the given ID.
+func (p LatticeParams) CellPos(id uint32) (x, y, z int, ok bool) {
+	if id == noCell || int(id) > p.CellCount() {
+		return 0, 0, 0, false
+	}
+	n := p.Side()
+	i := int(id - 1)
+	return i/(n*n) - p.HalfSize, i/n%n - p.HalfSize, i%n - p.HalfSize, true
+}
+
+// CellID returns the ID of the cell at the given lattice coordinates.
+func (p LatticeParams) CellID(x, y, z int) (uint32, bool) {
+	d := p.HalfSize
+	if x < -d || x > d || y < -d || y > d || z < -d || z > d {
+		return noCell, false
+	}
+	n := p.Side()
+	return uint32(((x+d)*n+(y+d))*n+(z+d)) + 1, true
+}
+
+const (
+	// floatsPerVertex covers the position, shift direction, face normal,
+	// corner index and texture coordinates of a vertex of the shared cube.
+	// Every face has its own four vertices so that each carries the normal
+	// and texture coordinates of its face.
+	floatsPerVertex = 12
+	vertsPerFace    = 4
+	vertsPerCube    = 6 * vertsPerFace
+	cornersPerCube  = 8
+	indicesPerCube  = 36
+	// floatsPerInstance covers the offset, color, ID, corner occlusion
+	// and size of a cell. The ID and the occlusion are stored as the bits
+	// of a float.
+	floatsPerInstance = 9
+)
+
+// cubeCorners lists the corner signs of the 12 triangles of a cube, two
+// faces at a time. The shift direction of every vertex points back towards
+// the cube center.
+var cubeCorners = [indicesPerCube][3]float32{
+	// Top
+	{-1, +1, -1}, {+1, +1, +1}, {+1, +1, -1},
+	{-1, +1, -1}, {+1, +1, +1}, {-1, +1, +1},
+
+	// Bottom
+	{-1, -1, -1}, {+1, -1, +1}, {+1, -1, -1},
+	{-1, -1, -1}, {+1, -1, +1}, {-1, -1, +1},
+
+	// Front
+	{-1, +1, +1}, {+1, +1, +1}, {+1, -1, +1},
+	{-1, +1, +1}, {-1, -1, +1}, {+1, -1, +1},
+
+	// Back
+	{-1, +1, -1}, {+1, +1, -1}, {+1, -1, -1},
+	{-1, +1, -1}, {-1, -1, -1}, {+1, -1, -1},
+
+	// Left
+	{-1, +1, -1}, {-1, +1, +1}, {-1, -1, +1},
+	{-1, +1, -1}, {-1, -1, +1}, {-1, -1, -1},
+
+	// Right
+	{+1, +1, -1}, {+1, +1, +1}, {+1, -1, +1},
+	{+1, +1, -1}, {+1, -1, +1}, {+1, -1, -1},
+}
+
+type cell struct {
+	// id identifies the cell in the picking buffer. IDs start at 1 so
+	// that 0 can mark the background.
+	id    uint32
+	pos   mgl32.Vec3
+	color mgl32.Vec3
+	// value is the scalar attached to the cell. Generated lattices use the
+	// distance from the origin, normalized to [0, 1].
+	value float32
+	// size scales the cube of the cell, in (0, 1]. Zero stands for 1, so
+	// that only cells drawn smaller need to set it.
+	size float32
+}
+
+// scale returns the factor the cube of c is scaled by.
+func (c cell) scale() float32 {
+	if c.size <= 0 || c.size > 1 {
+		return 1
+	}
+	return c.size
+}
+
+// cellGenerator colors the lattice cells by position, in a gradient whose
+// phase colorPhase shifts over time.
+type cellGenerator struct {
+	p       LatticeParams
+	dd      float32
+	maxDist float32
+}
+
+func newCellGenerator(p LatticeParams) cellGenerator {
+	g := cellGenerator{p: p, dd: 1 / float32(p.Side())}
+	g.maxDist = p.radius() - float32(math.Sqrt(3))*p.CubeSize/2
+	if g.maxDist <= 0 {
+		g.maxDist = 1
+	}
+	return g
+}
+
+// at returns the cell at the given lattice coordinates, which must be a
+// site of the lattice type.
+func (g cellGenerator) at(x, y, z int) cell {
+	id, _ := g.p.CellID(x, y, z)
+	pos := mgl32.Vec3{float32(x), float32(y), float32(z)}
+	return cell{
+		id:    id,
+		pos:   pos,
+		color: g.color(x, y, z),
+		value: g.p.World(pos).Len() / g.maxDist,
+	}
+}
+
+// color returns the gradient color of the cell at the given lattice
+// coordinates.
+func (g cellGenerator) color(x, y, z int) mgl32.Vec3 {
+	d := g.p.HalfSize
+	return mgl32.Vec3{
+		fract(g.dd * float32(x+d)),
+		fract(g.dd * float32(y+d)),
+		fract(g.dd * float32(z+d)),
+	}
+}
+
+// cycles reports whether c, as displayed, shows the gradient color of its
+// position, which colorPhase cycles. Cells colored otherwise, by a group,
+// an edit or a colormap, keep their colors.
+func (g cellGenerator) cycles(c cell) bool {
+	p := gridPos(c)
+	if (mgl32.Vec3{float32(p[0]), float32(p[1]), float32(p[2])}) != c.pos {
+		return false
+	}
+	return c.color == g.color(p[0], p[1], p[2])
+}
+
+// cornerIndex returns the index of the given corner of a cube, the order of
+// the corner occlusion levels.
+func cornerIndex(corner [3]float32) uint16 {
+	i := uint16(0)
+	for axis, sign := range corner {
+		if sign > 0 {
+			i |= 1 << axis
+		}
+	}
+	return i
+}
+
+// makeCube returns the vertices of a single cube of edge w centered on the
+// origin and the indices of its triangles. Every cell is drawn as an
+// instance of it.
+func makeCube(w float32) ([]float32, []uint16) {
+	verts := make([]float32, vertsPerCube*floatsPerVertex)
+	indices := make([]uint16, 0, indicesPerCube)
+	const indicesPerFace = indicesPerCube / 6
+	for face := 0; face < 6; face++ {
+		corners := cubeCorners[face*indicesPerFace : (face+1)*indicesPerFace]
+		normal := faceNormal(corners)
+		var slots []uint16
+		for _, corner := range corners {
+			ci := cornerIndex(corner)
+			slot := -1
+			for j, c := range slots {
+				if c == ci {
+					slot = j
+				}
+			}
+			if slot < 0 {
+				slot = len(slots)
+				slots = append(slots, ci)
+			}
+			i := face*vertsPerFace + slot
+			indices = append(indices, uint16(i))
+			v := verts[i*floatsPerVertex : (i+1)*floatsPerVertex]
+			v[0], v[1], v[2] = corner[0]*w/2, corner[1]*w/2, corner[2]*w/2
+			v[3], v[4], v[5] = -corner[0], -corner[1], -corner[2]
+			v[6], v[7], v[8] = normal[0], normal[1], normal[2]
+			v[9] = float32(ci)
+			v[10], v[11] = faceUV(corner, normal)
+		}
+	}
+	return verts, indices
+}
+
+// faceUV returns the texture coordinates of the cube corner on the face
+// with the given normal, the image upright on the sides and unmirrored
+// seen from outside.
+func faceUV(corner [3]float32, normal mgl32.Vec3) (float32, float32) {
+	var u, v float32
+	switch {
+	case normal[0] != 0:
+		u, v = -normal[0]*corner[2], corner[1]
+	case normal[1] != 0:
+		u, v = corner[0], -normal[1]*corner[2]
+	default:
+		u, v = normal[2]*corner[0], corner[1]
+	}
+	return (u + 1) / 2, (v + 1) / 2
+}
+
+// faceNormal returns the outward normal of the face made of corners, the
+// axis along which they all have the same sign.
+func faceNormal(corners [][3]float32) mgl32.Vec3 {
+	var n mgl32.Vec3
+	for axis := range n {
+		same := true
+		for _, c := range corners {
+			same = same && c[axis] == corners[0][axis]
+		}
+		if same {
+			n[axis] = corners[0][axis]
+		}
+	}
+	return n
+}
+
+// writeInstance writes the per-instance data of c, at the world position
+// pos, with the corner occlusion ao, into dst[:floatsPerInstance].
+func writeInstance(dst []float32, c cell, pos mgl32.Vec3, ao uint32) {
+	dst = dst[:floatsPerInstance]
+	dst[0], dst[1], dst[2] = pos[0], pos[1], pos[2]
+	dst[3], dst[4], dst[5] = c.color[0], c.color[1], c.color[2]
+	dst[6] = math.Float32frombits(c.id)
+	dst[7] = math.Float32frombits(ao)
+	dst[8] = c.scale()
+}
+
+// aoLevels is the number of occlusion levels of a corner, stored in
+// aoBits bits each. At the darkest level a corner is darkened by
+// aoStrength.
+const (
+	aoLevels   = 4
+	aoBits     = 2
+	aoStrength = 0.6
+)
+
+// cycleBit, above the corner occlusion levels in the occlusion word of an
+// instance, marks the cells showing the gradient of a generated lattice,
+// the only colors colorPhase shifts.
+const cycleBit = 1 << (cornersPerCube * aoBits)
+
+// cornerShade returns the brightness of the given corner for the packed
+// occlusion levels ao.
+func cornerShade(ao uint32, corner int) float32 {
+	level := ao >> (uint(corner) * aoBits) & (1<<aoBits - 1)
+	return 1 - aoStrength*float32(level)/(aoLevels-1)
+}
+
+// diffuseStrength is the part of a face's brightness that depends on the
+// angle to the sun, the rest is ambient light.
+const diffuseStrength = 0.5
+
+// faceShade returns the Lambert brightness of a face with the given
+// normal, lit by a sun in direction sun.
+func faceShade(normal, sun mgl32.Vec3) float32 {
+	return 1 - diffuseStrength + diffuseStrength*mgl32.Clamp(normal.Dot(sun), 0, 1)
+}
+
+// occupancy is the set of lattice positions holding a visible cell.
+type occupancy map[[3]int]bool
+
+func (o occupancy) add(c cell) {
+	o[gridPos(c)] = true
+}
+
+func gridPos(c cell) [3]int {
+	var p [3]int
+	for i := range p {
+		p[i] = int(math.Round(float64(c.pos[i])))
+	}
+	return p
+}
+
+// cornerOcclusion returns the occlusion levels of the corners of c, aoBits
+// per corner in the order of cornerIndex. A corner is darker the more of
+// the seven cells sharing it are occupied.
+func (o occupancy) cornerOcclusion(c cell) uint32 {
+	p := gridPos(c)
+	var ao uint32
+	for corner := uint32(0); corner < cornersPerCube; corner++ {
+		var d [3]int
+		for axis := range d {
+			d[axis] = -1
+			if corner&(1<<axis) != 0 {
+				d[axis] = 1
+			}
+		}
+		n := 0
+		for i := 1; i < 8; i++ {
+			q := p
+			for axis := range q {
+				if i&(1<<axis) != 0 {
+					q[axis] += d[axis]
+				}
+			}
+			if o[q] {
+				n++
+			}
+		}
+		level := uint32((n*(aoLevels-1) + 3) / 7)
+		ao |= level << (corner * aoBits)
+	}
+	return ao
+}
+
+// chunkSize is the edge, in cells, of the chunks that are culled as one.
+const chunkSize = 8
+
+// meshRange is a contiguous run of instances sharing one animation and
+// one chunk.
+type meshRange struct {
+	first, count int32
+	anim         Animation
+	// min and max bound the cubes of the range.
+	min, max mgl32.Vec3
+}
+
+type chunkKey [3]int
+
+func chunkOf(c cell) chunkKey {
+	var k chunkKey
+	for i := range k {
+		k[i] = int(math.Floor(float64(c.pos[i]) / chunkSize))
+	}
+	return k
+}
+
+func (k chunkKey) less(o chunkKey) bool {
+	for i := range k {
+		if k[i] != o[i] {
+			return k[i] < o[i]
+		}
+	}
+	return false
+}
+
+// drawCall is a meshRange resolved for the current frame.
+type drawCall struct {
+	first, count int32
+	shift        float32
+	// center is the center of the bounds of the range, by which
+	// transparent draws are sorted.
+	center mgl32.Vec3
+}
+
+// makeInstances writes the instance data of all visible cells, drawn as
+// cubes of edge w and changed by look if not nil, into a buffer of exactly
+// the required size. Cells are ordered by chunk and then by the group
+// displaying them, ungrouped cells first, so that every chunk of a group
+// occupies one range.
+func makeInstances(cells *cellStore, groups *Groups, w float32, look cellLook) ([]float32, []meshRange) {
+	l := newChunkLayout(cells, w, look)
+	l.remesh(groups, l.all())
+	return l.compact()
+}
+
+// chunkSet is a set of chunks to re-mesh.
+type chunkSet map[chunkKey]bool
+
+// meshChunk is the part of the instance buffer holding one chunk. It has
+// room for every cell of the chunk, hidden or not, so re-meshing a chunk
+// never moves the others.
+type meshChunk struct {
+	size   int
+	first  int32
+	ranges []meshRange
+}
+
+func (ch *meshChunk) visible() int {
+	n := 0
+	for _, r := range ch.ranges {
+		n += int(r.count)
+	}
+	return n
+}
+
+// cellLook returns a cell as it is drawn, changed by a display setting
+// rather than by an edit of the cell.
+type cellLook func(c cell) cell
+
+// chainLooks returns the look applying a and then b, either of which may
+// be nil.
+func chainLooks(a, b cellLook) cellLook {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return func(c cell) cell { return b(a(c)) }
+}
+
+// chunkLayout keeps the instance data of a lattice by chunk, so that edits
+// only re-mesh the chunks they touch.
+type chunkLayout struct {
+	w    float32
+	look cellLook
+	// spare is the room for more cells every chunk has beyond its own.
+	spare    int
+	cells    *cellStore
+	keys     []chunkKey
+	chunks   map[chunkKey]*meshChunk
+	data     []float32
+	occupied occupancy
+	// cubes is the number of visible instances.
+	cubes int
+}
+
+// newChunkLayout places the chunks of cells one after the other, to be
+// drawn as cubes of edge w and changed by look if not nil. The data is
+// empty until the chunks are re-meshed.
+func newChunkLayout(cells *cellStore, w float32, look cellLook) *chunkLayout {
+	return newSpareLayout(cells, w, look, 0)
+}
+
+// newSpareLayout is newChunkLayout with room for spare more cells in every
+// chunk, up to a full chunk, so that cells can be added without moving the
+// other chunks.
+func newSpareLayout(cells *cellStore, w float32, look cellLook, spare int) *chunkLayout {
+	l := &chunkLayout{
+		w:        w,
+		look:     look,
+		spare:    spare,
+		cells:    cells,
+		keys:     cells.keys,
+		chunks:   make(map[chunkKey]*meshChunk, len(cells.keys)),
+		occupied: make(occupancy),
+	}
+	n := 0
+	for _, k := range l.keys {
+		size := cells.chunkCount(k) + spare
+		if size > cellsPerChunk {
+			size = cellsPerChunk
+		}
+		size += cells.extra[k]
+		l.chunks[k] = &meshChunk{size: size, first: int32(n)}
+		n += size
+	}
+	l.data = make([]float32, n*floatsPerInstance)
+	return l
+}
+
+func (l *chunkLayout) all() chunkSet {
+	set := make(chunkSet, len(l.keys))
+	for _, k := range l.keys {
+		set[k] = true
+	}
+	return set
+}
+
+// dirty returns the chunks holding the cells ids and the chunks next to
+// them, whose corner occlusion depends on those cells.
+func (l *chunkLayout) dirty(ids Selection) chunkSet {
+	set := make(chunkSet)
+	for id := range ids {
+		if k, ok := l.cells.chunkOfID(id); ok {
+			set.addAround(k)
+		}
+	}
+	return set
+}
+
+// addAround adds chunk k and the chunks next to it to set.
+func (set chunkSet) addAround(k chunkKey) {
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dz := -1; dz <= 1; dz++ {
+				set[chunkKey{k[0] + dx, k[1] + dy, k[2] + dz}] = true
+			}
+		}
+	}
+}
+
+// update returns the layout of cells, which are in the lattice of the
+// cells of l and differ from them only in the chunks changed. Those and
+// the chunks next to them are re-meshed, the instances of the others are
+// taken over from l, which must not be used afterwards.
+func (l *chunkLayout) update(cells *cellStore, groups *Groups, changed chunkSet) *chunkLayout {
+	n := newSpareLayout(cells, l.w, l.look, l.spare)
+	n.occupied = l.occupied
+	dirty := make(chunkSet)
+	for k := range changed {
+		for _, c := range l.cells.chunk(k) {
+			delete(n.occupied, gridPos(c))
+		}
+		dirty.addAround(k)
+	}
+	for _, k := range n.keys {
+		old := l.chunks[k]
+		if dirty[k] || old == nil {
+			continue
+		}
+		ch := n.chunks[k]
+		size := old.size
+		if ch.size < size {
+			size = ch.size
+		}
+		copy(n.data[int(ch.first)*floatsPerInstance:], l.data[int(old.first)*floatsPerInstance:(int(old.first)+size)*floatsPerInstance])
+		for _, r := range old.ranges {
+			r.first += ch.first - old.first
+			ch.ranges = append(ch.ranges, r)
+		}
+		n.cubes += ch.visible()
+	}
+	n.remesh(groups, dirty)
+	return n
+}
+
+// edit replaces the cells of l by cells in the same lattice, which differ
+// from them only in the chunks changed, in place: those and the chunks next
+// to them are re-meshed within the room they have. It returns the spans of
+// the data, in floats, that changed, and false, leaving l as it is, if a
+// changed chunk is new or has no room for its cells.
+func (l *chunkLayout) edit(cells *cellStore, groups *Groups, changed chunkSet) ([][2]int, bool) {
+	for k := range changed {
+		if ch := l.chunks[k]; ch == nil || cells.chunkInstances(k) > ch.size {
+			return nil, false
+		}
+	}
+	dirty := make(chunkSet)
+	for k := range changed {
+		for _, c := range l.cells.chunk(k) {
+			delete(l.occupied, gridPos(c))
+		}
+		dirty.addAround(k)
+	}
+	l.cells = cells
+	return l.remesh(groups, dirty), true
+}
+
+// displayed returns c as displayed by groups, the bucket it is drawn in,
+// 0 for ungrouped cells and i+1 for group i, and false if it is hidden.
+// A cell belongs to the first group containing it.
+func displayed(c cell, groups *Groups) (cell, int, bool) {
+	for i, g := range groups.All() {
+		if !g.Cells.Contains(c.id) {
+			continue
+		}
+		if g.Hidden {
+			return c, 0, false
+		}
+		if g.Color != nil {
+			c.color = *g.Color
+		}
+		return c, i + 1, true
+	}
+	return c, 0, true
+}
+
+// remesh rewrites the instances of the dirty chunks and returns the spans
+// of the data, in floats, that changed.
+func (l *chunkLayout) remesh(groups *Groups, dirty chunkSet) [][2]int {
+	// The occlusion of a cell depends on its neighbors in other chunks, so
+	// the occupancy of all dirty chunks is updated first.
+	buckets := make(map[chunkKey][][]cell)
+	for k := range dirty {
+		ch := l.chunks[k]
+		if ch == nil {
+			continue
+		}
+		bs := make([][]cell, groups.Len()+1)
+		for _, c := range l.cells.chunk(k) {
+			delete(l.occupied, gridPos(c))
+			if l.look != nil {
+				c = l.look(c)
+			}
+			c, b, ok := displayed(c, groups)
+			if !ok {
+				continue
+			}
+			// A refined cell is drawn as its sub-cells, looking as the
+			// cell does but for their own colors and values.
+			if g := l.cells.subgrids[c.id]; g != nil {
+				for _, s := range g.cells {
+					if l.look != nil {
+						s = l.look(s)
+					}
+					s, _, _ = displayed(s, groups)
+					bs[b] = append(bs[b], s)
+				}
+				continue
+			}
+			bs[b] = append(bs[b], c)
+		}
+		buckets[k] = bs
+	}
+	for _, bs := range buckets {
+		for _, bucket := range bs {
+			for _, c := range bucket {
+				l.occupied.add(c)
+			}
+		}
+	}
+
+	var gen *cellGenerator
+	if l.cells.generated {
+		g := newCellGenerator(l.cells.params)
+		gen = &g
+	}
+	var spans [][2]int
+	for k, bs := range buckets {
+		ch := l.chunks[k]
+		l.cubes -= ch.visible()
+		ch.ranges = ch.ranges[:0]
+
+		base := int(ch.first) * floatsPerInstance
+		old := append([]float32(nil), l.data[base:base+ch.size*floatsPerInstance]...)
+
+		i := ch.first
+		for b, bucket := range bs {
+			if len(bucket) == 0 {
+				continue
+			}
+			anim := defaultAnimation
+			if b > 0 {
+				anim = groups.All()[b-1].Animation
+			}
+			p := l.cells.params
+			first := p.World(bucket[0].pos)
+			r := meshRange{first: i, anim: anim, min: first, max: first}
+			for _, c := range bucket {
+				pos := p.World(c.pos)
+				for k := range r.min {
+					if v := pos[k] - l.w/2; v < r.min[k] {
+						r.min[k] = v
+					}
+					if v := pos[k] + l.w/2; v > r.max[k] {
+						r.max[k] = v
+					}
+				}
+				// Occlusion is by whole cells, which would darken the
+				// corners of sub-cells inside them.
+				var ao uint32
+				if !isSubcell(c) {
+					ao = l.occupied.cornerOcclusion(c)
+				}
+				if gen != nil && gen.cycles(c) {
+					ao |= cycleBit
+				}
+				writeInstance(l.data[int(i)*floatsPerInstance:], c, pos, ao)
+				r.count++
+				i++
+			}
+			ch.ranges = append(ch.ranges, r)
+		}
+		l.cubes += ch.visible()
+
+		if span, ok := changedSpan(old, l.data[base:base+len(old)]); ok {
+			spans = append(spans, [2]int{base + span[0], base + span[1]})
+		}
+	}
+	return spans
+}
+
+// changedSpan returns the smallest span [first, end) outside of which a
+// and b are equal, and false if they are equal throughout.
+func changedSpan(a, b []float32) ([2]int, bool) {
+	first := 0
+	for first < len(a) && math.Float32bits(a[first]) == math.Float32bits(b[first]) {
+		first++
+	}
+	if first == len(a) {
+		return [2]int{}, false
+	}
+	end := len(a)
+	for math.Float32bits(a[end-1]) == math.Float32bits(b[end-1]) {
+		end--
+	}
+	return [2]int{first, end}, true
+}
+
+// ranges returns the ranges of all chunks in layout order.
+func (l *chunkLayout) ranges() []meshRange {
+	var ranges []meshRange
+	for _, k := range l.keys {
+		ranges = append(ranges, l.chunks[k].ranges...)
+	}
+	return ranges
+}
+
+// compact returns the visible instances without the room left for hidden
+// cells, and their ranges.
+func (l *chunkLayout) compact() ([]float32, []meshRange) {
+	instances := make([]float32, 0, l.cubes*floatsPerInstance)
+	ranges := l.ranges()
+	for i, r := range ranges {
+		first := int(r.first) * floatsPerInstance
+		ranges[i].first = int32(len(instances) / floatsPerInstance)
+		instances = append(instances, l.data[first:first+int(r.count)*floatsPerInstance]...)
+	}
+	return instances, ranges
+}
+
+// Mesh holds the GPU buffers of a lattice: the vertices of the shared
+// shape, a cube unless set otherwise, the index buffer of its triangles and
+// the per-cell instance data.
+type Mesh struct {
+	cube      uint32
+	indices   uint32
+	instances uint32
+
+	shape CellShape
+	// shapeIndices is the number of indices of the shape.
+	shapeIndices int
+
+	// cubes is the number of visible instances.
+	cubes  int
+	ranges []meshRange
+	layout *chunkLayout
+}
+
+// NewMesh creates the buffers of an empty mesh.
+func NewMesh() *Mesh {
+	m := &Mesh{}
+	gl.GenBuffers(1, &m.cube)
+	gl.GenBuffers(1, &m.indices)
+	gl.GenBuffers(1, &m.instances)
+	return m
+}
+
+// Upload replaces the mesh with the visible cells, drawn as the shape of
+// the mesh of width w and changed by look if not nil.
+func (m *Mesh) Upload(cells *cellStore, groups *Groups, w float32, look cellLook) {
+	m.layout = newChunkLayout(cells, w, look)
+	m.layout.remesh(groups, m.layout.all())
+	m.cubes = m.layout.cubes
+	m.ranges = m.layout.ranges()
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.instances)
+	gl.BufferData(gl.ARRAY_BUFFER, len(m.layout.data)*4, gl.Ptr(m.layout.data), gl.DYNAMIC_DRAW)
+	m.uploadShape(w)
+}
+
+// SetShape replaces the shape drawn for every cell by s of width w. The
+// instances stay as they are.
+func (m *Mesh) SetShape(s CellShape, w float32) {
+	m.shape = s
+	m.uploadShape(w)
+}
+
+// uploadShape uploads the vertices and indices of the shape of width w.
+func (m *Mesh) uploadShape(w float32) {
+	verts, indices := makeShape(m.shape, w)
+	m.shapeIndices = len(indices)
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.cube)
+	gl.BufferData(gl.ARRAY_BUFFER, len(verts)*4, gl.Ptr(verts), gl.STATIC_DRAW)
+
+	// The element array binding belongs to the bound vertex array, so
+	// upload through a scratch one rather than disturb the caller's.
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	var vao uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.BindVertexArray(vao)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, m.indices)
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*2, gl.Ptr(indices), gl.STATIC_DRAW)
+	gl.BindVertexArray(uint32(prevVAO))
+	gl.DeleteVertexArrays(1, &vao)
+}
+
+// Remesh rebuilds the chunks around the cells ids after they changed group
+// or their groups changed, and uploads only the instance data that
+// differs. The cells themselves must be those of the last Upload.
+func (m *Mesh) Remesh(groups *Groups, ids Selection) {
+	spans := m.layout.remesh(groups, m.layout.dirty(ids))
+	m.cubes = m.layout.cubes
+	m.ranges = m.layout.ranges()
+	m.uploadSpans(spans)
+}
+
+// uploadSpans uploads the spans of the instance data, in floats, that
+// changed.
+func (m *Mesh) uploadSpans(spans [][2]int) {
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.instances)
+	for _, span := range spans {
+		data := m.layout.data[span[0]:span[1]]
+		gl.BufferSubData(gl.ARRAY_BUFFER, span[0]*4, len(data)*4, gl.Ptr(data))
+	}
+}
+
+// Update replaces the cells of the last Upload by cells in the same
+// lattice, which differ from them only in the chunks changed. Only those
+// chunks and the ones next to them are re-meshed, but as the others move
+// within the buffer, all instance data is uploaded.
+func (m *Mesh) Update(cells *cellStore, groups *Groups, changed chunkSet) {
+	m.layout = m.layout.update(cells, groups, changed)
+	m.cubes = m.layout.cubes
+	m.ranges = m.layout.ranges()
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.instances)
+	gl.BufferData(gl.ARRAY_BUFFER, len(m.layout.data)*4, gl.Ptr(m.layout.data), gl.DYNAMIC_DRAW)
+}
+
+// editSpare is the room for added cells every chunk gets when an edit does
+// not fit into the mesh, so that the edits after it do.
+const editSpare = 32
+
+// Edit replaces the cells of the last Upload by cells in the same lattice,
+// which differ from them only in the chunks changed, as single cells added
+// or removed by hand do. The chunks are re-meshed in place and only the
+// instance data that differs is uploaded, unless the cells no longer fit:
+// then the mesh is laid out again with spare room, as Update does.
+func (m *Mesh) Edit(cells *cellStore, groups *Groups, changed chunkSet) {
+	spans, ok := m.layout.edit(cells, groups, changed)
+	if !ok {
+		m.layout.spare = editSpare
+		m.Update(cells, groups, changed)
+		return
+	}
+	m.cubes = m.layout.cubes
+	m.ranges = m.layout.ranges()
+	m.uploadSpans(spans)
+}
+
+// Instances returns the data of the visible instances, packed.
+func (m *Mesh) Instances() []float32 {
+	instances, _ := m.layout.compact()
+	return instances
+}
+
+// Triangles returns the number of triangles drawn for the whole mesh.
+func (m *Mesh) Triangles() int {
+	return m.cubes * m.TrianglesPerCell()
+}
+
+// TrianglesPerCell returns the number of triangles drawn for every cell.
+func (m *Mesh) TrianglesPerCell() int {
+	return m.shapeIndices / 3
+}
+
+// cubeAttribs are the locations of the per-instance attributes of one
+// program, -1 when the program does not use the attribute.
+type cubeAttribs struct {
+	offset, color, cellID, ao, size int32
+}
+
+// attribs configures the bound vertex array to feed the mesh to program.
+func (m *Mesh) attribs(program uint32) cubeAttribs {
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, m.indices)
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.cube)
+	if loc := gl.GetAttribLocation(program, gl.Str("vert\x00")); loc >= 0 {
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 3, gl.FLOAT, false, floatsPerVertex*4, 0)
+	}
+	if loc := gl.GetAttribLocation(program, gl.Str("shiftDir\x00")); loc >= 0 {
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 3, gl.FLOAT, false, floatsPerVertex*4, 3*4)
+	}
+	if loc := gl.GetAttribLocation(program, gl.Str("normal\x00")); loc >= 0 {
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 3, gl.FLOAT, false, floatsPerVertex*4, 6*4)
+	}
+	if loc := gl.GetAttribLocation(program, gl.Str("corner\x00")); loc >= 0 {
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 1, gl.FLOAT, false, floatsPerVertex*4, 9*4)
+	}
+	if loc := gl.GetAttribLocation(program, gl.Str("uv\x00")); loc >= 0 {
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 2, gl.FLOAT, false, floatsPerVertex*4, 10*4)
+	}
+
+	a := cubeAttribs{
+		offset: gl.GetAttribLocation(program, gl.Str("offset\x00")),
+		color:  gl.GetAttribLocation(program, gl.Str("color\x00")),
+		cellID: gl.GetAttribLocation(program, gl.Str("cellID\x00")),
+		ao:     gl.GetAttribLocation(program, gl.Str("ao\x00")),
+		size:   gl.GetAttribLocation(program, gl.Str("size\x00")),
+	}
+	for _, loc := range []int32{a.offset, a.color, a.cellID, a.ao, a.size} {
+		if loc >= 0 {
+			gl.EnableVertexAttribArray(uint32(loc))
+			gl.VertexAttribDivisor(uint32(loc), 1)
+		}
+	}
+	m.point(a, m.instances, 0)
+	return a
+}
+
+// point points the instance attributes at the given first instance of the
+// instance buffer. OpenGL 4.1 has no base instance, so every range is drawn
+// this way.
+func (m *Mesh) point(a cubeAttribs, buffer uint32, first int32) {
+	gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
+	base := uintptr(first) * floatsPerInstance * 4
+	if a.offset >= 0 {
+		gl.VertexAttribPointerWithOffset(uint32(a.offset), 3, gl.FLOAT, false, floatsPerInstance*4, base)
+	}
+	if a.color >= 0 {
+		gl.VertexAttribPointerWithOffset(uint32(a.color), 3, gl.FLOAT, false, floatsPerInstance*4, base+3*4)
+	}
+	if a.cellID >= 0 {
+		gl.VertexAttribIPointerWithOffset(uint32(a.cellID), 1, gl.UNSIGNED_INT, floatsPerInstance*4, base+6*4)
+	}
+	if a.ao >= 0 {
+		gl.VertexAttribIPointerWithOffset(uint32(a.ao), 1, gl.UNSIGNED_INT, floatsPerInstance*4, base+7*4)
+	}
+	if a.size >= 0 {
+		gl.VertexAttribPointerWithOffset(uint32(a.size), 1, gl.FLOAT, false, floatsPerInstance*4, base+8*4)
+	}
+}
+
+// Draw draws count cells starting at instance first. The vertex array
+// configured by attribs for the current program must be bound.
+func (m *Mesh) Draw(a cubeAttribs, first, count int32) {
+	m.DrawFrom(a, m.instances, first, count)
+}
+
+// DrawFrom draws count cells starting at instance first of another buffer
+// of instance data, in the shape of the mesh.
+func (m *Mesh) DrawFrom(a cubeAttribs, buffer uint32, first, count int32) {
+	m.point(a, buffer, first)
+	gl.DrawElementsInstanced(gl.TRIANGLES, int32(m.shapeIndices), gl.UNSIGNED_SHORT, nil, count)
+}
+
+// Delete releases the buffers of the mesh.
+func (m *Mesh) Delete() {
+	gl.DeleteBuffers(1, &m.cube)
+	gl.DeleteBuffers(1, &m.indices)
+	gl.DeleteBuffers(1, &m.instances)
+}
+
+func fract(v float32) float32 {
+	return v - float32(math.Floor(float64(v)))
+}
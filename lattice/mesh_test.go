@@ -0,0 +1,70 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestCycleBit(t *testing.T) {
+	p := DefaultLatticeParams()
+	p.HalfSize = 2
+	if p.ColorPeriod <= 0 {
+		t.Fatal("the default lattice does not cycle its colors")
+	}
+	s := generateStore(p)
+
+	// One cell keeps a color of its own above 1, as edited into the
+	// lattice, and two more are colored by groups, one of them emissive.
+	editedID, _ := p.CellID(0, 0, 1)
+	k, _ := s.chunkOfID(editedID)
+	cells := s.chunk(k)
+	for i := range cells {
+		if cells[i].id == editedID {
+			cells[i].color = mgl32.Vec3{2, 1.5, 0.5}
+		}
+	}
+	s = s.withChunk(k, cells)
+	var groups Groups
+	plainID, _ := p.CellID(1, 0, 0)
+	plain, _ := groups.Add("plain", NewSelection(plainID))
+	plain.Color = &mgl32.Vec3{0.2, 0.4, 0.6}
+	glowID, _ := p.CellID(0, 1, 0)
+	glow, _ := groups.Add("glow", NewSelection(glowID))
+	glow.Color = &mgl32.Vec3{3, 0.6, 0.2}
+	fixed := map[uint32]bool{editedID: true, plainID: true, glowID: true}
+
+	tests := []struct {
+		name  string
+		cells *cellStore
+		// cycles reports whether the cell with the given ID cycles.
+		cycles func(id uint32) bool
+	}{
+		{"generated", s, func(id uint32) bool { return !fixed[id] }},
+		{"loaded", newCellStore(s.unpack(), p), func(uint32) bool { return false }},
+	}
+	for _, test := range tests {
+		l := newChunkLayout(test.cells, p.CubeSize, nil)
+		l.remesh(&groups, l.all())
+		n := 0
+		for i := 0; i+floatsPerInstance <= len(l.data); i += floatsPerInstance {
+			id := math.Float32bits(l.data[i+6])
+			if id == 0 {
+				continue
+			}
+			n++
+			ao := math.Float32bits(l.data[i+7])
+			if got, want := ao&cycleBit != 0, test.cycles(id); got != want {
+				t.Errorf("%v: cell %v cycles %v, want %v", test.name, id, got, want)
+			}
+		}
+		if n != p.Side()*p.Side()*p.Side() {
+			t.Errorf("%v: got %v instances, want %v", test.name, n, p.Side()*p.Side()*p.Side())
+		}
+	}
+}
@@ -0,0 +1,978 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Message identifies a user-facing string in the message catalogs.
+type Message string
+
+const (
+	MsgLanguageName        Message = "language-name"
+	MsgLocaleChanged       Message = "locale-changed"
+	MsgUnknownLocale       Message = "unknown-locale"
+	MsgSelectedCell        Message = "selected-cell"
+	MsgDeselectedCell      Message = "deselected-cell"
+	MsgCellsSelected       Message = "cells-selected"
+	MsgGroupCreated        Message = "group-created"
+	MsgGroupExported       Message = "group-exported"
+	MsgSaveGroupsFailed    Message = "save-groups-failed"
+	MsgNoGroup             Message = "no-group"
+	MsgComparison          Message = "comparison"
+	MsgCellsSaved          Message = "cells-saved"
+	MsgCellsLoaded         Message = "cells-loaded"
+	MsgDiffStats           Message = "diff-stats"
+	MsgUnknownCommand      Message = "unknown-command"
+	MsgCommandFailed       Message = "command-failed"
+	MsgMissingGroupName    Message = "missing-group-name"
+	MsgMissingFileName     Message = "missing-file-name"
+	MsgExpectedColor       Message = "expected-color"
+	MsgExpectedSpeed       Message = "expected-speed"
+	MsgExpectedGroupFile   Message = "expected-group-file"
+	MsgExpectedTwoFiles    Message = "expected-two-files"
+	MsgExpectedCompare     Message = "expected-compare"
+	MsgUnknownMode         Message = "unknown-mode"
+	MsgUnknownPreset       Message = "unknown-preset"
+	MsgSwapMode            Message = "swap-mode"
+	MsgUnknownSwapMode     Message = "unknown-swap-mode"
+	MsgUSDRecording        Message = "usd-recording"
+	MsgUSDSaved            Message = "usd-saved"
+	MsgNotRecordingUSD     Message = "not-recording-usd"
+	MsgExpectedUSD         Message = "expected-usd"
+	MsgLatticeSize         Message = "lattice-size"
+	MsgExpectedSize        Message = "expected-size"
+	MsgStats               Message = "stats"
+	MsgShadersReloaded     Message = "shaders-reloaded"
+	MsgLightBaked          Message = "light-baked"
+	MsgNoLightmap          Message = "no-lightmap"
+	MsgLightmapSize        Message = "lightmap-size"
+	MsgExpectedLight       Message = "expected-light"
+	MsgTooManyDecals       Message = "too-many-decals"
+	MsgNoDecal             Message = "no-decal"
+	MsgExpectedDecal       Message = "expected-decal"
+	MsgOverdraw            Message = "overdraw"
+	MsgExpectedOverdraw    Message = "expected-overdraw"
+	MsgExpectedShadow      Message = "expected-shadow"
+	MsgCamera              Message = "camera"
+	MsgExpectedCamera      Message = "expected-camera"
+	MsgExpectedGoto        Message = "expected-goto"
+	MsgOrbitCamera         Message = "orbit-camera"
+	MsgFreeCamera          Message = "free-camera"
+	MsgMemory              Message = "memory"
+	MsgScreenshot          Message = "screenshot"
+	MsgScreenshotFailed    Message = "screenshot-failed"
+	MsgRecovered           Message = "recovered"
+	MsgAutosaveFailed      Message = "autosave-failed"
+	MsgCollabHosting       Message = "collab-hosting"
+	MsgCollabJoined        Message = "collab-joined"
+	MsgCollabLeft          Message = "collab-left"
+	MsgCollabLost          Message = "collab-lost"
+	MsgCollabFailed        Message = "collab-failed"
+	MsgNoCollab            Message = "no-collab"
+	MsgExpectedAddress     Message = "expected-address"
+	MsgPeerJoined          Message = "peer-joined"
+	MsgPeerLeft            Message = "peer-left"
+	MsgRecording           Message = "recording"
+	MsgRecordingSaved      Message = "recording-saved"
+	MsgRecordingFailed     Message = "recording-failed"
+	MsgRecordingResized    Message = "recording-resized"
+	MsgCollabDenied        Message = "collab-denied"
+	MsgCollabReadOnly      Message = "collab-read-only"
+	MsgLatticeType         Message = "lattice-type"
+	MsgUnknownLatticeType  Message = "unknown-lattice-type"
+	MsgLightmapGrid        Message = "lightmap-grid"
+	MsgCIFLoaded           Message = "cif-loaded"
+	MsgExpectedSupercell   Message = "expected-supercell"
+	MsgScriptFailed        Message = "script-failed"
+	MsgScriptDepth         Message = "script-depth"
+	MsgExpectedWait        Message = "expected-wait"
+	MsgNoScript            Message = "no-script"
+	MsgExpectedRecord      Message = "expected-record"
+	MsgWatching            Message = "watching"
+	MsgWatchStopped        Message = "watch-stopped"
+	MsgNotWatching         Message = "not-watching"
+	MsgMoleculeLoaded      Message = "molecule-loaded"
+	MsgExpectedShake       Message = "expected-shake"
+	MsgCellShape           Message = "cell-shape"
+	MsgUnknownShape        Message = "unknown-shape"
+	MsgCinemaOn            Message = "cinema-on"
+	MsgCinemaOff           Message = "cinema-off"
+	MsgCinemaRecording     Message = "cinema-recording"
+	MsgExpectedCinema      Message = "expected-cinema"
+	MsgCellOverlay         Message = "cell-overlay"
+	MsgNoUnitCell          Message = "no-unit-cell"
+	MsgUnknownOverlay      Message = "unknown-overlay"
+	MsgMillerPlane         Message = "miller-plane"
+	MsgPlaneHidden         Message = "plane-hidden"
+	MsgExpectedMiller      Message = "expected-miller"
+	MsgMillerPrompt        Message = "miller-prompt"
+	MsgBeautyOn            Message = "beauty-on"
+	MsgBeautyOff           Message = "beauty-off"
+	MsgExpectedBeauty      Message = "expected-beauty"
+	MsgExpectedScreenshot  Message = "expected-screenshot"
+	MsgNoCrystal           Message = "no-crystal"
+	MsgSupercell           Message = "supercell"
+	MsgStyle               Message = "style"
+	MsgUnknownStyle        Message = "unknown-style"
+	MsgDefects             Message = "defects"
+	MsgDefectsOff          Message = "defects-off"
+	MsgExpectedDefects     Message = "expected-defects"
+	MsgColormap            Message = "colormap"
+	MsgColormapOff         Message = "colormap-off"
+	MsgUnknownColormap     Message = "unknown-colormap"
+	MsgFade                Message = "fade"
+	MsgFadeOff             Message = "fade-off"
+	MsgExpectedFade        Message = "expected-fade"
+	MsgClip                Message = "clip"
+	MsgClipOff             Message = "clip-off"
+	MsgExpectedClip        Message = "expected-clip"
+	MsgTrails              Message = "trails"
+	MsgTrailsOff           Message = "trails-off"
+	MsgExpectedTrails      Message = "expected-trails"
+	MsgPickedCell          Message = "picked-cell"
+	MsgPointing            Message = "pointing"
+	MsgPointingOff         Message = "pointing-off"
+	MsgEditing             Message = "editing"
+	MsgEditingOff          Message = "editing-off"
+	MsgExpectedEdit        Message = "expected-edit"
+	MsgRemovedCell         Message = "removed-cell"
+	MsgPlacedCell          Message = "placed-cell"
+	MsgOutsideLattice      Message = "outside-lattice"
+	MsgColorRange          Message = "color-range"
+	MsgExpectedColorRange  Message = "expected-color-range"
+	MsgSizeField           Message = "size-field"
+	MsgSizeFieldOff        Message = "size-field-off"
+	MsgExpectedSizeField   Message = "expected-size-field"
+	MsgSceneSaved          Message = "scene-saved"
+	MsgSceneLoaded         Message = "scene-loaded"
+	MsgKeyframe            Message = "keyframe"
+	MsgPathTooShort        Message = "path-too-short"
+	MsgPathPlaying         Message = "path-playing"
+	MsgPathStopped         Message = "path-stopped"
+	MsgPathCleared         Message = "path-cleared"
+	MsgPathSaved           Message = "path-saved"
+	MsgPathLoaded          Message = "path-loaded"
+	MsgExpectedPath        Message = "expected-path"
+	MsgMoreCategories      Message = "more-categories"
+	MsgDataKind            Message = "data-kind"
+	MsgExpectedDataKind    Message = "expected-data-kind"
+	MsgTimeLapseFrame      Message = "time-lapse-frame"
+	MsgCheckpoint          Message = "checkpoint"
+	MsgResumed             Message = "resumed"
+	MsgGPUTimes            Message = "gpu-times"
+	MsgGPUSim              Message = "gpu-sim"
+	MsgGPUSimOff           Message = "gpu-sim-off"
+	MsgExpectedGPUSim      Message = "expected-gpu-sim"
+	MsgLifeStats           Message = "life-stats"
+	MsgGrayScottStats      Message = "gray-scott-stats"
+	MsgRefined             Message = "refined"
+	MsgMerged              Message = "merged"
+	MsgExpectedRefine      Message = "expected-refine"
+	MsgNothingSelected     Message = "nothing-selected"
+	MsgWireframe           Message = "wireframe"
+	MsgWireframeOff        Message = "wireframe-off"
+	MsgExpectedWireframe   Message = "expected-wireframe"
+	MsgPickedAt            Message = "picked-at"
+	MsgPickedDistance      Message = "picked-distance"
+	MsgAxes                Message = "axes"
+	MsgExpectedAxes        Message = "expected-axes"
+	MsgUnits               Message = "units"
+	MsgUnitsOff            Message = "units-off"
+	MsgExpectedUnits       Message = "expected-units"
+	MsgMeasured            Message = "measured"
+	MsgAlpha               Message = "alpha"
+	MsgAlphaOff            Message = "alpha-off"
+	MsgExpectedAlpha       Message = "expected-alpha"
+	MsgFog                 Message = "fog"
+	MsgFogOff              Message = "fog-off"
+	MsgExpectedFog         Message = "expected-fog"
+	MsgBreadcrumbs         Message = "breadcrumbs"
+	MsgBreadcrumbsOff      Message = "breadcrumbs-off"
+	MsgBreadcrumbsCleared  Message = "breadcrumbs-cleared"
+	MsgExpectedBreadcrumbs Message = "expected-breadcrumbs"
+	MsgExpectedCompass     Message = "expected-compass"
+	MsgDepthRange          Message = "depth-range"
+	MsgExpectedDepthRange  Message = "expected-depth-range"
+	MsgFitted              Message = "fitted"
+	MsgExpectedMinimap     Message = "expected-minimap"
+	MsgViewLinkCopied      Message = "view-link-copied"
+	MsgViewLinkOpened      Message = "view-link-opened"
+	MsgNoViewLink          Message = "no-view-link"
+	MsgDemoRecording       Message = "demo-recording"
+	MsgDemoSaved           Message = "demo-saved"
+	MsgDemoPlaying         Message = "demo-playing"
+	MsgDemoEnded           Message = "demo-ended"
+	MsgDemoFailed          Message = "demo-failed"
+	MsgNoDemo              Message = "no-demo"
+	MsgExpectedDemo        Message = "expected-demo"
+	MsgPostEffects         Message = "post-effects"
+	MsgPostEffectsOff      Message = "post-effects-off"
+	MsgNoPostEffect        Message = "no-post-effect"
+	MsgExpectedPost        Message = "expected-post"
+	MsgContextRestored     Message = "context-restored"
+	MsgReloading           Message = "reloading"
+	MsgNoReload            Message = "no-reload"
+	MsgFXAAFallback        Message = "fxaa-fallback"
+	MsgLayers              Message = "layers"
+	MsgExpectedLayer       Message = "expected-layer"
+	MsgTextures            Message = "textures"
+	MsgTexturesOff         Message = "textures-off"
+	MsgExpectedTexture     Message = "expected-texture"
+	MsgShaderHooks         Message = "shader-hooks"
+	MsgExpectedHook        Message = "expected-hook"
+	MsgMaterials           Message = "materials"
+	MsgMaterialsOff        Message = "materials-off"
+	MsgExpectedMaterial    Message = "expected-material"
+	MsgUnknownElement      Message = "unknown-element"
+	MsgDisplace            Message = "displace"
+	MsgDisplaceOff         Message = "displace-off"
+	MsgExpectedDisplace    Message = "expected-displace"
+	MsgLatticeTooLarge     Message = "lattice-too-large"
+	MsgSceneReloaded       Message = "scene-reloaded"
+)
+
+// defaultLocale is complete and provides the messages missing from the
+// other catalogs.
+const defaultLocale = "en"
+
+var catalogs = map[string]map[Message]string{
+	"en": {
+		MsgLanguageName:        "English",
+		MsgLocaleChanged:       "Language: %v",
+		MsgUnknownLocale:       "Unknown language %q, available: %v",
+		MsgSelectedCell:        "Selected cell (%v, %v, %v), %v selected",
+		MsgDeselectedCell:      "Deselected cell (%v, %v, %v), %v selected",
+		MsgCellsSelected:       "%v cells selected",
+		MsgGroupCreated:        "Created group %v",
+		MsgGroupExported:       "Exported %v to %v",
+		MsgSaveGroupsFailed:    "Failed to save groups: %v",
+		MsgNoGroup:             "No group %q",
+		MsgComparison:          "Comparison: %v",
+		MsgCellsSaved:          "Saved %v cells to %v",
+		MsgCellsLoaded:         "Loaded %v cells from %v",
+		MsgDiffStats:           "Added: %v, removed: %v, changed: %v, unchanged: %v",
+		MsgUnknownCommand:      "Unknown command %q, try help",
+		MsgCommandFailed:       "%v: %v (usage: %v)",
+		MsgMissingGroupName:    "Missing group name",
+		MsgMissingFileName:     "Missing file name",
+		MsgExpectedColor:       "Expected a group name and three components",
+		MsgExpectedSpeed:       "Expected a group name and a speed",
+		MsgExpectedGroupFile:   "Expected a group name and a file name",
+		MsgExpectedTwoFiles:    "Expected two file names",
+		MsgExpectedCompare:     "Expected a mode and optionally two presets",
+		MsgUnknownMode:         "Unknown mode %q",
+		MsgUnknownPreset:       "Unknown preset %q",
+		MsgSwapMode:            "Vsync: %v",
+		MsgUnknownSwapMode:     "Unknown vsync mode %q",
+		MsgUSDRecording:        "Recording USD to %v",
+		MsgUSDSaved:            "Saved %v frames to %v",
+		MsgNotRecordingUSD:     "Not recording USD",
+		MsgExpectedUSD:         "Expected start <file.usda> [changes] or stop",
+		MsgLatticeSize:         "Lattice: %v cells per side, %v cells",
+		MsgExpectedSize:        "Expected a non-negative number of cells on each side",
+		MsgStats:               "%.0f fps, %.2f ms per frame\nCamera: %v\nRoll %.0f°, pitch %.0f°, yaw %.0f°\nField of view %.0f°\nTriangles: %v of %v",
+		MsgShadersReloaded:     "Shaders reloaded",
+		MsgLightBaked:          "Baked lighting for %v cells in %v",
+		MsgNoLightmap:          "No lightmap, run light bake first",
+		MsgLightmapSize:        "The lightmap has %v cells per side, the lattice %v",
+		MsgExpectedLight:       "Expected bake, off, save <file>, load <file> or sun <x> <y> <z>",
+		MsgTooManyDecals:       "At most %v decals can be shown",
+		MsgNoDecal:             "No decal %q",
+		MsgExpectedDecal:       "Expected image or text <name> <x> <y> <z> <size> followed by a file or text, or remove <name>",
+		MsgOverdraw:            "Overdraw heat map, red at %v fragments per pixel",
+		MsgExpectedOverdraw:    "Expected off or the number of fragments shown in red",
+		MsgExpectedShadow:      "Expected off, on or the shadow map size and an optional bias",
+		MsgCamera:              "Camera at %.3f %.3f %.3f, pitch %.2f°, yaw %.2f°",
+		MsgExpectedCamera:      "Expected <x> <y> <z>, optionally followed by <pitch> <yaw> in degrees",
+		MsgExpectedGoto:        "Expected <x> <y> <z>, optionally followed by the flight time in seconds",
+		MsgOrbitCamera:         "Orbiting the lattice, scroll to zoom",
+		MsgFreeCamera:          "Flying freely",
+		MsgMemory:              "Cells: %v in %.1f MB, %.1f MB unpacked",
+		MsgScreenshot:          "Saved screenshot to %v",
+		MsgScreenshotFailed:    "Failed to save screenshot: %v",
+		MsgRecovered:           "Restored the session autosaved at %v",
+		MsgAutosaveFailed:      "Autosave failed: %v",
+		MsgCollabHosting:       "Hosting a session on %v",
+		MsgCollabJoined:        "Joined the session at %v as %v",
+		MsgCollabLeft:          "Left the session",
+		MsgCollabLost:          "Lost the connection to the session host",
+		MsgCollabFailed:        "Failed to start the session: %v",
+		MsgNoCollab:            "Not in a session",
+		MsgExpectedAddress:     "Expected an address such as localhost:7000 and an optional name",
+		MsgPeerJoined:          "%v joined the session",
+		MsgPeerLeft:            "%v left the session",
+		MsgRecording:           "Recording to %v",
+		MsgRecordingSaved:      "Saved %v frames to %v",
+		MsgRecordingFailed:     "Recording failed: %v",
+		MsgRecordingResized:    "The window was resized, recording stopped",
+		MsgCollabDenied:        "The session host refused the token or the name",
+		MsgCollabReadOnly:      "Joined read-only, group edits are not shared",
+		MsgLatticeType:         "Lattice type %v, %v cells",
+		MsgUnknownLatticeType:  "Unknown lattice type %q, available: %v",
+		MsgLightmapGrid:        "Baked lighting needs a lattice on the unscaled cubic grid",
+		MsgCIFLoaded:           "Loaded %v atoms from %v",
+		MsgExpectedSupercell:   "Expected a supercell size such as 3 or 2x2x4",
+		MsgScriptFailed:        "Script %v stopped at line %v: %v",
+		MsgScriptDepth:         "Scripts can run other scripts at most %v deep",
+		MsgExpectedWait:        "Expected a non-negative number of seconds",
+		MsgNoScript:            "Only scripts can wait",
+		MsgExpectedRecord:      "Expected start [motion] or stop",
+		MsgWatching:            "Showing %v cells from %v, reloaded when it changes",
+		MsgWatchStopped:        "Stopped watching %v",
+		MsgNotWatching:         "No file is being watched",
+		MsgMoleculeLoaded:      "Loaded %v atoms from %v",
+		MsgExpectedShake:       "Expected a trauma from 0 to 1, optionally followed by the amplitude in degrees and the decay per second",
+		MsgCellShape:           "Drawing cells as %v",
+		MsgUnknownShape:        "Unknown shape %q, expected cube or sphere",
+		MsgCinemaOn:            "Cinema mode at %.2f:1 and %v frames per second",
+		MsgCinemaOff:           "Cinema mode off",
+		MsgCinemaRecording:     "Cinema mode cannot change while recording",
+		MsgExpectedCinema:      "Expected on or off, optionally followed by an aspect such as 2.39:1",
+		MsgCellOverlay:         "Cell edges: %v",
+		MsgNoUnitCell:          "This structure has no unit cell to draw",
+		MsgUnknownOverlay:      "Unknown cell overlay %q, expected off, unit or super",
+		MsgMillerPlane:         "Showing the (%v) plane",
+		MsgPlaneHidden:         "Plane hidden",
+		MsgExpectedMiller:      "Expected Miller indices such as 1 1 1 or 1-10, or off",
+		MsgMillerPrompt:        "Miller indices (h k l): ",
+		MsgBeautyOn:            "Beauty mode on: still views average up to %v jittered frames",
+		MsgBeautyOff:           "Beauty mode off",
+		MsgExpectedBeauty:      "Expected on or off",
+		MsgExpectedScreenshot:  "Expected nothing or transparent",
+		MsgNoCrystal:           "No crystal structure loaded, open one with cif <file>",
+		MsgSupercell:           "Supercell of %vx%vx%v unit cells, %v atoms",
+		MsgStyle:               "Style %v",
+		MsgUnknownStyle:        "Unknown style %q",
+		MsgDefects:             "%v vacancies and %v interstitials injected, seed %v",
+		MsgDefectsOff:          "Point defects removed",
+		MsgExpectedDefects:     "Expected fractions of vacancies and interstitials and a seed, or off",
+		MsgColormap:            "Cells colored by %v through %v",
+		MsgColormapOff:         "Cells show their own colors",
+		MsgUnknownColormap:     "Unknown colormap %q",
+		MsgFade:                "Cells faded by density within %d steps, by up to %.0f%%",
+		MsgFadeOff:             "Density fading off",
+		MsgExpectedFade:        "Expected a neighborhood radius and a strength, or off",
+		MsgClip:                "Clipping plane %v",
+		MsgClipOff:             "Clipping plane off",
+		MsgExpectedClip:        "Expected a normal x y z and an offset, view or off",
+		MsgTrails:              "Trails over the last %d changes",
+		MsgTrailsOff:           "Trails off",
+		MsgExpectedTrails:      "Expected a trail length or off",
+		MsgPickedCell:          "Cell %d %d %d  color #%02x%02x%02x  value %.3g",
+		MsgPointing:            "Click cells to pick them, I to look around again",
+		MsgPointingOff:         "Looking around, clicks pick the cell in the middle",
+		MsgEditing:             "Editing: left click removes a cube, right click places one",
+		MsgEditingOff:          "Editing off",
+		MsgExpectedEdit:        "Expected on or off",
+		MsgRemovedCell:         "Removed cell %d %d %d",
+		MsgPlacedCell:          "Placed cell %d %d %d",
+		MsgOutsideLattice:      "Cell %d %d %d is outside the lattice",
+		MsgColorRange:          "Colormap range %v, clipping %.3g%% at either end",
+		MsgExpectedColorRange:  "Expected linear or equalize and a clip percentage",
+		MsgSizeField:           "Cells sized by %v, down to %.2g",
+		MsgSizeFieldOff:        "Cells show their own sizes",
+		MsgExpectedSizeField:   "Expected a field or off and a minimum size from 0 to 1",
+		MsgSceneSaved:          "Saved the scene to %v",
+		MsgSceneLoaded:         "Loaded the scene from %v",
+		MsgKeyframe:            "Keyframe %v at %.1f s",
+		MsgPathTooShort:        "A camera path needs at least two keyframes",
+		MsgPathPlaying:         "Playing %v keyframes over %.1f s",
+		MsgPathStopped:         "Camera path stopped",
+		MsgPathCleared:         "Camera path cleared",
+		MsgPathSaved:           "Saved %v keyframes to %v",
+		MsgPathLoaded:          "Loaded %v keyframes from %v",
+		MsgExpectedPath:        "Expected add [<seconds>], play, stop, clear, save <file> or load <file>",
+		MsgMoreCategories:      "%v more",
+		MsgDataKind:            "Data colored as %v",
+		MsgExpectedDataKind:    "Expected auto, continuous or categorical",
+		MsgTimeLapseFrame:      "Frame %v of %v at step %v",
+		MsgCheckpoint:          "Checkpoint of step %v saved to %v",
+		MsgResumed:             "Resuming after step %v from %v",
+		MsgGPUTimes:            "GPU: simulation %.2f ms, shadows %.2f ms, lattice %.2f ms, post %.2f ms, HUD %.2f ms",
+		MsgGPUSim:              "GPU simulation %v on %d³ cells, %d steps per frame",
+		MsgGPUSimOff:           "GPU simulation off",
+		MsgExpectedGPUSim:      "Expected off, life or grayscott, a grid side and steps per frame",
+		MsgLifeStats:           "Life: step %d, %d³ cells, %.1f%% alive",
+		MsgGrayScottStats:      "Gray-Scott: step %d, %d³ cells, mean U %.4f, V %.4f",
+		MsgRefined:             "Refined %d cells %d times along each axis",
+		MsgMerged:              "Merged the sub-cells of %d cells",
+		MsgExpectedRefine:      "Expected 2, 4 or off",
+		MsgNothingSelected:     "Nothing selected",
+		MsgWireframe:           "Wireframe in flat cell colors",
+		MsgWireframeOff:        "Wireframe off",
+		MsgExpectedWireframe:   "Expected on or off",
+		MsgPickedAt:            "At %v",
+		MsgPickedDistance:      "%v from the cell picked before",
+		MsgAxes:                "Data axes %v up, %v-handed",
+		MsgExpectedAxes:        "Expected y or z, optionally followed by right or left",
+		MsgUnits:               "A grid step is %v",
+		MsgUnitsOff:            "Lengths in world units",
+		MsgExpectedUnits:       "Expected a positive length per cell such as 0.5nm, or off",
+		MsgMeasured:            "%d cells span %v by %v by %v, %v across",
+		MsgAlpha:               "Cubes %.0f%% opaque, drawn back to front",
+		MsgAlphaOff:            "Cubes opaque",
+		MsgExpectedAlpha:       "Expected an alpha from 0 to 1",
+		MsgFog:                 "Fog of density %.3g",
+		MsgFogOff:              "Fog off",
+		MsgExpectedFog:         "Expected a density of at least 0, optionally followed by <r> <g> <b>, or off",
+		MsgBreadcrumbs:         "Dropping breadcrumbs every %.3g units",
+		MsgBreadcrumbsOff:      "Stopped dropping breadcrumbs, %d kept",
+		MsgBreadcrumbsCleared:  "Breadcrumbs cleared",
+		MsgExpectedBreadcrumbs: "Expected on with an optional positive spacing, off or clear",
+		MsgExpectedCompass:     "Expected on or off",
+		MsgDepthRange:          "Near plane at %.3g, far plane at %.3g",
+		MsgExpectedDepthRange:  "Expected <near> <far> with 0 < near < far",
+		MsgFitted:              "Framed the lattice, %v across, near plane at %.3g, far plane at %.3g",
+		MsgExpectedMinimap:     "Expected on or off",
+		MsgViewLinkCopied:      "Copied view link %v",
+		MsgViewLinkOpened:      "Opened view link",
+		MsgNoViewLink:          "No view link on the clipboard",
+		MsgDemoRecording:       "Recording demo to %v",
+		MsgDemoSaved:           "Saved demo of %v frames to %v",
+		MsgDemoPlaying:         "Playing demo %v, %v frames",
+		MsgDemoEnded:           "Demo %v ended",
+		MsgDemoFailed:          "Demo failed: %v",
+		MsgNoDemo:              "No demo is recorded or played",
+		MsgExpectedDemo:        "Expected record <file> [<fps>] with fps above 0, play <file> or stop",
+		MsgPostEffects:         "Post-processing: %v",
+		MsgPostEffectsOff:      "Post-processing off",
+		MsgNoPostEffect:        "No post-processing effect %v",
+		MsgExpectedPost:        "Expected gamma <gamma> above 0, vignette <strength> [<radius> from 0 to 1], bloom [<strength> [<threshold>]], ssao [<strength> [<radius> above 0]], fxaa, remove <effect> or off",
+		MsgContextRestored:     "The GL context was lost; rebuilt the renderer in a new one",
+		MsgReloading:           "Running %v again",
+		MsgNoReload:            "No startup script or scene to reload; start with -exec or -load",
+		MsgFXAAFallback:        "Multisampling with %v samples is unavailable; smoothing edges with FXAA instead",
+		MsgLayers:              "Layers shown: %v; captured: %v",
+		MsgExpectedLayer:       "Expected layer background|lattice|overlays|debug, then on|off or capture on|off",
+		MsgTextures:            "Textured the cells with %v images",
+		MsgTexturesOff:         "Cell textures off",
+		MsgExpectedTexture:     "Expected texture <file>... or off",
+		MsgShaderHooks:         "Shader hooks: %v",
+		MsgExpectedHook:        "Expected hook color <file>|off, hook displace <file> [<bound>]|off or hook off",
+		MsgMaterials:           "%v material rules give the sites their textures",
+		MsgMaterialsOff:        "Materials off, the sites take the textures in turn",
+		MsgExpectedMaterial:    "Expected material <layer> element <symbol>|value <value>|selection or material off",
+		MsgUnknownElement:      "Unknown element %q",
+		MsgDisplace:            "Displacing the cells by up to %v, frequency %v, speed %v",
+		MsgDisplaceOff:         "Displacement off",
+		MsgExpectedDisplace:    "Expected displace <amplitude> [<frequency> [<speed> [<image>]]] or off",
+		MsgLatticeTooLarge:     "A lattice of %v cells a side is too large, the cells are numbered in 32 bits for at most %v a side",
+		MsgSceneReloaded:       "Applied the changes to %v",
+	},
+	"de": {
+		MsgLanguageName:        "Deutsch",
+		MsgLocaleChanged:       "Sprache: %v",
+		MsgUnknownLocale:       "Unbekannte Sprache %q, verfügbar: %v",
+		MsgSelectedCell:        "Zelle (%v, %v, %v) ausgewählt, %v ausgewählt",
+		MsgDeselectedCell:      "Auswahl von Zelle (%v, %v, %v) aufgehoben, %v ausgewählt",
+		MsgCellsSelected:       "%v Zellen ausgewählt",
+		MsgGroupCreated:        "Gruppe %v erstellt",
+		MsgGroupExported:       "%v nach %v exportiert",
+		MsgSaveGroupsFailed:    "Gruppen konnten nicht gespeichert werden: %v",
+		MsgNoGroup:             "Keine Gruppe %q",
+		MsgComparison:          "Vergleich: %v",
+		MsgCellsSaved:          "%v Zellen in %v gespeichert",
+		MsgCellsLoaded:         "%v Zellen aus %v geladen",
+		MsgDiffStats:           "Hinzugefügt: %v, entfernt: %v, geändert: %v, unverändert: %v",
+		MsgUnknownCommand:      "Unbekannter Befehl %q, siehe help",
+		MsgCommandFailed:       "%v: %v (Verwendung: %v)",
+		MsgMissingGroupName:    "Gruppenname fehlt",
+		MsgMissingFileName:     "Dateiname fehlt",
+		MsgExpectedColor:       "Gruppenname und drei Farbkomponenten erwartet",
+		MsgExpectedSpeed:       "Gruppenname und Geschwindigkeit erwartet",
+		MsgExpectedGroupFile:   "Gruppenname und Dateiname erwartet",
+		MsgExpectedTwoFiles:    "Zwei Dateinamen erwartet",
+		MsgExpectedCompare:     "Modus und optional zwei Voreinstellungen erwartet",
+		MsgUnknownMode:         "Unbekannter Modus %q",
+		MsgUnknownPreset:       "Unbekannte Voreinstellung %q",
+		MsgSwapMode:            "VSync: %v",
+		MsgUnknownSwapMode:     "Unbekannter VSync-Modus %q",
+		MsgUSDRecording:        "USD-Aufnahme nach %v",
+		MsgUSDSaved:            "%v Bilder in %v gespeichert",
+		MsgNotRecordingUSD:     "Keine USD-Aufnahme aktiv",
+		MsgExpectedUSD:         "start <datei.usda> [changes] oder stop erwartet",
+		MsgLatticeSize:         "Gitter: %v Zellen pro Seite, %v Zellen",
+		MsgExpectedSize:        "Nicht negative Anzahl von Zellen pro Seite erwartet",
+		MsgStats:               "%.0f fps, %.2f ms pro Bild\nKamera: %v\nRollen %.0f°, Nicken %.0f°, Gieren %.0f°\nSichtfeld %.0f°\nDreiecke: %v von %v",
+		MsgShadersReloaded:     "Shader neu geladen",
+		MsgLightBaked:          "Beleuchtung für %v Zellen in %v berechnet",
+		MsgNoLightmap:          "Keine Lightmap, zuerst light bake ausführen",
+		MsgLightmapSize:        "Die Lightmap hat %v Zellen pro Seite, das Gitter %v",
+		MsgExpectedLight:       "bake, off, save <datei>, load <datei> oder sun <x> <y> <z> erwartet",
+		MsgTooManyDecals:       "Höchstens %v Decals können angezeigt werden",
+		MsgNoDecal:             "Kein Decal %q",
+		MsgExpectedDecal:       "image oder text <name> <x> <y> <z> <größe> gefolgt von Datei oder Text, oder remove <name> erwartet",
+		MsgOverdraw:            "Overdraw-Heatmap, rot ab %v Fragmenten pro Pixel",
+		MsgExpectedOverdraw:    "off oder die rot dargestellte Anzahl Fragmente erwartet",
+		MsgExpectedShadow:      "off, on oder die Größe der Shadow-Map und optional der Bias erwartet",
+		MsgCamera:              "Kamera bei %.3f %.3f %.3f, Neigung %.2f°, Gieren %.2f°",
+		MsgExpectedCamera:      "<x> <y> <z> erwartet, optional gefolgt von <neigung> <gieren> in Grad",
+		MsgExpectedGoto:        "<x> <y> <z> erwartet, optional gefolgt von der Flugzeit in Sekunden",
+		MsgOrbitCamera:         "Kamera umkreist das Gitter, Mausrad zum Zoomen",
+		MsgFreeCamera:          "Freier Flug",
+		MsgMemory:              "Zellen: %v in %.1f MB, entpackt %.1f MB",
+		MsgScreenshot:          "Bildschirmfoto in %v gespeichert",
+		MsgScreenshotFailed:    "Bildschirmfoto konnte nicht gespeichert werden: %v",
+		MsgRecovered:           "Sitzung vom %v wiederhergestellt",
+		MsgAutosaveFailed:      "Automatisches Speichern fehlgeschlagen: %v",
+		MsgCollabHosting:       "Sitzung auf %v gestartet",
+		MsgCollabJoined:        "Sitzung auf %v als %v beigetreten",
+		MsgCollabLeft:          "Sitzung verlassen",
+		MsgCollabLost:          "Verbindung zum Sitzungshost verloren",
+		MsgCollabFailed:        "Sitzung konnte nicht gestartet werden: %v",
+		MsgNoCollab:            "Keine Sitzung aktiv",
+		MsgExpectedAddress:     "Adresse wie localhost:7000 und optionaler Name erwartet",
+		MsgPeerJoined:          "%v ist der Sitzung beigetreten",
+		MsgPeerLeft:            "%v hat die Sitzung verlassen",
+		MsgRecording:           "Aufnahme nach %v",
+		MsgRecordingSaved:      "%v Bilder in %v gespeichert",
+		MsgRecordingFailed:     "Aufnahme fehlgeschlagen: %v",
+		MsgRecordingResized:    "Das Fenster wurde vergrößert oder verkleinert, Aufnahme beendet",
+		MsgCollabDenied:        "Der Sitzungshost hat das Token oder den Namen abgelehnt",
+		MsgCollabReadOnly:      "Nur lesend beigetreten, Gruppenänderungen werden nicht geteilt",
+		MsgLatticeType:         "Gittertyp %v, %v Zellen",
+		MsgUnknownLatticeType:  "Unbekannter Gittertyp %q, verfügbar: %v",
+		MsgLightmapGrid:        "Gebackene Beleuchtung erfordert ein Gitter auf dem unskalierten kubischen Raster",
+		MsgCIFLoaded:           "%v Atome aus %v geladen",
+		MsgExpectedSupercell:   "Superzellengröße wie 3 oder 2x2x4 erwartet",
+		MsgScriptFailed:        "Skript %v in Zeile %v abgebrochen: %v",
+		MsgScriptDepth:         "Skripte können andere Skripte höchstens %v Ebenen tief ausführen",
+		MsgExpectedWait:        "Nicht negative Anzahl von Sekunden erwartet",
+		MsgNoScript:            "Nur Skripte können warten",
+		MsgExpectedRecord:      "start [motion] oder stop erwartet",
+		MsgWatching:            "%v Zellen aus %v angezeigt, bei Änderungen neu geladen",
+		MsgWatchStopped:        "%v wird nicht mehr beobachtet",
+		MsgNotWatching:         "Es wird keine Datei beobachtet",
+		MsgMoleculeLoaded:      "%v Atome aus %v geladen",
+		MsgExpectedShake:       "Trauma von 0 bis 1 erwartet, optional gefolgt von der Amplitude in Grad und dem Abklingen pro Sekunde",
+		MsgCellShape:           "Zellen werden als %v gezeichnet",
+		MsgUnknownShape:        "Unbekannte Form %q, cube oder sphere erwartet",
+		MsgCinemaOn:            "Kinomodus mit %.2f:1 und %v Bildern pro Sekunde",
+		MsgCinemaOff:           "Kinomodus aus",
+		MsgCinemaRecording:     "Der Kinomodus kann während der Aufnahme nicht geändert werden",
+		MsgExpectedCinema:      "on oder off erwartet, optional gefolgt von einem Seitenverhältnis wie 2.39:1",
+		MsgCellOverlay:         "Zellkanten: %v",
+		MsgNoUnitCell:          "Diese Struktur hat keine Elementarzelle zum Zeichnen",
+		MsgUnknownOverlay:      "Unbekannte Zellanzeige %q, off, unit oder super erwartet",
+		MsgMillerPlane:         "Ebene (%v) wird angezeigt",
+		MsgPlaneHidden:         "Ebene ausgeblendet",
+		MsgExpectedMiller:      "Miller-Indizes wie 1 1 1 oder 1-10 erwartet, oder off",
+		MsgMillerPrompt:        "Miller-Indizes (h k l): ",
+		MsgBeautyOn:            "Schönheitsmodus an: ruhige Ansichten mitteln bis zu %v verschobene Bilder",
+		MsgBeautyOff:           "Schönheitsmodus aus",
+		MsgExpectedBeauty:      "on oder off erwartet",
+		MsgExpectedScreenshot:  "Nichts oder transparent erwartet",
+		MsgNoCrystal:           "Keine Kristallstruktur geladen, öffne eine mit cif <Datei>",
+		MsgSupercell:           "Superzelle aus %vx%vx%v Einheitszellen, %v Atome",
+		MsgStyle:               "Stil %v",
+		MsgUnknownStyle:        "Unbekannter Stil %q",
+		MsgDefects:             "%v Leerstellen und %v Zwischengitteratome eingefügt, Startwert %v",
+		MsgDefectsOff:          "Punktdefekte entfernt",
+		MsgExpectedDefects:     "Anteile der Leerstellen und Zwischengitteratome und einen Startwert oder off erwartet",
+		MsgColormap:            "Zellen nach %v mit %v eingefärbt",
+		MsgColormapOff:         "Zellen zeigen ihre eigenen Farben",
+		MsgUnknownColormap:     "Unbekannte Farbskala %q",
+		MsgFade:                "Zellen nach Dichte im Umkreis von %d Schritten um bis zu %.0f%% abgeblendet",
+		MsgFadeOff:             "Dichteabblendung aus",
+		MsgExpectedFade:        "Umkreisradius und Stärke oder off erwartet",
+		MsgClip:                "Schnittebene %v",
+		MsgClipOff:             "Schnittebene aus",
+		MsgExpectedClip:        "Normale x y z und Abstand, view oder off erwartet",
+		MsgTrails:              "Spuren über die letzten %d Änderungen",
+		MsgTrailsOff:           "Spuren aus",
+		MsgExpectedTrails:      "Spurlänge oder off erwartet",
+		MsgPickedCell:          "Zelle %d %d %d  Farbe #%02x%02x%02x  Wert %.3g",
+		MsgPointing:            "Zellen zum Auswählen anklicken, I zum Umsehen",
+		MsgPointingOff:         "Umsehen, Klicks wählen die Zelle in der Mitte",
+		MsgEditing:             "Bearbeiten: Linksklick entfernt einen Würfel, Rechtsklick setzt einen",
+		MsgEditingOff:          "Bearbeiten aus",
+		MsgExpectedEdit:        "on oder off erwartet",
+		MsgRemovedCell:         "Zelle %d %d %d entfernt",
+		MsgPlacedCell:          "Zelle %d %d %d gesetzt",
+		MsgOutsideLattice:      "Zelle %d %d %d liegt außerhalb des Gitters",
+		MsgColorRange:          "Farbskalenbereich %v, %.3g%% an beiden Enden abgeschnitten",
+		MsgExpectedColorRange:  "linear oder equalize und ein Abschneideprozentsatz erwartet",
+		MsgSizeField:           "Zellengröße nach %v, bis hinab zu %.2g",
+		MsgSizeFieldOff:        "Zellen zeigen ihre eigene Größe",
+		MsgExpectedSizeField:   "Feld oder off und eine Mindestgröße von 0 bis 1 erwartet",
+		MsgSceneSaved:          "Szene in %v gespeichert",
+		MsgSceneLoaded:         "Szene aus %v geladen",
+		MsgKeyframe:            "Schlüsselbild %v bei %.1f s",
+		MsgPathTooShort:        "Ein Kamerapfad braucht mindestens zwei Schlüsselbilder",
+		MsgPathPlaying:         "%v Schlüsselbilder über %.1f s werden abgespielt",
+		MsgPathStopped:         "Kamerapfad angehalten",
+		MsgPathCleared:         "Kamerapfad gelöscht",
+		MsgPathSaved:           "%v Schlüsselbilder in %v gespeichert",
+		MsgPathLoaded:          "%v Schlüsselbilder aus %v geladen",
+		MsgExpectedPath:        "add [<Sekunden>], play, stop, clear, save <Datei> oder load <Datei> erwartet",
+		MsgMoreCategories:      "%v weitere",
+		MsgDataKind:            "Daten als %v gefärbt",
+		MsgExpectedDataKind:    "auto, continuous oder categorical erwartet",
+		MsgTimeLapseFrame:      "Bild %v von %v bei Schritt %v",
+		MsgCheckpoint:          "Prüfpunkt von Schritt %v in %v gespeichert",
+		MsgResumed:             "Fortsetzung nach Schritt %v aus %v",
+		MsgGPUTimes:            "GPU: Simulation %.2f ms, Schatten %.2f ms, Gitter %.2f ms, Nachbearbeitung %.2f ms, HUD %.2f ms",
+		MsgGPUSim:              "GPU-Simulation %v auf %d³ Zellen, %d Schritte pro Bild",
+		MsgGPUSimOff:           "GPU-Simulation aus",
+		MsgExpectedGPUSim:      "off, life oder grayscott, eine Gitterseite und Schritte pro Bild erwartet",
+		MsgLifeStats:           "Life: Schritt %d, %d³ Zellen, %.1f%% lebendig",
+		MsgGrayScottStats:      "Gray-Scott: Schritt %d, %d³ Zellen, Mittel U %.4f, V %.4f",
+		MsgRefined:             "%d Zellen entlang jeder Achse %d-fach verfeinert",
+		MsgMerged:              "Unterzellen von %d Zellen zusammengeführt",
+		MsgExpectedRefine:      "2, 4 oder off erwartet",
+		MsgNothingSelected:     "Nichts ausgewählt",
+		MsgWireframe:           "Drahtgitter in flachen Zellfarben",
+		MsgWireframeOff:        "Drahtgitter aus",
+		MsgExpectedWireframe:   "on oder off erwartet",
+		MsgPickedAt:            "Bei %v",
+		MsgPickedDistance:      "%v von der zuvor gewählten Zelle",
+		MsgAxes:                "Datenachsen %v oben, %v-händig",
+		MsgExpectedAxes:        "y oder z erwartet, optional gefolgt von right oder left",
+		MsgUnits:               "Ein Gitterschritt ist %v",
+		MsgUnitsOff:            "Längen in Welteinheiten",
+		MsgExpectedUnits:       "Positive Länge pro Zelle wie 0.5nm oder off erwartet",
+		MsgMeasured:            "%d Zellen spannen %v mal %v mal %v, %v diagonal",
+		MsgAlpha:               "Würfel zu %.0f%% deckend, von hinten nach vorn gezeichnet",
+		MsgAlphaOff:            "Würfel deckend",
+		MsgExpectedAlpha:       "Alpha von 0 bis 1 erwartet",
+		MsgFog:                 "Nebel der Dichte %.3g",
+		MsgFogOff:              "Nebel aus",
+		MsgExpectedFog:         "Dichte ab 0 erwartet, optional gefolgt von <r> <g> <b>, oder off",
+		MsgBreadcrumbs:         "Brotkrumen alle %.3g Einheiten",
+		MsgBreadcrumbsOff:      "Keine neuen Brotkrumen, %d behalten",
+		MsgBreadcrumbsCleared:  "Brotkrumen entfernt",
+		MsgExpectedBreadcrumbs: "on mit optionalem positivem Abstand, off oder clear erwartet",
+		MsgExpectedCompass:     "on oder off erwartet",
+		MsgDepthRange:          "Nahe Ebene bei %.3g, ferne Ebene bei %.3g",
+		MsgExpectedDepthRange:  "<nah> <fern> mit 0 < nah < fern erwartet",
+		MsgFitted:              "Gitter eingepasst, %v Durchmesser, nahe Ebene bei %.3g, ferne Ebene bei %.3g",
+		MsgExpectedMinimap:     "on oder off erwartet",
+		MsgViewLinkCopied:      "Ansichtslink %v kopiert",
+		MsgViewLinkOpened:      "Ansichtslink geöffnet",
+		MsgNoViewLink:          "Kein Ansichtslink in der Zwischenablage",
+		MsgDemoRecording:       "Demo wird nach %v aufgezeichnet",
+		MsgDemoSaved:           "Demo mit %v Frames in %v gespeichert",
+		MsgDemoPlaying:         "Demo %v wird abgespielt, %v Frames",
+		MsgDemoEnded:           "Demo %v beendet",
+		MsgDemoFailed:          "Demo fehlgeschlagen: %v",
+		MsgNoDemo:              "Keine Demo wird aufgezeichnet oder abgespielt",
+		MsgExpectedDemo:        "record <Datei> [<fps>] mit fps über 0, play <Datei> oder stop erwartet",
+		MsgPostEffects:         "Nachbearbeitung: %v",
+		MsgPostEffectsOff:      "Nachbearbeitung aus",
+		MsgNoPostEffect:        "Kein Nachbearbeitungseffekt %v",
+		MsgExpectedPost:        "gamma <Gamma> über 0, vignette <Stärke> [<Radius> zwischen 0 und 1], bloom [<Stärke> [<Schwelle>]], ssao [<Stärke> [<Radius> über 0]], fxaa, remove <Effekt> oder off erwartet",
+		MsgContextRestored:     "Der GL-Kontext ging verloren; Renderer in einem neuen neu aufgebaut",
+		MsgReloading:           "%v wird erneut ausgeführt",
+		MsgNoReload:            "Kein Startskript und keine Szene zum Neuladen; mit -exec oder -load starten",
+		MsgFXAAFallback:        "Multisampling mit %v Samples ist nicht verfügbar; Kanten werden stattdessen mit FXAA geglättet",
+		MsgLayers:              "Angezeigte Ebenen: %v; aufgenommen: %v",
+		MsgExpectedLayer:       "Ebene background|lattice|overlays|debug und on|off oder capture on|off erwartet",
+		MsgTextures:            "Zellen mit %v Bildern texturiert",
+		MsgTexturesOff:         "Zelltexturen aus",
+		MsgExpectedTexture:     "texture <Datei>... oder off erwartet",
+		MsgShaderHooks:         "Shader-Hooks: %v",
+		MsgExpectedHook:        "hook color <Datei>|off, hook displace <Datei> [<Grenze>]|off oder hook off erwartet",
+		MsgMaterials:           "%v Materialregeln geben den Gitterplätzen ihre Texturen",
+		MsgMaterialsOff:        "Materialien aus, die Gitterplätze nehmen die Texturen reihum",
+		MsgExpectedMaterial:    "material <Ebene> element <Symbol>|value <Wert>|selection oder material off erwartet",
+		MsgUnknownElement:      "Unbekanntes Element %q",
+		MsgDisplace:            "Zellen um bis zu %v verschoben, Frequenz %v, Geschwindigkeit %v",
+		MsgDisplaceOff:         "Verschiebung aus",
+		MsgExpectedDisplace:    "displace <Amplitude> [<Frequenz> [<Geschwindigkeit> [<Bild>]]] oder off erwartet",
+		MsgLatticeTooLarge:     "Ein Gitter mit %v Zellen je Seite ist zu groß, die Zellen werden in 32 Bit nummeriert, für höchstens %v je Seite",
+		MsgSceneReloaded:       "Änderungen an %v übernommen",
+	},
+	"fr": {
+		MsgLanguageName:        "Français",
+		MsgLocaleChanged:       "Langue : %v",
+		MsgUnknownLocale:       "Langue inconnue %q, disponibles : %v",
+		MsgSelectedCell:        "Cellule (%v, %v, %v) sélectionnée, %v sélectionnées",
+		MsgDeselectedCell:      "Cellule (%v, %v, %v) désélectionnée, %v sélectionnées",
+		MsgCellsSelected:       "%v cellules sélectionnées",
+		MsgGroupCreated:        "Groupe %v créé",
+		MsgGroupExported:       "%v exporté vers %v",
+		MsgSaveGroupsFailed:    "Impossible d'enregistrer les groupes : %v",
+		MsgNoGroup:             "Aucun groupe %q",
+		MsgComparison:          "Comparaison : %v",
+		MsgCellsSaved:          "%v cellules enregistrées dans %v",
+		MsgCellsLoaded:         "%v cellules chargées depuis %v",
+		MsgDiffStats:           "Ajoutées : %v, supprimées : %v, modifiées : %v, inchangées : %v",
+		MsgUnknownCommand:      "Commande inconnue %q, essayez help",
+		MsgCommandFailed:       "%v : %v (utilisation : %v)",
+		MsgMissingGroupName:    "Nom de groupe manquant",
+		MsgMissingFileName:     "Nom de fichier manquant",
+		MsgExpectedColor:       "Nom de groupe et trois composantes attendus",
+		MsgExpectedSpeed:       "Nom de groupe et vitesse attendus",
+		MsgExpectedGroupFile:   "Nom de groupe et nom de fichier attendus",
+		MsgExpectedTwoFiles:    "Deux noms de fichier attendus",
+		MsgExpectedCompare:     "Mode et éventuellement deux préréglages attendus",
+		MsgUnknownMode:         "Mode inconnu %q",
+		MsgUnknownPreset:       "Préréglage inconnu %q",
+		MsgSwapMode:            "Synchronisation verticale : %v",
+		MsgUnknownSwapMode:     "Mode de synchronisation verticale inconnu %q",
+		MsgUSDRecording:        "Enregistrement USD vers %v",
+		MsgUSDSaved:            "%v images enregistrées dans %v",
+		MsgNotRecordingUSD:     "Aucun enregistrement USD en cours",
+		MsgExpectedUSD:         "start <fichier.usda> [changes] ou stop attendu",
+		MsgLatticeSize:         "Réseau : %v cellules par côté, %v cellules",
+		MsgExpectedSize:        "Nombre positif ou nul de cellules de chaque côté attendu",
+		MsgStats:               "%.0f ips, %.2f ms par image\nCaméra : %v\nRoulis %.0f°, tangage %.0f°, lacet %.0f°\nChamp de vision %.0f°\nTriangles : %v sur %v",
+		MsgShadersReloaded:     "Shaders rechargés",
+		MsgLightBaked:          "Éclairage précalculé pour %v cellules en %v",
+		MsgNoLightmap:          "Aucune lightmap, lancez d'abord light bake",
+		MsgLightmapSize:        "La lightmap a %v cellules par côté, le réseau %v",
+		MsgExpectedLight:       "bake, off, save <fichier>, load <fichier> ou sun <x> <y> <z> attendu",
+		MsgTooManyDecals:       "Au plus %v décalcomanies peuvent être affichées",
+		MsgNoDecal:             "Aucune décalcomanie %q",
+		MsgExpectedDecal:       "image ou text <nom> <x> <y> <z> <taille> suivi d'un fichier ou d'un texte, ou remove <nom> attendu",
+		MsgOverdraw:            "Carte de chaleur de la surcharge, rouge à %v fragments par pixel",
+		MsgExpectedOverdraw:    "off ou le nombre de fragments affichés en rouge attendu",
+		MsgExpectedShadow:      "off, on ou la taille de la shadow map et un biais facultatif attendu",
+		MsgCamera:              "Caméra à %.3f %.3f %.3f, tangage %.2f°, lacet %.2f°",
+		MsgExpectedCamera:      "<x> <y> <z> attendu, suivi éventuellement de <tangage> <lacet> en degrés",
+		MsgExpectedGoto:        "<x> <y> <z> attendu, suivi éventuellement de la durée du vol en secondes",
+		MsgOrbitCamera:         "Orbite autour du réseau, molette pour zoomer",
+		MsgFreeCamera:          "Vol libre",
+		MsgMemory:              "Cellules : %v en %.1f Mo, %.1f Mo décompressées",
+		MsgScreenshot:          "Capture d'écran enregistrée dans %v",
+		MsgScreenshotFailed:    "Impossible d'enregistrer la capture d'écran : %v",
+		MsgRecovered:           "Session enregistrée automatiquement le %v restaurée",
+		MsgAutosaveFailed:      "Échec de l'enregistrement automatique : %v",
+		MsgCollabHosting:       "Session hébergée sur %v",
+		MsgCollabJoined:        "Session de %v rejointe en tant que %v",
+		MsgCollabLeft:          "Session quittée",
+		MsgCollabLost:          "Connexion perdue avec l'hôte de la session",
+		MsgCollabFailed:        "Impossible de démarrer la session : %v",
+		MsgNoCollab:            "Aucune session en cours",
+		MsgExpectedAddress:     "Adresse attendue, comme localhost:7000, suivie d'un nom facultatif",
+		MsgPeerJoined:          "%v a rejoint la session",
+		MsgPeerLeft:            "%v a quitté la session",
+		MsgRecording:           "Enregistrement vers %v",
+		MsgRecordingSaved:      "%v images enregistrées dans %v",
+		MsgRecordingFailed:     "Échec de l'enregistrement vidéo : %v",
+		MsgRecordingResized:    "La fenêtre a été redimensionnée, enregistrement arrêté",
+		MsgCollabDenied:        "L'hôte de la session a refusé le jeton ou le nom",
+		MsgCollabReadOnly:      "Session rejointe en lecture seule, les modifications des groupes ne sont pas partagées",
+		MsgLatticeType:         "Type de réseau %v, %v cellules",
+		MsgUnknownLatticeType:  "Type de réseau inconnu %q, disponibles : %v",
+		MsgLightmapGrid:        "L'éclairage précalculé nécessite un réseau sur la grille cubique non mise à l'échelle",
+		MsgCIFLoaded:           "%v atomes chargés depuis %v",
+		MsgExpectedSupercell:   "Taille de supermaille attendue, comme 3 ou 2x2x4",
+		MsgScriptFailed:        "Script %v arrêté à la ligne %v : %v",
+		MsgScriptDepth:         "Les scripts peuvent exécuter d'autres scripts sur %v niveaux au plus",
+		MsgExpectedWait:        "Nombre positif ou nul de secondes attendu",
+		MsgNoScript:            "Seuls les scripts peuvent attendre",
+		MsgExpectedRecord:      "start [motion] ou stop attendu",
+		MsgWatching:            "%v cellules de %v affichées, rechargées à chaque modification",
+		MsgWatchStopped:        "%v n'est plus surveillé",
+		MsgNotWatching:         "Aucun fichier n'est surveillé",
+		MsgMoleculeLoaded:      "%v atomes chargés depuis %v",
+		MsgExpectedShake:       "Trauma de 0 à 1 attendu, suivi éventuellement de l'amplitude en degrés et de la décroissance par seconde",
+		MsgCellShape:           "Cellules dessinées en %v",
+		MsgUnknownShape:        "Forme %q inconnue, cube ou sphere attendu",
+		MsgCinemaOn:            "Mode cinéma en %.2f:1 à %v images par seconde",
+		MsgCinemaOff:           "Mode cinéma désactivé",
+		MsgCinemaRecording:     "Le mode cinéma ne peut pas changer pendant un enregistrement",
+		MsgExpectedCinema:      "on ou off attendu, suivi éventuellement d'un format tel que 2.39:1",
+		MsgCellOverlay:         "Arêtes des mailles : %v",
+		MsgNoUnitCell:          "Cette structure n'a pas de maille à dessiner",
+		MsgUnknownOverlay:      "Affichage des mailles %q inconnu, off, unit ou super attendu",
+		MsgMillerPlane:         "Plan (%v) affiché",
+		MsgPlaneHidden:         "Plan masqué",
+		MsgExpectedMiller:      "Indices de Miller tels que 1 1 1 ou 1-10 attendus, ou off",
+		MsgMillerPrompt:        "Indices de Miller (h k l) : ",
+		MsgBeautyOn:            "Mode beauté activé : les vues fixes moyennent jusqu'à %v images décalées",
+		MsgBeautyOff:           "Mode beauté désactivé",
+		MsgExpectedBeauty:      "on ou off attendu",
+		MsgExpectedScreenshot:  "Rien ou transparent attendu",
+		MsgNoCrystal:           "Aucune structure cristalline chargée, ouvrez-en une avec cif <fichier>",
+		MsgSupercell:           "Supermaille de %vx%vx%v mailles, %v atomes",
+		MsgStyle:               "Style %v",
+		MsgUnknownStyle:        "Style inconnu %q",
+		MsgDefects:             "%v lacunes et %v interstitiels injectés, graine %v",
+		MsgDefectsOff:          "Défauts ponctuels retirés",
+		MsgExpectedDefects:     "Fractions de lacunes et d'interstitiels et une graine, ou off, attendues",
+		MsgColormap:            "Cellules colorées selon %v avec %v",
+		MsgColormapOff:         "Les cellules montrent leurs propres couleurs",
+		MsgUnknownColormap:     "Palette inconnue %q",
+		MsgFade:                "Cellules estompées selon la densité sur %d pas, jusqu'à %.0f%%",
+		MsgFadeOff:             "Estompage par densité désactivé",
+		MsgExpectedFade:        "Rayon de voisinage et intensité, ou off, attendus",
+		MsgClip:                "Plan de coupe %v",
+		MsgClipOff:             "Plan de coupe désactivé",
+		MsgExpectedClip:        "Normale x y z et décalage, view ou off, attendus",
+		MsgTrails:              "Traînées sur les %d dernières modifications",
+		MsgTrailsOff:           "Traînées désactivées",
+		MsgExpectedTrails:      "Longueur de traînée ou off attendue",
+		MsgPickedCell:          "Cellule %d %d %d  couleur #%02x%02x%02x  valeur %.3g",
+		MsgPointing:            "Cliquer sur les cellules pour les choisir, I pour regarder autour",
+		MsgPointingOff:         "Vue libre, les clics choisissent la cellule au centre",
+		MsgEditing:             "Édition : clic gauche retire un cube, clic droit en place un",
+		MsgEditingOff:          "Édition désactivée",
+		MsgExpectedEdit:        "on ou off attendu",
+		MsgRemovedCell:         "Cellule %d %d %d retirée",
+		MsgPlacedCell:          "Cellule %d %d %d placée",
+		MsgOutsideLattice:      "La cellule %d %d %d est hors du réseau",
+		MsgColorRange:          "Plage de palette %v, %.3g %% écrêtés à chaque extrémité",
+		MsgExpectedColorRange:  "linear ou equalize et un pourcentage d'écrêtage attendus",
+		MsgSizeField:           "Taille des cellules selon %v, jusqu'à %.2g",
+		MsgSizeFieldOff:        "Les cellules montrent leur propre taille",
+		MsgExpectedSizeField:   "Champ ou off et taille minimale de 0 à 1 attendus",
+		MsgSceneSaved:          "Scène enregistrée dans %v",
+		MsgSceneLoaded:         "Scène chargée depuis %v",
+		MsgKeyframe:            "Image clé %v à %.1f s",
+		MsgPathTooShort:        "Un chemin de caméra demande au moins deux images clés",
+		MsgPathPlaying:         "Lecture de %v images clés sur %.1f s",
+		MsgPathStopped:         "Chemin de caméra arrêté",
+		MsgPathCleared:         "Chemin de caméra effacé",
+		MsgPathSaved:           "%v images clés enregistrées dans %v",
+		MsgPathLoaded:          "%v images clés chargées depuis %v",
+		MsgExpectedPath:        "add [<secondes>], play, stop, clear, save <fichier> ou load <fichier> attendu",
+		MsgMoreCategories:      "%v de plus",
+		MsgDataKind:            "Données colorées comme %v",
+		MsgExpectedDataKind:    "auto, continuous ou categorical attendu",
+		MsgTimeLapseFrame:      "Image %v sur %v à l'étape %v",
+		MsgCheckpoint:          "Point de reprise de l'étape %v enregistré dans %v",
+		MsgResumed:             "Reprise après l'étape %v depuis %v",
+		MsgGPUTimes:            "GPU : simulation %.2f ms, ombres %.2f ms, réseau %.2f ms, post-traitement %.2f ms, HUD %.2f ms",
+		MsgGPUSim:              "Simulation GPU %v sur %d³ cellules, %d pas par image",
+		MsgGPUSimOff:           "Simulation GPU désactivée",
+		MsgExpectedGPUSim:      "off, life ou grayscott, un côté de grille et des pas par image attendus",
+		MsgLifeStats:           "Life : pas %d, %d³ cellules, %.1f %% vivantes",
+		MsgGrayScottStats:      "Gray-Scott : pas %d, %d³ cellules, moyenne U %.4f, V %.4f",
+		MsgRefined:             "%d cellules raffinées %d fois selon chaque axe",
+		MsgMerged:              "Sous-cellules de %d cellules fusionnées",
+		MsgExpectedRefine:      "2, 4 ou off attendu",
+		MsgNothingSelected:     "Rien n'est sélectionné",
+		MsgWireframe:           "Fil de fer aux couleurs plates des cellules",
+		MsgWireframeOff:        "Fil de fer désactivé",
+		MsgExpectedWireframe:   "on ou off attendu",
+		MsgPickedAt:            "À %v",
+		MsgPickedDistance:      "%v de la cellule choisie avant",
+		MsgAxes:                "Axes des données %v en haut, %v",
+		MsgExpectedAxes:        "y ou z attendu, suivi éventuellement de right ou left",
+		MsgUnits:               "Un pas de grille vaut %v",
+		MsgUnitsOff:            "Longueurs en unités du monde",
+		MsgExpectedUnits:       "Longueur positive par cellule comme 0.5nm, ou off attendu",
+		MsgMeasured:            "%d cellules s'étendent sur %v par %v par %v, %v en diagonale",
+		MsgAlpha:               "Cubes opaques à %.0f %%, dessinés de l'arrière vers l'avant",
+		MsgAlphaOff:            "Cubes opaques",
+		MsgExpectedAlpha:       "Alpha de 0 à 1 attendu",
+		MsgFog:                 "Brouillard de densité %.3g",
+		MsgFogOff:              "Brouillard désactivé",
+		MsgExpectedFog:         "Densité d'au moins 0 attendue, suivie éventuellement de <r> <g> <b>, ou off",
+		MsgBreadcrumbs:         "Miettes de pain toutes les %.3g unités",
+		MsgBreadcrumbsOff:      "Plus de miettes de pain, %d gardées",
+		MsgBreadcrumbsCleared:  "Miettes de pain effacées",
+		MsgExpectedBreadcrumbs: "on avec un espacement positif facultatif, off ou clear attendu",
+		MsgExpectedCompass:     "on ou off attendu",
+		MsgDepthRange:          "Plan proche à %.3g, plan lointain à %.3g",
+		MsgExpectedDepthRange:  "<proche> <lointain> attendu avec 0 < proche < lointain",
+		MsgFitted:              "Réseau cadré, %v de diamètre, plan proche à %.3g, plan lointain à %.3g",
+		MsgExpectedMinimap:     "on ou off attendu",
+		MsgViewLinkCopied:      "Lien de vue %v copié",
+		MsgViewLinkOpened:      "Lien de vue ouvert",
+		MsgNoViewLink:          "Aucun lien de vue dans le presse-papiers",
+		MsgDemoRecording:       "Enregistrement de la démo dans %v",
+		MsgDemoSaved:           "Démo de %v images enregistrée dans %v",
+		MsgDemoPlaying:         "Lecture de la démo %v, %v images",
+		MsgDemoEnded:           "Démo %v terminée",
+		MsgDemoFailed:          "Échec de la démo : %v",
+		MsgNoDemo:              "Aucune démo n'est enregistrée ou lue",
+		MsgExpectedDemo:        "record <fichier> [<fps>] avec fps supérieur à 0, play <fichier> ou stop attendu",
+		MsgPostEffects:         "Post-traitement : %v",
+		MsgPostEffectsOff:      "Post-traitement désactivé",
+		MsgNoPostEffect:        "Aucun effet de post-traitement %v",
+		MsgExpectedPost:        "gamma <gamma> supérieur à 0, vignette <intensité> [<rayon> entre 0 et 1], bloom [<intensité> [<seuil>]], ssao [<intensité> [<rayon> supérieur à 0]], fxaa, remove <effet> ou off attendu",
+		MsgContextRestored:     "Le contexte GL a été perdu ; rendu reconstruit dans un nouveau contexte",
+		MsgReloading:           "Nouvelle exécution de %v",
+		MsgNoReload:            "Aucun script de démarrage ni scène à recharger ; démarrer avec -exec ou -load",
+		MsgFXAAFallback:        "Le multiéchantillonnage à %v échantillons n'est pas disponible ; lissage des bords par FXAA à la place",
+		MsgLayers:              "Calques affichés : %v ; capturés : %v",
+		MsgExpectedLayer:       "Calque background|lattice|overlays|debug puis on|off ou capture on|off attendu",
+		MsgTextures:            "Cellules texturées avec %v images",
+		MsgTexturesOff:         "Textures des cellules désactivées",
+		MsgExpectedTexture:     "texture <fichier>... ou off attendu",
+		MsgShaderHooks:         "Hooks de shader : %v",
+		MsgExpectedHook:        "hook color <fichier>|off, hook displace <fichier> [<borne>]|off ou hook off attendu",
+		MsgMaterials:           "%v règles de matériau donnent leurs textures aux sites",
+		MsgMaterialsOff:        "Matériaux désactivés, les sites prennent les textures à tour de rôle",
+		MsgExpectedMaterial:    "material <couche> element <symbole>|value <valeur>|selection ou material off attendu",
+		MsgUnknownElement:      "Élément %q inconnu",
+		MsgDisplace:            "Cellules déplacées jusqu'à %v, fréquence %v, vitesse %v",
+		MsgDisplaceOff:         "Déplacement désactivé",
+		MsgExpectedDisplace:    "displace <amplitude> [<fréquence> [<vitesse> [<image>]]] ou off attendu",
+		MsgLatticeTooLarge:     "Un réseau de %v cellules de côté est trop grand, les cellules sont numérotées sur 32 bits pour au plus %v de côté",
+		MsgSceneReloaded:       "Modifications de %v appliquées",
+	},
+}
+
+// fallbackLocale formats the errors returned where no locale is at hand,
+// such as by the loaders.
+var fallbackLocale = Locale{name: defaultLocale, messages: catalogs[defaultLocale]}
+
+// Locale formats user-facing messages in one language.
+type Locale struct {
+	name     string
+	messages map[Message]string
+}
+
+// NewLocale returns the locale for a name such as "de" or "de_DE.UTF-8".
+func NewLocale(name string) (Locale, bool) {
+	lang := strings.ToLower(name)
+	if i := strings.IndexAny(lang, "_-."); i >= 0 {
+		lang = lang[:i]
+	}
+	messages, ok := catalogs[lang]
+	if !ok {
+		return Locale{name: defaultLocale, messages: catalogs[defaultLocale]}, false
+	}
+	return Locale{name: lang, messages: messages}, true
+}
+
+// DetectLocale returns the locale configured in the environment, using the
+// same variables as gettext.
+func DetectLocale() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(env); v != "" && v != "C" && v != "POSIX" {
+			return v
+		}
+	}
+	return defaultLocale
+}
+
+// Locales returns the names of the available locales.
+func Locales() []string {
+	names := make([]string, 0, len(catalogs))
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l Locale) String() string {
+	return l.name
+}
+
+// Sprintf formats msg in the language of l, falling back to English.
+func (l Locale) Sprintf(msg Message, args ...interface{}) string {
+	format, ok := l.messages[msg]
+	if !ok {
+		format = catalogs[defaultLocale][msg]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Errorf is like Sprintf but returns an error.
+func (l Locale) Errorf(msg Message, args ...interface{}) error {
+	return errors.New(l.Sprintf(msg, args...))
+}
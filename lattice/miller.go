@@ -0,0 +1,277 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// ParseMiller parses Miller indices h k l, separated by spaces or commas
+// and optionally in parentheses, or written together as single digits
+// such as 111 or 1-10.
+func ParseMiller(s string) ([3]int, error) {
+	var hkl [3]int
+	indices := strings.Trim(strings.TrimSpace(s), "()")
+	fields := strings.FieldsFunc(indices, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) == 1 {
+		fields = nil
+		sign := ""
+		for _, r := range indices {
+			if r == '-' && sign == "" {
+				sign = "-"
+				continue
+			}
+			fields = append(fields, sign+string(r))
+			sign = ""
+		}
+	}
+	if len(fields) != 3 {
+		return hkl, fmt.Errorf("invalid Miller indices %q", s)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return hkl, fmt.Errorf("invalid Miller indices %q", s)
+		}
+		hkl[i] = n
+	}
+	if hkl == [3]int{} {
+		return hkl, fmt.Errorf("invalid Miller indices %q", s)
+	}
+	return hkl, nil
+}
+
+// millerString formats Miller indices as ParseMiller reads them.
+func millerString(hkl [3]int) string {
+	return fmt.Sprintf("%d %d %d", hkl[0], hkl[1], hkl[2])
+}
+
+// millerRune reports whether r may be part of Miller indices.
+func millerRune(r rune) bool {
+	return r >= '0' && r <= '9' || strings.ContainsRune("- ,()", r)
+}
+
+// millerPolygon returns the corners, in order around it, of the plane hkl
+// of the home cell of f clipped to the whole of f. The plane cuts the axes
+// of the cell at 1/h, 1/k and 1/l, from the corner of the cell the
+// positive indices count from: a negative index counts from the far end
+// of its axis, so that the plane always crosses the home cell.
+func millerPolygon(f cellFrame, hkl [3]int) []mgl32.Vec3 {
+	a, b, c := f.axes[0], f.axes[1], f.axes[2]
+	n := b.Cross(c).Mul(float32(hkl[0])).
+		Add(c.Cross(a).Mul(float32(hkl[1]))).
+		Add(a.Cross(b).Mul(float32(hkl[2])))
+	origin := f.unit().origin
+	for axis, i := range hkl {
+		if i < 0 {
+			origin = origin.Add(f.axes[axis])
+		}
+	}
+	// The plane is n·x = d: n·(x-origin) is the volume of the cell at the
+	// intercepts.
+	d := n.Dot(origin) + a.Dot(b.Cross(c))
+
+	var corners [cornersPerCube]mgl32.Vec3
+	for i := range corners {
+		corners[i] = f.origin
+		for axis := range f.axes {
+			if i&(1<<axis) != 0 {
+				corners[i] = corners[i].Add(f.axes[axis].Mul(float32(f.count[axis])))
+			}
+		}
+	}
+	var points []mgl32.Vec3
+	for i, p := range corners {
+		for axis := range f.axes {
+			if i&(1<<axis) != 0 {
+				continue
+			}
+			q := corners[i|1<<axis]
+			sp, sq := n.Dot(p)-d, n.Dot(q)-d
+			if (sp < 0) != (sq < 0) {
+				points = append(points, p.Add(q.Sub(p).Mul(sp/(sp-sq))))
+			}
+		}
+	}
+	if len(points) < 3 {
+		return nil
+	}
+
+	var center mgl32.Vec3
+	for _, p := range points {
+		center = center.Add(p)
+	}
+	center = center.Mul(1 / float32(len(points)))
+	u := points[0].Sub(center).Normalize()
+	v := n.Normalize().Cross(u)
+	angle := func(p mgl32.Vec3) float64 {
+		p = p.Sub(center)
+		return math.Atan2(float64(p.Dot(v)), float64(p.Dot(u)))
+	}
+	sort.Slice(points, func(i, j int) bool { return angle(points[i]) < angle(points[j]) })
+	return points
+}
+
+// Colors of the plane and its outline.
+var (
+	planeColor   = mgl32.Vec4{0.3, 0.75, 1, 0.35}
+	outlineColor = mgl32.Vec4{0.3, 0.75, 1, 1}
+)
+
+// planeRenderer draws a crystallographic plane as a translucent polygon
+// through the cells, outlined where it meets the bounds of the cells.
+type planeRenderer struct {
+	program uint32
+	vao     uint32
+	vbo     uint32
+
+	projectionUniform int32
+	cameraUniform     int32
+	colorUniform      int32
+}
+
+func newPlaneRenderer() (*planeRenderer, error) {
+	program, err := glutil.NewProgram(planeVertexShader, planeFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	p := &planeRenderer{
+		program:           program,
+		projectionUniform: gl.GetUniformLocation(program, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(program, gl.Str("camera\x00")),
+		colorUniform:      gl.GetUniformLocation(program, gl.Str("color\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &p.vao)
+	gl.BindVertexArray(p.vao)
+	gl.GenBuffers(1, &p.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, p.vbo)
+	vert := uint32(gl.GetAttribLocation(program, gl.Str("vert\x00")))
+	gl.EnableVertexAttribArray(vert)
+	gl.VertexAttribPointerWithOffset(vert, 3, gl.FLOAT, false, 3*4, 0)
+	gl.BindVertexArray(uint32(prevVAO))
+	return p, nil
+}
+
+// Render draws the plane hkl of the unit cells of f as seen through
+// projection and camera. The plane is depth tested against the cells but
+// does not hide the ones behind it.
+func (p *planeRenderer) Render(projection, camera mgl32.Mat4, f cellFrame, hkl [3]int) {
+	polygon := millerPolygon(f, hkl)
+	if polygon == nil {
+		return
+	}
+	verts := make([]float32, 0, len(polygon)*3)
+	for _, v := range polygon {
+		verts = append(verts, v[0], v[1], v[2])
+	}
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.UseProgram(p.program)
+	gl.UniformMatrix4fv(p.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(p.cameraUniform, 1, false, &camera[0])
+	gl.BindVertexArray(p.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, p.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(verts)*4, gl.Ptr(verts), gl.STREAM_DRAW)
+
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.DepthMask(false)
+	gl.Uniform4fv(p.colorUniform, 1, &planeColor[0])
+	gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(polygon)))
+	gl.DepthMask(true)
+	gl.Disable(gl.BLEND)
+	gl.Uniform4fv(p.colorUniform, 1, &outlineColor[0])
+	gl.DrawArrays(gl.LINE_LOOP, 0, int32(len(polygon)))
+}
+
+// Delete releases the GL objects of the renderer.
+func (p *planeRenderer) Delete() {
+	gl.DeleteBuffers(1, &p.vbo)
+	gl.DeleteVertexArrays(1, &p.vao)
+	gl.DeleteProgram(p.program)
+}
+
+// ShowPlane draws the crystallographic plane with Miller indices hkl
+// relative to the unit cell the lattice repeats, as the cell overlay
+// shows it.
+func (r *Renderer) ShowPlane(hkl [3]int) error {
+	if r.params.cellFrame().empty() {
+		return r.locale.Errorf(MsgNoUnitCell)
+	}
+	if r.planes == nil {
+		p, err := newPlaneRenderer()
+		if err != nil {
+			return err
+		}
+		r.planes = p
+	}
+	r.plane = hkl
+	r.Notify(MsgMillerPlane, millerString(hkl))
+	return nil
+}
+
+// HidePlane stops drawing the crystallographic plane.
+func (r *Renderer) HidePlane() {
+	r.plane = [3]int{}
+	r.Notify(MsgPlaneHidden)
+}
+
+// PromptPlane asks for the Miller indices of the plane to show on the
+// HUD. Submitting no indices hides the plane.
+func (r *Renderer) PromptPlane() {
+	r.openPrompt(r.locale.Sprintf(MsgMillerPrompt), millerRune, func(r *Renderer, text string) error {
+		if strings.TrimSpace(text) == "" {
+			r.HidePlane()
+			return nil
+		}
+		hkl, err := ParseMiller(text)
+		if err != nil {
+			return r.locale.Errorf(MsgExpectedMiller)
+		}
+		return r.ShowPlane(hkl)
+	})
+}
+
+var planeVertexShader = `
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+
+in vec3 vert;
+
+void main() {
+    gl_Position = projection * camera * vec4(vert, 1);
+}
+` + "\x00"
+
+var planeFragmentShader = `
+#version 330
+
+uniform vec4 color;
+
+out vec4 outputColor;
+
+void main() {
+    outputColor = color;
+}
+` + "\x00"
@@ -0,0 +1,140 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+// minimapSize is the side of the minimap in HUD pixels before scaling.
+const minimapSize = 180
+
+var (
+	minimapBack        = mgl32.Vec3{0.08, 0.08, 0.1}
+	minimapBorder      = mgl32.Vec4{1, 1, 1, 0.6}
+	minimapBoundsColor = mgl32.Vec3{0.8, 0.8, 0.8}
+	minimapCameraColor = mgl32.Vec3{1, 0.8, 0.2}
+	// minimapSettings draw the lattice in the minimap lit, but without
+	// the costlier effects.
+	minimapSettings = RenderSettings{Name: "minimap", Animate: true, Shading: true}
+)
+
+// ToggleMinimap shows or hides the minimap.
+func (r *Renderer) ToggleMinimap() {
+	r.showMinimap = !r.showMinimap
+}
+
+// minimapRect returns the position and side of the minimap in HUD
+// coordinates, against the right edge halfway down a screen of the given
+// size.
+func minimapRect(width, height int, scale float32) (x, y, side float32) {
+	side = minimapSize * scale
+	return float32(width) - side - 8*scale, (float32(height) - side) / 2, side
+}
+
+// addMinimap queues the border of the minimap.
+func (r *Renderer) addMinimap(t *hud.Text, width, height int, scale float32) {
+	if !r.showMinimap {
+		return
+	}
+	x, y, side := minimapRect(width, height, scale)
+	b := scale
+	t.AddRect(x-b, y-b, side+2*b, b, minimapBorder)
+	t.AddRect(x-b, y+side, side+2*b, b, minimapBorder)
+	t.AddRect(x-b, y, b, side, minimapBorder)
+	t.AddRect(x+side, y, b, side, minimapBorder)
+}
+
+// drawMinimap draws the minimap in a second, lighter pass: the whole
+// lattice seen from above along the up axis of the data through an
+// orthographic projection, the box around it and the frustum of the
+// camera.
+func (r *Renderer) drawMinimap(width, height int, scale float32) {
+	if !r.showMinimap {
+		return
+	}
+	x, y, side := minimapRect(width, height, scale)
+	vx, vy, vs := int32(x), int32(float32(height)-y-side), int32(side)
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+	gl.Viewport(vx, vy, vs, vs)
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Scissor(vx, vy, vs, vs)
+	gl.ClearColor(minimapBack[0], minimapBack[1], minimapBack[2], 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	r.styles[r.style].clearColor()
+	defer gl.Disable(gl.SCISSOR_TEST)
+
+	// The view looks down at the center of the lattice from above, with
+	// the far side of the display frame at the top.
+	center, radius := r.boundingSphere()
+	radius *= 1.1
+	top := r.displayPos(center)
+	view := mgl32.LookAtV(top.Add(mgl32.Vec3{0, 2 * radius, 0}), top, mgl32.Vec3{0, 0, -1})
+	projection := mgl32.Ortho(-radius, radius, -radius, radius, radius/100, 4*radius)
+	camera := view.Mul4(r.frame)
+
+	gl.UseProgram(r.program)
+	gl.BindVertexArray(r.vao)
+	gl.UniformMatrix4fv(r.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(r.cameraUniform, 1, false, &camera[0])
+	draws, fog := r.draws, r.fog
+	r.draws, r.fog = r.casters, Fog{}
+	r.drawLattice(minimapSettings)
+	r.draws, r.fog = draws, fog
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.Enable(gl.MULTISAMPLE)
+	gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+	gl.UniformMatrix4fv(r.cameraUniform, 1, false, &r.camera[0])
+
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	if lo, hi, ok := r.meshBounds(); ok {
+		lines := boxLines(lo, hi)
+		colors := make([]mgl32.Vec3, len(lines)/2)
+		for i := range colors {
+			colors[i] = minimapBoundsColor
+		}
+		r.lines.Render(projection, camera, lines, colors)
+	}
+	// The frustum is in the display frame, as the camera position is.
+	_, _, w, h := r.viewport()
+	verts := frustumLines(collabCamera{Pos: r.camPos, Pitch: r.pitch, Yaw: r.yaw, FOV: r.fov, Aspect: float32(w) / float32(h)}, radius/4)
+	lines := make([]mgl32.Vec3, len(verts)/3)
+	colors := make([]mgl32.Vec3, len(lines)/2)
+	for i := range lines {
+		lines[i] = mgl32.Vec3{verts[3*i], verts[3*i+1], verts[3*i+2]}
+	}
+	for i := range colors {
+		colors[i] = minimapCameraColor
+	}
+	r.lines.Render(projection, view, lines, colors)
+}
+
+// boxLines returns the line vertices of the edges of the box from lo to
+// hi.
+func boxLines(lo, hi mgl32.Vec3) []mgl32.Vec3 {
+	corner := func(i int) mgl32.Vec3 {
+		c := lo
+		for axis := range c {
+			if i&(1<<axis) != 0 {
+				c[axis] = hi[axis]
+			}
+		}
+		return c
+	}
+	var lines []mgl32.Vec3
+	for i := 0; i < cornersPerCube; i++ {
+		for axis := 0; axis < 3; axis++ {
+			if i&(1<<axis) == 0 {
+				lines = append(lines, corner(i), corner(i|1<<axis))
+			}
+		}
+	}
+	return lines
+}
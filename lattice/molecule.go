@@ -0,0 +1,276 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// atom is an atom of a molecule file, at a Cartesian position in
+// ångströms.
+type atom struct {
+	element string
+	pos     [3]float64
+}
+
+// LoadMolecule reads the atoms of an XYZ or PDB file, chosen by extension,
+// and places them on the grid like the atoms of a crystal structure. Atoms
+// are colored by element and sized by their van der Waals radius relative
+// to the largest atom, with the atomic number as their value.
+func LoadMolecule(path string, p LatticeParams) ([]cell, LatticeParams, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, p, err
+	}
+	defer f.Close()
+	var atoms []atom
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".xyz":
+		atoms, err = parseXYZ(f, path)
+	case ".pdb", ".ent":
+		atoms, err = parsePDB(f, path)
+	default:
+		return nil, p, fmt.Errorf("%v: unknown molecule format %q", path, ext)
+	}
+	if err != nil {
+		return nil, p, err
+	}
+	if len(atoms) == 0 {
+		return nil, p, fmt.Errorf("%v: no atoms", path)
+	}
+	cells, p := moleculeCells(atoms, p)
+	if err := p.checkSize(fallbackLocale); err != nil {
+		return nil, p, fmt.Errorf("%v: %v", path, err)
+	}
+	return cells, p, nil
+}
+
+// parseXYZ reads the first frame of an XYZ file: the number of atoms, a
+// comment line, then an element symbol or atomic number and x, y and z on
+// every line.
+func parseXYZ(f io.Reader, path string) ([]atom, error) {
+	sc := bufio.NewScanner(f)
+	line := 0
+	next := func() (string, bool) {
+		line++
+		if !sc.Scan() {
+			return "", false
+		}
+		return sc.Text(), true
+	}
+	header, ok := next()
+	if !ok {
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%v: empty file", path)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%v:1: expected the number of atoms", path)
+	}
+	next()
+	// The count is not trusted to size the slice: a damaged one would
+	// allocate all memory before the atoms run out.
+	var atoms []atom
+	for len(atoms) < n {
+		text, ok := next()
+		if !ok {
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%v: expected %v atoms, found %v", path, n, len(atoms))
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%v:%v: expected an element and 3 coordinates", path, line)
+		}
+		a := atom{element: xyzElement(fields[0])}
+		for i := range a.pos {
+			if a.pos[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+			}
+			if math.IsNaN(a.pos[i]) || math.IsInf(a.pos[i], 0) {
+				return nil, fmt.Errorf("%v:%v: invalid coordinate %v", path, line, fields[i+1])
+			}
+		}
+		atoms = append(atoms, a)
+	}
+	return atoms, nil
+}
+
+// xyzElement returns the element of the first column of an XYZ file,
+// which some programs write as the atomic number.
+func xyzElement(s string) string {
+	if z, err := strconv.Atoi(s); err == nil {
+		for symbol, n := range elementNumbers {
+			if n == z {
+				return symbol
+			}
+		}
+	}
+	return elementSymbol(s)
+}
+
+// parsePDB reads the ATOM and HETATM records of the first model of a PDB
+// file.
+func parsePDB(f io.Reader, path string) ([]atom, error) {
+	sc := bufio.NewScanner(f)
+	var atoms []atom
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		record := strings.TrimSpace(pdbColumns(text, 1, 6))
+		if record == "ENDMDL" {
+			break
+		}
+		if record != "ATOM" && record != "HETATM" {
+			continue
+		}
+		var a atom
+		for i, cols := range [3][2]int{{31, 38}, {39, 46}, {47, 54}} {
+			v, err := strconv.ParseFloat(strings.TrimSpace(pdbColumns(text, cols[0], cols[1])), 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("%v:%v: invalid coordinate", path, line)
+			}
+			a.pos[i] = v
+		}
+		a.element = pdbElement(pdbColumns(text, 77, 78), pdbColumns(text, 13, 16))
+		atoms = append(atoms, a)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return atoms, nil
+}
+
+// pdbColumns returns the columns from to to of a fixed width record,
+// counted from 1 as in the PDB format description, shorter if the line
+// ends early.
+func pdbColumns(line string, from, to int) string {
+	if from > len(line) {
+		return ""
+	}
+	if to > len(line) {
+		to = len(line)
+	}
+	return line[from-1 : to]
+}
+
+// pdbElement returns the element of an atom from its element column or,
+// in files leaving that empty, from its atom name. Names align the element
+// symbol to the first two columns, so that a leading space marks a one
+// letter element.
+func pdbElement(element, name string) string {
+	if e := strings.TrimSpace(element); e != "" {
+		return elementSymbol(e)
+	}
+	if len(name) >= 2 && name[0] != ' ' && (name[0] < '0' || name[0] > '9') {
+		return elementSymbol(name[:2])
+	}
+	if name = strings.TrimLeft(name, " 0123456789"); name != "" {
+		return elementSymbol(name[:1])
+	}
+	return name
+}
+
+// moleculeCells places atoms on the grid, centered on the origin and
+// scaled so that the closest atoms are cifSpacing apart, so that no two
+// cubes overlap.
+func moleculeCells(atoms []atom, p LatticeParams) ([]cell, LatticeParams) {
+	scale := cifSpacing / closestPair(atoms)
+	if math.IsInf(scale, 0) || scale == 0 {
+		scale = 1
+	}
+	var lo, hi [3]float64
+	maxRadius := 0.0
+	for i, a := range atoms {
+		for k := range a.pos {
+			if i == 0 || a.pos[k] < lo[k] {
+				lo[k] = a.pos[k]
+			}
+			if i == 0 || a.pos[k] > hi[k] {
+				hi[k] = a.pos[k]
+			}
+		}
+		maxRadius = math.Max(maxRadius, vdwRadius(a.element))
+	}
+
+	p.Type = SimpleCubic
+	p.Scale = 1.0 / cifGridSteps
+	p.HalfSize = 0
+	p.crystal = cellFrame{}
+	cells := make([]cell, 0, len(atoms))
+	for _, a := range atoms {
+		var grid mgl32.Vec3
+		for axis := range grid {
+			g := int(math.Round((a.pos[axis] - (lo[axis]+hi[axis])/2) * scale * cifGridSteps))
+			if abs(g) > p.HalfSize {
+				p.HalfSize = abs(g)
+			}
+			grid[axis] = float32(g)
+		}
+		z, color := elementInfo(a.element)
+		size := float32(vdwRadius(a.element) / maxRadius)
+		cells = append(cells, cell{pos: grid, color: color, value: float32(z), size: size})
+	}
+	assignIDs(cells, p)
+	return cells, p
+}
+
+// closestPair returns the smallest distance between two atoms at
+// different positions. Sorting by x limits the pairs compared to those
+// closer along x than the best distance so far.
+func closestPair(atoms []atom) float64 {
+	sorted := append([]atom(nil), atoms...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].pos[0] < sorted[j].pos[0] })
+	min := math.Inf(1)
+	for i, a := range sorted {
+		for _, b := range sorted[i+1:] {
+			if b.pos[0]-a.pos[0] >= min {
+				break
+			}
+			dx, dy, dz := b.pos[0]-a.pos[0], b.pos[1]-a.pos[1], b.pos[2]-a.pos[2]
+			if d := math.Sqrt(dx*dx + dy*dy + dz*dz); d > 1e-6 && d < min {
+				min = d
+			}
+		}
+	}
+	return min
+}
+
+// defaultVDWRadius is the radius of the elements missing from vdwRadii.
+const defaultVDWRadius = 2.0
+
+// vdwRadius returns the van der Waals radius of an element in ångströms.
+func vdwRadius(symbol string) float64 {
+	if r, ok := vdwRadii[symbol]; ok {
+		return r
+	}
+	return defaultVDWRadius
+}
+
+// vdwRadii are the van der Waals radii of the common elements, after
+// Bondi (1964) and Mantina et al. (2009).
+var vdwRadii = map[string]float64{
+	"H": 1.20, "He": 1.40, "Li": 1.82, "Be": 1.53, "B": 1.92, "C": 1.70,
+	"N": 1.55, "O": 1.52, "F": 1.47, "Ne": 1.54, "Na": 2.27, "Mg": 1.73,
+	"Al": 1.84, "Si": 2.10, "P": 1.80, "S": 1.80, "Cl": 1.75, "Ar": 1.88,
+	"K": 2.75, "Ca": 2.31, "Ni": 1.63, "Cu": 1.40, "Zn": 1.39, "Ga": 1.87,
+	"Ge": 2.11, "As": 1.85, "Se": 1.90, "Br": 1.85, "Kr": 2.02, "Rb": 3.03,
+	"Sr": 2.49, "Pd": 1.63, "Ag": 1.72, "Cd": 1.58, "In": 1.93, "Sn": 2.17,
+	"Sb": 2.06, "Te": 2.06, "I": 1.98, "Xe": 2.16, "Cs": 3.43, "Ba": 2.68,
+	"Pt": 1.75, "Au": 1.66, "Hg": 1.55, "Tl": 1.96, "Pb": 2.02, "Bi": 2.07,
+	"U": 1.86,
+}
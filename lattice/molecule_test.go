@@ -0,0 +1,144 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseXYZ(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []atom
+	}{
+		{
+			"water",
+			"3\nwater\nO 0 0 0.1\nH 0.76 0 -0.5\nh -0.76 0 -0.5\n",
+			[]atom{{"O", [3]float64{0, 0, 0.1}}, {"H", [3]float64{0.76, 0, -0.5}}, {"H", [3]float64{-0.76, 0, -0.5}}},
+		},
+		{
+			"atomic numbers",
+			" 2 \n\n8 1 2 3 extra\n26\t-1\t-2\t-3\n",
+			[]atom{{"O", [3]float64{1, 2, 3}}, {"Fe", [3]float64{-1, -2, -3}}},
+		},
+		{
+			"second frame",
+			"1\nframe 1\nC 0 0 0\n1\nframe 2\nC 1 1 1\n",
+			[]atom{{"C", [3]float64{0, 0, 0}}},
+		},
+		{"no atoms", "0\n", nil},
+	}
+	for _, test := range tests {
+		got, err := parseXYZ(strings.NewReader(test.src), "test.xyz")
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseXYZMalformed(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"empty", "", "test.xyz: empty file"},
+		{"no count", "water\n", "test.xyz:1: expected the number of atoms"},
+		{"negative count", "-1\n\n", "test.xyz:1: expected the number of atoms"},
+		{"too few atoms", "3\n\nO 0 0 0\nH 1 0 0\n", "test.xyz: expected 3 atoms, found 2"},
+		{"huge count", "999999999999\n\nO 0 0 0\n", "test.xyz: expected 999999999999 atoms, found 1"},
+		{"short line", "1\n\nO 0 0\n", "test.xyz:3: expected an element and 3 coordinates"},
+		{"bad coordinate", "1\n\nO 0 zero 0\n", `test.xyz:3: strconv.ParseFloat: parsing "zero": invalid syntax`},
+		{"nan", "1\n\nO 0 NaN 0\n", "test.xyz:3: invalid coordinate NaN"},
+		{"infinite", "1\n\nO 0 0 -Inf\n", "test.xyz:3: invalid coordinate -Inf"},
+	}
+	for _, test := range tests {
+		_, err := parseXYZ(strings.NewReader(test.src), "test.xyz")
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v: parseXYZ error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// pdbAtom returns an ATOM or HETATM record with its fields in the columns
+// of the PDB format.
+func pdbAtom(record, name string, x, y, z float64, element string) string {
+	return fmt.Sprintf("%-6s%5d %-4s%14s%8.3f%8.3f%8.3f%22s%2s", record, 1, name, "", x, y, z, "", element)
+}
+
+func TestParsePDB(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []atom
+	}{
+		{
+			"elements",
+			[]string{
+				"REMARK   1 made up",
+				pdbAtom("ATOM", "FE", 1, 2, 3, "FE"),
+				pdbAtom("HETATM", " CA ", -1.5, 0, 2.25, ""),
+				pdbAtom("HETATM", "CA  ", 0, 0, 0, ""),
+				pdbAtom("ATOM", "1HB ", 0, 1, 0, ""),
+				"TER",
+			},
+			[]atom{
+				{"Fe", [3]float64{1, 2, 3}},
+				{"C", [3]float64{-1.5, 0, 2.25}},
+				{"Ca", [3]float64{0, 0, 0}},
+				{"H", [3]float64{0, 1, 0}},
+			},
+		},
+		{
+			"first model",
+			[]string{
+				"MODEL        1",
+				pdbAtom("ATOM", " N  ", 1, 1, 1, "N"),
+				"ENDMDL",
+				"MODEL        2",
+				pdbAtom("ATOM", " N  ", 2, 2, 2, "N"),
+				"ENDMDL",
+			},
+			[]atom{{"N", [3]float64{1, 1, 1}}},
+		},
+		{"no atoms", []string{"HEADER    NOTHING", "END"}, nil},
+	}
+	for _, test := range tests {
+		src := strings.Join(test.lines, "\n") + "\n"
+		got, err := parsePDB(strings.NewReader(src), "test.pdb")
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParsePDBMalformed(t *testing.T) {
+	nan := strings.Replace(pdbAtom("ATOM", " C  ", 0, 0, 0, "C"), "   0.000", "     NaN", 1)
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"short record", []string{"HEADER", "ATOM      1  C"}, "test.pdb:2: invalid coordinate"},
+		{"bad coordinate", []string{strings.Replace(pdbAtom("ATOM", " C  ", 0, 0, 0, "C"), "   0.000", "   x.000", 1)}, "test.pdb:1: invalid coordinate"},
+		{"nan", []string{nan}, "test.pdb:1: invalid coordinate"},
+	}
+	for _, test := range tests {
+		src := strings.Join(test.lines, "\n") + "\n"
+		_, err := parsePDB(strings.NewReader(src), "test.pdb")
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v: parsePDB error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
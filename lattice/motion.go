@@ -0,0 +1,361 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// MotionPass renders the motion of the lattice on screen since the last
+// frame it rendered, in pixels per pixel, for encoders and frame
+// interpolators to use alongside the recorded colors. The motion follows
+// the camera, the lattice transform, the shift of the cells and their
+// displacement, built in and by a displace hook; cells replaced between
+// frames, such as by a simulation step, do not move.
+type MotionPass struct {
+	program uint32
+	vao     uint32
+	mesh    *Mesh
+	attribs cubeAttribs
+	fbo     uint32
+	tex     uint32
+	depthRb uint32
+
+	width, height int32
+
+	// previous maps the world to clip space as it was in the last frame,
+	// previousModel the lattice to the world and previousTime the time of
+	// the frame, with hasPrevious set once there was one.
+	previous      mgl32.Mat4
+	previousModel mgl32.Mat4
+	previousTime  float32
+	hasPrevious   bool
+
+	currentUniform       int32
+	previousUniform      int32
+	modelUniform         int32
+	previousModelUniform int32
+	timeUniform          int32
+	previousTimeUniform  int32
+	shiftUniform         int32
+	clipUniform          int32
+	viewportUniform      int32
+	displaceUniforms     displaceUniforms
+}
+
+// motionScene is what the motion pass draws: the lattice as the frame
+// shows it, at time, displaced by displacement.
+type motionScene struct {
+	projection, camera, model mgl32.Mat4
+	clip                      mgl32.Vec4
+	draws                     []drawCall
+	time                      float32
+	displacement              Displacement
+}
+
+// NewMotionPass creates the motion pass program and a framebuffer of the
+// given size. The cells are read from mesh and displaced by the code of
+// vsrc, the lattice vertex shader with its hooks.
+func NewMotionPass(vsrc string, mesh *Mesh, width, height int32) (*MotionPass, error) {
+	m := &MotionPass{mesh: mesh, width: width, height: height}
+	if err := m.build(vsrc); err != nil {
+		return nil, err
+	}
+
+	gl.GenTextures(1, &m.tex)
+	gl.BindTexture(gl.TEXTURE_2D, m.tex)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RG32F, width, height, 0, gl.RG, gl.FLOAT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	gl.GenRenderbuffers(1, &m.depthRb)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, m.depthRb)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, width, height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.GenFramebuffers(1, &m.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, m.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, m.tex, 0)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, m.depthRb)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		m.Delete()
+		return nil, errors.New("motion framebuffer is incomplete")
+	}
+	return m, nil
+}
+
+// build builds the program of the pass, displacing the cells with the
+// code of the lattice vertex shader vsrc, in place of the one it had.
+func (m *MotionPass) build(vsrc string) error {
+	src, err := motionVertexShader(vsrc)
+	if err != nil {
+		return err
+	}
+	program, err := glutil.NewProgram(src, motionFragmentShader)
+	if err != nil {
+		return err
+	}
+	if m.program != 0 {
+		gl.DeleteVertexArrays(1, &m.vao)
+		gl.DeleteProgram(m.program)
+	}
+	uniform := func(name string) int32 {
+		return gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+	}
+	m.program = program
+	m.currentUniform = uniform("current")
+	m.previousUniform = uniform("previous")
+	m.modelUniform = uniform("model")
+	m.previousModelUniform = uniform("previousModel")
+	m.timeUniform = uniform("currentTime")
+	m.previousTimeUniform = uniform("previousTime")
+	m.shiftUniform = uniform("shift")
+	m.clipUniform = uniform("clipPlane")
+	m.viewportUniform = uniform("viewport")
+	m.displaceUniforms = displaceUniforms{
+		amplitude: uniform("displaceAmplitude"),
+		frequency: uniform("displaceFrequency"),
+		speed:     uniform("displaceSpeed"),
+	}
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+	gl.UseProgram(program)
+	gl.Uniform1i(uniform("displaceMap"), displaceUnit)
+	gl.GenVertexArrays(1, &m.vao)
+	gl.BindVertexArray(m.vao)
+	m.attribs = m.mesh.attribs(program)
+	return nil
+}
+
+// Render draws the motion of sc since the last frame rendered into the
+// framebuffer of the pass. The first frame does not move.
+func (m *MotionPass) Render(sc motionScene) {
+	current := sc.projection.Mul4(sc.camera)
+	if !m.hasPrevious {
+		m.previous, m.previousModel, m.previousTime = current, sc.model, sc.time
+		m.hasPrevious = true
+	}
+
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, m.fbo)
+	gl.Viewport(0, 0, m.width, m.height)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+
+	still := [4]float32{}
+	gl.ClearBufferfv(gl.COLOR, 0, &still[0])
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+
+	gl.UseProgram(m.program)
+	gl.UniformMatrix4fv(m.currentUniform, 1, false, &current[0])
+	gl.UniformMatrix4fv(m.previousUniform, 1, false, &m.previous[0])
+	gl.UniformMatrix4fv(m.modelUniform, 1, false, &sc.model[0])
+	gl.UniformMatrix4fv(m.previousModelUniform, 1, false, &m.previousModel[0])
+	gl.Uniform1f(m.timeUniform, sc.time)
+	gl.Uniform1f(m.previousTimeUniform, m.previousTime)
+	d := sc.displacement
+	gl.Uniform1f(m.displaceUniforms.amplitude, d.Amplitude)
+	gl.Uniform1f(m.displaceUniforms.frequency, d.Frequency)
+	gl.Uniform1f(m.displaceUniforms.speed, d.Speed)
+	gl.Uniform4f(m.clipUniform, sc.clip[0], sc.clip[1], sc.clip[2], sc.clip[3])
+	gl.Uniform2f(m.viewportUniform, float32(m.width), float32(m.height))
+	defer enableClipping()()
+
+	gl.BindVertexArray(m.vao)
+	for _, d := range sc.draws {
+		gl.Uniform1f(m.shiftUniform, d.shift)
+		m.mesh.Draw(m.attribs, d.first, d.count)
+	}
+	m.previous, m.previousModel, m.previousTime = current, sc.model, sc.time
+}
+
+// read starts reading the motion into the pixel pack buffer pbo, two
+// float32 per pixel, bottom row first.
+func (m *MotionPass) read(pbo uint32) {
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, m.fbo)
+	gl.ReadBuffer(gl.COLOR_ATTACHMENT0)
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, pbo)
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+	gl.ReadPixels(0, 0, m.width, m.height, gl.RG, gl.FLOAT, nil)
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+}
+
+// Delete releases the GL objects owned by the pass.
+func (m *MotionPass) Delete() {
+	gl.DeleteFramebuffers(1, &m.fbo)
+	gl.DeleteTextures(1, &m.tex)
+	gl.DeleteRenderbuffers(1, &m.depthRb)
+	gl.DeleteVertexArrays(1, &m.vao)
+	gl.DeleteProgram(m.program)
+}
+
+// flowTag starts every Middlebury .flo file.
+const flowTag = 202021.25
+
+// writeFlow writes the motion of a frame, two float32 per pixel bottom row
+// first as MotionPass reads it, to path as a Middlebury .flo file: top row
+// first, with y growing downwards. A nil motion writes a frame that does
+// not move.
+func writeFlow(path string, width, height int, motion []float32) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	binary.Write(w, binary.LittleEndian, float32(flowTag))
+	binary.Write(w, binary.LittleEndian, [2]int32{int32(width), int32(height)})
+	row := make([]float32, 2*width)
+	for y := height - 1; y >= 0; y-- {
+		if motion != nil {
+			src := motion[2*width*y:][:2*width]
+			for i := 0; i < len(row); i += 2 {
+				row[i], row[i+1] = src[i], -src[i+1]
+			}
+		}
+		binary.Write(w, binary.LittleEndian, row)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// motionDir returns the directory the motion of the recording path is
+// written to, next to it.
+func motionDir(path string) string {
+	return path[:len(path)-len(filepath.Ext(path))] + ".motion"
+}
+
+// flowName returns the name of the motion file of the recorded frame n,
+// counting from 1 as encoders number frames.
+func flowName(n int) string {
+	return fmt.Sprintf("frame%06d.flo", n)
+}
+
+// bytesToFloats reinterprets the float32 pixels read from a buffer.
+func bytesToFloats(b []byte) []float32 {
+	f := make([]float32, len(b)/4)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return f
+}
+
+// displacementStart and displacementEnd bound the code of the lattice
+// vertex shader that displaces the cells: the displacement map, its
+// cellDisplacement function and the displace function, which a hook may
+// have replaced, up to the main function.
+const (
+	displacementStart = "uniform sampler3D displaceMap;"
+	displacementEnd   = "void main()"
+)
+
+// motionVertexShader returns the vertex shader of the motion pass, which
+// displaces the cells with the code of vsrc, the lattice vertex shader
+// with its hooks, once as of this frame and once as of the last.
+func motionVertexShader(vsrc string) (string, error) {
+	begin := strings.Index(vsrc, displacementStart)
+	end := strings.Index(vsrc, displacementEnd)
+	if begin < 0 || end < begin {
+		return "", fmt.Errorf("%v has no displacement code before its main function", vertexShaderFile)
+	}
+	code := vsrc[begin:end]
+	if !strings.Contains(code, "cellDisplacement(") || !strings.Contains(code, "displace(") {
+		return "", fmt.Errorf("%v does not define cellDisplacement and displace before its main function", vertexShaderFile)
+	}
+	return motionVertexHead + code + motionVertexMain, nil
+}
+
+var motionVertexHead = `
+#version 330
+
+// current and previous map the world to clip space in this frame and the
+// last, model and previousModel the lattice to the world.
+uniform mat4 current;
+uniform mat4 previous;
+uniform mat4 model;
+uniform mat4 previousModel;
+uniform float currentTime;
+uniform float previousTime;
+uniform float shift;
+uniform vec4 clipPlane;
+
+in vec3 vert;
+in vec3 shiftDir;
+in vec3 normal;
+in vec3 offset;
+in float size;
+out vec4 currentPos;
+out vec4 previousPos;
+
+// time is the time the cells are displaced as of, which the displacement
+// code of the lattice shader reads.
+float time;
+`
+
+var motionVertexMain = `
+// displaced returns the world position of the vertex, placed by m and
+// displaced as of t, as the lattice shader places it.
+vec4 displaced(mat4 m, float t) {
+    time = t;
+    vec4 world = m * vec4(shiftDir * shift + vert * size + offset + cellDisplacement(), 1);
+    world.xyz = displace(world.xyz, mat3(m) * normal, offset, t);
+    return world;
+}
+
+void main() {
+    vec4 world = displaced(model, currentTime);
+    currentPos = current * world;
+    previousPos = previous * displaced(previousModel, previousTime);
+    gl_Position = currentPos;
+    gl_ClipDistance[0] = dot(clipPlane, world);
+}
+` + "\x00"
+
+var motionFragmentShader = `
+#version 330
+
+uniform vec2 viewport;
+
+in vec4 currentPos;
+in vec4 previousPos;
+out vec2 motion;
+
+void main() {
+    motion = (currentPos.xy / currentPos.w - previousPos.xy / previousPos.w) * 0.5 * viewport;
+}
+` + "\x00"
@@ -0,0 +1,86 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMotionVertexShader(t *testing.T) {
+	vsrc, _, err := loadShaders("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var displaceHook shaderHookPoint
+	for _, p := range shaderHookPoints {
+		if p.name == "displace" {
+			displaceHook = p
+		}
+	}
+	wave := "vec3 displace(vec3 pos, vec3 normal, vec3 cell, float time) {\n    return pos + normal * sin(time + cell.x);\n}\n"
+	hooked, err := displaceHook.inject(vsrc, wave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, vsrc string
+		want       []string
+	}{
+		{"built in", vsrc, []string{
+			"uniform sampler3D displaceMap;",
+			"uniform float displaceAmplitude;",
+			"vec3 cellDisplacement() {",
+			"vec3 displace(vec3 pos, vec3 normal, vec3 cell, float time) {\n    return pos;\n}",
+		}},
+		{"displace hook", hooked, []string{
+			"vec3 cellDisplacement() {",
+			"return pos + normal * sin(time + cell.x);",
+		}},
+	}
+	for _, test := range tests {
+		src, err := motionVertexShader(test.vsrc)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		for _, s := range test.want {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: motion shader lacks %q", test.name, s)
+			}
+		}
+		// Both frames are displaced, and the lattice shader contributes
+		// nothing past the hook point.
+		if n := strings.Count(src, "cellDisplacement()"); n != 2 {
+			t.Errorf("%v: cellDisplacement appears %v times, want its definition and one call", test.name, n)
+		}
+		if n := strings.Count(src, "displaced("); n != 3 {
+			t.Errorf("%v: displaced appears %v times, want its definition and two calls", test.name, n)
+		}
+		if n := strings.Count(src, "void main()"); n != 1 {
+			t.Errorf("%v: %v main functions, want 1", test.name, n)
+		}
+	}
+}
+
+func TestMotionVertexShaderMalformed(t *testing.T) {
+	vsrc, _, err := loadShaders("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, vsrc string
+	}{
+		{"no displacement map", strings.Replace(vsrc, displacementStart, "", 1)},
+		{"no main", strings.Replace(vsrc, displacementEnd, "", 1)},
+		{"main before map", displacementEnd + "{}\n" + strings.Replace(vsrc, displacementEnd, "", 1)},
+		{"no displace", strings.Replace(vsrc, "vec3 displace(", "vec3 moved(", 1)},
+	}
+	for _, test := range tests {
+		if _, err := motionVertexShader(test.vsrc); err == nil {
+			t.Errorf("%v: got no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// npyMagic starts every NumPy .npy file.
+	npyMagic = "\x93NUMPY"
+	// maxNPYHeader and maxNPYData are the largest header and array, in
+	// bytes, read from a .npy file, so that a damaged length does not
+	// allocate all memory.
+	maxNPYHeader = 1 << 20
+	maxNPYData   = 1 << 30
+)
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// readNPY reads values by grid position from a NumPy .npy array of
+// little-endian or single-byte numbers. A three-dimensional array is a grid
+// of values centered on the origin, element [i, j, k] of an array of shape
+// (a, b, c) lying at x, y, z = i - a/2, j - b/2, k - c/2, rounded down: the
+// grid of the generated lattice of -size n has shape (2n+1, 2n+1, 2n+1).
+// An array of shape (n, 4) lists x, y, z and value rows.
+func readNPY(r io.Reader) (map[[3]int]float32, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic[:len(npyMagic)]) != npyMagic {
+		return nil, errors.New("not a NumPy .npy file")
+	}
+	var headerLen int
+	if major := magic[len(npyMagic)]; major == 1 {
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	} else {
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	}
+	if headerLen > maxNPYHeader {
+		return nil, fmt.Errorf("header of %v bytes is too large", headerLen)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	fortran := npyFortran.FindSubmatch(header)
+	shapeText := npyShape.FindSubmatch(header)
+	if descr == nil || fortran == nil || shapeText == nil {
+		return nil, fmt.Errorf("invalid header %q", header)
+	}
+	read, size, err := npyReader(string(descr[1]))
+	if err != nil {
+		return nil, err
+	}
+	var shape []int
+	for _, s := range strings.Split(string(shapeText[1]), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid shape %q", shapeText[1])
+		}
+		shape = append(shape, n)
+	}
+	// Checking each factor against the bound before multiplying keeps the
+	// count from overflowing.
+	count := 1
+	for _, n := range shape {
+		if n > 0 && count > maxNPYData/size/n {
+			return nil, fmt.Errorf("array of shape %v is larger than %v bytes", shape, maxNPYData)
+		}
+		count *= n
+	}
+	data := make([]byte, count*size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+	reversed := string(fortran[1]) == "True"
+	// at returns the element at the given indices, in C or Fortran order.
+	at := func(indices ...int) float32 {
+		i := 0
+		for k := range indices {
+			if reversed {
+				k = len(indices) - 1 - k
+			}
+			i = i*shape[k] + indices[k]
+		}
+		return read(data[i*size:])
+	}
+
+	values := make(map[[3]int]float32)
+	switch {
+	case len(shape) == 3:
+		for i := 0; i < shape[0]; i++ {
+			for j := 0; j < shape[1]; j++ {
+				for k := 0; k < shape[2]; k++ {
+					values[[3]int{i - shape[0]/2, j - shape[1]/2, k - shape[2]/2}] = at(i, j, k)
+				}
+			}
+		}
+	case len(shape) == 2 && shape[1] == 4:
+		for i := 0; i < shape[0]; i++ {
+			var pos [3]int
+			for axis := range pos {
+				pos[axis] = int(math.Round(float64(at(i, axis))))
+			}
+			values[pos] = at(i, 3)
+		}
+	default:
+		return nil, fmt.Errorf("expected an array of shape (a, b, c) or (n, 4), not %v", shape)
+	}
+	return values, nil
+}
+
+// npyReader returns a function reading a number of the NumPy type descr
+// as a float32 and its size in bytes.
+func npyReader(descr string) (func([]byte) float32, int, error) {
+	le := binary.LittleEndian
+	switch descr {
+	case "<f4":
+		return func(b []byte) float32 { return math.Float32frombits(le.Uint32(b)) }, 4, nil
+	case "<f8":
+		return func(b []byte) float32 { return float32(math.Float64frombits(le.Uint64(b))) }, 8, nil
+	case "|i1":
+		return func(b []byte) float32 { return float32(int8(b[0])) }, 1, nil
+	case "|u1":
+		return func(b []byte) float32 { return float32(b[0]) }, 1, nil
+	case "<i2":
+		return func(b []byte) float32 { return float32(int16(le.Uint16(b))) }, 2, nil
+	case "<u2":
+		return func(b []byte) float32 { return float32(le.Uint16(b)) }, 2, nil
+	case "<i4":
+		return func(b []byte) float32 { return float32(int32(le.Uint32(b))) }, 4, nil
+	case "<u4":
+		return func(b []byte) float32 { return float32(le.Uint32(b)) }, 4, nil
+	case "<i8":
+		return func(b []byte) float32 { return float32(int64(le.Uint64(b))) }, 8, nil
+	case "<u8":
+		return func(b []byte) float32 { return float32(le.Uint64(b)) }, 8, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported element type %q", descr)
+}
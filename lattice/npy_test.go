@@ -0,0 +1,114 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// npyFile returns a .npy file of the given format version with the header
+// and data.
+func npyFile(version byte, header string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(npyMagic)
+	b.Write([]byte{version, 0})
+	if version == 1 {
+		binary.Write(&b, binary.LittleEndian, uint16(len(header)))
+	} else {
+		binary.Write(&b, binary.LittleEndian, uint32(len(header)))
+	}
+	b.WriteString(header)
+	b.Write(data)
+	return b.Bytes()
+}
+
+func npyHeader(descr, fortran, shape string) string {
+	return "{'descr': '" + descr + "', 'fortran_order': " + fortran + ", 'shape': (" + shape + "), }\n"
+}
+
+func float32Bytes(v ...float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func TestReadNPY(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want map[[3]int]float32
+	}{
+		{
+			"grid",
+			npyFile(1, npyHeader("|u1", "False", "2, 2, 2"), []byte{0, 1, 2, 3, 4, 5, 6, 7}),
+			map[[3]int]float32{
+				{-1, -1, -1}: 0, {-1, -1, 0}: 1, {-1, 0, -1}: 2, {-1, 0, 0}: 3,
+				{0, -1, -1}: 4, {0, -1, 0}: 5, {0, 0, -1}: 6, {0, 0, 0}: 7,
+			},
+		},
+		{
+			"fortran order",
+			npyFile(1, npyHeader("|u1", "True", "2, 2, 1"), []byte{0, 1, 2, 3}),
+			map[[3]int]float32{{-1, -1, 0}: 0, {0, -1, 0}: 1, {-1, 0, 0}: 2, {0, 0, 0}: 3},
+		},
+		{
+			"rows",
+			npyFile(2, npyHeader("<f4", "False", "2, 4"), float32Bytes(1, 2, 3, 0.5, -1.4, 0, 2.6, 7)),
+			map[[3]int]float32{{1, 2, 3}: 0.5, {-1, 0, 3}: 7},
+		},
+		{
+			"signed",
+			npyFile(1, npyHeader("<i2", "False", "1, 1, 1"), []byte{0xfe, 0xff}),
+			map[[3]int]float32{{0, 0, 0}: -2},
+		},
+		{
+			"empty",
+			npyFile(1, npyHeader("<f8", "False", "0, 4"), nil),
+			map[[3]int]float32{},
+		},
+	}
+	for _, test := range tests {
+		got, err := readNPY(bytes.NewReader(test.file))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadNPYMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"not npy", []byte("PK\x03\x04 not an array at all")},
+		{"truncated header", npyFile(1, npyHeader("<f4", "False", "1, 4"), nil)[:20]},
+		{"no descr", npyFile(1, "{'fortran_order': False, 'shape': (1, 4), }", float32Bytes(0, 0, 0, 0))},
+		{"big endian", npyFile(1, npyHeader(">f4", "False", "1, 4"), float32Bytes(0, 0, 0, 0))},
+		{"bad shape", npyFile(1, npyHeader("<f4", "False", "1, four"), nil)},
+		{"negative shape", npyFile(1, npyHeader("<f4", "False", "-1, 4"), nil)},
+		{"negative shapes", npyFile(1, npyHeader("|u1", "False", "-2, -2, 1"), []byte{0, 0, 0, 0})},
+		{"overflowing shape", npyFile(1, npyHeader("<f8", "False", "9223372036854775807, 9223372036854775807, 2"), nil)},
+		{"huge shape", npyFile(1, npyHeader("<f4", "False", "100000, 100000, 100000"), nil)},
+		{"huge header", append([]byte(npyMagic+"\x02\x00"), 0, 0, 0, 0x40)},
+		{"truncated data", npyFile(1, npyHeader("<f4", "False", "2, 4"), float32Bytes(1, 2, 3, 4))},
+		{"wrong shape", npyFile(1, npyHeader("|u1", "False", "2, 3"), []byte{0, 0, 0, 0, 0, 0})},
+	}
+	for _, test := range tests {
+		if values, err := readNPY(bytes.NewReader(test.file)); err == nil {
+			t.Errorf("%v: got %v, want an error", test.name, values)
+		}
+	}
+}
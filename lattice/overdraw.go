@@ -0,0 +1,227 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// defaultOverdrawLayers is the fragment count shown in red by the
+// overdraw heat map.
+const defaultOverdrawLayers = 16
+
+// Overdraw counts the fragments rasterized for every pixel into a float
+// texture and shows the counts as a heat map, from blue for a single
+// fragment to red for Layers fragments. Depth testing is off while
+// counting, so the counts include every hidden surface the fragment shader
+// may run for.
+type Overdraw struct {
+	Layers int
+
+	countProgram uint32
+	heatProgram  uint32
+	countVAO     uint32
+	heatVAO      uint32
+	mesh         *Mesh
+	attribs      cubeAttribs
+	fbo          uint32
+	countTex     uint32
+
+	width, height int32
+
+	projectionUniform int32
+	cameraUniform     int32
+	modelUniform      int32
+	shiftUniform      int32
+	layersUniform     int32
+}
+
+// NewOverdraw creates the counting and heat map programs and a count
+// buffer of the given size. The cells are read from mesh.
+func NewOverdraw(mesh *Mesh, width, height int) (*Overdraw, error) {
+	countProgram, err := glutil.NewProgram(countVertexShader, countFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	heatProgram, err := glutil.NewProgram(heatVertexShader, heatFragmentShader)
+	if err != nil {
+		gl.DeleteProgram(countProgram)
+		return nil, err
+	}
+
+	o := &Overdraw{
+		Layers:            defaultOverdrawLayers,
+		countProgram:      countProgram,
+		heatProgram:       heatProgram,
+		mesh:              mesh,
+		projectionUniform: gl.GetUniformLocation(countProgram, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(countProgram, gl.Str("camera\x00")),
+		modelUniform:      gl.GetUniformLocation(countProgram, gl.Str("model\x00")),
+		shiftUniform:      gl.GetUniformLocation(countProgram, gl.Str("shift\x00")),
+		layersUniform:     gl.GetUniformLocation(heatProgram, gl.Str("layers\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &o.countVAO)
+	gl.BindVertexArray(o.countVAO)
+	o.attribs = mesh.attribs(countProgram)
+	// The heat map is a single triangle covering the screen, generated
+	// from the vertex IDs, but core profiles still require a bound VAO.
+	gl.GenVertexArrays(1, &o.heatVAO)
+	gl.BindVertexArray(uint32(prevVAO))
+
+	gl.UseProgram(heatProgram)
+	gl.Uniform1i(gl.GetUniformLocation(heatProgram, gl.Str("counts\x00")), 0)
+
+	gl.GenFramebuffers(1, &o.fbo)
+	gl.GenTextures(1, &o.countTex)
+	if err := o.Resize(width, height); err != nil {
+		o.Delete()
+		return nil, err
+	}
+	return o, nil
+}
+
+// Resize reallocates the count buffer to match the framebuffer size.
+func (o *Overdraw) Resize(width, height int) error {
+	o.width, o.height = int32(width), int32(height)
+
+	gl.BindTexture(gl.TEXTURE_2D, o.countTex)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R16F, o.width, o.height, 0, gl.RED, gl.FLOAT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, o.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, o.countTex, 0)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		return errors.New("overdraw framebuffer is incomplete")
+	}
+	return nil
+}
+
+// Render counts the fragments of draws and draws the heat map over the
+// whole bound framebuffer.
+func (o *Overdraw) Render(projection, camera, model mgl32.Mat4, draws []drawCall) {
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.Viewport(0, 0, o.width, o.height)
+
+	// The heat map goes to whichever framebuffer was bound, the window's
+	// unless rendering offscreen.
+	var target int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &target)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, o.fbo)
+	zero := float32(0)
+	gl.ClearBufferfv(gl.COLOR, 0, &zero)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE)
+
+	gl.UseProgram(o.countProgram)
+	gl.UniformMatrix4fv(o.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(o.cameraUniform, 1, false, &camera[0])
+	gl.UniformMatrix4fv(o.modelUniform, 1, false, &model[0])
+	gl.BindVertexArray(o.countVAO)
+	for _, d := range draws {
+		gl.Uniform1f(o.shiftUniform, d.shift)
+		o.mesh.Draw(o.attribs, d.first, d.count)
+	}
+
+	gl.Disable(gl.BLEND)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(target))
+
+	gl.UseProgram(o.heatProgram)
+	gl.Uniform1f(o.layersUniform, float32(o.Layers))
+	gl.BindTexture(gl.TEXTURE_2D, o.countTex)
+	gl.BindVertexArray(o.heatVAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// Delete releases the GL objects owned by the overdraw view.
+func (o *Overdraw) Delete() {
+	gl.DeleteFramebuffers(1, &o.fbo)
+	gl.DeleteTextures(1, &o.countTex)
+	gl.DeleteVertexArrays(1, &o.countVAO)
+	gl.DeleteVertexArrays(1, &o.heatVAO)
+	gl.DeleteProgram(o.countProgram)
+	gl.DeleteProgram(o.heatProgram)
+}
+
+var countVertexShader = `
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+uniform mat4 model;
+uniform float shift;
+
+in vec3 vert;
+in vec3 shiftDir;
+in vec3 offset;
+in float size;
+
+void main() {
+    gl_Position = projection * camera * model * vec4(shiftDir * shift + vert * size + offset, 1);
+}
+` + "\x00"
+
+var countFragmentShader = `
+#version 330
+
+out float outputCount;
+
+void main() {
+    outputCount = 1;
+}
+` + "\x00"
+
+var heatVertexShader = `
+#version 330
+
+out vec2 fragUV;
+
+void main() {
+    vec2 pos = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2);
+    fragUV = pos;
+    gl_Position = vec4(pos * 2 - 1, 0, 1);
+}
+` + "\x00"
+
+var heatFragmentShader = `
+#version 330
+
+uniform sampler2D counts;
+uniform float layers;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    float count = texture(counts, fragUV).r;
+    if (count < 0.5) {
+        outputColor = vec4(0, 0, 0, 1);
+        return;
+    }
+    // Blue through cyan, green and yellow to red.
+    float t = clamp((count - 1) / max(layers - 1, 1), 0, 1);
+    outputColor = vec4(clamp(1.5 - abs(4 * t - vec3(4, 2, 0)), 0, 1), 1);
+}
+` + "\x00"
@@ -0,0 +1,151 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"image"
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// defaultEyeSeparation is the default Options.EyeSeparation, half the
+// default cube size.
+const defaultEyeSeparation = 0.5
+
+// cubeFace is a face of the cube map a panorama is sampled from, in the
+// frame of a camera looking down -z with +y up.
+type cubeFace struct {
+	forward, up mgl32.Vec3
+}
+
+// right returns the direction to the right of the face.
+func (f cubeFace) right() mgl32.Vec3 {
+	return f.forward.Cross(f.up)
+}
+
+// cubeFaces are the faces of the cube map: the four around the horizon,
+// then the top and the bottom.
+var cubeFaces = [6]cubeFace{
+	{mgl32.Vec3{0, 0, -1}, mgl32.Vec3{0, 1, 0}},
+	{mgl32.Vec3{1, 0, 0}, mgl32.Vec3{0, 1, 0}},
+	{mgl32.Vec3{0, 0, 1}, mgl32.Vec3{0, 1, 0}},
+	{mgl32.Vec3{-1, 0, 0}, mgl32.Vec3{0, 1, 0}},
+	{mgl32.Vec3{0, 1, 0}, mgl32.Vec3{0, 0, 1}},
+	{mgl32.Vec3{0, -1, 0}, mgl32.Vec3{0, 0, -1}},
+}
+
+// panorama renders top-bottom stereo equirectangular frames, the format of
+// VR video: the left eye's 360° view above the right eye's, each twice as
+// wide as high. Every eye renders the six faces of a cube map around the
+// camera, from a position shifted sideways along each face around the
+// horizon, so that depth reads in every direction one looks. The top and
+// bottom faces are seen by both eyes from the camera, as the sideways
+// direction is undefined at the poles.
+type panorama struct {
+	width int
+	// face is the size of the cube map faces, target the framebuffer they
+	// are rendered into.
+	face   int32
+	target *offscreen
+	// lookup maps the pixels of an eye's half of the frame to the cube map:
+	// face index times face*face plus the offset of the pixel in the face.
+	lookup []int32
+}
+
+// newPanorama creates a panorama of frames width pixels wide and high,
+// rendering cube map faces with samples samples per pixel.
+func newPanorama(width int, samples int32) (*panorama, error) {
+	face := int32(width / 4)
+	if face < 1 {
+		face = 1
+	}
+	target, err := newOffscreen(face, face, samples)
+	if err != nil {
+		return nil, err
+	}
+	p := &panorama{width: width, face: face, target: target}
+	p.lookup = equirectLookup(width, width/2, int(face))
+	return p, nil
+}
+
+// Delete frees the framebuffer of the cube map faces.
+func (p *panorama) Delete() {
+	p.target.Delete()
+}
+
+// equirectLookup returns the cube map pixel of every pixel of a width by
+// height equirectangular image, with longitude 0 at the center looking down
+// -z and latitude 90° at the top, sampling the nearest pixel of faces of
+// size face.
+func equirectLookup(width, height, face int) []int32 {
+	lookup := make([]int32, width*height)
+	for j := 0; j < height; j++ {
+		lat := math.Pi/2 - (float64(j)+0.5)/float64(height)*math.Pi
+		for i := 0; i < width; i++ {
+			lon := (float64(i)+0.5)/float64(width)*2*math.Pi - math.Pi
+			d := mgl32.Vec3{
+				float32(math.Cos(lat) * math.Sin(lon)),
+				float32(math.Sin(lat)),
+				float32(-math.Cos(lat) * math.Cos(lon)),
+			}
+			best, dot := 0, float32(-2)
+			for k, f := range cubeFaces {
+				if p := d.Dot(f.forward); p > dot {
+					best, dot = k, p
+				}
+			}
+			f := cubeFaces[best]
+			x, y := d.Dot(f.right())/dot, d.Dot(f.up)/dot
+			col := clampInt(int((x+1)/2*float32(face)), 0, face-1)
+			row := clampInt(int((1-y)/2*float32(face)), 0, face-1)
+			lookup[j*width+i] = int32((best*face+row)*face + col)
+		}
+	}
+	return lookup
+}
+
+// renderPanorama renders a stereo panorama from the camera position,
+// turned to the heading of the camera but level with the horizon, so that
+// the horizon of the video stays where the viewer's is. Camera shake and
+// comparisons are left out.
+func (r *Renderer) renderPanorama(p *panorama) *image.RGBA {
+	camera, projection := r.camera, r.projection
+	r.projection = mgl32.Perspective(math.Pi/2, 1, r.near, r.far)
+	heading := mgl32.QuatRotate(r.yaw, mgl32.Vec3{0, 1, 0})
+	mono := Comparison{A: r.compare.A}
+
+	n := int(p.face)
+	img := image.NewRGBA(image.Rect(0, 0, p.width, p.width))
+	faces := make([]byte, len(cubeFaces)*n*n*4)
+	for eye, side := range []float32{-1, 1} {
+		for k, f := range cubeFaces {
+			pos := r.camPos
+			if f.forward[1] == 0 {
+				pos = pos.Add(heading.Rotate(f.right().Mul(side * r.eyeSeparation / 2)))
+			}
+			r.camera = mgl32.LookAtV(pos, pos.Add(heading.Rotate(f.forward)), heading.Rotate(f.up)).Mul4(r.frame)
+			r.cull()
+
+			gl.BindFramebuffer(gl.FRAMEBUFFER, p.target.fbo)
+			gl.Viewport(0, 0, p.face, p.face)
+			gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+			gl.UseProgram(r.program)
+			gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+			r.renderScene(&mono, 0, 0, p.face, p.face)
+			copy(faces[k*n*n*4:], p.target.read().Pix)
+		}
+		half := img.Pix[eye*len(img.Pix)/2:]
+		for i, src := range p.lookup {
+			copy(half[i*4:i*4+4], faces[src*4:src*4+4])
+		}
+	}
+
+	r.camera, r.projection = camera, projection
+	r.cull()
+	r.updateViewport()
+	return img
+}
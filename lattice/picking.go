@@ -0,0 +1,187 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// noCell is the ID written to the picking buffer where no cell was drawn.
+const noCell = 0
+
+// Picker renders cell IDs into an integer texture so that the cell under
+// a window position can be read back exactly, regardless of how the cell
+// is shaded in the color pass.
+type Picker struct {
+	program uint32
+	vao     uint32
+	mesh    *Mesh
+	attribs cubeAttribs
+	fbo     uint32
+	idTex   uint32
+	depthRb uint32
+
+	width, height int32
+
+	projectionUniform int32
+	cameraUniform     int32
+	modelUniform      int32
+	shiftUniform      int32
+	clipUniform       int32
+}
+
+// NewPicker creates the ID pass program and a framebuffer of the given
+// size. The cells are read from mesh.
+func NewPicker(mesh *Mesh, width, height int) (*Picker, error) {
+	program, err := glutil.NewProgram(idVertexShader, idFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Picker{
+		program:           program,
+		mesh:              mesh,
+		projectionUniform: gl.GetUniformLocation(program, gl.Str("projection\x00")),
+		cameraUniform:     gl.GetUniformLocation(program, gl.Str("camera\x00")),
+		modelUniform:      gl.GetUniformLocation(program, gl.Str("model\x00")),
+		shiftUniform:      gl.GetUniformLocation(program, gl.Str("shift\x00")),
+		clipUniform:       gl.GetUniformLocation(program, gl.Str("clipPlane\x00")),
+	}
+
+	gl.GenVertexArrays(1, &p.vao)
+	gl.BindVertexArray(p.vao)
+	p.attribs = mesh.attribs(program)
+
+	gl.GenFramebuffers(1, &p.fbo)
+	gl.GenTextures(1, &p.idTex)
+	gl.GenRenderbuffers(1, &p.depthRb)
+	if err := p.Resize(width, height); err != nil {
+		p.Delete()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Resize reallocates the picking buffer to match the framebuffer size.
+func (p *Picker) Resize(width, height int) error {
+	p.width, p.height = int32(width), int32(height)
+
+	gl.BindTexture(gl.TEXTURE_2D, p.idTex)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R32UI, p.width, p.height, 0, gl.RED_INTEGER, gl.UNSIGNED_INT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	gl.BindRenderbuffer(gl.RENDERBUFFER, p.depthRb)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, p.width, p.height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, p.idTex, 0)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, p.depthRb)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		return errors.New("picking framebuffer is incomplete")
+	}
+	return nil
+}
+
+// PickAt renders the ID pass and returns the ID of the cell covering the
+// framebuffer pixel (x, y), with y growing downwards. It returns false if
+// no cell is drawn there. Cells cut away by the clipping plane clip, as
+// clipPlane.equation returns it, cannot be picked.
+func (p *Picker) PickAt(x, y int, projection, camera, model mgl32.Mat4, clip mgl32.Vec4, draws []drawCall) (uint32, bool) {
+	if x < 0 || y < 0 || int32(x) >= p.width || int32(y) >= p.height {
+		return noCell, false
+	}
+
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo)
+	gl.Viewport(0, 0, p.width, p.height)
+
+	clearID := uint32(noCell)
+	gl.ClearBufferuiv(gl.COLOR, 0, &clearID)
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+
+	gl.UseProgram(p.program)
+	gl.UniformMatrix4fv(p.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(p.cameraUniform, 1, false, &camera[0])
+	gl.UniformMatrix4fv(p.modelUniform, 1, false, &model[0])
+	gl.Uniform4f(p.clipUniform, clip[0], clip[1], clip[2], clip[3])
+	defer enableClipping()()
+
+	gl.BindVertexArray(p.vao)
+	for _, d := range draws {
+		gl.Uniform1f(p.shiftUniform, d.shift)
+		p.mesh.Draw(p.attribs, d.first, d.count)
+	}
+
+	var id uint32
+	gl.ReadBuffer(gl.COLOR_ATTACHMENT0)
+	gl.ReadPixels(int32(x), p.height-1-int32(y), 1, 1, gl.RED_INTEGER, gl.UNSIGNED_INT, gl.Ptr(&id))
+	return id, id != noCell
+}
+
+// Delete releases the GL objects owned by the picker.
+func (p *Picker) Delete() {
+	gl.DeleteFramebuffers(1, &p.fbo)
+	gl.DeleteTextures(1, &p.idTex)
+	gl.DeleteRenderbuffers(1, &p.depthRb)
+	gl.DeleteVertexArrays(1, &p.vao)
+	gl.DeleteProgram(p.program)
+}
+
+var idVertexShader = `
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+uniform mat4 model;
+uniform float shift;
+uniform vec4 clipPlane;
+
+in vec3 vert;
+in vec3 shiftDir;
+in vec3 offset;
+in float size;
+in uint cellID;
+flat out uint fragID;
+
+void main() {
+    vec4 world = model * vec4(shiftDir * shift + vert * size + offset, 1);
+    gl_Position = projection * camera * world;
+    gl_ClipDistance[0] = dot(clipPlane, world);
+    fragID = cellID;
+}
+` + "\x00"
+
+var idFragmentShader = `
+#version 330
+
+flat in uint fragID;
+out uint outputID;
+
+void main() {
+    outputID = fragID;
+}
+` + "\x00"
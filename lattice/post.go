@@ -0,0 +1,368 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// PostEffect is a post-processing effect: a fragment shader run over the
+// whole frame once the lattice is drawn, before the HUD.
+type PostEffect interface {
+	// Name names the effect in the console. A chain holds one effect of a
+	// name.
+	Name() string
+	// Shader returns the GLSL fragment shader of the effect, which reads
+	// the frame from the sampler2D uniform frame at the vec2 input fragUV
+	// and writes the vec4 output outputColor.
+	Shader() string
+	// SetUniforms sets the uniforms of the effect in program, which is in
+	// use.
+	SetUniforms(program uint32)
+}
+
+// postPrepass is implemented by effects that draw from the frame into
+// textures of their own before their pass reads them.
+type postPrepass interface {
+	// prepare draws from frame, the texture the pass of the effect reads,
+	// into textures of p.
+	prepare(p *postChain, frame uint32) error
+}
+
+// Gamma corrects the frame by a gamma, raising the colors to 1/Gamma so
+// that a gamma above 1 brightens the midtones.
+type Gamma struct {
+	Gamma float32
+}
+
+func (Gamma) Name() string { return "gamma" }
+
+func (Gamma) Shader() string { return gammaFragmentShader }
+
+func (g Gamma) SetUniforms(program uint32) {
+	gl.Uniform1f(gl.GetUniformLocation(program, gl.Str("gamma\x00")), g.Gamma)
+}
+
+// Vignette darkens the frame towards its corners by up to Strength,
+// starting Radius of the way from the center to a corner, or halfway for
+// 0.
+type Vignette struct {
+	Strength float32 `json:"strength"`
+	Radius   float32 `json:"radius"`
+}
+
+// defaultVignetteRadius is where a vignette starts unless given.
+const defaultVignetteRadius = 0.5
+
+func (Vignette) Name() string { return "vignette" }
+
+func (Vignette) Shader() string { return vignetteFragmentShader }
+
+func (v Vignette) SetUniforms(program uint32) {
+	gl.Uniform1f(gl.GetUniformLocation(program, gl.Str("strength\x00")), v.Strength)
+	radius := v.Radius
+	if radius <= 0 {
+		radius = defaultVignetteRadius
+	}
+	gl.Uniform1f(gl.GetUniformLocation(program, gl.Str("radius\x00")), radius)
+}
+
+// postChain runs the post-processing effects. The scene is drawn into a
+// multisampled framebuffer, resolved into the first of two textures, and
+// every effect but the last draws a full-screen triangle reading one
+// texture into the other; the last draws into the framebuffer the scene
+// was meant for. The framebuffers of the chain hold half floats, so that
+// colors above 1 survive until the last effect.
+//
+// Texture unit 0 holds the input of an effect, unit 1 any texture its
+// prepass drew.
+type postChain struct {
+	effects []PostEffect
+	// programs are the compiled effects by name.
+	programs map[string]uint32
+	vao      uint32
+
+	// The framebuffers are allocated on first use, for frames of width by
+	// height.
+	samples       int32
+	allocated     bool
+	width, height int32
+	sceneFBO      uint32
+	sceneColor    uint32
+	sceneDepth    uint32
+	fbos, texs    [2]uint32
+	// bloom, if not nil, blurs the highlights for a bloom effect, and ssao
+	// estimates the occlusion for an ambient occlusion effect.
+	bloom *bloomBuffers
+	ssao  *ssaoBuffers
+	// projection is the projection the scene was drawn with.
+	projection mgl32.Mat4
+
+	// target is the framebuffer the scene was meant for, and the viewport
+	// of it the last effect draws into.
+	target                             int32
+	targetX, targetY, targetW, targetH int32
+}
+
+func newPostChain(samples int32) *postChain {
+	return &postChain{samples: samples, programs: map[string]uint32{}}
+}
+
+// active reports whether there are effects to run.
+func (p *postChain) active() bool {
+	return len(p.effects) > 0
+}
+
+// set adds e to the chain, in place of the effect of its name if any.
+func (p *postChain) set(e PostEffect) error {
+	if _, ok := p.programs[e.Name()]; !ok {
+		program, err := glutil.NewProgram(accumVertexShader, e.Shader())
+		if err != nil {
+			return err
+		}
+		gl.UseProgram(program)
+		gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("frame\x00")), 0)
+		p.programs[e.Name()] = program
+	}
+	for i, old := range p.effects {
+		if old.Name() == e.Name() {
+			p.effects[i] = e
+			return nil
+		}
+	}
+	p.effects = append(p.effects, e)
+	return nil
+}
+
+// has reports whether the chain holds an effect named name.
+func (p *postChain) has(name string) bool {
+	for _, e := range p.effects {
+		if e.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the effect named name, and reports whether there was one.
+func (p *postChain) remove(name string) bool {
+	for i, e := range p.effects {
+		if e.Name() == name {
+			p.effects = append(p.effects[:i], p.effects[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// resize reallocates the framebuffers for frames of width by height.
+func (p *postChain) resize(width, height int32) error {
+	if !p.allocated {
+		gl.GenVertexArrays(1, &p.vao)
+		gl.GenFramebuffers(1, &p.sceneFBO)
+		gl.GenRenderbuffers(1, &p.sceneColor)
+		gl.GenRenderbuffers(1, &p.sceneDepth)
+		gl.GenFramebuffers(2, &p.fbos[0])
+		gl.GenTextures(2, &p.texs[0])
+		p.allocated = true
+	}
+	p.width, p.height = width, height
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+
+	gl.BindRenderbuffer(gl.RENDERBUFFER, p.sceneColor)
+	gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, p.samples, gl.RGBA16F, width, height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, p.sceneDepth)
+	gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, p.samples, gl.DEPTH_COMPONENT24, width, height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.sceneFBO)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, p.sceneColor)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, p.sceneDepth)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		return errors.New("post-processing framebuffer is incomplete")
+	}
+	for i, tex := range p.texs {
+		gl.BindTexture(gl.TEXTURE_2D, tex)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA16F, width, height, 0, gl.RGBA, gl.FLOAT, nil)
+		// Effects sample their input at the texel centers, where linear
+		// filtering changes nothing, unless they downsample it.
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbos[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, tex, 0)
+		if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+			return errors.New("post-processing framebuffer is incomplete")
+		}
+	}
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return nil
+}
+
+// begin redirects the scene meant for the viewport at x, y of the given
+// size of the bound framebuffer into the chain, where it is drawn at the
+// origin with projection.
+func (p *postChain) begin(x, y, width, height int32, projection mgl32.Mat4) error {
+	if width != p.width || height != p.height {
+		if err := p.resize(width, height); err != nil {
+			return err
+		}
+	}
+	p.projection = projection
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &p.target)
+	p.targetX, p.targetY, p.targetW, p.targetH = x, y, width, height
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.sceneFBO)
+	gl.Viewport(0, 0, width, height)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	return nil
+}
+
+// end runs the effects over the scene drawn since begin, the last one into
+// the viewport given to begin.
+func (p *postChain) end() error {
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, p.sceneFBO)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, p.fbos[0])
+	gl.BlitFramebuffer(0, 0, p.width, p.height, 0, 0, p.width, p.height, gl.COLOR_BUFFER_BIT, gl.NEAREST)
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	gl.Disable(gl.BLEND)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.BindVertexArray(p.vao)
+
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(p.target))
+	in := 0
+	for i, e := range p.effects {
+		if pre, ok := e.(postPrepass); ok {
+			if err := pre.prepare(p, p.texs[in]); err != nil {
+				return err
+			}
+		}
+		if i == len(p.effects)-1 {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(p.target))
+			gl.Viewport(p.targetX, p.targetY, p.targetW, p.targetH)
+		} else {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbos[1-in])
+			gl.Viewport(0, 0, p.width, p.height)
+		}
+		program := p.programs[e.Name()]
+		gl.UseProgram(program)
+		e.SetUniforms(program)
+		gl.BindTexture(gl.TEXTURE_2D, p.texs[in])
+		gl.DrawArrays(gl.TRIANGLES, 0, 3)
+		in = 1 - in
+	}
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	return nil
+}
+
+// Delete releases the GL objects of the chain.
+func (p *postChain) Delete() {
+	for _, program := range p.programs {
+		gl.DeleteProgram(program)
+	}
+	if p.bloom != nil {
+		p.bloom.Delete()
+	}
+	if p.ssao != nil {
+		p.ssao.Delete()
+	}
+	if !p.allocated {
+		return
+	}
+	gl.DeleteFramebuffers(1, &p.sceneFBO)
+	gl.DeleteRenderbuffers(1, &p.sceneColor)
+	gl.DeleteRenderbuffers(1, &p.sceneDepth)
+	gl.DeleteFramebuffers(2, &p.fbos[0])
+	gl.DeleteTextures(2, &p.texs[0])
+	gl.DeleteVertexArrays(1, &p.vao)
+}
+
+// SetPostEffect adds the post-processing effect e after the others, or in
+// place of the one of its name.
+func (r *Renderer) SetPostEffect(e PostEffect) error {
+	if err := r.post.set(e); err != nil {
+		return err
+	}
+	r.Notify(MsgPostEffects, r.postEffectNames())
+	return nil
+}
+
+// RemovePostEffect removes the post-processing effect named name.
+func (r *Renderer) RemovePostEffect(name string) error {
+	if !r.post.remove(name) {
+		return r.locale.Errorf(MsgNoPostEffect, name)
+	}
+	r.Notify(MsgPostEffects, r.postEffectNames())
+	return nil
+}
+
+// ClearPostEffects removes all post-processing effects.
+func (r *Renderer) ClearPostEffects() {
+	r.post.effects = nil
+	r.Notify(MsgPostEffectsOff)
+}
+
+// postEffectNames returns the names of the post-processing effects in the
+// order they run.
+func (r *Renderer) postEffectNames() string {
+	names := ""
+	for i, e := range r.post.effects {
+		if i > 0 {
+			names += ", "
+		}
+		names += e.Name()
+	}
+	return names
+}
+
+var gammaFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+uniform float gamma;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    vec4 color = texture(frame, fragUV);
+    outputColor = vec4(pow(color.rgb, vec3(1 / gamma)), color.a);
+}
+` + "\x00"
+
+var vignetteFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+uniform float strength;
+uniform float radius;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    vec4 color = texture(frame, fragUV);
+    // The distance from the center is 1 at the corners.
+    float d = length(fragUV - 0.5) * sqrt(2.0);
+    float shade = 1 - strength * smoothstep(radius, 1, d);
+    outputColor = vec4(color.rgb * shade, color.a);
+}
+` + "\x00"
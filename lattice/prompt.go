@@ -0,0 +1,66 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+// prompt is a line of text typed into the window, shown on the HUD until
+// Enter submits it or Escape cancels it. Keys do nothing else meanwhile.
+type prompt struct {
+	label string
+	text  []rune
+	// accept reports whether a typed rune belongs in the text, which also
+	// keeps out the character of the key that opened the prompt.
+	accept func(rune) bool
+	submit func(r *Renderer, text string) error
+}
+
+// openPrompt opens a prompt labeled label, stopping the camera as the keys
+// moving it are released into the prompt.
+func (r *Renderer) openPrompt(label string, accept func(rune) bool, submit func(*Renderer, string) error) {
+	r.camSpeed = mgl32.Vec3{}
+	r.rotationSpeed = mgl32.Vec2{}
+	r.prompt = &prompt{label: label, accept: accept, submit: submit}
+}
+
+// onPromptKey edits, submits or cancels the open prompt. Held keys repeat.
+func (r *Renderer) onPromptKey(key glfw.Key, action glfw.Action) {
+	if action == glfw.Release {
+		return
+	}
+	p := r.prompt
+	switch key {
+	case glfw.KeyEnter, glfw.KeyKPEnter:
+		r.prompt = nil
+		if err := p.submit(r, string(p.text)); err != nil {
+			r.NotifyError(err)
+		}
+	case glfw.KeyEscape:
+		r.prompt = nil
+	case glfw.KeyBackspace:
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	}
+}
+
+// OnChar types char into the open prompt.
+func (r *Renderer) OnChar(w *glfw.Window, char rune) {
+	if p := r.prompt; p != nil && p.accept(char) {
+		p.text = append(p.text, char)
+	}
+}
+
+// add queues the prompt with a cursor on t, centered a third of the way
+// down a screen of the given size.
+func (p *prompt) add(t *hud.Text, width, height int, scale float32) {
+	line := p.label + string(p.text) + "_"
+	w, _ := hud.Measure(line, scale)
+	hud.AddPanel(t, (float32(width)-w)/2, float32(height)/3, scale, line)
+}
@@ -0,0 +1,329 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a compiled boolean expression over cell fields, such as
+//
+//	value > 0.8 && y < 10
+//
+// The fields are x, y, z, r, g, b, value and id. Expressions support the
+// arithmetic operators + - * / %, comparisons, && || and !.
+type Query struct {
+	src  string
+	root queryNode
+}
+
+// queryNode evaluates to a number; comparisons and logical operators
+// yield 1 for true and 0 for false.
+type queryNode func(c *cell) float64
+
+var queryFields = map[string]queryNode{
+	"x":     func(c *cell) float64 { return float64(c.pos[0]) },
+	"y":     func(c *cell) float64 { return float64(c.pos[1]) },
+	"z":     func(c *cell) float64 { return float64(c.pos[2]) },
+	"r":     func(c *cell) float64 { return float64(c.color[0]) },
+	"g":     func(c *cell) float64 { return float64(c.color[1]) },
+	"b":     func(c *cell) float64 { return float64(c.color[2]) },
+	"value": func(c *cell) float64 { return float64(c.value) },
+	"id":    func(c *cell) float64 { return float64(c.id) },
+}
+
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Query{src: src, root: root}, nil
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+func (q *Query) Match(c *cell) bool {
+	return q.root(c) != 0
+}
+
+// Select returns the IDs of all cells of s matching the query, unpacking
+// one chunk at a time.
+func (q *Query) Select(s *cellStore) Selection {
+	sel := NewSelection()
+	for _, k := range s.keys {
+		cells := s.chunk(k)
+		for i := range cells {
+			if q.Match(&cells[i]) {
+				sel.Add(cells[i].id)
+			}
+		}
+	}
+	return sel
+}
+
+type queryToken struct {
+	text string
+	num  float64
+	pos  int
+}
+
+type queryParser struct {
+	src    string
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	offset := len(p.src)
+	if p.pos < len(p.tokens) {
+		offset = p.tokens[p.pos].pos
+	}
+	return fmt.Errorf("query: %v at offset %v", fmt.Sprintf(format, args...), offset)
+}
+
+func (p *queryParser) tokenize() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.' || src[j] == 'e' ||
+				(src[j] == '-' || src[j] == '+') && j > i && src[j-1] == 'e') {
+				j++
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return fmt.Errorf("query: bad number %q at offset %v", src[i:j], i)
+			}
+			p.tokens = append(p.tokens, queryToken{text: src[i:j], num: num, pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			p.tokens = append(p.tokens, queryToken{text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("query: unexpected %q at offset %v", c, i)
+			}
+			p.tokens = append(p.tokens, queryToken{text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *queryParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	for _, op := range ops {
+		if t == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *cell) float64 { return boolNum(l(c) != 0 || right(c) != 0) }
+	}
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *cell) float64 { return boolNum(l(c) != 0 && right(c) != 0) }
+	}
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if _, ok := p.accept("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cell) float64 { return boolNum(operand(c) == 0) }, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *queryParser) parseCmp() (queryNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("<", "<=", ">", ">=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "<":
+		return func(c *cell) float64 { return boolNum(left(c) < right(c)) }, nil
+	case "<=":
+		return func(c *cell) float64 { return boolNum(left(c) <= right(c)) }, nil
+	case ">":
+		return func(c *cell) float64 { return boolNum(left(c) > right(c)) }, nil
+	case ">=":
+		return func(c *cell) float64 { return boolNum(left(c) >= right(c)) }, nil
+	case "==":
+		return func(c *cell) float64 { return boolNum(left(c) == right(c)) }, nil
+	default:
+		return func(c *cell) float64 { return boolNum(left(c) != right(c)) }, nil
+	}
+}
+
+func (p *queryParser) parseSum() (queryNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(c *cell) float64 { return l(c) + right(c) }
+		} else {
+			left = func(c *cell) float64 { return l(c) - right(c) }
+		}
+	}
+}
+
+func (p *queryParser) parseProduct() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		switch op {
+		case "*":
+			left = func(c *cell) float64 { return l(c) * right(c) }
+		case "/":
+			left = func(c *cell) float64 { return l(c) / right(c) }
+		default:
+			left = func(c *cell) float64 { return math.Mod(l(c), right(c)) }
+		}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cell) float64 { return -operand(c) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("unexpected end of query")
+	}
+	t := p.tokens[p.pos]
+	if _, ok := p.accept("("); ok {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, p.errorf("missing )")
+		}
+		return inner, nil
+	}
+	if field, ok := queryFields[t.text]; ok {
+		p.pos++
+		return field, nil
+	}
+	if r := rune(t.text[0]); unicode.IsDigit(r) || r == '.' {
+		p.pos++
+		num := t.num
+		return func(*cell) float64 { return num }, nil
+	}
+	return nil, p.errorf("unknown field %q", t.text)
+}
+
+func boolNum(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestParseQuery(t *testing.T) {
+	c := cell{
+		id:    7,
+		pos:   mgl32.Vec3{1, 2, 3},
+		color: mgl32.Vec3{0.5, 0.25, 1},
+		value: 0.9,
+	}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"value > 0.8 && y < 10", true},
+		{"value > 0.95 || b < 0", false},
+		{"x + y * z == 7", true},
+		{"(x + y) * z == 9", true},
+		{"x - y - z == -4", true},
+		{"z / 2 >= 1.5", true},
+		{"id % 4 == 3", true},
+		{"!(id == 7)", false},
+		{"x != 1", false},
+		{"-x < 0 && --x > 0", true},
+		{"r <= 0.5 && g < r", true},
+		{"1e1 > value * 10", true},
+		{"  value>.5  ", true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.src)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", test.src, err)
+			continue
+		}
+		if got := q.Match(&c); got != test.want {
+			t.Errorf("ParseQuery(%q).Match = %v, want %v", test.src, got, test.want)
+		}
+		if q.String() != test.src {
+			t.Errorf("ParseQuery(%q).String() = %q", test.src, q.String())
+		}
+	}
+}
+
+func TestParseQueryMalformed(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"value >",
+		"x > 1 &&",
+		"(x > 1",
+		"x > 1)",
+		"foo > 1",
+		"x $ 1",
+		"x & y",
+		"1..2 > x",
+		"1e > x",
+		"x 1",
+		"()",
+	} {
+		if q, err := ParseQuery(src); err == nil {
+			t.Errorf("ParseQuery(%q) = %v, want an error", src, q)
+		}
+	}
+}
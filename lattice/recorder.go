@@ -0,0 +1,450 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// defaultRecordFrameRate is the default Options.RecordFrameRate.
+const defaultRecordFrameRate = 30
+
+// recordBuffers is the number of pixel buffers frames are read into. A
+// frame is copied out of its buffer a frame or two after it was read, once
+// the GPU is done with it, so reading never stalls the frame loop.
+const recordBuffers = 3
+
+// recordQueue is the number of frames waiting for the encoder before
+// capturing waits for it.
+const recordQueue = 8
+
+// recorder captures the frames drawn in the window at a fixed frame rate
+// and encodes them on its own goroutine.
+type recorder struct {
+	path          string
+	x, y          int32
+	width, height int32
+	period        float64
+	next          float64
+	frames        int
+
+	// pbos are a ring of pixel buffers. The count slots from first hold
+	// frames still being read, each with its fence and the number of
+	// times it is written to keep the video in time.
+	pbos    [recordBuffers]uint32
+	fences  [recordBuffers]uintptr
+	repeats [recordBuffers]int
+	first   int
+	count   int
+
+	// motion, if not nil, renders the motion of each recorded frame, read
+	// into motionPBOs alongside the colors and written to motionDir. Its
+	// program is built again from the lattice shaders while motionStale
+	// is set.
+	motion      *MotionPass
+	motionPBOs  [recordBuffers]uint32
+	motionDir   string
+	motionStale bool
+
+	encoded chan recordedFrame
+	done    chan error
+}
+
+type recordedFrame struct {
+	pix    []byte
+	motion []byte
+	repeat int
+}
+
+// frameEncoder writes RGBA frames, bottom row first, to a video file.
+type frameEncoder interface {
+	WriteFrame(pix []byte) error
+	Close() error
+}
+
+// newRecorder starts recording the rectangle of the framebuffer at x, y of
+// the given size to path at fps frames per second. With mesh it also
+// writes the motion of the cells of mesh in every frame, as Middlebury
+// .flo files in the directory motionDir(path), displacing them with the
+// code of vsrc, the lattice vertex shader with its hooks.
+func newRecorder(path string, x, y, width, height int32, fps int, mesh *Mesh, vsrc string) (*recorder, error) {
+	var motion *MotionPass
+	if mesh != nil {
+		if err := os.MkdirAll(motionDir(path), 0755); err != nil {
+			return nil, err
+		}
+		var err error
+		if motion, err = NewMotionPass(vsrc, mesh, width, height); err != nil {
+			return nil, err
+		}
+	}
+	enc, err := newFrameEncoder(path, int(width), int(height), fps)
+	if err != nil {
+		if motion != nil {
+			motion.Delete()
+		}
+		return nil, err
+	}
+	rec := &recorder{
+		path:    path,
+		x:       x,
+		y:       y,
+		width:   width,
+		height:  height,
+		period:  1 / float64(fps),
+		next:    glfw.GetTime(),
+		motion:  motion,
+		encoded: make(chan recordedFrame, recordQueue),
+		done:    make(chan error, 1),
+	}
+	size := int(width) * int(height) * 4
+	gl.GenBuffers(recordBuffers, &rec.pbos[0])
+	for _, pbo := range rec.pbos {
+		gl.BindBuffer(gl.PIXEL_PACK_BUFFER, pbo)
+		gl.BufferData(gl.PIXEL_PACK_BUFFER, size, nil, gl.STREAM_READ)
+	}
+	if motion != nil {
+		rec.motionDir = motionDir(path)
+		gl.GenBuffers(recordBuffers, &rec.motionPBOs[0])
+		for _, pbo := range rec.motionPBOs {
+			gl.BindBuffer(gl.PIXEL_PACK_BUFFER, pbo)
+			gl.BufferData(gl.PIXEL_PACK_BUFFER, 2*size, nil, gl.STREAM_READ)
+		}
+	}
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+	go rec.encode(enc)
+	return rec, nil
+}
+
+// encode writes the frames read until the recording stops. After an error
+// the remaining frames are dropped.
+func (rec *recorder) encode(enc frameEncoder) {
+	var err error
+	n := 0
+	for f := range rec.encoded {
+		for i := 0; i < f.repeat && err == nil; i++ {
+			err = enc.WriteFrame(f.pix)
+			n++
+			if err == nil && f.motion != nil {
+				// The repeats of a slow frame stand still.
+				var motion []float32
+				if i == 0 {
+					motion = bytesToFloats(f.motion)
+				}
+				err = writeFlow(filepath.Join(rec.motionDir, flowName(n)), int(rec.width), int(rec.height), motion)
+			}
+		}
+	}
+	if closeErr := enc.Close(); err == nil {
+		err = closeErr
+	}
+	rec.done <- err
+}
+
+// capture reads the back buffer of the default framebuffer if a frame is
+// due at time now, in seconds, and with it the motion of sc, and hands the
+// frames read earlier to the encoder. It must be called after the frame is
+// drawn and before the buffers are swapped.
+func (rec *recorder) capture(now float64, sc motionScene) {
+	rec.collect(false)
+	if now < rec.next {
+		return
+	}
+	// A slow frame stands in for all the frames due since the last one.
+	repeat := 1 + int((now-rec.next)/rec.period)
+	rec.next += float64(repeat) * rec.period
+	if rec.count == recordBuffers {
+		rec.collect(true)
+	}
+
+	slot := (rec.first + rec.count) % recordBuffers
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+	gl.ReadBuffer(gl.BACK)
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, rec.pbos[slot])
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(rec.x, rec.y, rec.width, rec.height, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+	if rec.motion != nil {
+		rec.motion.Render(sc)
+		rec.motion.read(rec.motionPBOs[slot])
+	}
+	rec.fences[slot] = gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0)
+	rec.repeats[slot] = repeat
+	rec.count++
+	rec.frames += repeat
+}
+
+// collect copies the frames the GPU has finished reading out of their
+// buffers, oldest first. With wait set it waits for at least one, dropping
+// it if it takes over a second.
+func (rec *recorder) collect(wait bool) {
+	size := int(rec.width) * int(rec.height) * 4
+	for rec.count > 0 {
+		slot := rec.first
+		var timeout uint64
+		if wait {
+			timeout = uint64(time.Second)
+		}
+		status := gl.ClientWaitSync(rec.fences[slot], gl.SYNC_FLUSH_COMMANDS_BIT, timeout)
+		signaled := status == gl.ALREADY_SIGNALED || status == gl.CONDITION_SATISFIED
+		if !signaled && !wait {
+			return
+		}
+		wait = false
+		gl.DeleteSync(rec.fences[slot])
+
+		if signaled {
+			f := recordedFrame{pix: make([]byte, size), repeat: rec.repeats[slot]}
+			gl.BindBuffer(gl.PIXEL_PACK_BUFFER, rec.pbos[slot])
+			gl.GetBufferSubData(gl.PIXEL_PACK_BUFFER, 0, size, gl.Ptr(f.pix))
+			if rec.motion != nil {
+				f.motion = make([]byte, 2*size)
+				gl.BindBuffer(gl.PIXEL_PACK_BUFFER, rec.motionPBOs[slot])
+				gl.GetBufferSubData(gl.PIXEL_PACK_BUFFER, 0, 2*size, gl.Ptr(f.motion))
+			}
+			gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+			rec.encoded <- f
+		} else {
+			// The GPU is stuck, or the context lost: drop the frame
+			// rather than the whole recording.
+			rec.frames -= rec.repeats[slot]
+		}
+
+		rec.first = (rec.first + 1) % recordBuffers
+		rec.count--
+	}
+}
+
+// stop waits for the frames still being read and encoded, finishes the
+// file and releases the pixel buffers.
+func (rec *recorder) stop() error {
+	for rec.count > 0 {
+		rec.collect(true)
+	}
+	close(rec.encoded)
+	err := <-rec.done
+	gl.DeleteBuffers(recordBuffers, &rec.pbos[0])
+	if rec.motion != nil {
+		gl.DeleteBuffers(recordBuffers, &rec.motionPBOs[0])
+		rec.motion.Delete()
+	}
+	return err
+}
+
+// recordingExt returns the extension of recordings: MP4 when ffmpeg is
+// installed, GIF otherwise.
+func recordingExt() string {
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		return ".mp4"
+	}
+	return ".gif"
+}
+
+// newFrameEncoder returns an encoder for the format of the extension of
+// path.
+func newFrameEncoder(path string, width, height, fps int) (frameEncoder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return newGIFEncoder(path, width, height, fps), nil
+	default:
+		return newFFmpegEncoder(path, width, height, fps)
+	}
+}
+
+// ffmpegEncoder pipes the raw frames to ffmpeg, which picks the codec
+// from the extension of the file.
+type ffmpegEncoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func newFFmpegEncoder(path string, width, height, fps int) (*ffmpegEncoder, error) {
+	e := &ffmpegEncoder{}
+	e.cmd = exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "rgba",
+		"-s", fmt.Sprintf("%vx%v", width, height), "-r", fmt.Sprint(fps),
+		"-i", "-",
+		// OpenGL rows start at the bottom, and 4:2:0 chroma needs an
+		// even size.
+		"-vf", "vflip,scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-pix_fmt", "yuv420p",
+		path)
+	e.cmd.Stderr = &e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	e.stdin = stdin
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *ffmpegEncoder) WriteFrame(pix []byte) error {
+	_, err := e.stdin.Write(pix)
+	return err
+}
+
+func (e *ffmpegEncoder) Close() error {
+	e.stdin.Close()
+	if err := e.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(e.stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %v", msg)
+		}
+		return fmt.Errorf("ffmpeg: %v", err)
+	}
+	return nil
+}
+
+// gifEncoder encodes an animated GIF without external tools. The frames
+// are kept in memory until it is closed, so it suits short clips.
+type gifEncoder struct {
+	path          string
+	width, height int
+	delay         int
+	anim          gif.GIF
+}
+
+func newGIFEncoder(path string, width, height, fps int) *gifEncoder {
+	// GIF delays are in hundredths of a second.
+	delay := (100 + fps/2) / fps
+	if delay < 2 {
+		// Most viewers slow down shorter delays to a tenth of a second.
+		delay = 2
+	}
+	return &gifEncoder{path: path, width: width, height: height, delay: delay}
+}
+
+func (e *gifEncoder) WriteFrame(pix []byte) error {
+	src := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+	for y := 0; y < e.height; y++ {
+		copy(src.Pix[y*src.Stride:][:src.Stride], pix[(e.height-1-y)*src.Stride:][:src.Stride])
+	}
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 0xff
+	}
+	dst := image.NewPaletted(src.Rect, palette.Plan9)
+	draw.FloydSteinberg.Draw(dst, dst.Rect, src, image.Point{})
+	e.anim.Image = append(e.anim.Image, dst)
+	e.anim.Delay = append(e.anim.Delay, e.delay)
+	return nil
+}
+
+func (e *gifEncoder) Close() error {
+	f, err := os.Create(e.path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, &e.anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ToggleRecording starts recording the window to the screenshot
+// directory, or saves the recording in progress.
+func (r *Renderer) ToggleRecording() {
+	if r.recorder != nil {
+		r.StopRecording()
+		return
+	}
+	if err := r.StartRecording(); err != nil {
+		r.NotifyError(r.locale.Errorf(MsgRecordingFailed, err))
+	}
+}
+
+// StartRecording starts recording the frames drawn in the window, without
+// the bars of cinema mode, at the recording frame rate, to a file named
+// after the current time, with the motion of every frame next to it if
+// recordMotion is set.
+func (r *Renderer) StartRecording() error {
+	if err := os.MkdirAll(r.screenshotDir, 0755); err != nil {
+		return err
+	}
+	name := captureName(time.Now(), recordingExt())
+	path := filepath.Join(r.screenshotDir, name)
+	x, y, width, height := r.viewport()
+	var motionMesh *Mesh
+	var vsrc string
+	if r.recordMotion {
+		motionMesh = r.mesh
+		var err error
+		if vsrc, _, _, err = r.hookedShaders(r.hooks); err != nil {
+			return err
+		}
+	}
+	rec, err := newRecorder(path, x, y, width, height, r.recordFrameRate, motionMesh, vsrc)
+	if err != nil {
+		return err
+	}
+	r.recorder = rec
+	r.Notify(MsgRecording, path)
+	return nil
+}
+
+// StopRecording saves the recording in progress, if any, and reports it.
+func (r *Renderer) StopRecording() {
+	if r.recorder == nil {
+		return
+	}
+	rec := r.recorder
+	r.recorder = nil
+	if err := rec.stop(); err != nil {
+		r.NotifyError(r.locale.Errorf(MsgRecordingFailed, err))
+		return
+	}
+	r.Notify(MsgRecordingSaved, rec.frames, rec.path)
+}
+
+// recordFrame captures the frame just drawn when recording. Resizing the
+// window ends the recording, whose frames all have the size it started
+// with.
+func (r *Renderer) recordFrame() {
+	if r.recorder == nil {
+		return
+	}
+	x, y, width, height := r.viewport()
+	if x != r.recorder.x || y != r.recorder.y || width != r.recorder.width || height != r.recorder.height {
+		r.NotifyError(r.locale.Errorf(MsgRecordingResized))
+		r.StopRecording()
+		return
+	}
+	if rec := r.recorder; rec.motion != nil && rec.motionStale {
+		vsrc, _, _, err := r.hookedShaders(r.hooks)
+		if err == nil {
+			err = rec.motion.build(vsrc)
+		}
+		if err != nil {
+			r.NotifyError(err)
+		}
+		rec.motionStale = false
+	}
+	r.recorder.capture(glfw.GetTime(), motionScene{
+		projection:   r.projection,
+		camera:       r.camera,
+		model:        r.model,
+		clip:         r.clip.equation(),
+		draws:        r.draws,
+		time:         float32(r.frameTimer.prevTime),
+		displacement: r.displacement,
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// defaultAutosavePeriod is the default Options.AutosavePeriod.
+const defaultAutosavePeriod = 30 * time.Second
+
+// Files of the recovery directory. The session file is written last, so
+// its presence marks a complete autosave.
+const (
+	recoveryCells   = "cells.csv"
+	recoveryGroups  = "groups.json"
+	recoverySession = "session.json"
+)
+
+// sessionJSON is the session file: what the lattice file and the groups
+// file leave out.
+type sessionJSON struct {
+	Time      time.Time     `json:"time"`
+	Lattice   LatticeParams `json:"lattice"`
+	Camera    mgl32.Vec3    `json:"camera"`
+	Pitch     float32       `json:"pitch"`
+	Yaw       float32       `json:"yaw"`
+	Selection [][3]int      `json:"selection"`
+}
+
+// sessionSnapshot is a copy of the editable state, taken on the main
+// goroutine and written on the autosave goroutine. The cell store is never
+// modified once built, so it is shared rather than copied.
+type sessionSnapshot struct {
+	time      time.Time
+	params    LatticeParams
+	cells     *cellStore
+	groups    *Groups
+	selection Selection
+	camera    mgl32.Vec3
+	pitch     float32
+	yaw       float32
+}
+
+// snapshot copies the editable state of r.
+func (r *Renderer) snapshot() sessionSnapshot {
+	return sessionSnapshot{
+		time:      time.Now(),
+		params:    r.params,
+		cells:     r.cells,
+		groups:    r.groups.clone(),
+		selection: NewSelection(r.selection.IDs()...),
+		camera:    r.camPos,
+		pitch:     r.pitch,
+		yaw:       r.yaw,
+	}
+}
+
+// autosaver writes snapshots to a recovery directory on its own goroutine,
+// so that large lattices do not stall the frame loop.
+type autosaver struct {
+	dir    string
+	period time.Duration
+	next   time.Time
+
+	snapshots chan sessionSnapshot
+	errs      chan error
+	done      chan struct{}
+}
+
+// newAutosaver starts saving to dir at most once every period.
+func newAutosaver(dir string, period time.Duration) *autosaver {
+	a := &autosaver{
+		dir:       dir,
+		period:    period,
+		next:      time.Now().Add(period),
+		snapshots: make(chan sessionSnapshot, 1),
+		errs:      make(chan error, 1),
+		done:      make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// due reports whether the next autosave should be taken now. It is not
+// while the previous one is still waiting to be written.
+func (a *autosaver) due(now time.Time) bool {
+	return now.After(a.next) && len(a.snapshots) == 0
+}
+
+// save queues s for writing.
+func (a *autosaver) save(s sessionSnapshot) {
+	a.next = s.time.Add(a.period)
+	a.snapshots <- s
+}
+
+// err returns an error of a previous autosave, if any.
+func (a *autosaver) err() error {
+	select {
+	case err := <-a.errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (a *autosaver) run() {
+	defer close(a.done)
+	// The cells are only written again when they were replaced.
+	var saved *cellStore
+	for s := range a.snapshots {
+		err := writeRecovery(a.dir, s, s.cells != saved)
+		if err == nil {
+			saved = s.cells
+		} else {
+			select {
+			case a.errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+// stop waits for the last autosave and removes the recovery files: the
+// session ended cleanly.
+func (a *autosaver) stop() error {
+	close(a.snapshots)
+	<-a.done
+	for _, name := range []string{recoverySession, recoveryGroups, recoveryCells} {
+		if err := os.Remove(filepath.Join(a.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecovery writes s to dir, replacing each file only once it has been
+// written completely.
+func writeRecovery(dir string, s sessionSnapshot, cells bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// The session file is removed first so that a crash while writing the
+	// others never leaves a mix of old and new files looking complete.
+	if err := os.Remove(filepath.Join(dir, recoverySession)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if cells {
+		err := replaceFile(filepath.Join(dir, recoveryCells), func(path string) error {
+			return SaveCells(path, s.cells.unpackRefined())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := replaceFile(filepath.Join(dir, recoveryGroups), func(path string) error {
+		return s.groups.Save(path, s.params)
+	})
+	if err != nil {
+		return err
+	}
+
+	session := sessionJSON{
+		Time:      s.time,
+		Lattice:   s.params,
+		Camera:    s.camera,
+		Pitch:     s.pitch,
+		Yaw:       s.yaw,
+		Selection: make([][3]int, 0, len(s.selection)),
+	}
+	for _, id := range s.selection.IDs() {
+		if x, y, z, ok := s.params.CellPos(id); ok {
+			session.Selection = append(session.Selection, [3]int{x, y, z})
+		}
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(dir, recoverySession), func(path string) error {
+		return os.WriteFile(path, data, 0644)
+	})
+}
+
+// replaceFile writes path through write, to a temporary file first.
+func replaceFile(path string, write func(path string) error) error {
+	tmp := path + ".tmp"
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadRecovery reads the session autosaved to dir. It returns false
+// without an error if there is none, that is if the last session ended
+// cleanly.
+func loadRecovery(dir string) (sessionSnapshot, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, recoverySession))
+	if os.IsNotExist(err) {
+		return sessionSnapshot{}, false, nil
+	} else if err != nil {
+		return sessionSnapshot{}, false, err
+	}
+	var session sessionJSON
+	if err := json.Unmarshal(data, &session); err != nil {
+		return sessionSnapshot{}, false, fmt.Errorf("failed to parse %v: %v", recoverySession, err)
+	}
+
+	p := session.Lattice
+	if err := p.checkSize(fallbackLocale); err != nil {
+		return sessionSnapshot{}, false, fmt.Errorf("%v: %v", recoverySession, err)
+	}
+	cells, _, err := LoadCells(filepath.Join(dir, recoveryCells), p)
+	if err != nil {
+		return sessionSnapshot{}, false, err
+	}
+	// LoadCells numbers the cells within the lattice they span, which is
+	// smaller than that of the session once its edge cells are gone. The
+	// sub-cells are keyed by the IDs of the cells they refine.
+	assignIDs(cells, p)
+	groups, err := LoadGroups(filepath.Join(dir, recoveryGroups), p)
+	if err != nil {
+		return sessionSnapshot{}, false, err
+	}
+	selection := NewSelection()
+	for _, pos := range session.Selection {
+		if id, ok := p.CellID(pos[0], pos[1], pos[2]); ok {
+			selection.Add(id)
+		}
+	}
+	return sessionSnapshot{
+		time:      session.Time,
+		params:    p,
+		cells:     newCellStore(cells, p),
+		groups:    groups,
+		selection: selection,
+		camera:    session.Camera,
+		pitch:     session.Pitch,
+		yaw:       session.Yaw,
+	}, true, nil
+}
+
+// restore replaces the editable state of r by s.
+func (r *Renderer) restore(s sessionSnapshot) {
+	r.params = s.params
+	r.cells = s.cells
+	r.crystal = nil
+	r.pristine = nil
+	r.groups = s.groups
+	r.selection = s.selection
+	r.camPos = s.camera
+	r.pitch = s.pitch
+	r.yaw = s.yaw
+}
+
+// autosave hands a snapshot to the autosaver when one is due, and reports
+// failed autosaves.
+func (r *Renderer) autosave() {
+	if r.autosaver == nil {
+		return
+	}
+	if err := r.autosaver.err(); err != nil {
+		r.NotifyError(r.locale.Errorf(MsgAutosaveFailed, err))
+	}
+	if now := time.Now(); r.autosaver.due(now) {
+		r.autosaver.save(r.snapshot())
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// reloader reloads the startup script and the scene file whenever the
+// process is sent SIGHUP, so that the settings they make can be changed on
+// a running instance by editing them. The script sets them through the
+// console commands, as it did at startup; of the scene only the settings
+// changed in the file are applied.
+type reloader struct {
+	// script is the startup script, scene the scene file, either empty if
+	// there is none.
+	script string
+	scene  string
+	// applied is the scene file as it was last applied.
+	applied sceneJSON
+	signals chan os.Signal
+}
+
+// newReloader starts listening for SIGHUP to run the script at path.
+func newReloader(script string) *reloader {
+	l := &reloader{script: script, signals: make(chan os.Signal, 1)}
+	signal.Notify(l.signals, syscall.SIGHUP)
+	return l
+}
+
+// stop stops listening for SIGHUP.
+func (l *reloader) stop() {
+	signal.Stop(l.signals)
+}
+
+// pollReload reloads the startup script and the scene file if SIGHUP was
+// received since the last frame.
+func (r *Renderer) pollReload() {
+	if r.reloader == nil {
+		return
+	}
+	select {
+	case <-r.reloader.signals:
+		if err := r.Reload(); err != nil {
+			r.NotifyError(err)
+		}
+	default:
+	}
+}
+
+// watchScene makes Reload apply the changes to the scene file path, which
+// is sc as applied.
+func (r *Renderer) watchScene(path string, sc sceneJSON) {
+	if r.reloader == nil {
+		r.reloader = newReloader("")
+	}
+	r.reloader.scene, r.reloader.applied = path, sc
+}
+
+// Reload applies the changes to the scene file, then runs the startup
+// script again, stopping any script still running so that two runs of it
+// do not interleave.
+func (r *Renderer) Reload() error {
+	l := r.reloader
+	if l == nil {
+		return r.locale.Errorf(MsgNoReload)
+	}
+	if l.scene != "" {
+		if err := r.reloadScene(); err != nil {
+			return err
+		}
+		r.Notify(MsgSceneReloaded, l.scene)
+	}
+	if l.script == "" {
+		return nil
+	}
+	r.console.scripts = nil
+	if err := r.console.RunScript(r, l.script); err != nil {
+		return err
+	}
+	r.Notify(MsgReloading, l.script)
+	return nil
+}
+
+// reloadScene reads the scene file again and applies the settings that
+// changed in it since it was last applied. The others keep the values they
+// have on the running instance, which the console may have changed since.
+func (r *Renderer) reloadScene() error {
+	l := r.reloader
+	sc, err := readScene(l.scene)
+	if err != nil {
+		return err
+	}
+	prev := l.applied
+	next := r.scene()
+	cells := !reflect.DeepEqual(prev.Lattice, sc.Lattice) ||
+		!reflect.DeepEqual(prev.Cells, sc.Cells) ||
+		!reflect.DeepEqual(prev.Selection, sc.Selection)
+	if cells {
+		next.Lattice, next.Cells, next.Selection = sc.Lattice, sc.Cells, sc.Selection
+	}
+	render := mergeChanged(&next.Render, prev.Render, sc.Render)
+	camera := mergeChanged(&next.Camera, prev.Camera, sc.Camera)
+	switch {
+	case cells || render:
+		err = r.applyScene(next, l.scene)
+	case camera:
+		r.applySceneCamera(next.Camera)
+	}
+	if err != nil {
+		return err
+	}
+	l.applied = sc
+	return nil
+}
+
+// mergeChanged sets the exported fields of the struct dst that differ
+// between prev and next, two values of its type, to those of next. It
+// reports whether any did. Unexported fields, which scene files do not
+// hold, are left alone.
+func mergeChanged(dst, prev, next interface{}) bool {
+	d := reflect.ValueOf(dst).Elem()
+	p, n := reflect.ValueOf(prev), reflect.ValueOf(next)
+	changed := false
+	for i := 0; i < d.NumField(); i++ {
+		if !d.Field(i).CanSet() || !n.Field(i).CanInterface() {
+			continue
+		}
+		if !reflect.DeepEqual(p.Field(i).Interface(), n.Field(i).Interface()) {
+			d.Field(i).Set(n.Field(i))
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestMergeChanged(t *testing.T) {
+	file := sceneJSON{
+		Lattice: DefaultLatticeParams(),
+		Camera:  sceneCamera{Position: mgl32.Vec3{0, 0, 10}, FOV: 45},
+		Render: sceneRender{
+			Style:      DefaultStyles()[0],
+			Shape:      "cube",
+			Colormap:   "none",
+			ColorRange: "linear",
+			FadeRadius: 2,
+		},
+	}
+	// live is the scene as changed at the console since the file was
+	// applied: another colormap and a camera moved away.
+	live := file
+	live.Render.Colormap = "viridis"
+	live.Camera.Position = mgl32.Vec3{5, 5, 5}
+
+	tests := []struct {
+		name string
+		// edit is the edit to the JSON of the scene file.
+		old, new    string
+		render      sceneRender
+		camera      sceneCamera
+		renderMoved bool
+		cameraMoved bool
+	}{
+		{"unedited", "", "", live.Render, live.Camera, false, false},
+		{
+			"fade edited", `"fade_radius": 2`, `"fade_radius": 3`,
+			func() sceneRender { rs := live.Render; rs.FadeRadius = 3; return rs }(),
+			live.Camera, true, false,
+		},
+		{
+			"fov edited", `"fov": 45`, `"fov": 60`,
+			live.Render,
+			func() sceneCamera { c := live.Camera; c.FOV = 60; return c }(),
+			false, true,
+		},
+		{
+			"colormap edited", `"colormap": "none"`, `"colormap": "plasma"`,
+			func() sceneRender { rs := live.Render; rs.Colormap = "plasma"; return rs }(),
+			live.Camera, true, false,
+		},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		data, err := json.MarshalIndent(file, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		edited := strings.Replace(string(data), test.old, test.new, 1)
+		if test.old != "" && edited == string(data) {
+			t.Fatalf("%v: %q is not in the scene file", test.name, test.old)
+		}
+		path := filepath.Join(dir, "scene.json")
+		if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sc, err := readScene(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := live
+		if got := mergeChanged(&next.Render, file.Render, sc.Render); got != test.renderMoved {
+			t.Errorf("%v: render changed %v, want %v", test.name, got, test.renderMoved)
+		}
+		if got := mergeChanged(&next.Camera, file.Camera, sc.Camera); got != test.cameraMoved {
+			t.Errorf("%v: camera changed %v, want %v", test.name, got, test.cameraMoved)
+		}
+		if !reflect.DeepEqual(next.Render, test.render) {
+			t.Errorf("%v: render = %+v, want %+v", test.name, next.Render, test.render)
+		}
+		if next.Camera != test.camera {
+			t.Errorf("%v: camera = %+v, want %+v", test.name, next.Camera, test.camera)
+		}
+	}
+}
+
+func TestMergeChangedUnexported(t *testing.T) {
+	// LatticeParams holds the unexported crystal, which a scene file does
+	// not, and which must neither be compared nor set.
+	live := DefaultLatticeParams()
+	live.crystal = cellFrame{count: [3]int{2, 2, 2}}
+	prev := DefaultLatticeParams()
+	next := prev
+	next.HalfSize = 12
+	if !mergeChanged(&live, prev, next) {
+		t.Error("mergeChanged reported no change")
+	}
+	if live.HalfSize != 12 || live.crystal.empty() {
+		t.Errorf("mergeChanged = %+v, want HalfSize 12 and the crystal kept", live)
+	}
+}
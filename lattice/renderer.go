@@ -0,0 +1,2109 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lattice renders a color cube lattice using GLFW 3 and OpenGL 4.1
+// core forward-compatible profile.
+package lattice
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/hud"
+)
+
+const (
+	windowWidth  = 800
+	windowHeight = 600
+)
+
+var (
+	x    = mgl32.Vec3{1, 0, 0}
+	y    = mgl32.Vec3{0, 1, 0}
+	z    = mgl32.Vec3{0, 0, 1}
+	zero = mgl32.Vec3{}
+)
+
+type FrameTimer struct {
+	prevTime   float64
+	elapsed    float64
+	checkPoint float64
+	frames     int32
+	mspf       float32
+}
+
+func (ft *FrameTimer) OnFrame() {
+	if ft.prevTime == 0 {
+		ft.prevTime = glfw.GetTime()
+		return
+	}
+
+	period := 1.0
+	time := glfw.GetTime()
+	ft.elapsed = time - ft.prevTime
+	ft.prevTime = time
+	if time >= ft.checkPoint {
+		dt := (time - (ft.checkPoint - period))
+		ft.mspf = 1000 * float32(dt) / float32(ft.frames)
+		ft.checkPoint = time + period
+		ft.frames = 0
+	}
+	ft.frames++
+}
+
+// Renderer draws a lattice into a GLFW window and handles its input.
+type Renderer struct {
+	camSpeed mgl32.Vec3
+	camPos   mgl32.Vec3
+	// rotationSpeed is the pitch and yaw rate of the held arrow keys in
+	// radians per second, turnRate the rate of a single key.
+	rotationSpeed mgl32.Vec2
+	turnRate      float32
+	gamepad       GamepadMapping
+	// flight, if not nil, moves the camera to a goto target.
+	flight *cameraFlight
+	// path flies the camera through keyframes while it plays.
+	path cameraPath
+	// quiet keeps notifications off the terminal.
+	quiet bool
+	// orbiting keeps the camera orbitDistance away from the lattice
+	// center, facing it, instead of flying freely.
+	orbiting      bool
+	orbitDistance float32
+	// fov is the vertical field of view in degrees, near and far the
+	// distances of the near and far planes of the projection.
+	fov       float32
+	near, far float32
+	// shake turns the view by its trauma, on top of the camera angles.
+	shake cameraShake
+	// cinema letterboxes the lattice for recordings.
+	cinema cinema
+	// eyeSeparation is the distance between the eyes of stereo panoramas.
+	eyeSeparation float32
+
+	cameraUniform int32
+	shiftUniform  int32
+	aoUniform     int32
+
+	// sun points towards the light shading the faces, also used by the
+	// next light bake.
+	sun            mgl32.Vec3
+	sunUniform     int32
+	diffuseUniform int32
+
+	// shadow is rendered every frame from the casters, all drawn ranges
+	// whether visible or not, unless shadowsOff is set.
+	shadow         *ShadowMap
+	shadowsOff     bool
+	casters        []drawCall
+	shadowUniforms shadowUniforms
+
+	cursor cursor
+
+	roll  float32
+	pitch float32
+	yaw   float32
+
+	frameTimer FrameTimer
+
+	w *glfw.Window
+
+	// count is the number of triangles in the mesh, drawn the number
+	// drawn after culling.
+	count, drawn int
+	showStats    bool
+	// overdraw replaces the lattice by its overdraw heat map while
+	// showOverdraw is set.
+	overdraw     *Overdraw
+	showOverdraw bool
+	// wireframe draws the edges of the triangles of the cells in flat
+	// colors instead of their faces, with the wireframe program wire,
+	// built when first needed unless that failed with wireErr.
+	wireframe bool
+	wire      *wireframeProgram
+	wireErr   error
+	// alpha is the opacity of the cubes. Below 1 the draws are sorted
+	// back to front and blended over what is behind them.
+	alpha float32
+	// fog fades the cubes into a color with depth.
+	fog         Fog
+	fogUniforms fogUniforms
+	// lines draws the compass, shown while showCompass is set, the
+	// breadcrumbs dropped along the path of the camera and the outlines of
+	// the minimap, shown while showMinimap is set.
+	lines       *lineRenderer
+	showCompass bool
+	crumbs      breadcrumbs
+	showMinimap bool
+
+	projection mgl32.Mat4
+	camera     mgl32.Mat4
+	model      mgl32.Mat4
+	// frame takes world positions, in the axes of the data, to the display
+	// frame the camera moves in, so that the up axis of the data points up
+	// on the screen.
+	frame      mgl32.Mat4
+	up         UpAxis
+	handedness Handedness
+	// units is the physical length of a grid step the HUD reports lengths
+	// in.
+	units Units
+
+	params    LatticeParams
+	cells     *cellStore
+	groups    *Groups
+	selection Selection
+	// crystal is the crystal structure the cells are a supercell of, if
+	// they were loaded from a CIF file.
+	crystal *crystal
+	// pristine holds the cells without the point defects injected into
+	// them, if any are.
+	pristine *cellStore
+	// coloring colors the cells by a scalar field instead of their own
+	// colors, unless its colormap is off.
+	coloring cellColoring
+	// legend describes the colors of the colormap, nil while it is off.
+	legend *colorLegend
+	// sizing scales the cells by a second scalar field while on.
+	sizing cellSizing
+	// fade shrinks and dims cells in dense regions, unless its radius is 0.
+	fade DensityFade
+
+	mesh    *Mesh
+	attribs cubeAttribs
+	draws   []drawCall
+
+	picker *Picker
+
+	program           uint32
+	vao               uint32
+	projectionUniform int32
+
+	compare Comparison
+
+	text   *hud.Text
+	toasts hud.Toasts
+	// timers time the passes of the frames on the GPU for the stats.
+	timers *gpuTimers
+
+	console    *Console
+	groupsFile string
+	locale     Locale
+	swap       SwapMode
+
+	usd *usdRecorder
+
+	// autosaver, if not nil, periodically saves the session for recovery
+	// after a crash.
+	autosaver *autosaver
+
+	// collab, if not nil, shares the groups with the other clients of a
+	// network session, whose cameras ghosts draws.
+	collab     *collabState
+	ghosts     *ghostRenderer
+	collabAuth CollabAuth
+
+	// cellOverlay selects the unit cell edges cellFrames draws, created
+	// the first time they are shown.
+	cellOverlay CellOverlay
+	cellFrames  *cellFrameRenderer
+	// plane, if not zero, are the Miller indices of the crystallographic
+	// plane planes draws, created the first time one is shown.
+	plane  [3]int
+	planes *planeRenderer
+	// prompt, if not nil, is the line being typed on the HUD.
+	prompt *prompt
+	// beauty, if not nil, averages jittered frames of still views.
+	beauty *accumulator
+	// post runs the post-processing effects over the scene.
+	post *postChain
+
+	// layers are the render layers shown and captured.
+	layers layerState
+	// reloader, if not nil, reloads the startup script and the scene file
+	// on SIGHUP.
+	reloader *reloader
+	// watchdog, if not nil, restarts the process when frames stop coming.
+	watchdog *watchdog
+	// resetStatus is set if the context reports being lost, and
+	// contextLost once it has been.
+	resetStatus bool
+	contextLost bool
+	// trails, if not nil, draws where cells were before they changed.
+	trails *cellTrails
+	// gpuSim, if not nil, is a simulation run on the GPU, drawn instead
+	// of the lattice.
+	gpuSim *gpuSim
+	// picked, if not nil, is the cell last clicked, highlighted with its
+	// details on the HUD, and pickedBefore the one clicked before it,
+	// which the HUD measures the distance from. pointing frees the cursor
+	// to click cells with instead of turning the camera.
+	picked       *cell
+	pickedBefore *cell
+	pointing     bool
+	// editing makes the mouse buttons remove and place cubes.
+	editing bool
+
+	// screenshotDir receives the screenshots, taken at the end of the
+	// frame when screenshotPending is set, over a transparent background
+	// if screenshotTransparent is also set.
+	screenshotDir         string
+	screenshotPending     bool
+	screenshotTransparent bool
+
+	// recorder, if not nil, records the frames at recordFrameRate.
+	recorder        *recorder
+	recordFrameRate int
+	// recordMotion writes the motion of the recorded frames next to them.
+	recordMotion bool
+
+	shaderDir     string
+	shaderWatcher io.Closer
+	// hooks replace functions of the lattice shaders.
+	hooks       ShaderHooks
+	timeUniform int32
+	// colorPhaseUniform shifts the gradient of a generated lattice.
+	colorPhaseUniform int32
+	shadersChanged    <-chan struct{}
+
+	// watch, if not nil, reloads the cells from a file when it changes.
+	watch *fileWatch
+	// fadeUniform is how far the watched file has faded in.
+	fadeUniform int32
+	// source is the scene or lattice file last loaded or saved, which view
+	// links refer to.
+	source string
+	// demo records the input of the session, or replays it.
+	demo demo
+
+	lightmap      *Lightmap
+	lightUniforms lightUniforms
+
+	decals        *Decals
+	decalUniforms decalUniforms
+	// textures, if not nil, texture the faces of the cells.
+	textures        *cellTextures
+	textureUniforms textureUniforms
+	// materials choose the cell textures of the lattice sites.
+	materials cellMaterials
+	// displacement moves the cells by displaceMap, if not nil.
+	displacement     Displacement
+	displaceMap      *displaceMap
+	displaceUniforms displaceUniforms
+
+	// styles are selectable with the number keys, style is the current one.
+	styles        []Style
+	style         int
+	styleUniforms styleUniforms
+	// stylePost are the post-processing effects the style added.
+	stylePost []PostEffect
+
+	// clip cuts into the lattice while on.
+	clip        clipPlane
+	clipUniform int32
+
+	alphaUniform int32
+}
+
+// lightUniforms are the locations of the lightmap uniforms of the lattice
+// program.
+type lightUniforms struct {
+	on, origin, side int32
+}
+
+// shadowUniforms are the locations of the shadow uniforms of the lattice
+// program.
+type shadowUniforms struct {
+	matrix, on, bias int32
+}
+
+// decalUniforms are the locations of the decal uniforms of the lattice
+// program.
+type decalUniforms struct {
+	matrices, layers, count int32
+}
+
+// styleUniforms are the locations of the style uniforms of the lattice
+// program.
+type styleUniforms struct {
+	saturation, tint, brightness                 int32
+	edgeWidth, edgeColor, edgeCellColor, opacity int32
+	fresnel, eye                                 int32
+}
+
+func newRenderer(w *glfw.Window) *Renderer {
+	return &Renderer{
+		camPos: mgl32.Vec3{-41.5, -43.5, -37.5},
+		pitch:  mgl32.DegToRad(21.5),
+		yaw:    mgl32.DegToRad(-135),
+		w:      w,
+
+		params:    DefaultLatticeParams(),
+		groups:    &Groups{},
+		selection: NewSelection(),
+		compare:   NewComparison(),
+		showStats: true,
+		sun:       DefaultLightParams().Sun,
+		turnRate:  mgl32.DegToRad(defaultTurnRate),
+		fov:       defaultFOV,
+		shake:     cameraShake{amplitude: defaultShakeAmplitude, decay: defaultShakeDecay},
+		cinema:    cinema{aspect: defaultCinemaAspect},
+		styles:    DefaultStyles(),
+	}
+}
+
+func (r *Renderer) Update(w *glfw.Window) {
+	r.frameTimer.OnFrame()
+	dt := r.frameTimer.elapsed
+	if dt == 0 {
+		return
+	}
+
+	sensitivity := float32(0.001)
+
+	dx, dy := r.cursor.deltas()
+	pad := r.pollGamepad()
+	pitchSpeed := r.rotationSpeed[0] + pad.pitch
+	yawSpeed := r.rotationSpeed[1] + pad.yaw
+	r.roll = 0
+	r.pitch = normAngle(r.pitch + float32(-dy)*sensitivity + pitchSpeed*float32(dt))
+	r.pitch = mgl32.Clamp(r.pitch, -math.Pi/2, math.Pi/2)
+	r.yaw = normAngle(r.yaw + float32(-dx)*sensitivity + yawSpeed*float32(dt))
+
+	q := r.orientation()
+	r.camPos = r.camPos.Add(q.Rotate(r.camSpeed.Add(pad.move)).Mul(float32(dt)))
+	r.updateFlight()
+	r.updatePath()
+	r.updateOrbit()
+	r.crumbs.drop(r.worldPos(r.camPos))
+	r.camera = r.shake.rotation(r.frameTimer.prevTime).Mat4().Mul4(r.viewMatrix()).Mul4(r.frame)
+	r.cull()
+
+	if r.usd != nil {
+		r.usd.addCamera(r.frameTimer.prevTime, r.camera)
+	}
+}
+
+// cull collects the shadow casters, all ranges of the mesh, and the draws,
+// those in the view frustum of the projection and camera. The bounds of
+// the ranges grow by as far as the cells may be displaced, and nothing is
+// culled while a displace hook without a bound is set.
+func (r *Renderer) cull() {
+	r.draws = r.draws[:0]
+	r.casters = r.casters[:0]
+	r.drawn = 0
+	f := newFrustum(r.projection.Mul4(r.camera).Mul4(r.model))
+	reach, unbounded := r.displaceReach()
+	pad := mgl32.Vec3{reach, reach, reach}
+	for _, mr := range r.mesh.ranges {
+		d := drawCall{
+			first:  mr.first,
+			count:  mr.count,
+			shift:  mr.anim.Shift(r.frameTimer.prevTime),
+			center: mr.min.Add(mr.max).Mul(0.5),
+		}
+		r.casters = append(r.casters, d)
+		if !unbounded && !f.intersectsBox(mr.min.Sub(pad), mr.max.Add(pad)) {
+			continue
+		}
+		r.draws = append(r.draws, d)
+		r.drawn += int(mr.count) * r.mesh.TrianglesPerCell()
+	}
+	if r.alpha < 1 {
+		r.sortDraws()
+	}
+}
+
+func (r *Renderer) orientation() mgl32.Quat {
+	return mgl32.AnglesToQuat(r.roll, r.yaw, r.pitch, mgl32.ZYX)
+}
+
+// viewMatrix returns the world to camera transform for the current camera
+// position and angles.
+func (r *Renderer) viewMatrix() mgl32.Mat4 {
+	camera := mgl32.Ident4()
+	camera = r.orientation().Mat4().Mul4(camera)
+	camera = mgl32.Translate3D(r.camPos[0], r.camPos[1], r.camPos[2]).Mul4(camera)
+	return camera.Inv()
+}
+
+func (r *Renderer) projectionFor(width, height int32) mgl32.Mat4 {
+	return mgl32.Perspective(mgl32.DegToRad(r.fov), float32(width)/float32(height), r.near, r.far)
+}
+
+// Render draws the shown layers into the bound framebuffer.
+func (r *Renderer) Render() {
+	r.timers.frame()
+	r.runGPUSim()
+	r.renderLayers(r.layers.shown, true)
+}
+
+// renderLayers draws the layers of set into the bound framebuffer,
+// clearing it first if clear is set.
+func (r *Renderer) renderLayers(set Layers, clear bool) {
+	if !set.Has(BackgroundLayer) {
+		gl.ClearColor(0, 0, 0, 1)
+		defer r.styles[r.style].clearColor()
+	}
+	if clear {
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	}
+	r.layers.drawing = set
+	defer func() { r.layers.drawing = r.layers.shown }()
+	if set.Has(LatticeLayer) || set.Has(OverlayLayer) {
+		r.renderSceneLayers()
+	}
+	if r.cinema.on {
+		return
+	}
+
+	w, h := r.w.GetFramebufferSize()
+	scale := hudScale(h)
+	overlays, debug := set.Has(OverlayLayer), set.Has(DebugLayer)
+	if debug && r.showStats {
+		hud.AddPanel(r.text, 8*scale, 8*scale, scale, r.statsText())
+	}
+	if overlays {
+		r.addPicked(r.text, w, scale)
+		r.addLegend(r.text, w, h, scale)
+		r.addCompass(r.text, w, scale)
+		r.addMinimap(r.text, w, h, scale)
+	}
+	if debug {
+		r.toasts.Add(r.text, h, scale)
+		if r.prompt != nil {
+			r.prompt.add(r.text, w, h, scale)
+		}
+	}
+	r.timers.begin(hudPass)
+	if overlays {
+		r.drawMinimap(w, h, scale)
+	}
+	r.text.Draw(w, h)
+	if overlays {
+		r.drawCompass(w, h, scale)
+	}
+	r.timers.end(hudPass)
+}
+
+// renderSceneLayers draws the lattice and the overlays in the scene
+// through the post-processing effects, if any.
+func (r *Renderer) renderSceneLayers() {
+	x, y, width, height := r.viewport()
+	// With post-processing the scene is drawn at the origin of the
+	// framebuffer of the chain.
+	sx, sy, post := x, y, r.post.active()
+	if post {
+		if err := r.post.begin(x, y, width, height, r.projection); err != nil {
+			r.NotifyError(err)
+			r.ClearPostEffects()
+			post = false
+		} else {
+			sx, sy = 0, 0
+		}
+	}
+	if r.beauty != nil && !r.overdrawShown() {
+		if err := r.beauty.render(r, sx, sy, width, height); err != nil {
+			r.NotifyError(err)
+			r.SetBeauty(false)
+		}
+	} else {
+		r.renderScene(&r.compare, sx, sy, width, height)
+	}
+	if post {
+		r.timers.begin(postPass)
+		if err := r.post.end(); err != nil {
+			r.NotifyError(err)
+			r.ClearPostEffects()
+		}
+		r.timers.end(postPass)
+	}
+}
+
+// colorPhase returns how far the gradient of a generated lattice has
+// cycled, or 0 where the cells show colors of their own.
+func (r *Renderer) colorPhase() float32 {
+	if !r.cells.generated || r.coloring.colormap != NoColormap || r.params.ColorPeriod <= 0 {
+		return 0
+	}
+	return float32(math.Mod(r.frameTimer.prevTime/r.params.ColorPeriod, 1))
+}
+
+// overdrawShown reports whether the overdraw view replaces the lattice in
+// the frame being drawn, which it does only with the debug layer.
+func (r *Renderer) overdrawShown() bool {
+	return r.showOverdraw && r.layers.drawing.Has(DebugLayer)
+}
+
+// renderScene draws the lattice, compared as c, the crystallographic
+// plane, the cell overlay, the ghosts of collaborators and the breadcrumbs
+// into the viewport at x, y of the given size, without the HUD, as far as
+// the layers drawn hold them.
+func (r *Renderer) renderScene(c *Comparison, x, y, width, height int32) {
+	gl.UseProgram(r.program)
+	gl.BindVertexArray(r.vao)
+	gl.UniformMatrix4fv(r.cameraUniform, 1, false, &r.camera[0])
+	gl.Uniform1f(r.timeUniform, float32(r.frameTimer.prevTime))
+	gl.Uniform1f(r.colorPhaseUniform, r.colorPhase())
+	r.bindLightmap()
+	r.bindDecals()
+	r.bindCellTextures()
+	r.bindMaterials()
+	r.bindDisplacement()
+	r.bindFade()
+	r.bindShadows()
+
+	r.timers.begin(latticePass)
+	defer r.timers.end(latticePass)
+	if r.layers.drawing.Has(LatticeLayer) {
+		if r.overdrawShown() {
+			r.overdraw.Render(r.projection, r.camera, r.model, r.draws)
+		} else {
+			c.render(r, x, y, width, height)
+		}
+	}
+	if !r.layers.drawing.Has(OverlayLayer) {
+		return
+	}
+	if frame := r.params.cellFrame(); r.plane != [3]int{} && !frame.empty() {
+		r.planes.Render(r.projection, r.camera, frame, r.plane)
+	}
+	if r.cellOverlay != NoCellOverlay {
+		r.cellFrames.Render(r.projection, r.camera, r.params.cellFrame(), r.cellOverlay)
+	}
+	if r.picked != nil {
+		r.cellFrames.RenderBox(r.projection, r.camera, r.pickedBox(), r.pickedBoxColor())
+	}
+	if r.collab != nil {
+		r.ghosts.Render(r.projection, r.camera, r.collab.ghostList())
+	}
+	lines, colors := r.crumbs.lines()
+	r.lines.Render(r.projection, r.camera, lines, colors)
+}
+
+// statsText describes the frame rate, the camera, the mesh, the memory
+// taken by the cells, the GPU time of the passes and the state of the GPU
+// simulation, if any, for the HUD.
+func (r *Renderer) statsText() string {
+	fps := float32(0)
+	if r.frameTimer.mspf > 0 {
+		fps = 1000 / r.frameTimer.mspf
+	}
+	packed, unpacked := r.cells.memory()
+	text := r.locale.Sprintf(MsgStats,
+		fps, r.frameTimer.mspf,
+		r.formatPos(r.worldPos(r.camPos)),
+		mgl32.RadToDeg(r.roll), mgl32.RadToDeg(r.pitch), mgl32.RadToDeg(r.yaw),
+		r.fov, r.drawn, r.count) + "\n" +
+		r.locale.Sprintf(MsgMemory, r.cells.count(), float64(packed)/(1<<20), float64(unpacked)/(1<<20)) + "\n" +
+		r.locale.Sprintf(MsgGPUTimes, r.timers.ms[simPass], r.timers.ms[shadowPass], r.timers.ms[latticePass], r.timers.ms[postPass], r.timers.ms[hudPass])
+	if r.gpuSim != nil {
+		text += "\n" + r.gpuSim.stats(r.locale)
+	}
+	return text
+}
+
+// Texture units of the lattice program.
+const (
+	lightmapUnit = 1
+	decalUnit    = 2
+	shadowUnit   = 3
+)
+
+func (r *Renderer) bindLightmap() {
+	if r.lightmap == nil {
+		gl.Uniform1f(r.lightUniforms.on, 0)
+		return
+	}
+	d := float32(r.params.HalfSize)
+	gl.Uniform1f(r.lightUniforms.on, 1)
+	gl.Uniform3f(r.lightUniforms.origin, -d, -d, -d)
+	gl.Uniform1f(r.lightUniforms.side, float32(r.lightmap.side))
+	gl.ActiveTexture(gl.TEXTURE0 + lightmapUnit)
+	gl.BindTexture(gl.TEXTURE_3D, r.lightmap.tex)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// bindShadows renders the shadow map and binds it for the lattice pass.
+func (r *Renderer) bindShadows() {
+	if r.shadowsOff {
+		return
+	}
+	r.timers.begin(shadowPass)
+	r.shadow.Render(r.sun, r.params, r.model, r.clip.equation(), r.casters)
+	r.timers.end(shadowPass)
+	m := r.shadow.matrix
+	gl.UniformMatrix4fv(r.shadowUniforms.matrix, 1, false, &m[0])
+	gl.Uniform1f(r.shadowUniforms.bias, r.shadow.Bias)
+	gl.ActiveTexture(gl.TEXTURE0 + shadowUnit)
+	gl.BindTexture(gl.TEXTURE_2D, r.shadow.depthTex)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+func (r *Renderer) bindDecals() {
+	decals := r.decals.All()
+	var matrices [maxDecals]mgl32.Mat4
+	var layers [maxDecals]int32
+	for i, d := range decals {
+		matrices[i] = d.toBox
+		layers[i] = int32(d.layer)
+	}
+	gl.Uniform1i(r.decalUniforms.count, int32(len(decals)))
+	if len(decals) == 0 {
+		return
+	}
+	gl.UniformMatrix4fv(r.decalUniforms.matrices, int32(len(decals)), false, &matrices[0][0])
+	gl.Uniform1iv(r.decalUniforms.layers, int32(len(decals)), &layers[0])
+	gl.ActiveTexture(gl.TEXTURE0 + decalUnit)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, r.decals.tex)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// AddDecal projects img from the current view direction onto the lattice
+// around center, covering size cells.
+func (r *Renderer) AddDecal(name string, img image.Image, center mgl32.Vec3, size float32) error {
+	if !r.decals.Add(name, img, center, r.orientation(), size) {
+		return r.locale.Errorf(MsgTooManyDecals, maxDecals)
+	}
+	return nil
+}
+
+// ShowOverdraw switches between the lattice and its overdraw heat map.
+func (r *Renderer) ShowOverdraw(show bool) {
+	r.showOverdraw = show
+	if show {
+		r.Notify(MsgOverdraw, r.overdraw.Layers)
+	}
+}
+
+// SetWireframe switches between drawing the faces of the cells and the
+// edges of their triangles in the flat colors of the cells, to inspect the
+// geometry of the shape they are drawn in.
+func (r *Renderer) SetWireframe(on bool) {
+	r.wireframe = on
+	if r.beauty != nil {
+		r.beauty.reset()
+	}
+	if on {
+		r.Notify(MsgWireframe)
+	} else {
+		r.Notify(MsgWireframeOff)
+	}
+}
+
+// BakeLight computes static lighting for the visible cells and draws the
+// lattice with it.
+func (r *Renderer) BakeLight(lp LightParams) {
+	defer r.pauseWatchdog()()
+	start := time.Now()
+	data := bakeLight(r.mesh.Instances(), r.params, lp)
+	r.SetLightmap(NewLightmap(data, r.params.Side()))
+	r.Notify(MsgLightBaked, r.cells.count(), time.Since(start).Round(time.Millisecond))
+}
+
+// SetSun points the directional light towards dir.
+func (r *Renderer) SetSun(dir mgl32.Vec3) {
+	r.sun = dir.Normalize()
+}
+
+// SetLightmap replaces the lightmap, nil to turn baked lighting off.
+func (r *Renderer) SetLightmap(l *Lightmap) {
+	if r.lightmap != nil {
+		r.lightmap.Delete()
+	}
+	r.lightmap = l
+}
+
+// hudScale returns the integer text scale for a framebuffer height, so
+// that text stays readable on large screens.
+func hudScale(height int) float32 {
+	if height < 1080 {
+		return 1
+	}
+	return float32(height / 540)
+}
+
+// Notify reports a message in the window and on the terminal, in the
+// language of the current locale.
+func (r *Renderer) Notify(msg Message, args ...interface{}) {
+	text := r.locale.Sprintf(msg, args...)
+	if !r.quiet {
+		fmt.Println(text)
+	}
+	r.toasts.Info(text)
+}
+
+// SetLocale changes the language of user-facing messages.
+func (r *Renderer) SetLocale(name string) error {
+	locale, ok := NewLocale(name)
+	if !ok {
+		return r.locale.Errorf(MsgUnknownLocale, name, strings.Join(Locales(), ", "))
+	}
+	r.locale = locale
+	r.Notify(MsgLocaleChanged, locale.Sprintf(MsgLanguageName))
+	return nil
+}
+
+// SetSwapMode changes how buffer swaps are synchronized with the display.
+func (r *Renderer) SetSwapMode(m SwapMode) {
+	r.swap = applySwapMode(m)
+	r.Notify(MsgSwapMode, r.swap)
+}
+
+// NotifyError reports an error in the window and in the log.
+func (r *Renderer) NotifyError(err error) {
+	log.Println(err)
+	r.toasts.Error(err.Error())
+}
+
+func (r *Renderer) drawLattice(settings RenderSettings) {
+	if settings.Multisample {
+		gl.Enable(gl.MULTISAMPLE)
+	} else {
+		gl.Disable(gl.MULTISAMPLE)
+	}
+	if (settings.Wireframe || r.wireframe) && r.drawWireframe(settings) {
+		return
+	}
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	style := r.styles[r.style]
+	if settings.Occlusion {
+		gl.Uniform1f(r.aoUniform, style.Occlusion)
+	} else {
+		gl.Uniform1f(r.aoUniform, 0)
+	}
+	if settings.Shading {
+		gl.Uniform1f(r.diffuseUniform, style.Diffuse)
+	} else {
+		gl.Uniform1f(r.diffuseUniform, 0)
+	}
+	gl.Uniform3f(r.sunUniform, r.sun[0], r.sun[1], r.sun[2])
+	if settings.Shadows && style.Shadows && !r.shadowsOff {
+		gl.Uniform1f(r.shadowUniforms.on, 1)
+	} else {
+		gl.Uniform1f(r.shadowUniforms.on, 0)
+	}
+	r.bindStyle(style)
+	r.bindFog(style)
+	clip := r.clip.equation()
+	gl.Uniform4f(r.clipUniform, clip[0], clip[1], clip[2], clip[3])
+	defer enableClipping()()
+	gl.Uniform1f(r.alphaUniform, r.alpha)
+	switch {
+	case r.alpha < 1:
+		defer blendTransparent()()
+	case style.Opacity < 1:
+		// The faces are added up rather than hiding each other.
+		gl.Enable(gl.BLEND)
+		gl.BlendFunc(gl.ONE, gl.ONE)
+		gl.DepthMask(false)
+		defer gl.Disable(gl.BLEND)
+		defer gl.DepthMask(true)
+	}
+
+	if r.gpuSim != nil {
+		r.drawGPUSim()
+		return
+	}
+	for _, d := range r.draws {
+		shift := d.shift
+		if !settings.Animate {
+			shift = restShift
+		}
+		gl.Uniform1f(r.shiftUniform, shift)
+		r.mesh.Draw(r.attribs, d.first, d.count)
+	}
+	r.drawTrails()
+}
+
+// UploadMesh rebuilds the mesh from the cells and their groups.
+func (r *Renderer) UploadMesh() {
+	r.mesh.Upload(r.cells, r.groups, r.params.CubeSize, r.cellLook())
+	r.meshChanged()
+}
+
+// cellLook returns how the display settings change the cells: colored by
+// the colormap, scaled by the size field, then faded by density, or nil if
+// none of them is on. It keeps the legend of the colormap for the HUD.
+func (r *Renderer) cellLook() cellLook {
+	color, legend := r.coloring.look(r.cells)
+	r.legend = legend
+	look := chainLooks(color, r.sizing.look(r.cells))
+	return chainLooks(look, r.fade.look(r.cells))
+}
+
+// lookOfAll reports whether the look of a cell depends on all cells, as
+// colors and sizes normalized over them do, so that a change of any cell
+// re-meshes all.
+func (r *Renderer) lookOfAll() bool {
+	return r.coloring.colormap != NoColormap || r.sizing.on || r.fade.Radius > 0
+}
+
+// remeshCells rebuilds the parts of the mesh around the cells ids, whose
+// groups changed. Edits cost the same however large the lattice is.
+func (r *Renderer) remeshCells(ids Selection) {
+	r.mesh.Remesh(r.groups, ids)
+	r.meshChanged()
+}
+
+func (r *Renderer) meshChanged() {
+	r.count = r.mesh.Triangles()
+	r.materials.dirty = true
+	if r.beauty != nil {
+		r.beauty.reset()
+	}
+	if r.trails != nil {
+		r.trails.record(r.mesh.Instances())
+	}
+	if r.usd != nil {
+		r.usd.addLattice(r.frameTimer.prevTime, r.mesh.Instances())
+	}
+}
+
+// StartUSD starts recording the camera path and the visible cells for
+// export as a USD stage. If changes is set, every change of the cells is
+// recorded as well.
+func (r *Renderer) StartUSD(path string, changes bool) {
+	w, h := r.w.GetFramebufferSize()
+	aspect := float32(1)
+	if h > 0 {
+		aspect = float32(w) / float32(h)
+	}
+	r.usd = newUSDRecorder(path, r.frameTimer.prevTime, aspect, r.fov, r.near, r.far, r.params.CubeSize, r.mesh.shape, changes)
+	r.usd.addLattice(r.frameTimer.prevTime, r.mesh.Instances())
+	r.Notify(MsgUSDRecording, path)
+}
+
+// StopUSD stops recording and writes the USD stage.
+func (r *Renderer) StopUSD() error {
+	if r.usd == nil {
+		return r.locale.Errorf(MsgNotRecordingUSD)
+	}
+	u := r.usd
+	r.usd = nil
+	if err := u.Save(); err != nil {
+		return err
+	}
+	r.Notify(MsgUSDSaved, len(u.cameras), u.path)
+	return nil
+}
+
+// SetCells replaces the displayed cells. Groups and the selection are
+// carried over by lattice coordinates.
+func (r *Renderer) SetCells(cells []cell, params LatticeParams) {
+	r.setStore(newCellStore(cells, params))
+}
+
+// setStore replaces the displayed cells by the packed cells s, in the
+// lattice described by s.params.
+func (r *Renderer) setStore(s *cellStore) {
+	params := s.params
+	relaid := params != r.params
+	if relaid {
+		r.groups.Remap(r.params, params)
+		r.selection = r.selection.Remap(r.params, params)
+		if r.lightmap != nil && (r.lightmap.side != params.Side() || !params.gridAligned()) {
+			r.SetLightmap(nil)
+		}
+	}
+	r.cells = s
+	r.params = params
+	r.crystal = nil
+	r.pristine = nil
+	r.picked = nil
+	r.UploadMesh()
+	// Trails across lattices of different layouts would not line up.
+	if relaid && r.trails != nil {
+		r.trails.clear(r.mesh.Instances())
+	}
+}
+
+// rebuildLattice regenerates the lattice with d cells on each side of the
+// origin and uploads it, unless it would be too large.
+func (r *Renderer) rebuildLattice(d int) error {
+	if d < 0 {
+		d = 0
+	}
+	params := r.params
+	params.HalfSize = d
+	params.Scale = 0
+	params.crystal = cellFrame{}
+	if err := params.checkSize(r.locale); err != nil {
+		return err
+	}
+	r.setStore(generateStore(params))
+	r.Notify(MsgLatticeSize, params.Side(), r.cells.count())
+	return nil
+}
+
+// SetLatticeType regenerates the lattice with the cells arranged as t.
+func (r *Renderer) SetLatticeType(t LatticeType) {
+	params := r.params
+	params.Type = t
+	params.Scale = 0
+	params.crystal = cellFrame{}
+	r.setStore(generateStore(params))
+	r.Notify(MsgLatticeType, t, r.cells.count())
+}
+
+// ToggleSelectionAtCenter toggles the selection of the cell in the middle
+// of the window, where the camera is aimed.
+func (r *Renderer) ToggleSelectionAtCenter() {
+	w, h := r.w.GetSize()
+	id, ok := r.PickAt(float64(w)/2, float64(h)/2)
+	if !ok {
+		return
+	}
+	x, y, z, _ := r.params.CellPos(id)
+	if r.selection.Toggle(id) {
+		r.Notify(MsgSelectedCell, x, y, z, len(r.selection))
+	} else {
+		r.Notify(MsgDeselectedCell, x, y, z, len(r.selection))
+	}
+}
+
+// GroupSelection stores the current selection as a new group and clears
+// the selection.
+func (r *Renderer) GroupSelection() {
+	if len(r.selection) == 0 {
+		return
+	}
+	name := fmt.Sprintf("group-%d", r.groups.Len()+1)
+	if _, err := r.groups.Add(name, r.selection); err != nil {
+		r.NotifyError(err)
+		return
+	}
+	changed := r.selection
+	r.selection = NewSelection()
+	r.Notify(MsgGroupCreated, name)
+	r.groupsChanged(changed)
+}
+
+// ToggleLastGroup hides or shows the most recently created group.
+func (r *Renderer) ToggleLastGroup() {
+	all := r.groups.All()
+	if len(all) == 0 {
+		return
+	}
+	g := all[len(all)-1]
+	g.Hidden = !g.Hidden
+	r.groupsChanged(g.Cells)
+}
+
+func (r *Renderer) updateGroup(name string, update func(g *Group)) error {
+	g := r.groups.Get(name)
+	if g == nil {
+		return r.locale.Errorf(MsgNoGroup, name)
+	}
+	update(g)
+	r.groupsChanged(g.Cells)
+	return nil
+}
+
+// groupsChanged saves the groups and re-meshes the cells changed, the cells
+// whose group or group settings changed.
+func (r *Renderer) groupsChanged(changed Selection) {
+	if err := r.groups.Save(r.groupsFile, r.params); err != nil {
+		r.NotifyError(r.locale.Errorf(MsgSaveGroupsFailed, err))
+	}
+	r.remeshCells(changed)
+	r.shareGroups()
+}
+
+// PickAt returns the ID of the cell under the window position (x, y), as
+// reported by the cursor callbacks.
+func (r *Renderer) PickAt(x, y float64) (uint32, bool) {
+	if r.picker == nil {
+		return noCell, false
+	}
+	w, h := r.w.GetSize()
+	fbw, fbh := r.w.GetFramebufferSize()
+	if w == 0 || h == 0 {
+		return noCell, false
+	}
+	fx := int(x * float64(fbw) / float64(w))
+	fy := int(y * float64(fbh) / float64(h))
+	return r.picker.PickAt(fx, fy, r.projection, r.camera, r.model, r.clip.equation(), r.draws)
+}
+
+func (r *Renderer) OnKey(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if r.prompt != nil {
+		r.onPromptKey(key, action)
+		return
+	}
+	if action != glfw.Press && action != glfw.Release {
+		return
+	}
+
+	camSpeed := float32(5.0)
+	if (mods & glfw.ModControl) > 0 {
+		camSpeed = 20
+	}
+	if (mods & glfw.ModShift) > 0 {
+		camSpeed = 0.1
+	}
+	mul := float32(1.0)
+	if action == glfw.Release {
+		mul = 0
+	}
+
+	switch key {
+
+	case glfw.KeyA:
+		r.camSpeed[0] = -camSpeed * mul
+	case glfw.KeyD:
+		r.camSpeed[0] = +camSpeed * mul
+	case glfw.KeyW:
+		r.camSpeed[2] = -camSpeed * mul
+	case glfw.KeyS:
+		r.camSpeed[2] = +camSpeed * mul
+	case glfw.KeySpace:
+		r.camSpeed[1] = +camSpeed * mul
+	case glfw.KeyZ:
+		r.camSpeed[1] = -camSpeed * mul
+	case glfw.KeyUp:
+		r.rotationSpeed[0] = +r.turnRate * mul
+	case glfw.KeyDown:
+		r.rotationSpeed[0] = -r.turnRate * mul
+	case glfw.KeyLeft:
+		r.rotationSpeed[1] = +r.turnRate * mul
+	case glfw.KeyRight:
+		r.rotationSpeed[1] = -r.turnRate * mul
+
+	case glfw.KeyP:
+		if action == glfw.Press {
+			r.ToggleSelectionAtCenter()
+		}
+	case glfw.KeyG:
+		if action == glfw.Press {
+			r.GroupSelection()
+		}
+	case glfw.KeyH:
+		if action == glfw.Press {
+			r.ToggleLastGroup()
+		}
+	case glfw.KeyV:
+		if action == glfw.Press && mods&glfw.ModControl != 0 {
+			if err := r.PasteViewLink(); err != nil {
+				r.NotifyError(err)
+			}
+			break
+		}
+		if action == glfw.Press {
+			r.compare.NextMode()
+			r.Notify(MsgComparison, &r.compare)
+		}
+	case glfw.KeyB:
+		if action == glfw.Press {
+			r.compare.NextB()
+			r.Notify(MsgComparison, &r.compare)
+		}
+
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		if action == glfw.Press {
+			if err := r.rebuildLattice(r.params.HalfSize + 1); err != nil {
+				r.NotifyError(err)
+			}
+		}
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		if action == glfw.Press {
+			if err := r.rebuildLattice(r.params.HalfSize - 1); err != nil {
+				r.NotifyError(err)
+			}
+		}
+
+	case glfw.KeyF1:
+		if action == glfw.Press {
+			r.showStats = !r.showStats
+		}
+	case glfw.KeyF2:
+		if action == glfw.Press {
+			r.SetWireframe(!r.wireframe)
+		}
+	case glfw.KeyF6:
+		if action == glfw.Press {
+			r.ShowOverdraw(!r.showOverdraw)
+		}
+	case glfw.KeyF:
+		if action == glfw.Press {
+			r.FitView()
+		}
+	case glfw.KeyF3:
+		if action == glfw.Press {
+			r.ToggleCompass()
+		}
+	case glfw.KeyF5:
+		if action == glfw.Press {
+			r.ToggleMinimap()
+		}
+	case glfw.KeyF4:
+		if action == glfw.Press && mods&glfw.ModShift != 0 {
+			r.ClearBreadcrumbs()
+		} else if action == glfw.Press {
+			r.SetBreadcrumbs(!r.crumbs.on, r.crumbs.spacing)
+		}
+	case glfw.KeyTab:
+		if action == glfw.Press {
+			r.ToggleOrbit()
+		}
+	case glfw.KeyF11:
+		if action == glfw.Press {
+			r.ToggleFullscreen()
+		}
+	case glfw.KeyF12:
+		if action == glfw.Press {
+			r.screenshotPending = true
+			r.screenshotTransparent = mods&glfw.ModShift != 0
+		}
+	case glfw.KeyR:
+		if action == glfw.Press {
+			r.ToggleRecording()
+		}
+	case glfw.KeyL:
+		if action == glfw.Press {
+			r.SetLatticeType(r.params.Type.next())
+		}
+	case glfw.KeyO:
+		if action == glfw.Press {
+			r.SetShape(r.mesh.shape.next())
+		}
+	case glfw.KeyK:
+		if action == glfw.Press {
+			r.ToggleCinema()
+		}
+	case glfw.KeyU:
+		if action == glfw.Press {
+			r.CycleCellOverlay()
+		}
+	case glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5, glfw.Key6, glfw.Key7, glfw.Key8, glfw.Key9:
+		if i := int(key - glfw.Key1); action == glfw.Press && i < len(r.styles) {
+			r.SetStyle(i)
+		}
+	case glfw.KeyRightBracket:
+		if action != glfw.Release {
+			r.GrowSupercell(1)
+		}
+	case glfw.KeyLeftBracket:
+		if action != glfw.Release {
+			r.GrowSupercell(-1)
+		}
+	case glfw.KeyM:
+		if action == glfw.Press {
+			r.PromptPlane()
+		}
+	case glfw.KeyJ:
+		if action == glfw.Press {
+			r.ToggleBeauty()
+		}
+	case glfw.KeyX:
+		if action == glfw.Press {
+			r.ToggleClip()
+		}
+	case glfw.KeyN:
+		if action == glfw.Press && mods&glfw.ModShift != 0 {
+			r.ClipToView()
+		} else if action == glfw.Press {
+			r.NextClipAxis()
+		}
+	case glfw.KeyPeriod:
+		if action != glfw.Release {
+			r.MoveClip(r.clipStep(mods&glfw.ModShift != 0))
+		}
+	case glfw.KeyComma:
+		if action != glfw.Release {
+			r.MoveClip(-r.clipStep(mods&glfw.ModShift != 0))
+		}
+	case glfw.KeyI:
+		if action == glfw.Press {
+			r.TogglePointing()
+		}
+	case glfw.KeyE:
+		if action == glfw.Press {
+			r.SetEditing(!r.editing)
+		}
+	case glfw.KeyT:
+		if action == glfw.Press {
+			r.AddKeyframe(defaultKeyGap)
+		}
+	case glfw.KeyY:
+		if action == glfw.Press {
+			r.TogglePath()
+		}
+	case glfw.KeyEnter:
+		if action == glfw.Press && mods&glfw.ModAlt != 0 {
+			r.ToggleFullscreen()
+		}
+
+	case glfw.KeyC:
+		if mods&glfw.ModControl != 0 {
+			if action == glfw.Press {
+				r.CopyViewLink()
+			}
+			break
+		}
+		r.SetCamera(mgl32.Vec3{30, 30, 30}, -34.5, 45)
+	case glfw.KeyEscape:
+		w.SetShouldClose(true)
+	}
+}
+
+// ToggleFullscreen switches between fullscreen on the primary monitor and
+// a windowWidth by windowHeight window centered on it. The window, and with
+// it the GL context, is kept.
+func (r *Renderer) ToggleFullscreen() {
+	m := glfw.GetPrimaryMonitor()
+	vm := m.GetVideoMode()
+	if r.w.GetMonitor() != nil {
+		x, y := m.GetPos()
+		x += (vm.Width - windowWidth) / 2
+		y += (vm.Height - windowHeight) / 2
+		r.w.SetMonitor(nil, x, y, windowWidth, windowHeight, 0)
+	} else {
+		r.w.SetMonitor(m, 0, 0, vm.Width, vm.Height, vm.RefreshRate)
+	}
+	// Some drivers reset the swap interval when the window changes mode.
+	applySwapMode(r.swap)
+	r.cursor.reanchor()
+}
+
+// OnFramebufferSize adapts the viewport, the projection and the picking
+// buffer to a resized window.
+func (r *Renderer) OnFramebufferSize(w *glfw.Window, width, height int) {
+	if width == 0 || height == 0 {
+		return
+	}
+	// A new size often comes with a new monitor or mode, both of which
+	// move the cursor.
+	r.cursor.reanchor()
+	r.updateViewport()
+	if err := r.picker.Resize(width, height); err != nil {
+		r.NotifyError(err)
+	}
+	if err := r.overdraw.Resize(width, height); err != nil {
+		r.NotifyError(err)
+	}
+}
+
+func (r *Renderer) OnCursorEnter(w *glfw.Window, entered bool) {
+	r.cursor.setEnabled(entered)
+}
+
+// OnFocus stops the camera from following the mouse while another window
+// has focus. With the cursor disabled no enter events arrive, so this is
+// what ends and resumes mouse look when switching windows.
+func (r *Renderer) OnFocus(w *glfw.Window, focused bool) {
+	r.cursor.setEnabled(focused)
+}
+
+// setCursorMode changes the cursor mode of the window. The cursor is
+// re-anchored, since disabling or enabling it moves it.
+func (r *Renderer) setCursorMode(mode int) {
+	r.w.SetInputMode(glfw.CursorMode, mode)
+	r.cursor.reanchor()
+}
+
+func (r *Renderer) OnMouseButton(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+	if r.editing {
+		if action != glfw.Press {
+			return
+		}
+		switch button {
+		case glfw.MouseButtonLeft:
+			r.RemoveCellAt(r.clickPos())
+		case glfw.MouseButtonRight:
+			r.PlaceCellAt(r.clickPos())
+		}
+		return
+	}
+	if button == glfw.MouseButtonRight {
+		r.compare.dragging = action == glfw.Press && r.compare.Mode == CompareSplit
+	}
+	if button == glfw.MouseButtonLeft && action == glfw.Press {
+		r.PickCell(r.clickPos())
+	}
+}
+
+func (r *Renderer) OnCursorPos(w *glfw.Window, xpos, ypos float64) {
+	dx, dy := r.cursor.move(xpos, ypos)
+	if r.compare.dragging {
+		width, _ := w.GetSize()
+		r.compare.Drag(dx, width)
+		return
+	}
+	if r.pointing {
+		return
+	}
+	r.cursor.add(dx, dy)
+}
+
+func normAngle(rad float32) float32 {
+	for rad > math.Pi {
+		rad -= 2 * math.Pi
+	}
+	for rad < -math.Pi {
+		rad += 2 * math.Pi
+	}
+	return rad
+}
+
+// Options configures the viewer started by Run.
+type Options struct {
+	// Title is the window title.
+	Title   string
+	Lattice LatticeParams
+	// CIF, if set, is a crystallography file whose structure is shown
+	// instead of the generated lattice, expanded into Supercell unit
+	// cells along a, b and c.
+	CIF       string
+	Supercell [3]int
+	// Molecule, if set, is an XYZ or PDB file whose atoms are shown
+	// instead of the generated lattice.
+	Molecule string
+	// Watch, if set, is a lattice file shown instead of the generated
+	// lattice and reloaded whenever it changes.
+	Watch string
+	// Script, if set, is a file of console commands run at startup, and
+	// again whenever the process is sent SIGHUP.
+	Script string
+	// CameraPath, if set, is a file of camera keyframes the camera flies
+	// along from the first frame.
+	CameraPath string
+	// Scene, if set, is a scene file whose cells, camera and render
+	// settings replace those set by the other options. Whenever the
+	// process is sent SIGHUP the settings changed in it are applied.
+	Scene string
+	// View, if not nil, is a shared view link opened after the scene.
+	View *ViewLink
+	// Demo, if set, is a demo file played from the first frame.
+	Demo string
+	// GroupsFile is where cell groups are loaded from and saved to.
+	GroupsFile string
+	// Console, if not nil, is read for console commands, one per line.
+	Console io.Reader
+	// Locale selects the language of user-facing messages.
+	Locale string
+	// Quiet keeps notifications off the terminal, such as when it carries
+	// the results of a benchmark.
+	Quiet bool
+	// Shape is the primitive cells are drawn as.
+	Shape CellShape
+	// CellOverlay selects the unit cell edges drawn over the lattice.
+	CellOverlay CellOverlay
+	// Plane, if not zero, are the Miller indices of a crystallographic
+	// plane to draw through the lattice.
+	Plane [3]int
+	// Colormap, unless off, colors the cells by the scalar Field through
+	// it: distance, index, value or a file of values.
+	Colormap Colormap
+	Field    string
+	// ColorRange spreads the scalars over the colormap, after clipping the
+	// percentage ColorClip of the cells at either end to its ends.
+	ColorRange ColorRange
+	ColorClip  float32
+	// DataKind is whether the scalars are continuous or categorical.
+	DataKind DataKind
+	// SizeField, if set, scales the cells by a second scalar field, from
+	// MinSize for the smallest scalar to full size for the largest.
+	SizeField string
+	MinSize   float32
+	// Fade, unless its radius is 0, shrinks and dims cells in dense
+	// regions.
+	Fade DensityFade
+	// Wireframe draws the edges of the triangles of the cells in flat
+	// colors instead of their faces.
+	Wireframe bool
+	// Alpha is the opacity of the cubes, from 0 to 1. Below 1 they are
+	// drawn back to front by chunk, blended over those behind them.
+	Alpha float32
+	// Fog fades the cubes into a color with their depth from the camera.
+	Fog Fog
+	// Compass shows the axes of the data as the view turns them.
+	// Breadcrumbs, if above 0, drops a marker every Breadcrumbs world
+	// units along the path of the camera.
+	Compass     bool
+	Breadcrumbs float32
+	// Minimap shows the lattice from above in an inset, with the frustum
+	// of the camera.
+	Minimap bool
+	// PostEffects are run over the scene in order once it is drawn.
+	PostEffects []PostEffect
+	// Near and Far are the distances of the near and far planes of the
+	// projection. Fit frames the lattice at startup instead, fitting the
+	// planes around it.
+	Near, Far float32
+	Fit       bool
+	// Trails, if above 0, is the number of changes of the cells whose
+	// previous positions are drawn as fading trails.
+	Trails int
+	// GPUSim, if set, is a simulation run on the GPU in place of the
+	// lattice, on a grid of GPUSimSide cells a side, stepped GPUSimSteps
+	// times per frame, 0 for the default of the simulation.
+	GPUSim      GPUSim
+	GPUSimSide  int
+	GPUSimSteps int
+	// Up and Handedness are the conventions of the axes of the data, and
+	// Units the physical length of a grid step, for the HUD readouts and
+	// measurements.
+	Up         UpAxis
+	Handedness Handedness
+	Units      Units
+	// Defects are point defects injected into the cells at startup.
+	Defects Defects
+	// Styles are the styles the number keys select, in order. Style is
+	// the name of the one to start with, the first if empty.
+	Styles []Style
+	Style  string
+	// Swap selects how buffer swaps are synchronized with the display.
+	Swap SwapMode
+	// Width and Height are the window size in screen coordinates. Zero
+	// uses the video mode of the primary monitor when fullscreen, and
+	// windowWidth by windowHeight otherwise.
+	Width, Height int
+	Fullscreen    bool
+	// Layers are the render layers shown in the window and CaptureLayers
+	// those screenshots and recordings capture, 0 for AllLayers and
+	// DefaultCaptureLayers.
+	Layers, CaptureLayers Layers
+	// Textures are PNG or JPEG images the faces of the cells are textured
+	// with, taken in turn by the lattice sites.
+	Textures []string
+	// Displacement, if its amplitude is not 0, moves the cells by noise or
+	// an image over time.
+	Displacement Displacement
+	// Samples is the number of multisampling samples, 0 to disable it. If
+	// the driver provides none, the edges are smoothed by FXAA instead.
+	Samples int
+	// DeepColor asks for a window with 10 bits a color channel and 2 of
+	// alpha, for deep color displays. It is not HDR: colors still end at 1.
+	DeepColor bool
+	// ShaderDir, if set, is a directory to load the lattice shaders from
+	// instead of the built-in ones. They are rebuilt when the files change.
+	ShaderDir string
+	// TurnRate is how fast the arrow keys turn the camera, in degrees per
+	// second.
+	TurnRate float32
+	// Gamepad maps the axes of a connected gamepad to camera motion.
+	Gamepad GamepadMapping
+	// ScreenshotDir is the directory F12 saves screenshots and R saves
+	// recordings to. It is created when needed.
+	ScreenshotDir string
+	// RecordFrameRate is the frame rate of recordings, which cinema mode
+	// locks the frame rate to.
+	RecordFrameRate int
+	// RecordMotion writes the motion of every recorded frame on screen, as
+	// Middlebury .flo files of pixel offsets, into a directory next to the
+	// recording, for frame interpolation and encoding by external tools.
+	RecordMotion bool
+	// CinemaAspect is the aspect of the picture in cinema mode.
+	CinemaAspect float32
+	// Panorama, if not zero, makes RunHeadless write top-bottom stereo
+	// equirectangular frames of this width for VR video instead of the
+	// window. EyeSeparation is the distance between their eyes.
+	Panorama      int
+	EyeSeparation float32
+	// RecoveryDir is where the session is autosaved every AutosavePeriod,
+	// and restored from after an unclean exit. A zero period turns
+	// autosaving off.
+	RecoveryDir    string
+	AutosavePeriod time.Duration
+	// Watchdog, if above 0, reports a hang when no frame starts for this
+	// long, for unattended displays: it logs where the frame loop is stuck
+	// and calls OnHang, if not nil, on a goroutine of its own. Baking light
+	// and loading files pause it. OnHang may restart the program, whose
+	// new process recovers the session if autosaving is on.
+	Watchdog time.Duration
+	OnHang   func()
+	// Host, if set, is the address to host a collaborative session on.
+	// Join, if set, is the address of a session to join instead.
+	// CollabName names this client in the session, the host name and
+	// process ID if empty.
+	Host, Join string
+	CollabName string
+	// CollabAuth restricts who may join a hosted session, and how this
+	// client joins one.
+	CollabAuth CollabAuth
+}
+
+// defaultTurnRate is the default Options.TurnRate.
+const defaultTurnRate = 90
+
+func DefaultOptions() Options {
+	return Options{
+		Title:           "Render",
+		Lattice:         DefaultLatticeParams(),
+		Supercell:       defaultSupercell,
+		GroupsFile:      "groups.json",
+		Console:         os.Stdin,
+		Locale:          DetectLocale(),
+		Fullscreen:      true,
+		Samples:         8,
+		TurnRate:        defaultTurnRate,
+		Gamepad:         DefaultGamepadMapping(),
+		Styles:          DefaultStyles(),
+		Fade:            DensityFade{Strength: defaultFadeStrength},
+		MinSize:         defaultMinSize,
+		GPUSimSide:      defaultGPUSimSide,
+		Alpha:           1,
+		Compass:         true,
+		Near:            defaultNear,
+		Far:             defaultFar,
+		ScreenshotDir:   ".",
+		RecordFrameRate: defaultRecordFrameRate,
+		CinemaAspect:    defaultCinemaAspect,
+		EyeSeparation:   defaultEyeSeparation,
+		RecoveryDir:     "recovery",
+		Layers:          AllLayers,
+		CaptureLayers:   DefaultCaptureLayers,
+		AutosavePeriod:  defaultAutosavePeriod,
+	}
+}
+
+// Run opens a window and renders the lattice until the window is closed,
+// rebuilding the renderer in a new window if the GL context is lost. GLFW
+// requires it to be called from the main goroutine, with the OS thread
+// locked by runtime.LockOSThread in an init function.
+func Run(opts Options) error {
+	if err := glfw.Init(); err != nil {
+		return fmt.Errorf("failed to initialize glfw: %v", err)
+	}
+	defer glfw.Terminate()
+
+	r, err := newWindowRenderer(opts)
+	if err != nil {
+		return err
+	}
+	for {
+		r.Run()
+		if !r.contextLost {
+			return r.Close()
+		}
+		if r, err = r.rebuild(opts); err != nil {
+			return err
+		}
+	}
+}
+
+// NewRenderer sets up rendering into window, makes its context current
+// and installs the input callbacks.
+func NewRenderer(window *glfw.Window, opts Options) (*Renderer, error) {
+	r := newRenderer(window)
+	r.quiet = opts.Quiet
+	r.params = opts.Lattice
+	r.groupsFile = opts.GroupsFile
+	r.turnRate = mgl32.DegToRad(opts.TurnRate)
+	r.gamepad = opts.Gamepad
+	if len(opts.Styles) > 0 {
+		r.styles = opts.Styles
+	}
+	r.screenshotDir = opts.ScreenshotDir
+	r.recordFrameRate = opts.RecordFrameRate
+	r.recordMotion = opts.RecordMotion
+	r.collabAuth = opts.CollabAuth
+	if r.recordFrameRate <= 0 {
+		r.recordFrameRate = defaultRecordFrameRate
+	}
+	if opts.CinemaAspect > 0 {
+		r.cinema.aspect = opts.CinemaAspect
+	}
+	r.eyeSeparation = opts.EyeSeparation
+	r.locale, _ = NewLocale(opts.Locale)
+	r.console = NewConsole()
+	if opts.Console != nil {
+		go r.console.Listen(opts.Console)
+	}
+	if opts.Script != "" {
+		if err := r.console.RunScript(r, opts.Script); err != nil {
+			return nil, err
+		}
+		r.reloader = newReloader(opts.Script)
+	}
+
+	r.setInputCallbacks(window)
+	window.SetFramebufferSizeCallback(r.OnFramebufferSize)
+	r.cursor.setEnabled(window.GetAttrib(glfw.Focused) == glfw.True)
+	r.setCursorMode(glfw.CursorDisabled)
+	if glfw.RawMouseMotionSupported() {
+		window.SetInputMode(glfw.RawMouseMotion, glfw.True)
+	}
+
+	window.MakeContextCurrent()
+
+	// Initialize Glow
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+
+	version := gl.GoStr(gl.GetString(gl.VERSION))
+	fmt.Println("OpenGL version", version)
+	r.swap = applySwapMode(opts.Swap)
+	fmt.Println("Display:", displayInfo(window))
+	var colorBits int32
+	gl.GetFramebufferAttachmentParameteriv(gl.FRAMEBUFFER, gl.BACK_LEFT, gl.FRAMEBUFFER_ATTACHMENT_RED_SIZE, &colorBits)
+	fmt.Println("Color depth:", colorBits, "bits per channel")
+	fmt.Println("Swap mode:", r.swap)
+
+	// Configure the vertex and fragment shaders
+	r.shaderDir = opts.ShaderDir
+	program, err := r.buildProgram(ShaderHooks{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The projection follows the framebuffer rather than the window, whose
+	// size is in screen coordinates and differs on high DPI displays.
+	fbw, fbh := window.GetFramebufferSize()
+	r.near, r.far = opts.Near, opts.Far
+	r.projection = r.projectionFor(int32(fbw), int32(fbh))
+	r.model = mgl32.Ident4()
+	r.frame = mgl32.Ident4()
+
+	// Configure the vertex data
+	gl.GenVertexArrays(1, &r.vao)
+	gl.BindVertexArray(r.vao)
+
+	r.mesh = NewMesh()
+	r.decals = NewDecals()
+
+	if r.cells, r.crystal, err = initialStore(opts); err != nil {
+		return nil, err
+	}
+	r.params = r.cells.params
+	if groups, err := LoadGroups(r.groupsFile, r.params); err == nil {
+		r.groups = groups
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Println(err)
+	}
+	if opts.AutosavePeriod > 0 {
+		if s, ok, err := loadRecovery(opts.RecoveryDir); err != nil {
+			log.Println(err)
+		} else if ok {
+			r.restore(s)
+			if err := r.groups.Save(r.groupsFile, r.params); err != nil {
+				log.Println(err)
+			}
+			r.Notify(MsgRecovered, s.time.Format("2006-01-02 15:04:05"))
+		}
+		r.autosaver = newAutosaver(opts.RecoveryDir, opts.AutosavePeriod)
+	}
+	r.mesh.shape = opts.Shape
+	if opts.Colormap != NoColormap {
+		if r.coloring, err = newCellColoring(opts.Colormap, opts.Field); err != nil {
+			return nil, err
+		}
+	}
+	r.coloring.rng, r.coloring.clip, r.coloring.kind = opts.ColorRange, opts.ColorClip, opts.DataKind
+	if opts.SizeField != "" {
+		src, err := newScalarSource(opts.SizeField)
+		if err != nil {
+			return nil, err
+		}
+		r.sizing = cellSizing{on: true, scalarSource: src, min: opts.MinSize}
+	}
+	r.fade = opts.Fade
+	r.wireframe = opts.Wireframe
+	r.alpha = opts.Alpha
+	r.fog = opts.Fog
+	r.up, r.handedness = opts.Up, opts.Handedness
+	r.frame = axesFrame(opts.Up, opts.Handedness)
+	r.units = opts.Units
+	r.UploadMesh()
+	if opts.Trails > 0 {
+		r.trails = newCellTrails(opts.Trails)
+		r.trails.record(r.mesh.Instances())
+	}
+	if opts.GPUSim != NoGPUSim {
+		if err := r.SetGPUSim(opts.GPUSim, opts.GPUSimSide, opts.GPUSimSteps); err != nil {
+			return nil, err
+		}
+	}
+	r.setProgram(program)
+	if len(opts.Textures) > 0 {
+		if r.textures, err = newCellTextures(opts.Textures); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Displacement.Amplitude != 0 {
+		if err := r.SetDisplacement(opts.Displacement); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Fit {
+		r.FitView()
+	}
+
+	if r.shaderDir != "" {
+		watcher, changed, err := watchShaders(r.shaderDir)
+		if err != nil {
+			return nil, err
+		}
+		r.shaderWatcher = watcher
+		r.shadersChanged = changed
+	}
+	if opts.Watch != "" {
+		if err := r.watchFile(opts.Watch); err != nil {
+			return nil, err
+		}
+		r.source = opts.Watch
+	}
+
+	// Configure global settings
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LESS)
+	r.styles[r.style].clearColor()
+
+	picker, err := NewPicker(r.mesh, fbw, fbh)
+	if err != nil {
+		return nil, err
+	}
+	r.picker = picker
+
+	overdraw, err := NewOverdraw(r.mesh, fbw, fbh)
+	if err != nil {
+		picker.Delete()
+		return nil, err
+	}
+	r.overdraw = overdraw
+
+	shadow, err := NewShadowMap(r.mesh, defaultShadowSize)
+	if err != nil {
+		picker.Delete()
+		overdraw.Delete()
+		return nil, err
+	}
+	r.shadow = shadow
+
+	text, err := hud.NewText()
+	if err != nil {
+		picker.Delete()
+		overdraw.Delete()
+		shadow.Delete()
+		return nil, err
+	}
+	r.text = text
+
+	lines, err := newLineRenderer()
+	if err != nil {
+		picker.Delete()
+		overdraw.Delete()
+		shadow.Delete()
+		text.Delete()
+		return nil, err
+	}
+	r.lines = lines
+	r.post = newPostChain(0)
+	for _, e := range opts.PostEffects {
+		if err := r.post.set(e); err != nil {
+			picker.Delete()
+			overdraw.Delete()
+			shadow.Delete()
+			text.Delete()
+			lines.Delete()
+			r.post.Delete()
+			return nil, err
+		}
+	}
+	r.post.samples = r.setupAntialiasing(opts.Samples)
+	r.showCompass = opts.Compass
+	r.showMinimap = opts.Minimap
+	r.layers = layerState{shown: opts.Layers, captured: opts.CaptureLayers}
+	if r.layers.shown == 0 {
+		r.layers.shown = AllLayers
+	}
+	if r.layers.captured == 0 {
+		r.layers.captured = DefaultCaptureLayers
+	}
+	r.layers.drawing = r.layers.shown
+	if opts.Breadcrumbs > 0 {
+		r.crumbs = breadcrumbs{on: true, spacing: opts.Breadcrumbs}
+	}
+	r.timers = newGPUTimers()
+
+	if opts.CellOverlay != NoCellOverlay {
+		if err := r.SetCellOverlay(opts.CellOverlay); err != nil {
+			r.NotifyError(err)
+		}
+	}
+	if opts.Plane != [3]int{} {
+		if err := r.ShowPlane(opts.Plane); err != nil {
+			r.NotifyError(err)
+		}
+	}
+	if !opts.Defects.empty() {
+		r.SetDefects(opts.Defects)
+	}
+	if opts.Style != "" {
+		if err := r.SetStyleByName(opts.Style); err != nil {
+			r.NotifyError(err)
+		}
+	}
+	if opts.Scene != "" {
+		sc, err := readScene(opts.Scene)
+		if err == nil {
+			err = r.applyScene(sc, opts.Scene)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.source = opts.Scene
+		r.watchScene(opts.Scene, sc)
+	}
+	if opts.View != nil {
+		if err := r.OpenViewLink(*opts.View); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Demo != "" {
+		if err := r.PlayDemo(opts.Demo); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CameraPath != "" {
+		if err := r.LoadPath(opts.CameraPath); err != nil {
+			return nil, err
+		}
+		if err := r.PlayPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Host != "" || opts.Join != "" {
+		addr, host := opts.Join, false
+		if opts.Host != "" {
+			addr, host = opts.Host, true
+		}
+		if err := r.StartCollab(addr, opts.CollabName, host); err != nil {
+			r.NotifyError(r.locale.Errorf(MsgCollabFailed, err))
+		}
+	}
+
+	// The watchdog starts last, so that loading the lattice is not taken
+	// for a hang.
+	r.resetStatus = hasResetStatus()
+	if opts.Watchdog > 0 {
+		info := fmt.Sprintf("OpenGL %v on %v", version, gl.GoStr(gl.GetString(gl.RENDERER)))
+		r.watchdog = newWatchdog(opts.Watchdog, info, opts.OnHang)
+	}
+
+	return r, nil
+}
+
+// setProgram makes program the lattice program, looking up its uniforms
+// and attributes.
+func (r *Renderer) setProgram(program uint32) {
+	r.program = program
+	gl.UseProgram(program)
+	// The wireframe variant is built again from the new shaders.
+	if r.wire != nil {
+		r.wire.Delete()
+	}
+	r.wire, r.wireErr = nil, nil
+	// So is the motion pass of a recording, once the hooks are set.
+	if r.recorder != nil {
+		r.recorder.motionStale = true
+	}
+
+	r.projectionUniform = gl.GetUniformLocation(program, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(r.projectionUniform, 1, false, &r.projection[0])
+
+	r.cameraUniform = gl.GetUniformLocation(program, gl.Str("camera\x00"))
+	gl.UniformMatrix4fv(r.cameraUniform, 1, false, &r.camera[0])
+
+	r.shiftUniform = gl.GetUniformLocation(program, gl.Str("shift\x00"))
+	gl.Uniform1f(r.shiftUniform, 1)
+
+	r.aoUniform = gl.GetUniformLocation(program, gl.Str("occlusion\x00"))
+	r.sunUniform = gl.GetUniformLocation(program, gl.Str("sun\x00"))
+	r.diffuseUniform = gl.GetUniformLocation(program, gl.Str("diffuse\x00"))
+
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("lightmap\x00")), lightmapUnit)
+	r.lightUniforms = lightUniforms{
+		on:     gl.GetUniformLocation(program, gl.Str("lightmapOn\x00")),
+		origin: gl.GetUniformLocation(program, gl.Str("lightmapOrigin\x00")),
+		side:   gl.GetUniformLocation(program, gl.Str("lightmapSide\x00")),
+	}
+
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("shadowMap\x00")), shadowUnit)
+	r.shadowUniforms = shadowUniforms{
+		matrix: gl.GetUniformLocation(program, gl.Str("shadowMatrix\x00")),
+		on:     gl.GetUniformLocation(program, gl.Str("shadows\x00")),
+		bias:   gl.GetUniformLocation(program, gl.Str("shadowBias\x00")),
+	}
+
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("decalImages\x00")), decalUnit)
+	r.decalUniforms = decalUniforms{
+		matrices: gl.GetUniformLocation(program, gl.Str("decals\x00")),
+		layers:   gl.GetUniformLocation(program, gl.Str("decalLayers\x00")),
+		count:    gl.GetUniformLocation(program, gl.Str("decalCount\x00")),
+	}
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("cellTextures\x00")), cellTextureUnit)
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("cellMaterials\x00")), cellMaterialUnit)
+	r.textureUniforms = textureUniforms{
+		count:     gl.GetUniformLocation(program, gl.Str("cellTextureCount\x00")),
+		materials: gl.GetUniformLocation(program, gl.Str("cellMaterialCount\x00")),
+	}
+
+	modelUniform := gl.GetUniformLocation(program, gl.Str("model\x00"))
+	gl.UniformMatrix4fv(modelUniform, 1, false, &r.model[0])
+
+	r.styleUniforms = styleUniforms{
+		saturation:    gl.GetUniformLocation(program, gl.Str("saturation\x00")),
+		tint:          gl.GetUniformLocation(program, gl.Str("tint\x00")),
+		brightness:    gl.GetUniformLocation(program, gl.Str("brightness\x00")),
+		edgeWidth:     gl.GetUniformLocation(program, gl.Str("edgeWidth\x00")),
+		edgeColor:     gl.GetUniformLocation(program, gl.Str("edgeColor\x00")),
+		edgeCellColor: gl.GetUniformLocation(program, gl.Str("edgeCellColor\x00")),
+		opacity:       gl.GetUniformLocation(program, gl.Str("opacity\x00")),
+		fresnel:       gl.GetUniformLocation(program, gl.Str("fresnel\x00")),
+		eye:           gl.GetUniformLocation(program, gl.Str("eye\x00")),
+	}
+
+	r.clipUniform = gl.GetUniformLocation(program, gl.Str("clipPlane\x00"))
+	r.alphaUniform = gl.GetUniformLocation(program, gl.Str("alpha\x00"))
+	r.timeUniform = gl.GetUniformLocation(program, gl.Str("time\x00"))
+	r.colorPhaseUniform = gl.GetUniformLocation(program, gl.Str("colorPhase\x00"))
+	r.fadeUniform = gl.GetUniformLocation(program, gl.Str("colorFade\x00"))
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("fadeColors\x00")), fadeUnit)
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("displaceMap\x00")), displaceUnit)
+	r.displaceUniforms = displaceUniforms{
+		amplitude: gl.GetUniformLocation(program, gl.Str("displaceAmplitude\x00")),
+		frequency: gl.GetUniformLocation(program, gl.Str("displaceFrequency\x00")),
+		speed:     gl.GetUniformLocation(program, gl.Str("displaceSpeed\x00")),
+	}
+	r.fogUniforms = newFogUniforms(program)
+
+	gl.BindFragDataLocation(program, 0, gl.Str("outputColor\x00"))
+
+	gl.BindVertexArray(r.vao)
+	r.attribs = r.mesh.attribs(program)
+}
+
+// pollShaders rebuilds the lattice program if the shader files changed.
+// The previous program is kept if the new one fails to build.
+func (r *Renderer) pollShaders() {
+	select {
+	case <-r.shadersChanged:
+	default:
+		return
+	}
+	program, err := r.buildProgram(r.hooks)
+	if err != nil {
+		r.NotifyError(err)
+		return
+	}
+	gl.DeleteProgram(r.program)
+	r.setProgram(program)
+	r.Notify(MsgShadersReloaded)
+}
+
+// Run renders frames until the window is asked to close.
+func (r *Renderer) Run() {
+	for !r.w.ShouldClose() {
+		if r.watchdog != nil {
+			r.watchdog.beat()
+		}
+		if r.checkContext() {
+			return
+		}
+
+		// Update
+		r.stepDemo()
+		r.pollReload()
+		r.console.Poll(r)
+		r.pollCollab()
+		r.pollShaders()
+		r.pollWatch()
+		r.Update(r.w)
+		r.autosave()
+
+		// Render
+		r.renderFrame()
+
+		// Maintenance
+		r.lockFrameRate()
+		r.w.SwapBuffers()
+		glfw.PollEvents()
+	}
+}
+
+// Close deletes the GL objects owned by the renderer and stops watching
+// the shaders and the lattice file. A USD recording still running is saved
+// first. Close must be called once, with the context of the window
+// current, after Run returns.
+func (r *Renderer) Close() error {
+	if r.watchdog != nil {
+		r.watchdog.stop()
+	}
+	if r.reloader != nil {
+		r.reloader.stop()
+	}
+	var err error
+	if r.usd != nil {
+		err = r.StopUSD()
+	}
+	r.StopRecording()
+	r.StopDemo()
+	if r.autosaver != nil {
+		if stopErr := r.autosaver.stop(); err == nil {
+			err = stopErr
+		}
+	}
+	r.StopCollab()
+	if r.ghosts != nil {
+		r.ghosts.Delete()
+	}
+	if r.cellFrames != nil {
+		r.cellFrames.Delete()
+	}
+	if r.planes != nil {
+		r.planes.Delete()
+	}
+	if r.beauty != nil {
+		r.beauty.Delete()
+	}
+	if r.trails != nil {
+		r.trails.Delete()
+	}
+	if r.gpuSim != nil {
+		r.gpuSim.Delete()
+	}
+	r.picker.Delete()
+	r.overdraw.Delete()
+	r.shadow.Delete()
+	r.text.Delete()
+	r.lines.Delete()
+	r.post.Delete()
+	r.timers.Delete()
+	r.mesh.Delete()
+	r.SetLightmap(nil)
+	r.decals.Delete()
+	if r.textures != nil {
+		r.textures.Delete()
+	}
+	r.materials.Delete()
+	if r.displaceMap != nil {
+		r.displaceMap.Delete()
+	}
+	gl.DeleteVertexArrays(1, &r.vao)
+	gl.DeleteProgram(r.program)
+	if r.wire != nil {
+		r.wire.Delete()
+	}
+	if r.shaderWatcher != nil {
+		r.shaderWatcher.Close()
+	}
+	r.StopWatching()
+	return err
+}
@@ -0,0 +1,283 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// sceneJSON is a scene file: the cells shown, with any edits and defects,
+// the camera and the render settings, so that a scene survives restarts.
+// The unit cell of an imported crystal is not kept, only its cells.
+type sceneJSON struct {
+	Lattice LatticeParams `json:"lattice"`
+	// Cells are the rows of a lattice file: x, y, z, r, g, b, value and
+	// size.
+	Cells     [][8]float32 `json:"cells"`
+	Selection [][3]int     `json:"selection"`
+	Camera    sceneCamera  `json:"camera"`
+	Render    sceneRender  `json:"render"`
+}
+
+// sceneCamera is the camera pose of a scene, with angles in degrees.
+type sceneCamera struct {
+	Position mgl32.Vec3 `json:"position"`
+	Pitch    float32    `json:"pitch"`
+	Yaw      float32    `json:"yaw"`
+	Roll     float32    `json:"roll"`
+	FOV      float32    `json:"fov"`
+}
+
+// sceneRender are the render settings of a scene. Fields and the enums
+// are stored by the names the console takes.
+type sceneRender struct {
+	Style        Style   `json:"style"`
+	Shape        string  `json:"shape"`
+	Colormap     string  `json:"colormap"`
+	Field        string  `json:"field"`
+	ColorRange   string  `json:"color_range"`
+	ColorClip    float32 `json:"color_clip"`
+	DataKind     string  `json:"data_kind,omitempty"`
+	SizeField    string  `json:"size_field,omitempty"`
+	MinSize      float32 `json:"min_size,omitempty"`
+	FadeRadius   int     `json:"fade_radius"`
+	FadeStrength float32 `json:"fade_strength"`
+	// Clip is the clipping plane, nil if clipping is off.
+	Clip   *sceneClip `json:"clip,omitempty"`
+	Trails int        `json:"trails"`
+	// Shaders are the shader hooks, with paths relative to the scene file,
+	// nil if there are none.
+	Shaders *ShaderHooks `json:"shaders,omitempty"`
+}
+
+type sceneClip struct {
+	Normal mgl32.Vec3 `json:"normal"`
+	Offset float32    `json:"offset"`
+}
+
+// isSceneFile reports whether path names a scene file rather than a
+// lattice file.
+func isSceneFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// SaveScene writes the cells, the camera and the render settings to path
+// as a scene file.
+func (r *Renderer) SaveScene(path string) error {
+	data, err := json.MarshalIndent(r.scene(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// scene returns the cells, the camera and the render settings as a scene.
+func (r *Renderer) scene() sceneJSON {
+	sc := sceneJSON{
+		Lattice:   r.params,
+		Selection: make([][3]int, 0, len(r.selection)),
+		Camera: sceneCamera{
+			Position: r.camPos,
+			Pitch:    mgl32.RadToDeg(r.pitch),
+			Yaw:      mgl32.RadToDeg(r.yaw),
+			Roll:     mgl32.RadToDeg(r.roll),
+			FOV:      r.fov,
+		},
+		Render: sceneRender{
+			Style:        r.styles[r.style],
+			Shape:        r.mesh.shape.String(),
+			Colormap:     r.coloring.colormap.String(),
+			Field:        r.coloring.scalarSource.String(),
+			ColorRange:   r.coloring.rng.String(),
+			ColorClip:    r.coloring.clip,
+			DataKind:     r.coloring.kind.String(),
+			FadeRadius:   r.fade.Radius,
+			FadeStrength: r.fade.Strength,
+		},
+	}
+	for _, c := range r.cells.unpackRefined() {
+		sc.Cells = append(sc.Cells, [8]float32{c.pos[0], c.pos[1], c.pos[2], c.color[0], c.color[1], c.color[2], c.value, c.scale()})
+	}
+	for _, id := range r.selection.IDs() {
+		if x, y, z, ok := r.params.CellPos(id); ok {
+			sc.Selection = append(sc.Selection, [3]int{x, y, z})
+		}
+	}
+	if r.sizing.on {
+		sc.Render.SizeField, sc.Render.MinSize = r.sizing.scalarSource.String(), r.sizing.min
+	}
+	if r.clip.on {
+		sc.Render.Clip = &sceneClip{Normal: r.clip.normal, Offset: r.clip.offset}
+	}
+	if r.trails != nil {
+		sc.Render.Trails = len(r.trails.steps)
+	}
+	if r.hooks != (ShaderHooks{}) {
+		// The paths are made absolute, as the scene may be saved elsewhere.
+		wd, _ := os.Getwd()
+		hooks := r.hooks.resolve(wd)
+		sc.Render.Shaders = &hooks
+	}
+	return sc
+}
+
+// readScene reads the scene file path.
+func readScene(path string) (sceneJSON, error) {
+	var sc sceneJSON
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	return sc, nil
+}
+
+// LoadScene replaces the cells, the camera and the render settings by
+// those of the scene file path.
+func (r *Renderer) LoadScene(path string) error {
+	defer r.pauseWatchdog()()
+	sc, err := readScene(path)
+	if err != nil {
+		return err
+	}
+	if err := r.applyScene(sc, path); err != nil {
+		return err
+	}
+	r.source = path
+	r.Notify(MsgSceneLoaded, path)
+	return nil
+}
+
+// applyScene replaces the state of r by the scene sc, read from path. A
+// scene with unknown settings changes nothing.
+func (r *Renderer) applyScene(sc sceneJSON, path string) error {
+	rs := sc.Render
+	shape, ok := ParseCellShape(rs.Shape)
+	if !ok {
+		return fmt.Errorf("%v: unknown cell shape %q", path, rs.Shape)
+	}
+	colormap, ok := ParseColormap(rs.Colormap)
+	if !ok {
+		return r.locale.Errorf(MsgUnknownColormap, rs.Colormap)
+	}
+	rng, ok := ParseColorRange(rs.ColorRange)
+	if !ok {
+		return fmt.Errorf("%v: unknown color range %q", path, rs.ColorRange)
+	}
+	kind := AutoKind
+	if rs.DataKind != "" {
+		if kind, ok = ParseDataKind(rs.DataKind); !ok {
+			return fmt.Errorf("%v: unknown data kind %q", path, rs.DataKind)
+		}
+	}
+	if err := sc.Lattice.checkSize(r.locale); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	var coloring cellColoring
+	if colormap != NoColormap {
+		var err error
+		if coloring, err = newCellColoring(colormap, rs.Field); err != nil {
+			return err
+		}
+	}
+	coloring.rng, coloring.clip, coloring.kind = rng, rs.ColorClip, kind
+	var sizing cellSizing
+	if rs.SizeField != "" {
+		src, err := newScalarSource(rs.SizeField)
+		if err != nil {
+			return err
+		}
+		sizing = cellSizing{on: true, scalarSource: src, min: rs.MinSize}
+		if sizing.min <= 0 || sizing.min > 1 {
+			sizing.min = defaultMinSize
+		}
+	}
+
+	// The hooks are built after the checks, so that a rejected scene keeps
+	// the program as it was.
+	var hooks ShaderHooks
+	if rs.Shaders != nil {
+		hooks = rs.Shaders.resolve(filepath.Dir(path))
+	}
+	if hooks != r.hooks {
+		program, err := r.buildProgram(hooks)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		gl.DeleteProgram(r.program)
+		r.setProgram(program)
+		r.hooks = hooks
+	}
+
+	p := sc.Lattice
+	cells := make([]cell, len(sc.Cells))
+	for i, row := range sc.Cells {
+		cells[i] = cell{
+			pos:   mgl32.Vec3{row[0], row[1], row[2]},
+			color: mgl32.Vec3{row[3], row[4], row[5]},
+			value: row[6],
+			size:  row[7],
+		}
+	}
+	assignIDs(cells, p)
+
+	i, ok := findStyle(r.styles, rs.Style.Name)
+	if !ok {
+		i = len(r.styles)
+		r.styles = append(r.styles, rs.Style)
+	}
+	r.styles[i] = rs.Style
+	r.style = i
+	r.styles[i].clearColor()
+	r.setStylePost(r.styles[i])
+	if shape != r.mesh.shape || p.CubeSize != r.params.CubeSize {
+		r.mesh.SetShape(shape, p.CubeSize)
+	}
+	r.coloring = coloring
+	r.sizing = sizing
+	r.fade = DensityFade{Radius: rs.FadeRadius, Strength: rs.FadeStrength}
+	r.clip = clipPlane{}
+	if rs.Clip != nil && rs.Clip.Normal.Len() > 0 {
+		r.clip = clipPlane{on: true, normal: rs.Clip.Normal.Normalize(), offset: rs.Clip.Offset}
+	}
+	if r.trails != nil && len(r.trails.steps) != rs.Trails {
+		r.trails.Delete()
+		r.trails = nil
+	}
+	if r.trails == nil && rs.Trails > 0 {
+		r.trails = newCellTrails(rs.Trails)
+	}
+
+	r.setStore(newCellStore(cells, p))
+	if r.trails != nil {
+		r.trails.clear(r.mesh.Instances())
+	}
+	r.selection = NewSelection()
+	for _, pos := range sc.Selection {
+		if id, ok := p.CellID(pos[0], pos[1], pos[2]); ok {
+			r.selection.Add(id)
+		}
+	}
+	r.applySceneCamera(sc.Camera)
+	return nil
+}
+
+// applySceneCamera moves the camera to the pose c.
+func (r *Renderer) applySceneCamera(c sceneCamera) {
+	r.SetCamera(c.Position, c.Pitch, c.Yaw)
+	r.roll = mgl32.DegToRad(c.Roll)
+	if c.FOV > 0 {
+		r.fov = mgl32.Clamp(c.FOV, minFOV, maxFOV)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// transparentSupersampling is the number of pixels along each axis a
+// transparent screenshot renders for every pixel it writes, which smooths
+// the edges of its background mask.
+const transparentSupersampling = 3
+
+// Screenshot writes the frame drawn so far, without the bars of cinema
+// mode, to a PNG in the screenshot directory, named after the current
+// time, and returns its path. It must be called before the buffers are
+// swapped. A transparent screenshot renders the lattice again instead,
+// see transparentFrame.
+func (r *Renderer) Screenshot(transparent bool) (string, error) {
+	x, y, width, height := r.viewport()
+	var img *image.RGBA
+	if transparent {
+		var err error
+		if img, err = r.transparentFrame(width, height); err != nil {
+			return "", err
+		}
+	} else {
+		img = readFramebuffer(int(x), int(y), int(width), int(height))
+	}
+
+	if err := os.MkdirAll(r.screenshotDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(r.screenshotDir, captureName(time.Now(), ".png"))
+	return path, writePNG(path, img)
+}
+
+// transparentFrame renders the lattice into an image of width by height,
+// without the HUD, transparent wherever the depth buffer shows that no
+// cell was drawn, so that it can be laid over other backgrounds. The
+// frame is rendered transparentSupersampling times larger and reduced, so
+// that the alpha of edge pixels is the share of the pixel covered.
+func (r *Renderer) transparentFrame(width, height int32) (*image.RGBA, error) {
+	n := int32(transparentSupersampling)
+	var maxSize int32
+	gl.GetIntegerv(gl.MAX_RENDERBUFFER_SIZE, &maxSize)
+	for n > 1 && (width*n > maxSize || height*n > maxSize) {
+		n--
+	}
+	target, err := newOffscreen(width*n, height*n, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Delete()
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, target.fbo)
+	gl.Viewport(0, 0, target.width, target.height)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	r.renderScene(&r.compare, 0, 0, target.width, target.height)
+	color := target.read()
+	depth := readDepth(int(target.width), int(target.height))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	r.updateViewport()
+
+	// The colors of image.RGBA are premultiplied by alpha, so the covered
+	// samples simply add up.
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	samples := int(n * n)
+	for y := 0; y < int(height); y++ {
+		for x := 0; x < int(width); x++ {
+			var sum [4]int
+			for sy := 0; sy < int(n); sy++ {
+				for sx := 0; sx < int(n); sx++ {
+					i := (y*int(n)+sy)*int(target.width) + x*int(n) + sx
+					if depth[i] >= 1 {
+						continue
+					}
+					for c := 0; c < 3; c++ {
+						sum[c] += int(color.Pix[i*4+c])
+					}
+					sum[3] += 0xff
+				}
+			}
+			p := img.Pix[y*img.Stride+x*4:]
+			for c := range sum {
+				p[c] = uint8(sum[c] / samples)
+			}
+		}
+	}
+	return img, nil
+}
+
+// readDepth reads the depth buffer of the bound read framebuffer, of width
+// by height, with the first row at the top like readPixels.
+func readDepth(width, height int) []float32 {
+	depth := make([]float32, width*height)
+	if width == 0 || height == 0 {
+		return depth
+	}
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+	gl.ReadPixels(0, 0, int32(width), int32(height), gl.DEPTH_COMPONENT, gl.FLOAT, gl.Ptr(depth))
+	row := make([]float32, width)
+	for top, bottom := 0, height-1; top < bottom; top, bottom = top+1, bottom-1 {
+		a := depth[top*width:][:width]
+		b := depth[bottom*width:][:width]
+		copy(row, a)
+		copy(a, b)
+		copy(b, row)
+	}
+	return depth
+}
+
+// writePNG writes img to path as a PNG.
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// captureName returns the file name with extension ext of a screenshot or
+// recording taken at t. The milliseconds keep shots taken in quick
+// succession apart.
+func captureName(t time.Time, ext string) string {
+	return "lattice-" + t.Format("20060102-150405.000") + ext
+}
+
+// readFramebuffer reads the rectangle at x, y of the back buffer of the
+// default framebuffer into an image, flipped so that the first row is the
+// top of the window.
+func readFramebuffer(x, y, width, height int) *image.RGBA {
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+	gl.ReadBuffer(gl.BACK)
+	return readPixels(x, y, width, height)
+}
+
+// readPixels reads the rectangle at x, y of the read buffer of the bound
+// read framebuffer into an image, flipped so that the first row is the
+// top.
+func readPixels(x, y, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == 0 || height == 0 {
+		return img
+	}
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(int32(x), int32(y), int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+
+	// OpenGL rows start at the bottom.
+	row := make([]byte, img.Stride)
+	for top, bottom := 0, height-1; top < bottom; top, bottom = top+1, bottom-1 {
+		a := img.Pix[top*img.Stride:][:img.Stride]
+		b := img.Pix[bottom*img.Stride:][:img.Stride]
+		copy(row, a)
+		copy(a, b)
+		copy(b, row)
+	}
+	// The window is opaque whatever the alpha left by blending.
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
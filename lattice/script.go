@@ -0,0 +1,102 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// maxScriptDepth bounds scripts running other scripts, so that a script
+// running itself fails instead of growing forever.
+const maxScriptDepth = 8
+
+// script is a file of console commands run one line per step, waiting
+// where it says so.
+type script struct {
+	name  string
+	lines []string
+	// next is the index of the next line to run, resume the time before
+	// which it must not run.
+	next   int
+	resume float64
+}
+
+// loadScript reads the script at path.
+func loadScript(path string) (*script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &script{name: path, lines: strings.Split(string(data), "\n")}, nil
+}
+
+// RunScript runs the console commands of the file at path, one per line,
+// starting with the next Poll. A script run from another one finishes
+// before the rest of the first runs.
+func (c *Console) RunScript(r *Renderer, path string) error {
+	if len(c.scripts) == maxScriptDepth {
+		return r.locale.Errorf(MsgScriptDepth, maxScriptDepth)
+	}
+	s, err := loadScript(path)
+	if err != nil {
+		return err
+	}
+	c.scripts = append(c.scripts, s)
+	return nil
+}
+
+// pollScripts runs the lines of the running scripts until one waits. A
+// failing line stops all scripts.
+func (c *Console) pollScripts(r *Renderer) {
+	now := glfw.GetTime()
+	for len(c.scripts) > 0 {
+		s := c.scripts[len(c.scripts)-1]
+		if now < s.resume {
+			return
+		}
+		if s.next == len(s.lines) {
+			c.scripts = c.scripts[:len(c.scripts)-1]
+			continue
+		}
+		line := s.lines[s.next]
+		s.next++
+		if err := c.Exec(r, line); err != nil {
+			r.NotifyError(r.locale.Errorf(MsgScriptFailed, s.name, s.next, err))
+			c.scripts = nil
+			return
+		}
+	}
+}
+
+func registerScriptCommands(c *Console) {
+	c.Register("exec", "exec <script>", func(r *Renderer, args string) error {
+		if args == "" {
+			return r.locale.Errorf(MsgMissingFileName)
+		}
+		return c.RunScript(r, args)
+	})
+	c.Register("reload", "reload", func(r *Renderer, args string) error {
+		return r.Reload()
+	})
+	c.Register("wait", "wait <seconds>", func(r *Renderer, args string) error {
+		seconds, err := strconv.ParseFloat(args, 64)
+		if err != nil || seconds < 0 {
+			return r.locale.Errorf(MsgExpectedWait)
+		}
+		if len(c.scripts) == 0 {
+			return r.locale.Errorf(MsgNoScript)
+		}
+		c.scripts[len(c.scripts)-1].resume = glfw.GetTime() + seconds
+		return nil
+	})
+	c.Register("quit", "quit", func(r *Renderer, args string) error {
+		r.w.SetShouldClose(true)
+		return nil
+	})
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Selection is a set of cell IDs.
+type Selection map[uint32]struct{}
+
+func NewSelection(ids ...uint32) Selection {
+	sel := make(Selection, len(ids))
+	for _, id := range ids {
+		sel.Add(id)
+	}
+	return sel
+}
+
+func (sel Selection) Add(id uint32) {
+	sel[id] = struct{}{}
+}
+
+func (sel Selection) Remove(id uint32) {
+	delete(sel, id)
+}
+
+func (sel Selection) Contains(id uint32) bool {
+	_, ok := sel[id]
+	return ok
+}
+
+// Toggle adds id to the selection if it is missing and removes it
+// otherwise. It reports whether id is selected afterwards.
+func (sel Selection) Toggle(id uint32) bool {
+	if sel.Contains(id) {
+		sel.Remove(id)
+		return false
+	}
+	sel.Add(id)
+	return true
+}
+
+// IDs returns the selected IDs in ascending order.
+func (sel Selection) IDs() []uint32 {
+	ids := make([]uint32, 0, len(sel))
+	for id := range sel {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Remap returns the selection with its IDs converted from the lattice
+// extent from to the extent to. Cells outside of to are dropped.
+func (sel Selection) Remap(from, to LatticeParams) Selection {
+	out := make(Selection, len(sel))
+	for id := range sel {
+		x, y, z, ok := from.CellPos(id)
+		if !ok {
+			continue
+		}
+		if id, ok := to.CellID(x, y, z); ok {
+			out.Add(id)
+		}
+	}
+	return out
+}
+
+// Animation describes the periodic size cycling of cubes.
+type Animation struct {
+	// Speed scales the cycle rate; 0 freezes the cubes.
+	Speed float64
+	// Phase offsets the cycle, in radians.
+	Phase float64
+}
+
+var defaultAnimation = Animation{Speed: 1}
+
+// restShift is the smallest shift, drawing the cubes at almost full size.
+const restShift = 0.002
+
+// Shift returns the distance the cube faces are moved inwards at time t.
+func (a Animation) Shift(t float64) float32 {
+	return float32(1+math.Sin(t/2*a.Speed+a.Phase))/2/4 + restShift
+}
+
+// Group is a named selection of cells with its own display settings.
+type Group struct {
+	Name   string
+	Cells  Selection
+	Hidden bool
+	// Color, if not nil, replaces the color of every cell in the group.
+	Color     *mgl32.Vec3
+	Animation Animation
+}
+
+// Export writes the group's cells of s to path as a lattice file,
+// unpacking one chunk at a time.
+func (g *Group) Export(path string, s *cellStore) error {
+	var members []cell
+	for _, k := range s.keys {
+		for _, c := range s.chunk(k) {
+			if !g.Cells.Contains(c.id) {
+				continue
+			}
+			if g.Color != nil {
+				c.color = *g.Color
+			}
+			members = append(members, c)
+		}
+	}
+	return SaveCells(path, members)
+}
+
+// Groups is an ordered collection of uniquely named groups. A cell that
+// belongs to several groups is displayed using the first one.
+type Groups struct {
+	list []*Group
+}
+
+// Add creates a group from a copy of sel.
+func (gs *Groups) Add(name string, sel Selection) (*Group, error) {
+	if gs.Get(name) != nil {
+		return nil, fmt.Errorf("group %q already exists", name)
+	}
+	g := &Group{
+		Name:      name,
+		Cells:     NewSelection(sel.IDs()...),
+		Animation: defaultAnimation,
+	}
+	gs.list = append(gs.list, g)
+	return g, nil
+}
+
+func (gs *Groups) Get(name string) *Group {
+	for _, g := range gs.list {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (gs *Groups) Remove(name string) bool {
+	for i, g := range gs.list {
+		if g.Name == name {
+			gs.list = append(gs.list[:i], gs.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of the groups that later changes to gs leave
+// untouched.
+func (gs *Groups) clone() *Groups {
+	c := &Groups{list: make([]*Group, len(gs.list))}
+	for i, g := range gs.list {
+		copied := *g
+		copied.Cells = NewSelection(g.Cells.IDs()...)
+		if g.Color != nil {
+			color := *g.Color
+			copied.Color = &color
+		}
+		c.list[i] = &copied
+	}
+	return c
+}
+
+func (gs *Groups) All() []*Group {
+	return gs.list
+}
+
+func (gs *Groups) Len() int {
+	return len(gs.list)
+}
+
+// Remap converts the cells of all groups from the lattice extent from to
+// the extent to.
+func (gs *Groups) Remap(from, to LatticeParams) {
+	for _, g := range gs.list {
+		g.Cells = g.Cells.Remap(from, to)
+	}
+}
+
+type groupJSON struct {
+	Name   string      `json:"name"`
+	Cells  [][3]int    `json:"cells"`
+	Hidden bool        `json:"hidden,omitempty"`
+	Color  *mgl32.Vec3 `json:"color,omitempty"`
+	Speed  float64     `json:"speed"`
+	Phase  float64     `json:"phase,omitempty"`
+}
+
+func newGroupJSON(g *Group, p LatticeParams) groupJSON {
+	gj := groupJSON{
+		Name:   g.Name,
+		Cells:  make([][3]int, 0, len(g.Cells)),
+		Hidden: g.Hidden,
+		Color:  g.Color,
+		Speed:  g.Animation.Speed,
+		Phase:  g.Animation.Phase,
+	}
+	for _, id := range g.Cells.IDs() {
+		if x, y, z, ok := p.CellPos(id); ok {
+			gj.Cells = append(gj.Cells, [3]int{x, y, z})
+		}
+	}
+	return gj
+}
+
+// selection returns the cells of gj within p.
+func (gj *groupJSON) selection(p LatticeParams) Selection {
+	sel := NewSelection()
+	for _, pos := range gj.Cells {
+		if id, ok := p.CellID(pos[0], pos[1], pos[2]); ok {
+			sel.Add(id)
+		}
+	}
+	return sel
+}
+
+// apply copies the display settings of gj to g.
+func (gj *groupJSON) apply(g *Group) {
+	g.Hidden = gj.Hidden
+	g.Color = gj.Color
+	g.Animation = Animation{Speed: gj.Speed, Phase: gj.Phase}
+}
+
+// Save writes the groups to path as JSON. Cells are stored by lattice
+// coordinates so the file stays valid when the lattice size changes.
+func (gs *Groups) Save(path string, p LatticeParams) error {
+	out := make([]groupJSON, 0, len(gs.list))
+	for _, g := range gs.list {
+		out = append(out, newGroupJSON(g, p))
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadGroups reads groups saved by Save. Cells outside of the lattice
+// described by p are dropped.
+func LoadGroups(path string, p LatticeParams) (*Groups, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var in []groupJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+
+	gs := &Groups{}
+	for i := range in {
+		g, err := gs.Add(in[i].Name, in[i].selection(p))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %v: %v", path, err)
+		}
+		in[i].apply(g)
+	}
+	return gs, nil
+}
+
+func formatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 32)
+}
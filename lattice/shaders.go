@@ -0,0 +1,100 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	vertexShaderFile   = "lattice.vert.glsl"
+	fragmentShaderFile = "lattice.frag.glsl"
+)
+
+//go:embed shaders/*.glsl
+var builtinShaders embed.FS
+
+// loadShaders returns the NUL-terminated sources of the lattice shaders
+// from dir, or the built-in ones if dir is empty.
+func loadShaders(dir string) (vertex, fragment string, err error) {
+	var files fs.FS
+	if dir == "" {
+		files, _ = fs.Sub(builtinShaders, "shaders")
+	} else {
+		files = os.DirFS(dir)
+	}
+	vs, err := fs.ReadFile(files, vertexShaderFile)
+	if err != nil {
+		return "", "", err
+	}
+	frag, err := fs.ReadFile(files, fragmentShaderFile)
+	if err != nil {
+		return "", "", err
+	}
+	return string(vs) + "\x00", string(frag) + "\x00", nil
+}
+
+// watchShaders reports changes to the shader files in dir on the returned
+// channel.
+func watchShaders(dir string) (*fsnotify.Watcher, <-chan struct{}, error) {
+	return watchFiles(dir, vertexShaderFile, fragmentShaderFile)
+}
+
+// watchFiles reports changes to the files in dir with the given names on
+// the returned channel, which holds at most one change. Editors often save
+// by replacing the file, so the directory is watched rather than the files.
+func watchFiles(dir string, names ...string) (*fsnotify.Watcher, <-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return nil, nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !containsString(names, filepath.Base(ev.Name)) {
+					continue
+				}
+				if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Println(err)
+			}
+		}
+	}()
+	return w, changed, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+#version 330
+
+// decals map world positions into the unit box of each decal, whose image
+// is in layer decalLayers[i] of decalImages.
+uniform mat4 decals[8];
+uniform int decalLayers[8];
+uniform int decalCount;
+uniform sampler2DArray decalImages;
+
+// cellTextures holds cellTextureCount images the faces are textured with,
+// multiplying their colors.
+uniform sampler2DArray cellTextures;
+uniform int cellTextureCount;
+
+// sun points towards a directional light. diffuse is the part of the
+// brightness that depends on the angle to it, 0 for flat colors.
+uniform vec3 sun;
+uniform float diffuse;
+
+// shadowMap holds the depth of the lattice seen from the sun. Faces behind
+// it lose their diffuse light while shadows is 1.
+uniform sampler2DShadow shadowMap;
+uniform float shadows;
+uniform float shadowBias;
+
+// brightness scales the faces. Lines edgeWidth wide run along the edges of
+// cubes in edgeColor, mixed with the cell color by edgeCellColor. With an
+// opacity below 1 the faces are added up, the edges stay opaque. Above 0,
+// fresnel weights the faces by how obliquely they are seen from eye, the
+// camera position.
+uniform float brightness;
+uniform float edgeWidth;
+uniform vec3 edgeColor;
+uniform float edgeCellColor;
+uniform float opacity;
+uniform float fresnel;
+uniform vec3 eye;
+
+// fogDensity above 0 fades the faces into fogColor with their depth, by
+// exponential fog.
+uniform float fogDensity;
+uniform vec3 fogColor;
+
+// alpha below 1 makes the faces translucent, blended over those behind
+// them with colors premultiplied by alpha. The edges stay opaque.
+uniform float alpha;
+
+// time is the time of the frame in seconds.
+uniform float time;
+
+in vec3 fragColor;
+in vec3 worldPos;
+in vec3 fragNormal;
+in vec4 shadowPos;
+in vec3 edgePos;
+in vec3 cellColor;
+in float viewDepth;
+in float emission;
+in vec2 texCoord;
+flat in int texLayer;
+out vec4 outputColor;
+
+float sunlight() {
+    vec3 p = shadowPos.xyz / shadowPos.w * 0.5 + 0.5;
+    if (shadows == 0 || any(lessThan(p, vec3(0))) || any(greaterThan(p, vec3(1)))) {
+        return 1;
+    }
+    return texture(shadowMap, vec3(p.xy, p.z - shadowBias));
+}
+
+// shadeColor returns the color of a face at worldPos, lit and textured,
+// before the decals, edges and fog. A scene can replace it with its own,
+// between the HOOK and END HOOK lines.
+// HOOK color
+vec3 shadeColor(vec3 color, vec3 worldPos, vec3 normal, vec2 uv, float time) {
+    return color;
+}
+// END HOOK
+
+void main() {
+    float lambert = max(dot(normalize(fragNormal), sun), 0) * sunlight();
+    vec3 color = fragColor * brightness * (1 - diffuse + diffuse * lambert);
+    if (cellTextureCount > 0) {
+        // Image rows run top to bottom, the texture coordinates bottom to
+        // top.
+        vec4 t = texture(cellTextures, vec3(texCoord.x, 1 - texCoord.y, texLayer));
+        color *= mix(vec3(1), t.rgb, t.a);
+    }
+    color += cellColor * emission;
+    color = shadeColor(color, worldPos, normalize(fragNormal), texCoord, time);
+    for (int i = 0; i < decalCount; i++) {
+        vec3 p = (decals[i] * vec4(worldPos, 1)).xyz;
+        if (all(greaterThanEqual(p, vec3(0))) && all(lessThanEqual(p, vec3(1)))) {
+            // Image rows run top to bottom, the box y axis bottom to top.
+            // The colors are premultiplied by alpha.
+            vec4 d = texture(decalImages, vec3(p.x, 1 - p.y, decalLayers[i]));
+            color = color * (1 - d.a) + d.rgb;
+        }
+    }
+    float edge = 0;
+    if (edgeWidth > 0) {
+        // On an edge of the cube two of the coordinates are near 1.
+        vec3 a = abs(edgePos);
+        float middle = a.x + a.y + a.z - max(a.x, max(a.y, a.z)) - min(a.x, min(a.y, a.z));
+        edge = smoothstep(1 - edgeWidth - fwidth(middle), 1 - edgeWidth, middle);
+        color = mix(color, mix(edgeColor, cellColor, edgeCellColor), edge);
+    }
+    if (fogDensity > 0) {
+        color = mix(fogColor, color, exp(-fogDensity * viewDepth));
+    }
+    float shell = 1;
+    if (fresnel > 0) {
+        float facing = abs(dot(normalize(fragNormal), normalize(eye - worldPos)));
+        shell = pow(max(1 - facing, 0), fresnel);
+    }
+    if (alpha < 1) {
+        float a = mix(alpha * shell, 1, edge);
+        outputColor = vec4(color * a, a);
+        return;
+    }
+    outputColor = vec4(color * mix(opacity * shell, 1, edge), 0);
+}
@@ -0,0 +1,143 @@
+#version 330
+
+uniform mat4 projection;
+uniform mat4 camera;
+uniform mat4 model;
+uniform float shift;
+uniform float occlusion;
+// shadowMatrix maps world positions to the clip space of the shadow map.
+uniform mat4 shadowMatrix;
+
+// lightmap holds baked light with one texel per cell, the one at
+// lightmapOrigin first. lightmapOn blends it in.
+uniform sampler3D lightmap;
+uniform float lightmapOn;
+uniform vec3 lightmapOrigin;
+uniform float lightmapSide;
+
+// The cell colors are desaturated, or saturated above 1, by saturation
+// and multiplied by tint. Colors above 1 are emissive: the cell shows its
+// color scaled to 1, glowing by how far the brightest component exceeds 1.
+uniform float saturation;
+uniform vec3 tint;
+
+// colorPhase shifts the color gradient of a generated lattice, whose
+// colors cycle over time, along each channel, wrapping at 1. Only the
+// cells with cycleBit set in ao show the gradient; other colors, above 1
+// among them, are left as they are.
+uniform float colorPhase;
+const uint cycleBit = 0x10000u;
+
+// While a reloaded file fades in, fadeColors holds the color faded from of
+// each cell ID, wrapped into rows of materialTableWidth, with alpha 1 where
+// there is one, and colorFade runs from 0 to 1. colorFade 1 shows the
+// colors of the cells.
+uniform sampler2D fadeColors;
+uniform float colorFade;
+
+// clipPlane cuts away the world positions with a negative dot product with
+// it, where clipping is enabled.
+uniform vec4 clipPlane;
+
+in vec3 vert;
+in vec3 shiftDir;
+in vec3 normal;
+in float corner;
+in vec2 uv;
+in vec3 offset;
+in vec3 color;
+in uint ao;
+in float size;
+in uint cellID;
+out vec3 fragColor;
+out vec3 worldPos;
+out vec3 fragNormal;
+out vec4 shadowPos;
+// edgePos runs from -1 to 1 across the cube, cellColor is the color of the
+// cell before any shading.
+out vec3 edgePos;
+out vec3 cellColor;
+// viewDepth is the distance in front of the camera.
+out float viewDepth;
+// emission is how much of cellColor the faces emit on top of their light.
+out float emission;
+// texCoord is where the face samples the cell textures, in the layer
+// texLayer.
+out vec2 texCoord;
+flat out int texLayer;
+
+// cellTextureCount is the number of cell textures, which the lattice
+// sites take in turn unless cellMaterials gives them one. It holds the
+// texture layer plus 1 of each cell ID, wrapped into rows of
+// materialTableWidth, where there are cellMaterialCount material rules.
+uniform int cellTextureCount;
+uniform usampler2D cellMaterials;
+uniform int cellMaterialCount;
+const uint materialTableWidth = 4096u;
+
+// time is the time of the frame in seconds.
+uniform float time;
+
+// displaceMap moves each cell by its red, green and blue along x, y and z,
+// from -displaceAmplitude at 0 to displaceAmplitude at 1, sampled at the
+// cell position times displaceFrequency and drifting through the map
+// displaceSpeed times a second. displaceAmplitude 0 leaves the cells be.
+uniform sampler3D displaceMap;
+uniform float displaceAmplitude;
+uniform float displaceFrequency;
+uniform float displaceSpeed;
+
+vec3 cellDisplacement() {
+    if (displaceAmplitude == 0) {
+        return vec3(0);
+    }
+    vec3 p = offset * displaceFrequency + time * displaceSpeed * vec3(1, 0.7, 0.4);
+    return (textureLod(displaceMap, p, 0).rgb * 2 - 1) * displaceAmplitude;
+}
+
+// displace moves the world position pos of a vertex of the cell at cell,
+// on a face with the given normal. A scene can replace it with its own,
+// between the HOOK and END HOOK lines.
+// HOOK displace
+vec3 displace(vec3 pos, vec3 normal, vec3 cell, float time) {
+    return pos;
+}
+// END HOOK
+
+void main() {
+    vec4 world = model * vec4(shiftDir * shift + vert * size + offset + cellDisplacement(), 1);
+    fragNormal = mat3(model) * normal;
+    world.xyz = displace(world.xyz, fragNormal, offset, time);
+    vec4 view = camera * world;
+    gl_Position = projection * view;
+    viewDepth = -view.z;
+    gl_ClipDistance[0] = dot(clipPlane, world);
+    worldPos = world.xyz;
+    shadowPos = shadowMatrix * world;
+    float level = float((ao >> (uint(corner) * 2u)) & 3u) / 3.0;
+    vec3 light = textureLod(lightmap, (offset - lightmapOrigin + 0.5) / lightmapSide, 0).rgb;
+    vec3 faded = color;
+    if (colorFade < 1) {
+        vec4 from = texelFetch(fadeColors, ivec2(cellID % materialTableWidth, cellID / materialTableWidth), 0);
+        faded = mix(color, mix(from.rgb, color, colorFade), from.a);
+    }
+    vec3 cycled = faded;
+    if (colorPhase > 0 && (ao & cycleBit) != 0u && all(lessThanEqual(faded, vec3(1)))) {
+        cycled = fract(faded + colorPhase);
+    }
+    float peak = max(max(cycled.r, cycled.g), max(cycled.b, 1));
+    vec3 base = cycled / peak;
+    emission = peak - 1;
+    vec3 luma = vec3(dot(base, vec3(0.2126, 0.7152, 0.0722)));
+    cellColor = clamp(mix(luma, base, saturation), 0, 1) * tint;
+    edgePos = -shiftDir;
+    texCoord = uv;
+    texLayer = cellTextureCount > 0 && cellID > 0u ? int((cellID - 1u) % uint(cellTextureCount)) : 0;
+    if (cellMaterialCount > 0 && cellTextureCount > 0) {
+        uint material = texelFetch(cellMaterials, ivec2(cellID % materialTableWidth, cellID / materialTableWidth), 0).r;
+        if (material > 0u) {
+            texLayer = min(int(material) - 1, cellTextureCount - 1);
+        }
+    }
+    fragColor = cellColor * (1 - occlusion * level) * mix(vec3(1), light, lightmapOn);
+}
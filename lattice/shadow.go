@@ -0,0 +1,195 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+const (
+	defaultShadowSize = 2048
+	defaultShadowBias = 0.002
+)
+
+// ShadowMap renders the depth of the lattice as seen from the sun, so the
+// lattice pass can tell which faces other cubes hide from it.
+type ShadowMap struct {
+	// Size is the width and height of the depth texture, set with
+	// SetSize.
+	Size int32
+	// Bias is subtracted from the depth of a fragment before comparing it
+	// to the shadow map, in the [0, 1] depth range of the map. Too small a
+	// bias lets faces shadow themselves, too large a bias detaches shadows
+	// from their casters.
+	Bias float32
+
+	program  uint32
+	vao      uint32
+	mesh     *Mesh
+	attribs  cubeAttribs
+	fbo      uint32
+	depthTex uint32
+
+	// matrix maps world positions to the clip space of the sun.
+	matrix mgl32.Mat4
+
+	lightUniform int32
+	modelUniform int32
+	shiftUniform int32
+	clipUniform  int32
+}
+
+// NewShadowMap creates the depth pass program and a shadow map of the
+// given size. The cells are read from mesh.
+func NewShadowMap(mesh *Mesh, size int) (*ShadowMap, error) {
+	program, err := glutil.NewProgram(shadowVertexShader, shadowFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShadowMap{
+		Bias:         defaultShadowBias,
+		program:      program,
+		mesh:         mesh,
+		lightUniform: gl.GetUniformLocation(program, gl.Str("light\x00")),
+		modelUniform: gl.GetUniformLocation(program, gl.Str("model\x00")),
+		shiftUniform: gl.GetUniformLocation(program, gl.Str("shift\x00")),
+		clipUniform:  gl.GetUniformLocation(program, gl.Str("clipPlane\x00")),
+	}
+
+	var prevVAO int32
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	gl.GenVertexArrays(1, &s.vao)
+	gl.BindVertexArray(s.vao)
+	s.attribs = mesh.attribs(program)
+	gl.BindVertexArray(uint32(prevVAO))
+
+	gl.GenFramebuffers(1, &s.fbo)
+	gl.GenTextures(1, &s.depthTex)
+	if err := s.SetSize(size); err != nil {
+		s.Delete()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetSize reallocates the depth texture with size texels on each side.
+func (s *ShadowMap) SetSize(size int) error {
+	s.Size = int32(size)
+
+	gl.BindTexture(gl.TEXTURE_2D, s.depthTex)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.DEPTH_COMPONENT24, s.Size, s.Size, 0, gl.DEPTH_COMPONENT, gl.FLOAT, nil)
+	// Comparing in the sampler makes linear filtering blend the results
+	// of the four nearest texels, softening the shadow edges.
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_COMPARE_MODE, gl.COMPARE_REF_TO_TEXTURE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_COMPARE_FUNC, gl.LEQUAL)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, s.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.TEXTURE_2D, s.depthTex, 0)
+	gl.DrawBuffer(gl.NONE)
+	gl.ReadBuffer(gl.NONE)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		return errors.New("shadow framebuffer is incomplete")
+	}
+	return nil
+}
+
+// Render draws the depth of draws as seen from a sun in direction sun,
+// fitting the lattice described by p. Cells cut away by the clipping plane
+// clip cast no shadows.
+func (s *ShadowMap) Render(sun mgl32.Vec3, p LatticeParams, model mgl32.Mat4, clip mgl32.Vec4, draws []drawCall) {
+	s.matrix = shadowMatrix(sun, p)
+
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	defer gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	var prevFBO int32
+	gl.GetIntegerv(gl.FRAMEBUFFER_BINDING, &prevFBO)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, uint32(prevFBO))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, s.fbo)
+	gl.Viewport(0, 0, s.Size, s.Size)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+
+	gl.UseProgram(s.program)
+	gl.UniformMatrix4fv(s.lightUniform, 1, false, &s.matrix[0])
+	gl.UniformMatrix4fv(s.modelUniform, 1, false, &model[0])
+	gl.Uniform4f(s.clipUniform, clip[0], clip[1], clip[2], clip[3])
+	defer enableClipping()()
+	gl.BindVertexArray(s.vao)
+	for _, d := range draws {
+		gl.Uniform1f(s.shiftUniform, d.shift)
+		s.mesh.Draw(s.attribs, d.first, d.count)
+	}
+}
+
+// shadowMatrix returns an orthographic projection looking along -sun that
+// covers the bounding sphere of the lattice.
+func shadowMatrix(sun mgl32.Vec3, p LatticeParams) mgl32.Mat4 {
+	radius := p.radius()
+	up := mgl32.Vec3{0, 1, 0}
+	if math.Abs(float64(sun.Dot(up))) > 0.99 {
+		up = mgl32.Vec3{0, 0, 1}
+	}
+	eye := sun.Mul(2 * radius)
+	view := mgl32.LookAtV(eye, mgl32.Vec3{}, up)
+	projection := mgl32.Ortho(-radius, radius, -radius, radius, radius, 3*radius)
+	return projection.Mul4(view)
+}
+
+// Delete releases the GL objects owned by the shadow map.
+func (s *ShadowMap) Delete() {
+	gl.DeleteFramebuffers(1, &s.fbo)
+	gl.DeleteTextures(1, &s.depthTex)
+	gl.DeleteVertexArrays(1, &s.vao)
+	gl.DeleteProgram(s.program)
+}
+
+var shadowVertexShader = `
+#version 330
+
+uniform mat4 light;
+uniform mat4 model;
+uniform float shift;
+uniform vec4 clipPlane;
+
+in vec3 vert;
+in vec3 shiftDir;
+in vec3 offset;
+in float size;
+
+void main() {
+    vec4 world = model * vec4(shiftDir * shift + vert * size + offset, 1);
+    gl_Position = light * world;
+    gl_ClipDistance[0] = dot(clipPlane, world);
+}
+` + "\x00"
+
+var shadowFragmentShader = `
+#version 330
+
+void main() {
+}
+` + "\x00"
@@ -0,0 +1,138 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CellShape selects the primitive every cell is drawn as.
+type CellShape int
+
+const (
+	// CubeShape draws cells as cubes filling their lattice position.
+	CubeShape CellShape = iota
+	// SphereShape draws cells as icospheres of diameter the cube size, as
+	// crystal viewers show atoms.
+	SphereShape
+)
+
+var cellShapeNames = []string{"cube", "sphere"}
+
+func (s CellShape) String() string {
+	return cellShapeNames[s]
+}
+
+// Set implements flag.Value.
+func (s *CellShape) Set(name string) error {
+	shape, ok := ParseCellShape(name)
+	if !ok {
+		return fmt.Errorf("unknown cell shape %q", name)
+	}
+	*s = shape
+	return nil
+}
+
+// ParseCellShape returns the cell shape with the given name.
+func ParseCellShape(name string) (CellShape, bool) {
+	for i, n := range cellShapeNames {
+		if n == name {
+			return CellShape(i), true
+		}
+	}
+	return CubeShape, false
+}
+
+// next returns the shape after s, wrapping around.
+func (s CellShape) next() CellShape {
+	return (s + 1) % CellShape(len(cellShapeNames))
+}
+
+// makeShape returns the vertices of the shape s of width w centered on the
+// origin and the indices of its triangles.
+func makeShape(s CellShape, w float32) ([]float32, []uint16) {
+	if s == SphereShape {
+		return makeSphere(w)
+	}
+	return makeCube(w)
+}
+
+// sphereSubdivisions is the number of times the faces of the icosahedron
+// are split in four, 320 triangles at 2.
+const sphereSubdivisions = 2
+
+// makeSphere returns the vertices of an icosphere of diameter w centered on
+// the origin and the indices of its triangles. Vertices carry the same
+// attributes as those of the cube: their shift direction points back
+// towards the center, and their corner is that of the octant they lie in,
+// so the corner occlusion of a cell darkens the side of the sphere facing
+// its neighbors.
+func makeSphere(w float32) ([]float32, []uint16) {
+	t := float32((1 + math.Sqrt(5)) / 2)
+	points := []mgl32.Vec3{
+		{-1, t, 0}, {1, t, 0}, {-1, -t, 0}, {1, -t, 0},
+		{0, -1, t}, {0, 1, t}, {0, -1, -t}, {0, 1, -t},
+		{t, 0, -1}, {t, 0, 1}, {-t, 0, -1}, {-t, 0, 1},
+	}
+	for i := range points {
+		points[i] = points[i].Normalize()
+	}
+	faces := [][3]uint16{
+		{0, 11, 5}, {0, 5, 1}, {0, 1, 7}, {0, 7, 10}, {0, 10, 11},
+		{1, 5, 9}, {5, 11, 4}, {11, 10, 2}, {10, 7, 6}, {7, 1, 8},
+		{3, 9, 4}, {3, 4, 2}, {3, 2, 6}, {3, 6, 8}, {3, 8, 9},
+		{4, 9, 5}, {2, 4, 11}, {6, 2, 10}, {8, 6, 7}, {9, 8, 1},
+	}
+	for i := 0; i < sphereSubdivisions; i++ {
+		midpoints := make(map[[2]uint16]uint16)
+		midpoint := func(a, b uint16) uint16 {
+			if a > b {
+				a, b = b, a
+			}
+			if m, ok := midpoints[[2]uint16{a, b}]; ok {
+				return m
+			}
+			m := uint16(len(points))
+			points = append(points, points[a].Add(points[b]).Normalize())
+			midpoints[[2]uint16{a, b}] = m
+			return m
+		}
+		split := make([][3]uint16, 0, 4*len(faces))
+		for _, f := range faces {
+			ab, bc, ca := midpoint(f[0], f[1]), midpoint(f[1], f[2]), midpoint(f[2], f[0])
+			split = append(split,
+				[3]uint16{f[0], ab, ca}, [3]uint16{f[1], bc, ab},
+				[3]uint16{f[2], ca, bc}, [3]uint16{ab, bc, ca})
+		}
+		faces = split
+	}
+
+	verts := make([]float32, len(points)*floatsPerVertex)
+	for i, n := range points {
+		v := verts[i*floatsPerVertex : (i+1)*floatsPerVertex]
+		v[0], v[1], v[2] = n[0]*w/2, n[1]*w/2, n[2]*w/2
+		v[3], v[4], v[5] = -n[0], -n[1], -n[2]
+		v[6], v[7], v[8] = n[0], n[1], n[2]
+		v[9] = float32(cornerIndex(n))
+		// Textures wrap around the sphere as a map around a globe.
+		v[10] = 0.5 + float32(math.Atan2(float64(n[2]), float64(n[0])))/(2*math.Pi)
+		v[11] = 0.5 + float32(math.Asin(float64(n[1])))/math.Pi
+	}
+	indices := make([]uint16, 0, 3*len(faces))
+	for _, f := range faces {
+		indices = append(indices, f[0], f[1], f[2])
+	}
+	return verts, indices
+}
+
+// SetShape draws the cells as the primitive s.
+func (r *Renderer) SetShape(s CellShape) {
+	r.mesh.SetShape(s, r.params.CubeSize)
+	r.count = r.mesh.Triangles()
+	r.Notify(MsgCellShape, s)
+}
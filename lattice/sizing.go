@@ -0,0 +1,74 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+// defaultMinSize is the size of the cells with the smallest scalar when
+// sizing by a field, unless told otherwise.
+const defaultMinSize = 0.2
+
+// cellSizing scales the cubes of the cells by a scalar field, a channel of
+// data independent of the one the colormap shows.
+type cellSizing struct {
+	on bool
+	scalarSource
+	// min is the size of the cells with the smallest scalar, those with
+	// the largest keeping their own.
+	min float32
+}
+
+// look returns the look scaling the cells of s by their scalars,
+// normalized over all of s, or nil if the sizing is off. Cells without a
+// scalar keep their size.
+func (z cellSizing) look(s *cellStore) cellLook {
+	if !z.on {
+		return nil
+	}
+	scalars := z.scalars(s)
+	if len(scalars) == 0 {
+		return nil
+	}
+	lo, hi := scalars[0], scalars[0]
+	for _, v := range scalars {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	if hi <= lo {
+		return nil
+	}
+	return func(x cell) cell {
+		if v, ok := z.scalar(x, s.params); ok {
+			t := (v - lo) / (hi - lo)
+			x.size = x.scale() * (z.min + (1-z.min)*t)
+		}
+		return x
+	}
+}
+
+// SetSizeField scales the cubes by field, as newScalarSource reads it,
+// from min for the smallest scalar to full size for the largest, or turns
+// the sizing off if field is off.
+func (r *Renderer) SetSizeField(field string, min float32) error {
+	if field == "off" {
+		r.sizing = cellSizing{}
+		r.UploadMesh()
+		r.Notify(MsgSizeFieldOff)
+		return nil
+	}
+	src, err := newScalarSource(field)
+	if err != nil {
+		return err
+	}
+	if min <= 0 || min > 1 {
+		min = defaultMinSize
+	}
+	r.sizing = cellSizing{on: true, scalarSource: src, min: min}
+	r.UploadMesh()
+	r.Notify(MsgSizeField, src, min)
+	return nil
+}
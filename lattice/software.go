@@ -0,0 +1,202 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// RenderImage draws the lattice described by opts from the initial camera
+// without OpenGL, for machines without a working driver. The image has the
+// window size of opts, or windowWidth by windowHeight if it is unset.
+func RenderImage(opts Options) (*image.RGBA, error) {
+	width, height := opts.Width, opts.Height
+	if width <= 0 || height <= 0 {
+		width, height = windowWidth, windowHeight
+	}
+
+	r := newRenderer(nil)
+	cells, _, err := initialStore(opts)
+	if err != nil {
+		return nil, err
+	}
+	r.cells = cells
+	r.params = cells.params
+	if opts.Colormap != NoColormap {
+		if r.coloring, err = newCellColoring(opts.Colormap, opts.Field); err != nil {
+			return nil, err
+		}
+	}
+	r.coloring.rng, r.coloring.clip, r.coloring.kind = opts.ColorRange, opts.ColorClip, opts.DataKind
+	if opts.SizeField != "" {
+		src, err := newScalarSource(opts.SizeField)
+		if err != nil {
+			return nil, err
+		}
+		r.sizing = cellSizing{on: true, scalarSource: src, min: opts.MinSize}
+	}
+	r.fade = opts.Fade
+	r.near, r.far = opts.Near, opts.Far
+	if opts.GroupsFile != "" {
+		if groups, err := LoadGroups(opts.GroupsFile, r.params); err == nil {
+			r.groups = groups
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	mvp := r.projectionFor(int32(width), int32(height)).Mul4(r.viewMatrix())
+	f := newFrustum(mvp)
+	verts, indices := makeShape(opts.Shape, r.params.CubeSize)
+	corners := make([]vertex, len(verts)/floatsPerVertex)
+	instances, ranges := makeInstances(r.cells, r.groups, r.params.CubeSize, r.cellLook())
+
+	rs := newRaster(width, height)
+	for _, mr := range ranges {
+		if !f.intersectsBox(mr.min, mr.max) {
+			continue
+		}
+		shift := mr.anim.Shift(0)
+		for i := mr.first; i < mr.first+mr.count; i++ {
+			inst := instances[int(i)*floatsPerInstance:][:floatsPerInstance]
+			offset := mgl32.Vec3{inst[0], inst[1], inst[2]}
+			base := mgl32.Vec3{inst[3], inst[4], inst[5]}
+			ao := math.Float32bits(inst[7])
+			size := inst[8]
+
+			for j := range corners {
+				v := verts[j*floatsPerVertex:][:floatsPerVertex]
+				p := mgl32.Vec3{v[0], v[1], v[2]}.Mul(size).Add(mgl32.Vec3{v[3], v[4], v[5]}.Mul(shift)).Add(offset)
+				shade := cornerShade(ao, int(v[9])) * faceShade(mgl32.Vec3{v[6], v[7], v[8]}, r.sun)
+				corners[j] = vertex{clip: mvp.Mul4x1(p.Vec4(1)), color: base.Mul(shade)}
+			}
+			for j := 0; j < len(indices); j += 3 {
+				rs.triangle(corners[indices[j]], corners[indices[j+1]], corners[indices[j+2]])
+			}
+		}
+	}
+	return rs.img, nil
+}
+
+// RenderFile renders the lattice with RenderImage and writes it to path as
+// a PNG.
+func RenderFile(opts Options, path string) error {
+	img, err := RenderImage(opts)
+	if err != nil {
+		return err
+	}
+	return writePNG(path, img)
+}
+
+// raster is a color image with a depth buffer that triangles are drawn
+// into, following the OpenGL conventions of the GPU path.
+type raster struct {
+	img   *image.RGBA
+	depth []float32
+}
+
+func newRaster(width, height int) *raster {
+	rs := &raster{
+		img:   image.NewRGBA(image.Rect(0, 0, width, height)),
+		depth: make([]float32, width*height),
+	}
+	for i := range rs.depth {
+		rs.depth[i] = 1
+	}
+	for i := 3; i < len(rs.img.Pix); i += 4 {
+		rs.img.Pix[i] = 255
+	}
+	return rs
+}
+
+// vertex is a triangle corner in clip space with its color.
+type vertex struct {
+	clip  mgl32.Vec4
+	color mgl32.Vec3
+}
+
+// triangle fills the triangle a, b, c, interpolating the corner colors.
+// Triangles crossing the near plane are dropped rather than clipped, which
+// only affects cubes touching the camera.
+func (rs *raster) triangle(a, b, c vertex) {
+	for _, v := range []vertex{a, b, c} {
+		if v.clip[3] <= 0 || v.clip[2] < -v.clip[3] {
+			return
+		}
+	}
+	width, height := rs.img.Rect.Dx(), rs.img.Rect.Dy()
+	sa, sb, sc := rs.toScreen(a.clip), rs.toScreen(b.clip), rs.toScreen(c.clip)
+
+	area := edge(sa, sb, sc)
+	if area == 0 {
+		return
+	}
+	minX := clampInt(int(math.Floor(float64(min3(sa[0], sb[0], sc[0])))), 0, width-1)
+	maxX := clampInt(int(math.Ceil(float64(max3(sa[0], sb[0], sc[0])))), 0, width-1)
+	minY := clampInt(int(math.Floor(float64(min3(sa[1], sb[1], sc[1])))), 0, height-1)
+	maxY := clampInt(int(math.Ceil(float64(max3(sa[1], sb[1], sc[1])))), 0, height-1)
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			p := mgl32.Vec3{float32(x) + 0.5, float32(y) + 0.5}
+			wa, wb, wc := edge(sb, sc, p)/area, edge(sc, sa, p)/area, edge(sa, sb, p)/area
+			if wa < 0 || wb < 0 || wc < 0 {
+				continue
+			}
+			z := wa*sa[2] + wb*sb[2] + wc*sc[2]
+			i := y*width + x
+			if z > 1 || z >= rs.depth[i] {
+				continue
+			}
+			rs.depth[i] = z
+			col := a.color.Mul(wa).Add(b.color.Mul(wb)).Add(c.color.Mul(wc))
+			rs.img.SetRGBA(x, y, color.RGBA{unitByte(col[0]), unitByte(col[1]), unitByte(col[2]), 255})
+		}
+	}
+}
+
+// toScreen returns the pixel coordinates of a clip space position, with y
+// growing downwards, and its depth in [-1, 1].
+func (rs *raster) toScreen(v mgl32.Vec4) mgl32.Vec3 {
+	ndc := v.Vec3().Mul(1 / v[3])
+	return mgl32.Vec3{
+		(ndc[0] + 1) / 2 * float32(rs.img.Rect.Dx()),
+		(1 - ndc[1]) / 2 * float32(rs.img.Rect.Dy()),
+		ndc[2],
+	}
+}
+
+// edge returns twice the signed area of the triangle a, b, p.
+func edge(a, b, p mgl32.Vec3) float32 {
+	return (b[0]-a[0])*(p[1]-a[1]) - (b[1]-a[1])*(p[0]-a[0])
+}
+
+func unitByte(v float32) uint8 {
+	return uint8(mgl32.Clamp(v, 0, 1)*255 + 0.5)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func min3(a, b, c float32) float32 {
+	return float32(math.Min(float64(a), math.Min(float64(b), float64(c))))
+}
+
+func max3(a, b, c float32) float32 {
+	return float32(math.Max(float64(a), math.Max(float64(b), float64(c))))
+}
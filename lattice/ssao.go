@@ -0,0 +1,288 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// Default ambient occlusion settings.
+const (
+	defaultSSAOStrength = 1
+	defaultSSAORadius   = 0.5
+)
+
+// ssaoSamples is the number of samples around each pixel that occlusion
+// is estimated from, as in the kernel of ssaoFragmentShader.
+const ssaoSamples = 16
+
+// SSAO darkens the frame where the surface is occluded by the geometry
+// around it, estimated from the depth of the frame: cells deep inside the
+// lattice and in the corners between cells get less ambient light. Radius
+// is how far around a point occluders count, in world units, or half a
+// unit for 0, and Strength how much the occluded points darken, 1 for
+// fully.
+type SSAO struct {
+	Strength, Radius float32
+}
+
+func (SSAO) Name() string { return "ssao" }
+
+func (SSAO) Shader() string { return ssaoCompositeFragmentShader }
+
+func (s SSAO) SetUniforms(program uint32) {
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("occlusion\x00")), 1)
+	gl.Uniform1f(gl.GetUniformLocation(program, gl.Str("strength\x00")), s.Strength)
+}
+
+// prepare estimates the occlusion of every pixel from the depth of the
+// scene drawn into p, and blurs it into the buffers of p, bound to texture
+// unit 1 for the pass of s.
+func (s SSAO) prepare(p *postChain, frame uint32) error {
+	if p.ssao == nil {
+		sb, err := newSSAOBuffers()
+		if err != nil {
+			return err
+		}
+		p.ssao = sb
+	}
+	sb := p.ssao
+	if p.width != sb.width || p.height != sb.height {
+		if err := sb.resize(p.width, p.height); err != nil {
+			return err
+		}
+	}
+
+	// The depth is resolved from the multisampled scene, as the color is.
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, p.sceneFBO)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, sb.depthFBO)
+	gl.BlitFramebuffer(0, 0, p.width, p.height, 0, 0, p.width, p.height, gl.DEPTH_BUFFER_BIT, gl.NEAREST)
+	gl.Viewport(0, 0, p.width, p.height)
+
+	radius := s.Radius
+	if radius <= 0 {
+		radius = defaultSSAORadius
+	}
+	inverse := p.projection.Inv()
+	gl.BindFramebuffer(gl.FRAMEBUFFER, sb.fbos[0])
+	gl.UseProgram(sb.occlusion)
+	gl.UniformMatrix4fv(sb.projectionUniform, 1, false, &p.projection[0])
+	gl.UniformMatrix4fv(sb.inverseUniform, 1, false, &inverse[0])
+	gl.Uniform1f(sb.radiusUniform, radius)
+	gl.BindTexture(gl.TEXTURE_2D, sb.depth)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, sb.fbos[1])
+	gl.UseProgram(sb.blur)
+	gl.BindTexture(gl.TEXTURE_2D, sb.texs[0])
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, sb.texs[1])
+	gl.ActiveTexture(gl.TEXTURE0)
+	return nil
+}
+
+// ssaoBuffers are the resolved depth of the scene and the textures the
+// occlusion is estimated and blurred in, and their programs.
+type ssaoBuffers struct {
+	occlusion, blur   uint32
+	projectionUniform int32
+	inverseUniform    int32
+	radiusUniform     int32
+
+	width, height int32
+	depthFBO      uint32
+	depth         uint32
+	fbos, texs    [2]uint32
+}
+
+func newSSAOBuffers() (*ssaoBuffers, error) {
+	occlusion, err := glutil.NewProgram(accumVertexShader, ssaoFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	blur, err := glutil.NewProgram(accumVertexShader, ssaoBlurFragmentShader)
+	if err != nil {
+		gl.DeleteProgram(occlusion)
+		return nil, err
+	}
+	sb := &ssaoBuffers{
+		occlusion:         occlusion,
+		blur:              blur,
+		projectionUniform: gl.GetUniformLocation(occlusion, gl.Str("projection\x00")),
+		inverseUniform:    gl.GetUniformLocation(occlusion, gl.Str("inverseProjection\x00")),
+		radiusUniform:     gl.GetUniformLocation(occlusion, gl.Str("radius\x00")),
+	}
+	gl.UseProgram(occlusion)
+	gl.Uniform1i(gl.GetUniformLocation(occlusion, gl.Str("depth\x00")), 0)
+	kernel := ssaoKernel()
+	gl.Uniform3fv(gl.GetUniformLocation(occlusion, gl.Str("kernel\x00")), ssaoSamples, &kernel[0][0])
+	gl.UseProgram(blur)
+	gl.Uniform1i(gl.GetUniformLocation(blur, gl.Str("frame\x00")), 0)
+	gl.GenFramebuffers(1, &sb.depthFBO)
+	gl.GenTextures(1, &sb.depth)
+	gl.GenFramebuffers(2, &sb.fbos[0])
+	gl.GenTextures(2, &sb.texs[0])
+	return sb, nil
+}
+
+// ssaoKernel returns the sample offsets of the occlusion in the unit
+// hemisphere around the z axis, denser towards the center, where nearby
+// geometry occludes most. The seed is fixed so that the occlusion does not
+// change between runs.
+func ssaoKernel() [ssaoSamples]mgl32.Vec3 {
+	rng := rand.New(rand.NewSource(1))
+	var kernel [ssaoSamples]mgl32.Vec3
+	for i := range kernel {
+		v := mgl32.Vec3{rng.Float32()*2 - 1, rng.Float32()*2 - 1, rng.Float32()}.Normalize()
+		scale := float32(i) / ssaoSamples
+		scale = 0.1 + 0.9*scale*scale
+		kernel[i] = v.Mul(rng.Float32() * scale)
+	}
+	return kernel
+}
+
+// resize reallocates the textures for frames of width by height.
+func (sb *ssaoBuffers) resize(width, height int32) error {
+	sb.width, sb.height = width, height
+	gl.BindTexture(gl.TEXTURE_2D, sb.depth)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.DEPTH_COMPONENT24, width, height, 0, gl.DEPTH_COMPONENT, gl.FLOAT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, sb.depthFBO)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.TEXTURE_2D, sb.depth, 0)
+	gl.DrawBuffer(gl.NONE)
+	gl.ReadBuffer(gl.NONE)
+	if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+		return errors.New("ambient occlusion depth framebuffer is incomplete")
+	}
+	for i, tex := range sb.texs {
+		gl.BindTexture(gl.TEXTURE_2D, tex)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R8, width, height, 0, gl.RED, gl.UNSIGNED_BYTE, nil)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, sb.fbos[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, tex, 0)
+		if gl.CheckFramebufferStatus(gl.FRAMEBUFFER) != gl.FRAMEBUFFER_COMPLETE {
+			return errors.New("ambient occlusion framebuffer is incomplete")
+		}
+	}
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return nil
+}
+
+// Delete releases the GL objects of the buffers.
+func (sb *ssaoBuffers) Delete() {
+	gl.DeleteFramebuffers(1, &sb.depthFBO)
+	gl.DeleteTextures(1, &sb.depth)
+	gl.DeleteFramebuffers(2, &sb.fbos[0])
+	gl.DeleteTextures(2, &sb.texs[0])
+	gl.DeleteProgram(sb.occlusion)
+	gl.DeleteProgram(sb.blur)
+}
+
+var ssaoFragmentShader = `
+#version 330
+
+uniform sampler2D depth;
+uniform mat4 projection;
+uniform mat4 inverseProjection;
+uniform float radius;
+uniform vec3 kernel[16];
+
+in vec2 fragUV;
+out float outputOcclusion;
+
+// viewPos returns the position in view space of the surface at uv.
+vec3 viewPos(vec2 uv) {
+    vec4 ndc = vec4(uv * 2 - 1, texture(depth, uv).r * 2 - 1, 1);
+    vec4 pos = inverseProjection * ndc;
+    return pos.xyz / pos.w;
+}
+
+// noise rotates the kernel by one of 16 angles repeating every 4 by 4
+// pixels, which the blur averages out.
+vec3 noise() {
+    ivec2 p = ivec2(gl_FragCoord.xy) & 3;
+    float angle = float(p.x * 4 + p.y) * 0.39269908;
+    return vec3(cos(angle), sin(angle), 0);
+}
+
+void main() {
+    vec3 pos = viewPos(fragUV);
+    // The cells are flat, so the normal follows from the neighbors.
+    vec3 normal = normalize(cross(dFdx(pos), dFdy(pos)));
+    if (texture(depth, fragUV).r >= 1) {
+        outputOcclusion = 1;
+        return;
+    }
+    if (dot(normal, pos) > 0) {
+        normal = -normal;
+    }
+    vec3 r = noise();
+    vec3 tangent = normalize(r - normal * dot(r, normal));
+    mat3 tbn = mat3(tangent, cross(normal, tangent), normal);
+
+    float occlusion = 0;
+    for (int i = 0; i < 16; i++) {
+        vec3 samplePos = pos + tbn * kernel[i] * radius;
+        vec4 clip = projection * vec4(samplePos, 1);
+        vec2 uv = clip.xy / clip.w * 0.5 + 0.5;
+        float z = viewPos(uv).z;
+        // Occluders much farther than the radius, such as the cells behind
+        // an edge, do not count.
+        float inRange = smoothstep(0, 1, radius / abs(pos.z - z));
+        occlusion += (z >= samplePos.z + 0.025 * radius ? 1 : 0) * inRange;
+    }
+    outputOcclusion = 1 - occlusion / 16;
+}
+` + "\x00"
+
+var ssaoBlurFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+
+in vec2 fragUV;
+out float outputOcclusion;
+
+void main() {
+    vec2 texel = 1.0 / vec2(textureSize(frame, 0));
+    float sum = 0;
+    for (int x = -2; x < 2; x++) {
+        for (int y = -2; y < 2; y++) {
+            sum += texture(frame, fragUV + vec2(x, y) * texel).r;
+        }
+    }
+    outputOcclusion = sum / 16;
+}
+` + "\x00"
+
+var ssaoCompositeFragmentShader = `
+#version 330
+
+uniform sampler2D frame;
+uniform sampler2D occlusion;
+uniform float strength;
+
+in vec2 fragUV;
+out vec4 outputColor;
+
+void main() {
+    vec4 color = texture(frame, fragUV);
+    float ao = mix(1, texture(occlusion, fragUV).r, clamp(strength, 0, 1));
+    outputColor = vec4(color.rgb * ao, color.a);
+}
+` + "\x00"
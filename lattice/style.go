@@ -0,0 +1,236 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Style bundles the look of the lattice: the material of the cells, their
+// palette, the lighting and the effects drawn over them. The comparison
+// presets switch parts of it off.
+type Style struct {
+	Name string `json:"name"`
+	// Background is the color behind the lattice.
+	Background mgl32.Vec3 `json:"background"`
+	// Saturation scales the saturation of the cell colors, 0 for gray, and
+	// Tint multiplies them.
+	Saturation float32    `json:"saturation"`
+	Tint       mgl32.Vec3 `json:"tint"`
+	// Brightness scales the color of the faces, apart from their edges.
+	Brightness float32 `json:"brightness"`
+	// Diffuse is the part of the brightness of a face that depends on its
+	// angle to the sun, Occlusion how much corners surrounded by other
+	// cells darken.
+	Diffuse   float32 `json:"diffuse"`
+	Occlusion float32 `json:"occlusion"`
+	Shadows   bool    `json:"shadows"`
+	// EdgeWidth is the width of the lines along the edges of the cubes as
+	// a fraction of half a cube, 0 for none. They are drawn in EdgeColor,
+	// mixed with the color of the cell by EdgeCellColor.
+	EdgeWidth     float32    `json:"edge_width"`
+	EdgeColor     mgl32.Vec3 `json:"edge_color"`
+	EdgeCellColor float32    `json:"edge_cell_color"`
+	// Opacity below 1 adds the faces up instead of hiding the ones behind,
+	// as in an X-ray image. Edges stay opaque.
+	Opacity float32 `json:"opacity"`
+	// Fresnel, if above 0, weights translucent faces by how obliquely they
+	// are seen, one minus the cosine of the angle raised to this power, so
+	// that cells read as shells bright at their outlines.
+	Fresnel float32 `json:"fresnel"`
+	// Bloom and Vignette, if not nil, are post-processing effects added
+	// while the style is on, in place of any of their names.
+	Bloom    *Bloom    `json:"bloom,omitempty"`
+	Vignette *Vignette `json:"vignette,omitempty"`
+}
+
+// DefaultStyles returns the built-in styles, the first of which is the
+// default look.
+func DefaultStyles() []Style {
+	plain := Style{
+		Name:       "default",
+		Saturation: 1,
+		Tint:       mgl32.Vec3{1, 1, 1},
+		Brightness: 1,
+		Diffuse:    diffuseStrength,
+		Occlusion:  aoStrength,
+		Shadows:    true,
+		Opacity:    1,
+	}
+	technical := plain
+	technical.Name = "technical"
+	technical.Background = mgl32.Vec3{0.93, 0.94, 0.96}
+	technical.Saturation = 0.35
+	technical.Diffuse = 0.35
+	technical.Occlusion = 0.3
+	technical.Shadows = false
+	technical.EdgeWidth = 0.06
+	technical.EdgeColor = mgl32.Vec3{0.12, 0.13, 0.15}
+
+	neon := plain
+	neon.Name = "neon"
+	neon.Background = mgl32.Vec3{0.02, 0.01, 0.05}
+	neon.Saturation = 1.6
+	neon.Brightness = 0.12
+	neon.Diffuse = 0
+	neon.Occlusion = 0
+	neon.Shadows = false
+	neon.EdgeWidth = 0.12
+	neon.EdgeColor = mgl32.Vec3{1, 1, 1}
+	neon.EdgeCellColor = 1
+	neon.Bloom = &Bloom{Strength: 1.2, Threshold: 0.6}
+
+	clay := plain
+	clay.Name = "clay"
+	clay.Background = mgl32.Vec3{0.55, 0.55, 0.58}
+	clay.Saturation = 0
+	clay.Tint = mgl32.Vec3{0.95, 0.8, 0.68}
+	clay.Diffuse = 0.7
+	clay.Occlusion = 0.9
+
+	xray := plain
+	xray.Name = "x-ray"
+	xray.Background = mgl32.Vec3{0, 0.02, 0.06}
+	xray.Saturation = 0.3
+	xray.Tint = mgl32.Vec3{0.6, 0.85, 1}
+	xray.Diffuse = 0
+	xray.Occlusion = 0
+	xray.Shadows = false
+	xray.EdgeWidth = 0.05
+	xray.EdgeColor = mgl32.Vec3{0.35, 0.55, 0.7}
+	xray.Opacity = 0.3
+	xray.Fresnel = 1.5
+	xray.Vignette = &Vignette{Strength: 0.6, Radius: 0.35}
+
+	ghost := plain
+	ghost.Name = "ghost"
+	ghost.Background = mgl32.Vec3{0.05, 0.05, 0.07}
+	ghost.Diffuse = 0
+	ghost.Occlusion = 0
+	ghost.Shadows = false
+	ghost.Opacity = 0.6
+	ghost.Fresnel = 3
+
+	return []Style{plain, technical, neon, clay, xray, ghost}
+}
+
+// LoadStyles reads styles from a JSON file holding a list of them and
+// returns the built-in styles with them added. A style named like a
+// built-in one replaces it, and fields missing from the file keep the
+// values of the style replaced or of the default style.
+func LoadStyles(path string) ([]Style, error) {
+	styles := DefaultStyles()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return styles, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return styles, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	for _, msg := range raw {
+		var named struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(msg, &named); err != nil || named.Name == "" {
+			return styles, fmt.Errorf("%v: every style needs a name", path)
+		}
+		i, ok := findStyle(styles, named.Name)
+		if !ok {
+			i = len(styles)
+			styles = append(styles, styles[0])
+		}
+		if err := json.Unmarshal(msg, &styles[i]); err != nil {
+			return styles, fmt.Errorf("failed to parse %v: %v", path, err)
+		}
+	}
+	return styles, nil
+}
+
+func findStyle(styles []Style, name string) (int, bool) {
+	for i, s := range styles {
+		if s.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// clearColor makes the background of s the color frames are cleared to.
+func (s Style) clearColor() {
+	gl.ClearColor(s.Background[0], s.Background[1], s.Background[2], 1)
+}
+
+// bindStyle sets the palette, material and effects of s for the lattice
+// program.
+func (r *Renderer) bindStyle(s Style) {
+	u := r.styleUniforms
+	gl.Uniform1f(u.saturation, s.Saturation)
+	gl.Uniform3f(u.tint, s.Tint[0], s.Tint[1], s.Tint[2])
+	gl.Uniform1f(u.brightness, s.Brightness)
+	gl.Uniform1f(u.edgeWidth, s.EdgeWidth)
+	gl.Uniform3f(u.edgeColor, s.EdgeColor[0], s.EdgeColor[1], s.EdgeColor[2])
+	gl.Uniform1f(u.edgeCellColor, s.EdgeCellColor)
+	gl.Uniform1f(u.opacity, s.Opacity)
+	gl.Uniform1f(u.fresnel, s.Fresnel)
+	eye := r.camera.Inv().Col(3)
+	gl.Uniform3f(u.eye, eye[0], eye[1], eye[2])
+}
+
+// postEffects returns the post-processing effects of s.
+func (s Style) postEffects() []PostEffect {
+	var effects []PostEffect
+	if s.Bloom != nil {
+		effects = append(effects, *s.Bloom)
+	}
+	if s.Vignette != nil {
+		effects = append(effects, *s.Vignette)
+	}
+	return effects
+}
+
+// SetStyle switches to style i of the styles of the renderer.
+func (r *Renderer) SetStyle(i int) {
+	r.style = i
+	r.styles[i].clearColor()
+	r.setStylePost(r.styles[i])
+	r.Notify(MsgStyle, r.styles[i].Name)
+}
+
+// setStylePost replaces the post-processing effects the previous style
+// added by those of s. Effects changed at the console since are kept.
+func (r *Renderer) setStylePost(s Style) {
+	for _, e := range r.stylePost {
+		for _, cur := range r.post.effects {
+			if cur == e {
+				r.post.remove(e.Name())
+				break
+			}
+		}
+	}
+	r.stylePost = nil
+	for _, e := range s.postEffects() {
+		if err := r.post.set(e); err != nil {
+			r.NotifyError(err)
+			continue
+		}
+		r.stylePost = append(r.stylePost, e)
+	}
+}
+
+// SetStyleByName switches to the style with the given name.
+func (r *Renderer) SetStyleByName(name string) error {
+	i, ok := findStyle(r.styles, name)
+	if !ok {
+		return r.locale.Errorf(MsgUnknownStyle, name)
+	}
+	r.SetStyle(i)
+	return nil
+}
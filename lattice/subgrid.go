@@ -0,0 +1,243 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// refineFactors are the factors a cell can be refined by along each axis.
+var refineFactors = []int{2, 4}
+
+// subgrid refines a cell into a finer grid of factor sub-cells along each
+// axis of its cube, for datasets of adaptive resolution such as those of
+// AMR simulations. The sub-cells are drawn in place of the cell, whose ID,
+// groups and selection they share.
+type subgrid struct {
+	factor int
+	// cells are the sub-cells present, positioned at their centers in
+	// lattice coordinates, with sizes relative to the cube of a cell.
+	cells []cell
+}
+
+func (g *subgrid) equal(o *subgrid) bool {
+	if o == nil || g.factor != o.factor || len(g.cells) != len(o.cells) {
+		return false
+	}
+	for i, c := range g.cells {
+		if c != o.cells[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isSubcell reports whether c is a sub-cell of a refined cell, which
+// leaves it off the grid.
+func isSubcell(c cell) bool {
+	for _, v := range c.pos {
+		if float64(v) != math.Round(float64(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// subcellFactor returns the refinement factor of the sub-cell c, from its
+// size, and false if its size or position fit no refinement. The centers
+// of the sub-cells along an axis are (i+0.5)/factor-0.5 from that of the
+// cell, for i from 0 to factor-1.
+func subcellFactor(c cell) (int, bool) {
+	f := int(math.Round(1 / float64(c.scale())))
+	if !containsInt(refineFactors, f) {
+		return 0, false
+	}
+	parent := gridPos(c)
+	for axis, v := range c.pos {
+		i := (float64(v)-float64(parent[axis])+0.5)*float64(f) - 0.5
+		if math.Abs(i-math.Round(i)) > 1e-3 {
+			return 0, false
+		}
+	}
+	return f, true
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// refineCell returns the factor³ sub-cells filling the cube of c, colored
+// as c.
+func refineCell(c cell, factor int) []cell {
+	sub := make([]cell, 0, factor*factor*factor)
+	step := 1 / float32(factor)
+	for x := 0; x < factor; x++ {
+		for y := 0; y < factor; y++ {
+			for z := 0; z < factor; z++ {
+				s := c
+				s.pos = c.pos.Add(mgl32.Vec3{float32(x), float32(y), float32(z)}.
+					Add(mgl32.Vec3{0.5, 0.5, 0.5}).Mul(step)).Sub(mgl32.Vec3{0.5, 0.5, 0.5})
+				s.size = step
+				sub = append(sub, s)
+			}
+		}
+	}
+	return sub
+}
+
+// splitSubcells separates the sub-cells from cells and gathers them into
+// subgrids keyed by the ID of the cell they refine. A refined cell missing
+// from cells is added with the mean color and value of its sub-cells, so
+// that it can be picked and grouped. The sub-cells must fit a refinement
+// and have the IDs of their cells.
+func splitSubcells(cells []cell) ([]cell, map[uint32]*subgrid) {
+	var coarse []cell
+	var subgrids map[uint32]*subgrid
+	for i, c := range cells {
+		if !isSubcell(c) {
+			if subgrids != nil {
+				coarse = append(coarse, c)
+			}
+			continue
+		}
+		if subgrids == nil {
+			subgrids = make(map[uint32]*subgrid)
+			coarse = append([]cell(nil), cells[:i]...)
+		}
+		g := subgrids[c.id]
+		if g == nil {
+			f, _ := subcellFactor(c)
+			g = &subgrid{factor: f}
+			subgrids[c.id] = g
+		}
+		g.cells = append(g.cells, c)
+	}
+	if subgrids == nil {
+		return cells, nil
+	}
+
+	present := make(map[uint32]bool, len(coarse))
+	for _, c := range coarse {
+		present[c.id] = true
+	}
+	for id, g := range subgrids {
+		if present[id] {
+			continue
+		}
+		p := gridPos(g.cells[0])
+		parent := cell{id: id, pos: mgl32.Vec3{float32(p[0]), float32(p[1]), float32(p[2])}}
+		for _, s := range g.cells {
+			parent.color = parent.color.Add(s.color)
+			parent.value += s.value
+		}
+		n := float32(len(g.cells))
+		parent.color = parent.color.Mul(1 / n)
+		parent.value /= n
+		coarse = append(coarse, parent)
+	}
+	return coarse, subgrids
+}
+
+// setSubgrids refines the cells of s by subgrids, keyed by ID, and counts
+// the instances they add to every chunk.
+func (s *cellStore) setSubgrids(subgrids map[uint32]*subgrid) {
+	s.subgrids, s.extra = nil, nil
+	if len(subgrids) == 0 {
+		return
+	}
+	s.subgrids = subgrids
+	s.extra = make(map[chunkKey]int)
+	for id, g := range subgrids {
+		if k, ok := s.chunkOfID(id); ok {
+			s.extra[k] += len(g.cells) - 1
+		}
+	}
+}
+
+// chunkInstances returns the number of instances drawing the cells of
+// chunk k: one per cell, or per sub-cell of a refined cell.
+func (s *cellStore) chunkInstances(k chunkKey) int {
+	return s.chunkCount(k) + s.extra[k]
+}
+
+// unpackRefined unpacks the cells as unpack does, with refined cells
+// replaced by their sub-cells, as lattice files store them.
+func (s *cellStore) unpackRefined() []cell {
+	cells := s.unpack()
+	if s.subgrids == nil {
+		return cells
+	}
+	refined := make([]cell, 0, len(cells))
+	for _, c := range cells {
+		if g := s.subgrids[c.id]; g != nil {
+			refined = append(refined, g.cells...)
+		} else {
+			refined = append(refined, c)
+		}
+	}
+	return refined
+}
+
+// refined returns a copy of s with the cells ids refined by factor, or
+// merged back into whole cells if factor is 1, and the number of cells
+// that changed.
+func (s *cellStore) refined(ids Selection, factor int) (*cellStore, int) {
+	subgrids := make(map[uint32]*subgrid, len(s.subgrids)+len(ids))
+	for id, g := range s.subgrids {
+		subgrids[id] = g
+	}
+	n := 0
+	for id := range ids {
+		c, ok := s.find(id)
+		if !ok {
+			continue
+		}
+		if factor == 1 {
+			if subgrids[id] != nil {
+				delete(subgrids, id)
+				n++
+			}
+			continue
+		}
+		if g := subgrids[id]; g == nil || g.factor != factor {
+			subgrids[id] = &subgrid{factor: factor, cells: refineCell(c, factor)}
+			n++
+		}
+	}
+	out := *s
+	out.setSubgrids(subgrids)
+	return &out, n
+}
+
+// Refine divides the selected cells into factor sub-cells along each axis,
+// colored as the cells were, or merges the sub-cells of the selected cells
+// back into one if factor is 1. Sub-cells loaded from a lattice file carry
+// their own colors and values instead.
+func (r *Renderer) Refine(factor int) error {
+	if factor != 1 && !containsInt(refineFactors, factor) {
+		return r.locale.Errorf(MsgExpectedRefine)
+	}
+	if len(r.selection) == 0 {
+		return r.locale.Errorf(MsgNothingSelected)
+	}
+	s, n := r.cells.refined(r.selection, factor)
+	r.cells = s
+	r.pristine = nil
+	r.picked = nil
+	r.UploadMesh()
+	if factor == 1 {
+		r.Notify(MsgMerged, n)
+	} else {
+		r.Notify(MsgRefined, n, factor)
+	}
+	return nil
+}
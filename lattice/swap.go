@@ -0,0 +1,96 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// SwapMode selects how buffer swaps are synchronized with the display.
+type SwapMode int
+
+const (
+	// SwapDefault keeps the swap interval chosen by the driver.
+	SwapDefault SwapMode = iota
+	// SwapImmediate swaps as soon as a frame is ready and may tear.
+	SwapImmediate
+	// SwapVsync waits for the vertical blank.
+	SwapVsync
+	// SwapAdaptive waits for the vertical blank unless the frame is late,
+	// in which case it swaps immediately. On variable refresh rate
+	// displays this keeps motion smooth without tearing.
+	SwapAdaptive
+)
+
+var swapModeNames = []string{"default", "off", "on", "adaptive"}
+
+func (m SwapMode) String() string {
+	return swapModeNames[m]
+}
+
+// Set implements flag.Value.
+func (m *SwapMode) Set(name string) error {
+	mode, ok := ParseSwapMode(name)
+	if !ok {
+		return fmt.Errorf("unknown swap mode %q", name)
+	}
+	*m = mode
+	return nil
+}
+
+// ParseSwapMode returns the swap mode with the given name.
+func ParseSwapMode(name string) (SwapMode, bool) {
+	for i, n := range swapModeNames {
+		if n == name {
+			return SwapMode(i), true
+		}
+	}
+	return SwapDefault, false
+}
+
+// adaptiveSwapSupported reports whether the current context accepts a
+// negative swap interval.
+func adaptiveSwapSupported() bool {
+	return glfw.ExtensionSupported("WGL_EXT_swap_control_tear") ||
+		glfw.ExtensionSupported("GLX_EXT_swap_control_tear")
+}
+
+// applySwapMode sets the swap interval of the current context and returns
+// the mode in effect. Adaptive sync falls back to vsync where the driver
+// does not support it.
+func applySwapMode(m SwapMode) SwapMode {
+	switch m {
+	case SwapImmediate:
+		glfw.SwapInterval(0)
+	case SwapVsync:
+		glfw.SwapInterval(1)
+	case SwapAdaptive:
+		if !adaptiveSwapSupported() {
+			glfw.SwapInterval(1)
+			return SwapVsync
+		}
+		glfw.SwapInterval(-1)
+	}
+	return m
+}
+
+// displayInfo describes the refresh behavior of the monitor showing w.
+func displayInfo(w *glfw.Window) string {
+	m := w.GetMonitor()
+	if m == nil {
+		m = glfw.GetPrimaryMonitor()
+	}
+	if m == nil {
+		return "no monitor"
+	}
+	vm := m.GetVideoMode()
+	adaptive := "unsupported"
+	if adaptiveSwapSupported() {
+		adaptive = "supported"
+	}
+	return fmt.Sprintf("%v, %vx%v at %v Hz, adaptive vsync %v", m.GetName(), vm.Width, vm.Height, vm.RefreshRate, adaptive)
+}
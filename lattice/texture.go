@@ -0,0 +1,110 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxCellTextures is the number of textures the cells can be given.
+	maxCellTextures = 16
+	// cellTextureSize is the width and height of the texture layer each
+	// image is scaled into.
+	cellTextureSize = 256
+	// cellTextureUnit is the texture unit of the cell textures in the
+	// lattice program.
+	cellTextureUnit = 4
+)
+
+// cellTextures holds the textures of the cell faces in the layers of a
+// mipmapped texture array. The lattice sites take the textures in turn,
+// in the order of their cell IDs, so that a single texture covers every
+// cell and two alternate between neighbors along an odd sided lattice.
+type cellTextures struct {
+	tex   uint32
+	files []string
+}
+
+// newCellTextures loads the PNG or JPEG images of files into a texture
+// array, scaling each to the size of a layer.
+func newCellTextures(files []string) (*cellTextures, error) {
+	if len(files) > maxCellTextures {
+		return nil, fmt.Errorf("%v textures, expected at most %v", len(files), maxCellTextures)
+	}
+	layers := make([]*image.RGBA, len(files))
+	for i, path := range files {
+		img, err := loadDecalImage(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load texture %v: %v", path, err)
+		}
+		layers[i] = image.NewRGBA(image.Rect(0, 0, cellTextureSize, cellTextureSize))
+		draw.ApproxBiLinear.Scale(layers[i], layers[i].Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+	t := &cellTextures{files: files}
+	gl.GenTextures(1, &t.tex)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, t.tex)
+	gl.TexImage3D(gl.TEXTURE_2D_ARRAY, 0, gl.RGBA8, cellTextureSize, cellTextureSize, int32(len(files)), 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	for i, img := range layers {
+		gl.TexSubImage3D(gl.TEXTURE_2D_ARRAY, 0, 0, 0, int32(i), cellTextureSize, cellTextureSize, 1, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	}
+	gl.GenerateMipmap(gl.TEXTURE_2D_ARRAY)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, 0)
+	return t, nil
+}
+
+// Delete releases the texture array.
+func (t *cellTextures) Delete() {
+	gl.DeleteTextures(1, &t.tex)
+}
+
+// textureUniforms are the locations of the cell texture uniforms of the
+// lattice program.
+type textureUniforms struct {
+	count, materials int32
+}
+
+// bindCellTextures binds the cell textures, if any, for the lattice pass.
+func (r *Renderer) bindCellTextures() {
+	if r.textures == nil {
+		gl.Uniform1i(r.textureUniforms.count, 0)
+		return
+	}
+	gl.Uniform1i(r.textureUniforms.count, int32(len(r.textures.files)))
+	gl.ActiveTexture(gl.TEXTURE0 + cellTextureUnit)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, r.textures.tex)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// SetCellTextures textures the faces of the cells with the images of
+// files, taken in turn by the lattice sites, or removes the textures for
+// none. The textures multiply the colors of the cells.
+func (r *Renderer) SetCellTextures(files []string) error {
+	var t *cellTextures
+	if len(files) > 0 {
+		var err error
+		if t, err = newCellTextures(files); err != nil {
+			return err
+		}
+	}
+	if r.textures != nil {
+		r.textures.Delete()
+	}
+	r.textures = t
+	if t == nil {
+		r.Notify(MsgTexturesOff)
+	} else {
+		r.Notify(MsgTextures, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// TimeLapse describes a time-lapse of a simulation run by an external
+// program, one step at a time, for slow processes left to run overnight.
+type TimeLapse struct {
+	// Command advances the simulation by a step every time it runs,
+	// writing the new state to the lattice file State.
+	Command []string
+	State   string
+	// Steps is the number of steps to run, every Every-th of which is
+	// captured.
+	Steps, Every int
+	// Frames is the pattern of the names of the frames, as for
+	// RunHeadless, States, if set, that of copies of the captured states.
+	// Both are formatted with the number of the frame.
+	Frames, States string
+	// Checkpoints, if set, is a directory the state is saved to every
+	// CheckpointEvery steps, so that the run can resume from there.
+	// Resume, if set, is the checkpoint file to resume from, which can be
+	// that of another run to branch from it.
+	Checkpoints     string
+	CheckpointEvery int
+	Resume          string
+}
+
+// RunTimeLapse runs the simulation of tl in a hidden window, rendering the
+// lattice of opts replaced by every captured state. The animation and a
+// camera path advance at 30 frames per second of the time-lapse.
+func RunTimeLapse(opts Options, tl TimeLapse) error {
+	if len(tl.Command) == 0 || tl.State == "" {
+		return errors.New("a time-lapse needs a simulation command and its state file")
+	}
+	if tl.Every <= 0 {
+		tl.Every = 1
+	}
+	if tl.Steps/tl.Every > 1 && !strings.Contains(tl.Frames, "%") {
+		return fmt.Errorf("%v frames need a pattern with a frame number verb such as %%04d, not %v", tl.Steps/tl.Every, tl.Frames)
+	}
+	if err := glfw.Init(); err != nil {
+		return fmt.Errorf("failed to initialize glfw: %v", err)
+	}
+	defer glfw.Terminate()
+
+	// The simulation alone changes the lattice.
+	opts.Watch = ""
+	opts.Console = nil
+	r, err := newHeadlessRenderer(opts)
+	if err != nil {
+		return err
+	}
+	err = r.timeLapse(tl, int32(opts.Samples))
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// timeLapse runs the steps of tl, rendering every captured state into an
+// offscreen framebuffer of the size of the window's.
+func (r *Renderer) timeLapse(tl TimeLapse, samples int32) error {
+	width, height := r.w.GetFramebufferSize()
+	target, err := newOffscreen(int32(width), int32(height), samples)
+	if err != nil {
+		return err
+	}
+	defer target.Delete()
+
+	first := 1
+	if tl.Resume != "" {
+		step, err := resumeCheckpoint(tl, tl.Resume)
+		if err != nil {
+			return err
+		}
+		first = step + 1
+		r.Notify(MsgResumed, step, tl.Resume)
+	}
+
+	glfw.SetTime(1)
+	r.frameTimer.OnFrame()
+	frames := tl.Steps / tl.Every
+	for step := first; step <= tl.Steps && !r.w.ShouldClose(); step++ {
+		cmd := exec.Command(tl.Command[0], tl.Command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("step %v: %v", step, err)
+		}
+		if tl.Checkpoints != "" && tl.CheckpointEvery > 0 && step%tl.CheckpointEvery == 0 {
+			path, err := saveCheckpoint(tl, step)
+			if err != nil {
+				return err
+			}
+			r.Notify(MsgCheckpoint, step, path)
+		}
+		if step%tl.Every != 0 {
+			continue
+		}
+		i := step/tl.Every - 1
+		cells, params, err := LoadCells(tl.State, r.params)
+		if err != nil {
+			return fmt.Errorf("step %v: %v", step, err)
+		}
+		r.SetCells(cells, params)
+		if tl.States != "" {
+			if err := SaveCells(fmt.Sprintf(tl.States, i), cells); err != nil {
+				return err
+			}
+		}
+
+		glfw.SetTime(1 + float64(i+1)/headlessFrameRate)
+		r.Update(r.w)
+		path := tl.Frames
+		if strings.Contains(path, "%") {
+			path = fmt.Sprintf(path, i)
+		}
+		if err := writePNG(path, r.renderOffscreen(target)); err != nil {
+			return err
+		}
+		r.Notify(MsgTimeLapseFrame, i+1, frames, step)
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// cellTrails draws where cells were over the last few changes of the
+// lattice that left positions empty, such as the steps of a simulation
+// written to a watched file, as smaller and darker cubes the older they
+// are, so that motion shows in a still picture.
+type cellTrails struct {
+	// steps is a ring buffer of the instance data left behind by the last
+	// changes, the newest at newest. A change that leaves nothing behind
+	// adds no step, so trails stay until cells move again.
+	steps  [][]float32
+	newest int
+	// prev is the instance data shown before the latest change.
+	prev []float32
+
+	buffer    uint32
+	instances int32
+}
+
+func newCellTrails(length int) *cellTrails {
+	t := &cellTrails{steps: make([][]float32, length), newest: length - 1}
+	gl.GenBuffers(1, &t.buffer)
+	return t
+}
+
+// instancePos returns the position of the instance data inst.
+func instancePos(inst []float32) mgl32.Vec3 {
+	return mgl32.Vec3{inst[0], inst[1], inst[2]}
+}
+
+// record adds the cells of the previous instance data whose positions
+// instances leaves empty as the newest step, then uploads the trails.
+// Cells that only change color leave no trail, as it would be hidden
+// inside them, and neither does the cross-fade of a watched file.
+func (t *cellTrails) record(instances []float32) {
+	prev := t.prev
+	t.prev = instances
+	if prev == nil {
+		return
+	}
+	shown := make(map[mgl32.Vec3]bool, len(instances)/floatsPerInstance)
+	for i := 0; i < len(instances); i += floatsPerInstance {
+		shown[instancePos(instances[i:])] = true
+	}
+	var left []float32
+	for i := 0; i < len(prev); i += floatsPerInstance {
+		if inst := prev[i : i+floatsPerInstance]; !shown[instancePos(inst)] {
+			left = append(left, inst...)
+		}
+	}
+	if left == nil {
+		return
+	}
+	t.newest = (t.newest + 1) % len(t.steps)
+	t.steps[t.newest] = left
+	t.upload()
+}
+
+// upload writes the steps into the buffer, each shrunk and darkened by its
+// age, the oldest by the most. Trails have no ID, so they cannot be picked.
+func (t *cellTrails) upload() {
+	var data []float32
+	n := len(t.steps)
+	for age := 0; age < n; age++ {
+		step := t.steps[(t.newest-age+n)%n]
+		fade := 1 - float32(age+1)/float32(n+1)
+		for i := 0; i < len(step); i += floatsPerInstance {
+			inst := append([]float32(nil), step[i:i+floatsPerInstance]...)
+			for j := 3; j < 6; j++ {
+				inst[j] *= fade
+			}
+			inst[6] = 0
+			inst[8] *= fade
+			data = append(data, inst...)
+		}
+	}
+	t.instances = int32(len(data) / floatsPerInstance)
+	gl.BindBuffer(gl.ARRAY_BUFFER, t.buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, len(data)*4, gl.Ptr(data), gl.DYNAMIC_DRAW)
+}
+
+// clear forgets the trails, starting over from instances.
+func (t *cellTrails) clear(instances []float32) {
+	for i := range t.steps {
+		t.steps[i] = nil
+	}
+	t.prev = instances
+	t.upload()
+}
+
+// Delete releases the buffer of the trails.
+func (t *cellTrails) Delete() {
+	gl.DeleteBuffers(1, &t.buffer)
+}
+
+// SetTrails draws trails of the cells over their last length changes, or
+// none if length is 0.
+func (r *Renderer) SetTrails(length int) {
+	if r.trails != nil {
+		r.trails.Delete()
+		r.trails = nil
+	}
+	if length <= 0 {
+		r.Notify(MsgTrailsOff)
+		return
+	}
+	r.trails = newCellTrails(length)
+	r.trails.record(r.mesh.Instances())
+	r.Notify(MsgTrails, length)
+}
+
+// drawTrails draws the trails, if any, with the lattice program.
+func (r *Renderer) drawTrails() {
+	if r.trails == nil || r.trails.instances == 0 {
+		return
+	}
+	gl.Uniform1f(r.shiftUniform, restShift)
+	r.mesh.DrawFrom(r.attribs, r.trails.buffer, 0, r.trails.instances)
+}
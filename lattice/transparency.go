@@ -0,0 +1,54 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"sort"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// SetAlpha makes the cubes alpha opaque, from 0 to 1, so that the interior
+// of the lattice shows through its outer layers. Below 1 the chunks are
+// drawn back to front, each blended over those behind it.
+func (r *Renderer) SetAlpha(alpha float32) error {
+	if alpha < 0 || alpha > 1 {
+		return r.locale.Errorf(MsgExpectedAlpha)
+	}
+	r.alpha = alpha
+	if r.beauty != nil {
+		r.beauty.reset()
+	}
+	if alpha < 1 {
+		r.Notify(MsgAlpha, 100*alpha)
+	} else {
+		r.Notify(MsgAlphaOff)
+	}
+	return nil
+}
+
+// sortDraws orders the draws back to front from the camera, by the
+// distance to the centers of their ranges. The cubes within a range are
+// not sorted, which chunks keep small enough not to show.
+func (r *Renderer) sortDraws() {
+	eye := r.camera.Inv().Col(3).Vec3()
+	sort.SliceStable(r.draws, func(i, j int) bool {
+		return r.draws[i].center.Sub(eye).LenSqr() > r.draws[j].center.Sub(eye).LenSqr()
+	})
+}
+
+// blendTransparent sets up blending the cubes over what is behind them,
+// without hiding it, and returns a function restoring opaque drawing. The
+// colors are premultiplied by alpha, and the alpha of the framebuffer is
+// kept for transparent screenshots.
+func blendTransparent() func() {
+	gl.Enable(gl.BLEND)
+	gl.BlendFuncSeparate(gl.ONE, gl.ONE_MINUS_SRC_ALPHA, gl.ZERO, gl.ONE)
+	gl.DepthMask(false)
+	return func() {
+		gl.DepthMask(true)
+		gl.Disable(gl.BLEND)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// UpAxis is the axis of the data pointing up on the screen.
+type UpAxis int
+
+const (
+	// YUp keeps y up, as the renderer does.
+	YUp UpAxis = iota
+	// ZUp keeps z up, as most scientific and CAD data does.
+	ZUp
+)
+
+var upAxisNames = []string{"y", "z"}
+
+func (a UpAxis) String() string {
+	return upAxisNames[a]
+}
+
+// Set implements flag.Value.
+func (a *UpAxis) Set(name string) error {
+	axis, ok := ParseUpAxis(name)
+	if !ok {
+		return fmt.Errorf("unknown up axis %q", name)
+	}
+	*a = axis
+	return nil
+}
+
+// ParseUpAxis returns the up axis with the given name.
+func ParseUpAxis(name string) (UpAxis, bool) {
+	for i, n := range upAxisNames {
+		if n == name {
+			return UpAxis(i), true
+		}
+	}
+	return YUp, false
+}
+
+// Handedness is whether the axes of the data are right- or left-handed.
+type Handedness int
+
+const (
+	// RightHanded axes, as OpenGL's, have z towards the viewer when x
+	// points right and y up.
+	RightHanded Handedness = iota
+	// LeftHanded axes, as those of Direct3D and many game engines, have z
+	// away from the viewer.
+	LeftHanded
+)
+
+var handednessNames = []string{"right", "left"}
+
+func (h Handedness) String() string {
+	return handednessNames[h]
+}
+
+// Set implements flag.Value.
+func (h *Handedness) Set(name string) error {
+	hand, ok := ParseHandedness(name)
+	if !ok {
+		return fmt.Errorf("unknown handedness %q", name)
+	}
+	*h = hand
+	return nil
+}
+
+// ParseHandedness returns the handedness with the given name.
+func ParseHandedness(name string) (Handedness, bool) {
+	for i, n := range handednessNames {
+		if n == name {
+			return Handedness(i), true
+		}
+	}
+	return RightHanded, false
+}
+
+// axesFrame returns the matrix taking positions in the data, whose axes
+// have the given up axis and handedness, to the display frame the camera
+// moves in, which is right-handed with y up. Left-handed axes are mirrored
+// along the axis towards the viewer.
+func axesFrame(up UpAxis, hand Handedness) mgl32.Mat4 {
+	m := mgl32.Ident4()
+	if hand == LeftHanded {
+		mirror := 2
+		if up == ZUp {
+			mirror = 1
+		}
+		m.Set(mirror, mirror, -1)
+	}
+	if up == ZUp {
+		// A quarter turn about x takes z up and y away from the viewer.
+		turn := mgl32.Mat4{1, 0, 0, 0, 0, 0, -1, 0, 0, 1, 0, 0, 0, 0, 0, 1}
+		m = turn.Mul4(m)
+	}
+	return m
+}
+
+// Units is the physical length of a grid step of the lattice, which the
+// HUD and measurements report lengths in.
+type Units struct {
+	// PerCell is the length of a grid step in units of Name, 0 to report
+	// world lengths.
+	PerCell float64
+	Name    string
+}
+
+func (u Units) String() string {
+	if u.PerCell == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(u.PerCell, 'g', -1, 64) + u.Name
+}
+
+// Set implements flag.Value.
+func (u *Units) Set(s string) error {
+	units, ok := ParseUnits(s)
+	if !ok {
+		return fmt.Errorf("units must be a positive length such as 0.5nm or 2.46 Å, not %q", s)
+	}
+	*u = units
+	return nil
+}
+
+// ParseUnits parses a length of a grid step such as 0.5nm, a number
+// followed by the name of its unit, if any. The empty string resets the
+// units to world lengths.
+func ParseUnits(s string) (Units, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Units{}, true
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.+-eE", r)
+	})
+	// An e starting the unit, as in 1em, is not an exponent.
+	for end > 0 && (s[end-1] == 'e' || s[end-1] == 'E') {
+		if _, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			break
+		}
+		end--
+	}
+	if end < 0 {
+		end = len(s)
+	}
+	v, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return Units{}, false
+	}
+	return Units{PerCell: v, Name: strings.TrimSpace(s[end:])}, true
+}
+
+// length converts the world length l into units, given the world length of
+// a grid step.
+func (u Units) length(l, step float32) float64 {
+	if u.PerCell == 0 || step == 0 {
+		return float64(l)
+	}
+	return float64(l/step) * u.PerCell
+}
+
+// unitSuffix returns the name of the units as appended to a number.
+func (u Units) unitSuffix() string {
+	if u.PerCell == 0 || u.Name == "" {
+		return ""
+	}
+	return " " + u.Name
+}
+
+// gridStep returns the world length of a grid step along x.
+func (p LatticeParams) gridStep() float32 {
+	return p.World(mgl32.Vec3{1, 0, 0}).Len()
+}
+
+// formatLength formats the world length l in the units of r.
+func (r *Renderer) formatLength(l float32) string {
+	return fmt.Sprintf("%.3g", r.units.length(l, r.params.gridStep())) + r.units.unitSuffix()
+}
+
+// formatPos formats the world position pos in the units of r, to a tenth
+// of a world unit without units.
+func (r *Renderer) formatPos(pos mgl32.Vec3) string {
+	if r.units.PerCell == 0 {
+		return fmt.Sprintf("%.1f, %.1f, %.1f", pos[0], pos[1], pos[2])
+	}
+	step := r.params.gridStep()
+	return fmt.Sprintf("%.3g, %.3g, %.3g", r.units.length(pos[0], step), r.units.length(pos[1], step), r.units.length(pos[2], step)) + r.units.unitSuffix()
+}
+
+// displayPos returns the position in the display frame of the world
+// position pos.
+func (r *Renderer) displayPos(pos mgl32.Vec3) mgl32.Vec3 {
+	return mgl32.TransformCoordinate(pos, r.frame)
+}
+
+// worldPos returns the world position of the position pos in the display
+// frame.
+func (r *Renderer) worldPos(pos mgl32.Vec3) mgl32.Vec3 {
+	return mgl32.TransformCoordinate(pos, r.frame.Inv())
+}
+
+// SetAxes turns the view so that the axis up of the data points up on the
+// screen, with axes of the given handedness, keeping the camera at the
+// same position in the data.
+func (r *Renderer) SetAxes(up UpAxis, hand Handedness) {
+	pos := r.worldPos(r.camPos)
+	r.up, r.handedness = up, hand
+	r.frame = axesFrame(up, hand)
+	r.camPos = r.displayPos(pos)
+	r.flight = nil
+	r.Notify(MsgAxes, up, hand)
+}
+
+// SetUnits reports lengths in units, or in world lengths for the zero
+// Units.
+func (r *Renderer) SetUnits(u Units) {
+	r.units = u
+	if u.PerCell == 0 {
+		r.Notify(MsgUnitsOff)
+		return
+	}
+	r.Notify(MsgUnits, u)
+}
+
+// Measure reports the extent of the selected cells along the axes of the
+// data and the distance across their bounding box, in the units of r.
+func (r *Renderer) Measure() error {
+	if len(r.selection) == 0 {
+		return r.locale.Errorf(MsgNothingSelected)
+	}
+	var lo, hi mgl32.Vec3
+	n := 0
+	for id := range r.selection {
+		c, ok := r.cells.find(id)
+		if !ok {
+			continue
+		}
+		pos := r.params.World(c.pos)
+		if n == 0 {
+			lo, hi = pos, pos
+		}
+		for axis, v := range pos {
+			if v < lo[axis] {
+				lo[axis] = v
+			}
+			if v > hi[axis] {
+				hi[axis] = v
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return r.locale.Errorf(MsgNothingSelected)
+	}
+	d := hi.Sub(lo)
+	r.Notify(MsgMeasured, n, r.formatLength(d[0]), r.formatLength(d[1]), r.formatLength(d[2]), r.formatLength(d.Len()))
+	return nil
+}
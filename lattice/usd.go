@@ -0,0 +1,211 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// usdTimeCodesPerSecond is the time resolution of exported USD stages.
+const usdTimeCodesPerSecond = 60
+
+// usdHorizontalAperture is the film width of exported cameras in
+// millimeters, the USD default.
+const usdHorizontalAperture = 20.955
+
+// usdRecorder samples the camera every frame and the visible cells
+// whenever they change, and writes them as an animated USD stage that DCC
+// tools can render offline.
+type usdRecorder struct {
+	path   string
+	start  float64
+	aspect float32
+	// fov is the vertical field of view in degrees, near and far the
+	// clipping range of the camera.
+	fov       float32
+	near, far float32
+	size      float32
+	shape     CellShape
+	// changes records every change of the visible cells rather than only
+	// the cells visible when recording started.
+	changes bool
+
+	cameras  []usdCamera
+	lattices []usdLattice
+}
+
+type usdCamera struct {
+	time float64
+	// transform is the camera to world transform.
+	transform mgl32.Mat4
+}
+
+type usdLattice struct {
+	time float64
+	// instances is the per-cell data as written by makeInstances.
+	instances []float32
+}
+
+func newUSDRecorder(path string, start float64, aspect, fov, near, far, cubeSize float32, shape CellShape, changes bool) *usdRecorder {
+	return &usdRecorder{path: path, start: start, aspect: aspect, fov: fov, near: near, far: far, size: cubeSize, shape: shape, changes: changes}
+}
+
+// addCamera records the view matrix of the frame at time t.
+func (u *usdRecorder) addCamera(t float64, view mgl32.Mat4) {
+	u.cameras = append(u.cameras, usdCamera{time: t, transform: view.Inv()})
+}
+
+// addLattice records the visible cells from time t on. Only the first call
+// is kept unless changes are recorded.
+func (u *usdRecorder) addLattice(t float64, instances []float32) {
+	if len(u.lattices) > 0 && !u.changes {
+		return
+	}
+	u.lattices = append(u.lattices, usdLattice{time: t, instances: instances})
+}
+
+func (u *usdRecorder) timeCode(t float64) float64 {
+	return (t - u.start) * usdTimeCodesPerSecond
+}
+
+// Save writes the recording to its path.
+func (u *usdRecorder) Save() error {
+	f, err := os.Create(u.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	u.write(w)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (u *usdRecorder) write(w io.Writer) {
+	end := 0.0
+	if len(u.cameras) > 0 {
+		end = u.timeCode(u.cameras[len(u.cameras)-1].time)
+	}
+	fmt.Fprintf(w, "#usda 1.0\n(\n")
+	fmt.Fprintf(w, "    defaultPrim = \"Session\"\n")
+	fmt.Fprintf(w, "    startTimeCode = 0\n")
+	fmt.Fprintf(w, "    endTimeCode = %v\n", formatFloat(float32(end)))
+	fmt.Fprintf(w, "    timeCodesPerSecond = %v\n", usdTimeCodesPerSecond)
+	fmt.Fprintf(w, "    upAxis = \"Y\"\n")
+	fmt.Fprintf(w, ")\n\n")
+
+	fmt.Fprintf(w, "def Xform \"Session\"\n{\n")
+	u.writeCamera(w)
+	u.writeLattice(w)
+	fmt.Fprintf(w, "}\n")
+}
+
+func (u *usdRecorder) writeCamera(w io.Writer) {
+	// Match the vertical field of view of projectionFor.
+	vertical := usdHorizontalAperture / float64(u.aspect)
+	focal := vertical / (2 * math.Tan(float64(mgl32.DegToRad(u.fov))/2))
+
+	fmt.Fprintf(w, "    def Camera \"Camera\"\n    {\n")
+	fmt.Fprintf(w, "        float2 clippingRange = (%v, %v)\n", formatFloat(u.near), formatFloat(u.far))
+	fmt.Fprintf(w, "        float focalLength = %v\n", formatFloat(float32(focal)))
+	fmt.Fprintf(w, "        float horizontalAperture = %v\n", formatFloat(usdHorizontalAperture))
+	fmt.Fprintf(w, "        float verticalAperture = %v\n", formatFloat(float32(vertical)))
+	fmt.Fprintf(w, "        matrix4d xformOp:transform.timeSamples = {\n")
+	for _, s := range u.cameras {
+		// USD matrices multiply row vectors, so the rows are the columns
+		// of the mgl32 matrix, which is its memory order.
+		m := s.transform
+		fmt.Fprintf(w, "            %v: ( (%v, %v, %v, %v), (%v, %v, %v, %v), (%v, %v, %v, %v), (%v, %v, %v, %v) ),\n",
+			formatFloat(float32(u.timeCode(s.time))),
+			m[0], m[1], m[2], m[3], m[4], m[5], m[6], m[7],
+			m[8], m[9], m[10], m[11], m[12], m[13], m[14], m[15])
+	}
+	fmt.Fprintf(w, "        }\n")
+	fmt.Fprintf(w, "        uniform token[] xformOpOrder = [\"xformOp:transform\"]\n")
+	fmt.Fprintf(w, "    }\n\n")
+}
+
+func (u *usdRecorder) writeLattice(w io.Writer) {
+	fmt.Fprintf(w, "    def PointInstancer \"Lattice\"\n    {\n")
+
+	fmt.Fprintf(w, "        point3f[] positions.timeSamples = {\n")
+	for _, s := range u.lattices {
+		u.writeArray(w, s, 0)
+	}
+	fmt.Fprintf(w, "        }\n")
+
+	fmt.Fprintf(w, "        color3f[] primvars:displayColor.timeSamples = {\n")
+	for _, s := range u.lattices {
+		u.writeArray(w, s, 3)
+	}
+	fmt.Fprintf(w, "        }\n")
+	fmt.Fprintf(w, "        uniform token primvars:displayColor:interpolation = \"vertex\"\n")
+
+	fmt.Fprintf(w, "        float3[] scales.timeSamples = {\n")
+	for _, s := range u.lattices {
+		u.writeScales(w, s)
+	}
+	fmt.Fprintf(w, "        }\n")
+
+	fmt.Fprintf(w, "        int[] protoIndices.timeSamples = {\n")
+	for _, s := range u.lattices {
+		fmt.Fprintf(w, "            %v: [", formatFloat(float32(u.timeCode(s.time))))
+		for i := 0; i < len(s.instances)/floatsPerInstance; i++ {
+			if i > 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprint(w, "0")
+		}
+		fmt.Fprintf(w, "],\n")
+	}
+	fmt.Fprintf(w, "        }\n")
+
+	prototype, attr, size := "Cube", "size", u.size
+	if u.shape == SphereShape {
+		prototype, attr, size = "Sphere", "radius", u.size/2
+	}
+	fmt.Fprintf(w, "        rel prototypes = </Session/Lattice/Prototypes/%v>\n\n", prototype)
+	fmt.Fprintf(w, "        def Scope \"Prototypes\"\n        {\n")
+	fmt.Fprintf(w, "            def %v \"%v\"\n            {\n", prototype, prototype)
+	fmt.Fprintf(w, "                double %v = %v\n", attr, formatFloat(size))
+	fmt.Fprintf(w, "            }\n        }\n")
+	fmt.Fprintf(w, "    }\n")
+}
+
+// writeArray writes one time sample of the vec3 stored at offset in every
+// instance.
+func (u *usdRecorder) writeArray(w io.Writer, s usdLattice, offset int) {
+	fmt.Fprintf(w, "            %v: [", formatFloat(float32(u.timeCode(s.time))))
+	for i := 0; i+floatsPerInstance <= len(s.instances); i += floatsPerInstance {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		v := s.instances[i+offset : i+offset+3]
+		fmt.Fprintf(w, "(%v, %v, %v)", v[0], v[1], v[2])
+	}
+	fmt.Fprintf(w, "],\n")
+}
+
+// writeScales writes one time sample of the scales of the instances, the
+// size of every cell along all three axes.
+func (u *usdRecorder) writeScales(w io.Writer, s usdLattice) {
+	fmt.Fprintf(w, "            %v: [", formatFloat(float32(u.timeCode(s.time))))
+	for i := 0; i+floatsPerInstance <= len(s.instances); i += floatsPerInstance {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		v := s.instances[i+8]
+		fmt.Fprintf(w, "(%v, %v, %v)", v, v, v)
+	}
+	fmt.Fprintf(w, "],\n")
+}
@@ -0,0 +1,193 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// viewLinkScheme is the URI scheme of view links.
+const viewLinkScheme = "gogllattice"
+
+// ViewLink is a viewpoint to share between users: the scene or lattice
+// file shown, the camera pose and the style. It is written as a URI such
+// as
+//
+//	gogllattice://view?file=scene.json&pos=1,2,3&pitch=10&yaw=45&fov=45&style=clay
+//
+// and can be given as a JSON object with the same fields instead.
+type ViewLink struct {
+	// File is the scene or lattice file to load, empty to keep the cells
+	// shown.
+	File string `json:"file,omitempty"`
+	// Position is the camera position in the axes of the data, so that it
+	// means the same whatever the axes settings of the viewer.
+	Position mgl32.Vec3 `json:"pos"`
+	// Pitch and Yaw are the camera angles and FOV the vertical field of
+	// view, in degrees, 0 to keep the current one.
+	Pitch float32 `json:"pitch"`
+	Yaw   float32 `json:"yaw"`
+	FOV   float32 `json:"fov,omitempty"`
+	// Style is the name of the style, empty to keep the current one.
+	Style string `json:"style,omitempty"`
+}
+
+// String returns the URI of l.
+func (l ViewLink) String() string {
+	q := url.Values{}
+	if l.File != "" {
+		q.Set("file", l.File)
+	}
+	pos := make([]string, len(l.Position))
+	for i, v := range l.Position {
+		pos[i] = formatFloat(v)
+	}
+	q.Set("pos", strings.Join(pos, ","))
+	q.Set("pitch", formatFloat(l.Pitch))
+	q.Set("yaw", formatFloat(l.Yaw))
+	if l.FOV > 0 {
+		q.Set("fov", formatFloat(l.FOV))
+	}
+	if l.Style != "" {
+		q.Set("style", l.Style)
+	}
+	u := url.URL{Scheme: viewLinkScheme, Host: "view", RawQuery: q.Encode()}
+	return u.String()
+}
+
+// IsViewLink reports whether s looks like a view link, as a URI of its
+// scheme or a JSON object, rather than a file name.
+func IsViewLink(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, viewLinkScheme+"://") || strings.HasPrefix(s, "{")
+}
+
+// ParseViewLink parses a view link, as a URI or a JSON object.
+func ParseViewLink(s string) (ViewLink, error) {
+	s = strings.TrimSpace(s)
+	var l ViewLink
+	if strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal([]byte(s), &l); err != nil {
+			return l, fmt.Errorf("failed to parse view link: %v", err)
+		}
+		return l, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return l, fmt.Errorf("failed to parse view link: %v", err)
+	}
+	if u.Scheme != viewLinkScheme || u.Host != "view" {
+		return l, fmt.Errorf("view links start with %v://view, not %q", viewLinkScheme, s)
+	}
+	q := u.Query()
+	l.File, l.Style = q.Get("file"), q.Get("style")
+	pos := strings.Split(q.Get("pos"), ",")
+	if len(pos) != 3 {
+		return l, fmt.Errorf("the pos of a view link is x,y,z, not %q", q.Get("pos"))
+	}
+	for i, p := range pos {
+		if l.Position[i], err = parseLinkFloat("pos", p); err != nil {
+			return l, err
+		}
+	}
+	if l.Pitch, err = parseLinkFloat("pitch", q.Get("pitch")); err != nil {
+		return l, err
+	}
+	if l.Yaw, err = parseLinkFloat("yaw", q.Get("yaw")); err != nil {
+		return l, err
+	}
+	if q.Get("fov") != "" {
+		if l.FOV, err = parseLinkFloat("fov", q.Get("fov")); err != nil {
+			return l, err
+		}
+	}
+	return l, nil
+}
+
+func parseLinkFloat(name, s string) (float32, error) {
+	v, err := strconv.ParseFloat(s, 32)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("the %v of a view link must be a number, not %q", name, s)
+	}
+	return float32(v), nil
+}
+
+// ViewLink returns a link to the current view: the file last loaded or
+// saved, the camera and the style.
+func (r *Renderer) ViewLink() ViewLink {
+	return ViewLink{
+		File:     r.source,
+		Position: r.worldPos(r.camPos),
+		Pitch:    mgl32.RadToDeg(r.pitch),
+		Yaw:      mgl32.RadToDeg(r.yaw),
+		FOV:      r.fov,
+		Style:    r.styles[r.style].Name,
+	}
+}
+
+// OpenViewLink loads the file of l, unless it is already shown, and moves
+// the camera to its viewpoint in its style.
+func (r *Renderer) OpenViewLink(l ViewLink) error {
+	if l.File != "" && l.File != r.source {
+		if err := r.LoadFile(l.File); err != nil {
+			return err
+		}
+	}
+	if l.Style != "" {
+		if err := r.SetStyleByName(l.Style); err != nil {
+			return err
+		}
+	}
+	r.SetCamera(r.displayPos(l.Position), l.Pitch, l.Yaw)
+	if l.FOV > 0 {
+		r.SetFOV(l.FOV)
+	}
+	r.Notify(MsgViewLinkOpened)
+	return nil
+}
+
+// CopyViewLink copies the link to the current view to the clipboard.
+func (r *Renderer) CopyViewLink() {
+	link := r.ViewLink().String()
+	r.w.SetClipboardString(link)
+	r.Notify(MsgViewLinkCopied, link)
+}
+
+// PasteViewLink opens the view link on the clipboard.
+func (r *Renderer) PasteViewLink() error {
+	s := r.w.GetClipboardString()
+	if !IsViewLink(s) {
+		return r.locale.Errorf(MsgNoViewLink)
+	}
+	l, err := ParseViewLink(s)
+	if err != nil {
+		return err
+	}
+	return r.OpenViewLink(l)
+}
+
+// LoadFile replaces the cells by those of a lattice file, or the whole
+// scene by a scene file.
+func (r *Renderer) LoadFile(path string) error {
+	defer r.pauseWatchdog()()
+	if isSceneFile(path) {
+		return r.LoadScene(path)
+	}
+	cells, params, err := LoadCells(path, r.params)
+	if err != nil {
+		return err
+	}
+	r.SetCells(cells, params)
+	r.source = path
+	r.Notify(MsgCellsLoaded, len(cells), path)
+	return nil
+}
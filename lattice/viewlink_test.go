@@ -0,0 +1,72 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestParseViewLink(t *testing.T) {
+	tests := []struct {
+		s    string
+		want ViewLink
+	}{
+		{
+			"gogllattice://view?file=scene.json&pos=1,2,3&pitch=10&yaw=45&fov=45&style=clay",
+			ViewLink{File: "scene.json", Position: mgl32.Vec3{1, 2, 3}, Pitch: 10, Yaw: 45, FOV: 45, Style: "clay"},
+		},
+		{
+			"  gogllattice://view?pos=-1.5,0,2e1&pitch=-90&yaw=0  ",
+			ViewLink{Position: mgl32.Vec3{-1.5, 0, 20}, Pitch: -90},
+		},
+		{
+			"gogllattice://view?file=my%20scene.json&pos=0,0,0&pitch=0&yaw=0",
+			ViewLink{File: "my scene.json"},
+		},
+		{
+			`{"file": "a.csv", "pos": [4, 5, 6], "pitch": 1, "yaw": 2, "fov": 30}`,
+			ViewLink{File: "a.csv", Position: mgl32.Vec3{4, 5, 6}, Pitch: 1, Yaw: 2, FOV: 30},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseViewLink(test.s)
+		if err != nil {
+			t.Errorf("ParseViewLink(%q): %v", test.s, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseViewLink(%q) = %+v, want %+v", test.s, got, test.want)
+		}
+		if back, err := ParseViewLink(got.String()); err != nil || back != got {
+			t.Errorf("ParseViewLink(%q) = %+v, %v, want %+v", got.String(), back, err, got)
+		}
+	}
+}
+
+func TestParseViewLinkMalformed(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"scene.json",
+		"http://view?pos=0,0,0&pitch=0&yaw=0",
+		"gogllattice://other?pos=0,0,0&pitch=0&yaw=0",
+		"gogllattice://view?pitch=0&yaw=0",
+		"gogllattice://view?pos=0,0&pitch=0&yaw=0",
+		"gogllattice://view?pos=0,0,zero&pitch=0&yaw=0",
+		"gogllattice://view?pos=0,0,0&yaw=0",
+		"gogllattice://view?pos=0,0,0&pitch=0&yaw=NaN",
+		"gogllattice://view?pos=0,Inf,0&pitch=0&yaw=0",
+		"gogllattice://view?pos=0,0,0&pitch=0&yaw=0&fov=wide",
+		"gogllattice://view?pos=0,0,0&pitch=1e39&yaw=0",
+		"gogllattice://%zz",
+		"{",
+		`{"pos": "here"}`,
+	} {
+		if l, err := ParseViewLink(s); err == nil {
+			t.Errorf("ParseViewLink(%q) = %+v, want an error", s, l)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"io"
+	"path/filepath"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// watchFadeDuration is how long, in seconds, the colors of a reloaded
+	// lattice file take to cross-fade from the previous contents.
+	watchFadeDuration = 0.5
+	// fadeUnit is the texture unit of the colors faded from in the
+	// lattice program.
+	fadeUnit = 7
+)
+
+// fileWatch reloads a lattice file whenever it changes.
+type fileWatch struct {
+	path    string
+	watcher io.Closer
+	changed <-chan struct{}
+	// fade, if not nil, is the cross-fade to the latest contents.
+	fade *colorFade
+}
+
+// colorFade blends the colors of the cells shown before a reload into
+// those of the reloaded cells. The reloaded cells are uploaded once and
+// the vertex shader blends them with the previous colors, looked up by
+// cell ID in a table wrapped into rows of materialTableWidth as the
+// material table is. Cells that appear or disappear do so at once.
+type colorFade struct {
+	// from are the colors faded from by grid position, kept for a reload
+	// during the fade to start from the colors shown.
+	from  map[[3]int]mgl32.Vec3
+	table uint32
+	start float64
+}
+
+// newColorFade uploads the table of the colors from of the cells of the
+// lattice p, starting to fade at start.
+func newColorFade(from map[[3]int]mgl32.Vec3, p LatticeParams, start float64) *colorFade {
+	f := &colorFade{from: from, start: start}
+	var n uint32
+	for pos := range from {
+		if id, ok := p.CellID(pos[0], pos[1], pos[2]); ok && id > n {
+			n = id
+		}
+	}
+	rows := int(n)/materialTableWidth + 1
+	// The alpha of the cells with a color to fade from is 1.
+	data := make([]float32, rows*materialTableWidth*4)
+	for pos, color := range from {
+		if id, ok := p.CellID(pos[0], pos[1], pos[2]); ok {
+			copy(data[id*4:], []float32{color[0], color[1], color[2], 1})
+		}
+	}
+	gl.GenTextures(1, &f.table)
+	gl.BindTexture(gl.TEXTURE_2D, f.table)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA16F, materialTableWidth, int32(rows), 0, gl.RGBA, gl.FLOAT, gl.Ptr(data))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return f
+}
+
+// at returns how far the fade is at the time now, 1 once it is over.
+func (f *colorFade) at(now float64) float32 {
+	t := float32((now - f.start) / watchFadeDuration)
+	if t > 1 {
+		return 1
+	}
+	return t
+}
+
+// Delete releases the table.
+func (f *colorFade) Delete() {
+	gl.DeleteTextures(1, &f.table)
+}
+
+// fadeProgress returns how far the watched file has faded in, 1 if it is
+// not fading.
+func (r *Renderer) fadeProgress() float32 {
+	if r.watch == nil || r.watch.fade == nil {
+		return 1
+	}
+	return r.watch.fade.at(r.frameTimer.prevTime)
+}
+
+// bindFade sets the fade uniform and binds the colors faded from, if the
+// watched file is fading in.
+func (r *Renderer) bindFade() {
+	gl.Uniform1f(r.fadeUniform, r.fadeProgress())
+	if r.watch == nil || r.watch.fade == nil {
+		return
+	}
+	gl.ActiveTexture(gl.TEXTURE0 + fadeUnit)
+	gl.BindTexture(gl.TEXTURE_2D, r.watch.fade.table)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// Watch shows the cells of the lattice file at path and reloads them
+// whenever the file changes, replacing any file watched before.
+func (r *Renderer) Watch(path string) error {
+	cells, params, err := LoadCells(path, r.params)
+	if err != nil {
+		return err
+	}
+	if err := r.watchFile(path); err != nil {
+		return err
+	}
+	r.SetCells(cells, params)
+	r.Notify(MsgWatching, len(cells), path)
+	return nil
+}
+
+// watchFile starts reloading the lattice file at path when it changes.
+func (r *Renderer) watchFile(path string) error {
+	watcher, changed, err := watchFiles(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	r.StopWatching()
+	r.watch = &fileWatch{path: path, watcher: watcher, changed: changed}
+	return nil
+}
+
+// StopWatching stops reloading the watched file, if any, leaving its last
+// contents shown.
+func (r *Renderer) StopWatching() {
+	if r.watch == nil {
+		return
+	}
+	if f := r.watch.fade; f != nil {
+		f.Delete()
+	}
+	r.watch.watcher.Close()
+	r.watch = nil
+}
+
+// pollWatch reloads the watched file if it changed and advances the
+// cross-fade. A file that fails to load, typically because it is being
+// written, is reported and the previous cells stay until the next change.
+func (r *Renderer) pollWatch() {
+	w := r.watch
+	if w == nil {
+		return
+	}
+	now := glfw.GetTime()
+	select {
+	case <-w.changed:
+		cells, params, err := LoadCells(w.path, r.params)
+		if err != nil {
+			r.NotifyError(err)
+			break
+		}
+		// The fade starts from the colors shown, as the groups color them,
+		// which during a fade are blended from the previous ones.
+		from := make(map[[3]int]mgl32.Vec3, r.cells.count())
+		for _, k := range r.cells.keys {
+			for _, c := range r.cells.chunk(k) {
+				c, _, ok := displayed(c, r.groups)
+				if !ok {
+					continue
+				}
+				pos := gridPos(c)
+				if f := w.fade; f != nil {
+					if prev, ok := f.from[pos]; ok {
+						c.color = prev.Add(c.color.Sub(prev).Mul(f.at(now)))
+					}
+				}
+				from[pos] = c.color
+			}
+		}
+		if w.fade != nil {
+			w.fade.Delete()
+		}
+		r.SetCells(cells, params)
+		w.fade = newColorFade(from, params, now)
+	default:
+	}
+
+	if f := w.fade; f != nil && f.at(now) >= 1 {
+		f.Delete()
+		w.fade = nil
+	}
+}
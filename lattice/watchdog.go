@@ -0,0 +1,118 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// watchdog reports when the frame loop hangs: it checks on its own
+// goroutine that frames keep coming, since a hung loop cannot check
+// itself. What to do about a hang, such as restarting the program, is up
+// to the hang function.
+type watchdog struct {
+	timeout time.Duration
+	// last is when the last frame started, in Unix nanoseconds, and frames
+	// how many have.
+	last   int64
+	frames int64
+	// paused counts the long operations running on the frame loop, during
+	// which no frame is expected.
+	paused int32
+	// info describes the GL context for the diagnostics, gathered on the
+	// main goroutine where the context is current.
+	info string
+	// hang, if not nil, is called on the goroutine of the watchdog after
+	// the diagnostics of a hang are logged.
+	hang func()
+	done chan struct{}
+}
+
+// newWatchdog starts watching for frames more than timeout apart, calling
+// hang once for every hang.
+func newWatchdog(timeout time.Duration, info string, hang func()) *watchdog {
+	w := &watchdog{timeout: timeout, last: time.Now().UnixNano(), info: info, hang: hang, done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+// beat marks the start of a frame.
+func (w *watchdog) beat() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+	atomic.AddInt64(&w.frames, 1)
+}
+
+// pause stops watching until resume, for an operation on the frame loop
+// that may take longer than the timeout, such as baking light or loading
+// a large file. Pauses nest.
+func (w *watchdog) pause() {
+	atomic.AddInt32(&w.paused, 1)
+}
+
+// resume undoes a pause. The time it took does not count towards the
+// frame it ran in.
+func (w *watchdog) resume() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+	atomic.AddInt32(&w.paused, -1)
+}
+
+func (w *watchdog) run() {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+	// reported is the start of the frame the last hang was reported in,
+	// which is not reported again.
+	var reported int64
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-ticker.C:
+			if atomic.LoadInt32(&w.paused) > 0 {
+				continue
+			}
+			last := atomic.LoadInt64(&w.last)
+			since := now.Sub(time.Unix(0, last))
+			if since < w.timeout || last == reported {
+				continue
+			}
+			reported = last
+			w.diagnose("no frame for %v", since.Round(time.Millisecond))
+			if w.hang != nil {
+				w.hang()
+			}
+		}
+	}
+}
+
+// diagnose logs why the watchdog reports a hang, with the stacks of all
+// goroutines, which show where the frame loop is stuck.
+func (w *watchdog) diagnose(format string, args ...interface{}) {
+	log.Printf("watchdog: "+format, args...)
+	log.Printf("watchdog: %v frames drawn, %v", atomic.LoadInt64(&w.frames), w.info)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Printf("watchdog: %v goroutines, %v MiB heap", runtime.NumGoroutine(), mem.HeapAlloc>>20)
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	log.Printf("watchdog: goroutines:\n%s", buf)
+}
+
+// stop stops watching.
+func (w *watchdog) stop() {
+	close(w.done)
+}
+
+// pauseWatchdog pauses the watchdog, if any, for a long operation on the
+// frame loop, and returns the function that resumes it.
+func (r *Renderer) pauseWatchdog() func() {
+	if r.watchdog == nil {
+		return func() {}
+	}
+	r.watchdog.pause()
+	return r.watchdog.resume
+}
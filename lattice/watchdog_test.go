@@ -0,0 +1,64 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const timeout = 40 * time.Millisecond
+	tests := []struct {
+		name string
+		// frame is what the frame loop does after the first frame starts.
+		frame func(w *watchdog)
+		hangs int32
+	}{
+		{"beating", func(w *watchdog) {
+			for i := 0; i < 8; i++ {
+				time.Sleep(timeout / 4)
+				w.beat()
+			}
+		}, 0},
+		{"hung", func(w *watchdog) { time.Sleep(4 * timeout) }, 1},
+		{"paused", func(w *watchdog) {
+			w.pause()
+			time.Sleep(4 * timeout)
+			w.resume()
+		}, 0},
+		{"nested pause", func(w *watchdog) {
+			w.pause()
+			w.pause()
+			time.Sleep(2 * timeout)
+			w.resume()
+			time.Sleep(2 * timeout)
+			w.resume()
+		}, 0},
+		{"hung after pause", func(w *watchdog) {
+			w.pause()
+			w.resume()
+			time.Sleep(4 * timeout)
+		}, 1},
+	}
+	for _, test := range tests {
+		var hangs int32
+		w := newWatchdog(timeout, "test", func() { atomic.AddInt32(&hangs, 1) })
+		w.beat()
+		test.frame(w)
+		w.beat()
+		w.stop()
+		if got := atomic.LoadInt32(&hangs); got != test.hangs {
+			t.Errorf("%v: %v hangs reported, want %v", test.name, got, test.hangs)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lattice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/outblasted/gogllattice/glutil"
+)
+
+// wireframeProgram is the variant of the lattice program that draws the
+// wireframe: the lattice vertex shader, hooks included, so that the cells
+// are placed and colored as the faces are, with a fragment shader of its
+// own drawing the edges in the flat colors of the cells, unlit.
+type wireframeProgram struct {
+	program uint32
+	vao     uint32
+	attribs cubeAttribs
+
+	projectionUniform int32
+	cameraUniform     int32
+	modelUniform      int32
+	shiftUniform      int32
+	saturationUniform int32
+	tintUniform       int32
+	brightnessUniform int32
+	colorPhaseUniform int32
+	fadeUniform       int32
+	clipUniform       int32
+	timeUniform       int32
+	displaceUniforms  displaceUniforms
+}
+
+// newWireframeProgram builds the wireframe program from the lattice vertex
+// shader vsrc. The cells are read from mesh.
+func newWireframeProgram(vsrc string, mesh *Mesh) (*wireframeProgram, error) {
+	program, err := glutil.NewProgram(vsrc, wireframeFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+	uniform := func(name string) int32 {
+		return gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+	}
+	w := &wireframeProgram{
+		program:           program,
+		projectionUniform: uniform("projection"),
+		cameraUniform:     uniform("camera"),
+		modelUniform:      uniform("model"),
+		shiftUniform:      uniform("shift"),
+		saturationUniform: uniform("saturation"),
+		tintUniform:       uniform("tint"),
+		brightnessUniform: uniform("brightness"),
+		colorPhaseUniform: uniform("colorPhase"),
+		fadeUniform:       uniform("colorFade"),
+		clipUniform:       uniform("clipPlane"),
+		timeUniform:       uniform("time"),
+		displaceUniforms: displaceUniforms{
+			amplitude: uniform("displaceAmplitude"),
+			frequency: uniform("displaceFrequency"),
+			speed:     uniform("displaceSpeed"),
+		},
+	}
+
+	var prevProgram, prevVAO int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &prevProgram)
+	gl.GetIntegerv(gl.VERTEX_ARRAY_BINDING, &prevVAO)
+	defer gl.UseProgram(uint32(prevProgram))
+	defer gl.BindVertexArray(uint32(prevVAO))
+
+	// Samplers of different types must not share a unit, even those the
+	// wireframe does not sample, so each keeps the unit of the lattice
+	// program.
+	gl.UseProgram(program)
+	gl.Uniform1i(uniform("lightmap"), lightmapUnit)
+	gl.Uniform1i(uniform("cellMaterials"), cellMaterialUnit)
+	gl.Uniform1i(uniform("displaceMap"), displaceUnit)
+	gl.Uniform1i(uniform("fadeColors"), fadeUnit)
+
+	gl.GenVertexArrays(1, &w.vao)
+	gl.BindVertexArray(w.vao)
+	w.attribs = mesh.attribs(program)
+	return w, nil
+}
+
+// Delete releases the program and its vertex array.
+func (w *wireframeProgram) Delete() {
+	gl.DeleteVertexArrays(1, &w.vao)
+	gl.DeleteProgram(w.program)
+}
+
+// buildWireframe builds the wireframe program with the shaders and hooks of
+// the lattice program.
+func (r *Renderer) buildWireframe() (*wireframeProgram, error) {
+	vsrc, _, sources, err := r.hookedShaders(r.hooks)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWireframeProgram(vsrc, r.mesh)
+	if err != nil && len(sources) > 0 {
+		return nil, fmt.Errorf("wireframe: %v (%v)", err, strings.Join(sources, ", "))
+	}
+	return w, err
+}
+
+// drawWireframe draws the edges of the cells with the wireframe program,
+// built when first needed, as drawLattice would draw their faces. The
+// projection, camera and model are those set on the lattice program, which
+// the minimap and the comparisons change. It returns false if the program
+// fails to build, reporting the error once.
+func (r *Renderer) drawWireframe(settings RenderSettings) bool {
+	if r.wire == nil {
+		if r.wireErr != nil {
+			return false
+		}
+		w, err := r.buildWireframe()
+		if err != nil {
+			r.wireErr = err
+			r.NotifyError(err)
+			return false
+		}
+		r.wire = w
+	}
+	w := r.wire
+
+	var projection, camera, model mgl32.Mat4
+	gl.GetUniformfv(r.program, r.projectionUniform, &projection[0])
+	gl.GetUniformfv(r.program, r.cameraUniform, &camera[0])
+	gl.GetUniformfv(r.program, gl.GetUniformLocation(r.program, gl.Str("model\x00")), &model[0])
+
+	gl.UseProgram(w.program)
+	defer gl.UseProgram(r.program)
+	gl.BindVertexArray(w.vao)
+	defer gl.BindVertexArray(r.vao)
+
+	gl.UniformMatrix4fv(w.projectionUniform, 1, false, &projection[0])
+	gl.UniformMatrix4fv(w.cameraUniform, 1, false, &camera[0])
+	gl.UniformMatrix4fv(w.modelUniform, 1, false, &model[0])
+	style := r.styles[r.style]
+	gl.Uniform1f(w.saturationUniform, style.Saturation)
+	gl.Uniform3f(w.tintUniform, style.Tint[0], style.Tint[1], style.Tint[2])
+	gl.Uniform1f(w.brightnessUniform, style.Brightness)
+	gl.Uniform1f(w.colorPhaseUniform, r.colorPhase())
+	gl.Uniform1f(w.fadeUniform, r.fadeProgress())
+	gl.Uniform1f(w.timeUniform, float32(r.frameTimer.prevTime))
+	d := r.displacement
+	gl.Uniform1f(w.displaceUniforms.amplitude, d.Amplitude)
+	gl.Uniform1f(w.displaceUniforms.frequency, d.Frequency)
+	gl.Uniform1f(w.displaceUniforms.speed, d.Speed)
+	clip := r.clip.equation()
+	gl.Uniform4f(w.clipUniform, clip[0], clip[1], clip[2], clip[3])
+	defer enableClipping()()
+
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.LINE)
+	defer gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	if r.gpuSim != nil {
+		gl.Uniform1f(w.shiftUniform, 0)
+		r.mesh.DrawFrom(w.attribs, r.gpuSim.instances, 0, r.gpuSim.cells())
+		return true
+	}
+	for _, d := range r.draws {
+		shift := d.shift
+		if !settings.Animate {
+			shift = restShift
+		}
+		gl.Uniform1f(w.shiftUniform, shift)
+		r.mesh.Draw(w.attribs, d.first, d.count)
+	}
+	if r.trails != nil && r.trails.instances > 0 {
+		gl.Uniform1f(w.shiftUniform, restShift)
+		r.mesh.DrawFrom(w.attribs, r.trails.buffer, 0, r.trails.instances)
+	}
+	return true
+}
+
+var wireframeFragmentShader = `
+#version 330
+
+// brightness scales the colors of the cells, as it does their faces.
+uniform float brightness;
+
+in vec3 cellColor;
+out vec4 outputColor;
+
+void main() {
+    outputColor = vec4(cellColor * brightness, 0);
+}
+` + "\x00"
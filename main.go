@@ -0,0 +1,275 @@
+// Copyright 2022 Alan Eneev. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gogllattice shows a color cube lattice, or the cells of a lattice, scene,
+// crystal or molecule file, in a window using GLFW 3 and OpenGL 4.1 core
+// forward-compatible profile. The flags set up the viewer; run with -h to
+// list them.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/outblasted/gogllattice/lattice"
+)
+
+func init() {
+	// GLFW event handling must run on the main OS thread
+	runtime.LockOSThread()
+}
+
+func main() {
+	opts := lattice.DefaultOptions()
+	flag.IntVar(&opts.Lattice.HalfSize, "size", opts.Lattice.HalfSize, "number of cells on each side of the origin")
+	flag.Var(&opts.Lattice.Type, "lattice", "lattice type: "+strings.Join(lattice.LatticeTypes(), ", "))
+	flag.StringVar(&opts.CIF, "cif", "", "show the crystal structure of this CIF file instead of the lattice")
+	flag.StringVar(&opts.Scene, "load", "", "restore the cells, camera and render settings of this scene file, as the save console command writes it")
+	flag.StringVar(&opts.Demo, "demo", "", "replay this demo file, as demo record writes it, frame for frame")
+	flag.StringVar(&opts.CameraPath, "camera-path", "", "fly the camera along the keyframes of this file, as path save writes it, such as for -headless fly-throughs")
+	flag.StringVar(&opts.Script, "exec", "", "run the console commands of this file at startup")
+	flag.StringVar(&opts.Molecule, "molecule", "", "show the atoms of this XYZ or PDB file instead of the lattice")
+	flag.StringVar(&opts.Watch, "watch", "", "show this lattice file instead of the lattice and reload it when it changes")
+	supercell := flag.String("supercell", "2", "unit cells of -cif along each axis, such as 3 or 2x2x4")
+	var cells [3]int
+	flag.IntVar(&cells[0], "nx", 0, "unit cells of -cif along a, overriding -supercell")
+	flag.IntVar(&cells[1], "ny", 0, "unit cells of -cif along b, overriding -supercell")
+	flag.IntVar(&cells[2], "nz", 0, "unit cells of -cif along c, overriding -supercell")
+	flag.IntVar(&opts.Width, "width", 0, "window width, 0 for the screen or default size")
+	flag.IntVar(&opts.Height, "height", 0, "window height, 0 for the screen or default size")
+	flag.BoolVar(&opts.Fullscreen, "fullscreen", opts.Fullscreen, "cover the primary monitor")
+	flag.Var(&opts.Layers, "layers", "render layers shown in the window, of background, lattice, overlays and debug, separated by commas")
+	flag.Var(&opts.CaptureLayers, "capture", "render layers captured by screenshots, recordings and headless frames")
+	flag.IntVar(&opts.Samples, "msaa", opts.Samples, "multisampling samples, 0 to disable")
+	flag.BoolVar(&opts.DeepColor, "deep-color", false, "ask for 10 bits a color channel, for deep color displays")
+	textures := flag.String("textures", "", "PNG or JPEG images to texture the cell faces with, separated by commas, taken in turn by the lattice sites")
+	displace := flag.Float64("displace", 0, "move the cells by drifting 3D noise up to this far, such as 0.3, 0 for none")
+	flag.StringVar(&opts.Displacement.Image, "displace-image", "", "PNG or JPEG image -displace samples instead of noise")
+	flag.Var(&opts.Shape, "shape", "cell shape: cube or sphere")
+	styles := flag.String("styles", "", "add the styles of this JSON file to the ones the number keys select")
+	flag.StringVar(&opts.Style, "style", "", "style to start with: default, technical, neon, clay, x-ray or one of -styles")
+	flag.Var(&opts.Colormap, "colormap", "color the cells by -field through this colormap: viridis, plasma or grayscale")
+	flag.StringVar(&opts.Field, "field", "distance", "scalar field -colormap colors by: distance, index, value or a CSV or .npy file of values")
+	flag.Var(&opts.ColorRange, "color-range", "how -colormap spreads the scalars: linear, or equalize to use the colors evenly")
+	flag.Var(&opts.DataKind, "data-kind", "whether the scalars of -field are continuous or categorical, or auto to tell by their values")
+	colorClip := flag.Float64("color-clip", 0, "percentage of the cells at either end of the scalars -colormap clips to its ends")
+	flag.StringVar(&opts.SizeField, "size-field", "", "scale the cells by this scalar field besides -colormap: distance, index, value or a CSV or .npy file of values")
+	minSize := flag.Float64("min-size", float64(opts.MinSize), "size of the cells with the smallest scalar of -size-field, from 0 to 1")
+	flag.IntVar(&opts.Fade.Radius, "fade", 0, "shrink and dim cells by the number of cells within this many grid steps, 0 to disable")
+	fadeStrength := flag.Float64("fade-strength", float64(opts.Fade.Strength), "how much -fade shrinks and dims the densest cells, from 0 to 1")
+	flag.BoolVar(&opts.Wireframe, "wireframe", false, "draw the edges of the triangles of the cells in flat colors instead of their faces")
+	alpha := flag.Float64("alpha", float64(opts.Alpha), "opacity of the cubes from 0 to 1; below 1 they are sorted back to front and blended")
+	fog := flag.Float64("fog", 0, "density of exponential fog fading the cubes into the background with depth, such as 0.02, 0 for none")
+	flag.BoolVar(&opts.Compass, "compass", opts.Compass, "show the axes of the data at the top of the screen as the view turns them")
+	gamma := flag.Float64("gamma", 0, "gamma correct the frame by this gamma, 0 for none")
+	vignette := flag.Float64("vignette", 0, "darken the corners of the frame by up to this fraction, 0 for none")
+	fxaa := flag.Bool("fxaa", false, "smooth the edges of the frame with FXAA, cheaper than -msaa")
+	ssao := flag.Float64("ssao", 0, "darken occluded cells, such as those deep inside the lattice, by this strength from 0 to 1, 0 for none")
+	bloom := flag.Float64("bloom", 0, "make colors above 1, of emissive cells and the picked cell, glow by this strength, 0 for none")
+	flag.BoolVar(&opts.Minimap, "minimap", false, "show the lattice from above in an inset, with the frustum of the camera")
+	breadcrumbs := flag.Float64("breadcrumbs", 0, "drop a breadcrumb every this many world units along the path of the camera, 0 for none")
+	near := flag.Float64("near", float64(opts.Near), "distance of the near plane of the projection")
+	far := flag.Float64("far", float64(opts.Far), "distance of the far plane of the projection")
+	flag.BoolVar(&opts.Fit, "fit", false, "frame the lattice at startup, fitting the camera and the near and far planes to its bounding sphere")
+	flag.IntVar(&opts.Trails, "trails", 0, "draw fading trails of where the cells were over this many changes, such as the steps of a -watch simulation")
+	flag.Var(&opts.Up, "up", "axis of the data pointing up on the screen: y or z")
+	flag.Var(&opts.Handedness, "handedness", "handedness of the axes of the data: right or left")
+	flag.Var(&opts.Units, "units", "physical length of a grid step the HUD and measurements report lengths in, such as 0.5nm")
+	flag.Var(&opts.GPUSim, "gpu-sim", "simulation run on the GPU in place of the lattice: off, life or grayscott")
+	flag.IntVar(&opts.GPUSimSide, "gpu-sim-side", opts.GPUSimSide, "cells along a side of the -gpu-sim grid, a power of two from 8 to 256")
+	flag.IntVar(&opts.GPUSimSteps, "gpu-sim-steps", 0, "steps of -gpu-sim per frame, 0 for the default of the simulation")
+	flag.Float64Var(&opts.Defects.Vacancies, "vacancies", 0, "fraction of the cells to remove at random as vacancies")
+	flag.Float64Var(&opts.Defects.Interstitials, "interstitials", 0, "number of interstitials to add at random between the cells, as a fraction of the cells")
+	flag.Int64Var(&opts.Defects.Seed, "defect-seed", 0, "seed of the random placement of -vacancies and -interstitials, 0 for the clock")
+	flag.Var(&opts.CellOverlay, "cell", "unit cell edges drawn over the lattice: off, unit or super")
+	plane := flag.String("plane", "", "draw the crystallographic plane with these Miller indices, such as 111 or \"1 -1 0\"")
+	flag.Var(&opts.Swap, "vsync", "swap mode: default, off, on or adaptive")
+	flag.StringVar(&opts.ShaderDir, "shaders", "", "load the lattice shaders from this directory and reload them on change")
+	turn := flag.Float64("turn", float64(opts.TurnRate), "arrow key turn rate in degrees per second")
+	gamepad := flag.String("gamepad", "", "read the gamepad mapping from this JSON file")
+	flag.StringVar(&opts.ScreenshotDir, "screenshot-dir", opts.ScreenshotDir, "directory F12 saves screenshots and R saves recordings to")
+	flag.IntVar(&opts.RecordFrameRate, "record-fps", opts.RecordFrameRate, "frame rate of recordings")
+	flag.BoolVar(&opts.RecordMotion, "record-motion", false, "write the motion of every recorded frame as .flo files next to the recording")
+	aspect := flag.String("cinema-aspect", "2.39:1", "aspect of the picture in the cinema mode K toggles, such as 2.39:1 or 16:9")
+	flag.StringVar(&opts.RecoveryDir, "recovery-dir", opts.RecoveryDir, "directory the session is autosaved to")
+	flag.DurationVar(&opts.AutosavePeriod, "autosave", opts.AutosavePeriod, "autosave period, 0 to disable autosaving and recovery")
+	flag.DurationVar(&opts.Watchdog, "watchdog", 0, "restart the program when no frame is drawn for this long, 0 to disable")
+	flag.StringVar(&opts.Host, "host", "", "host a collaborative session on this address, such as :7000")
+	flag.StringVar(&opts.Join, "join", "", "join the collaborative session hosted at this address")
+	flag.StringVar(&opts.CollabName, "name", "", "name of this client in a collaborative session")
+	flag.StringVar(&opts.CollabAuth.Token, "token", os.Getenv("LATTICE_TOKEN"), "token granting write access to a hosted session, and presented when joining one")
+	flag.StringVar(&opts.CollabAuth.ReadToken, "read-token", os.Getenv("LATTICE_READ_TOKEN"), "token granting read-only access to a hosted session")
+	flag.StringVar(&opts.CollabAuth.CertFile, "tls-cert", "", "PEM certificate to host a session over TLS with")
+	flag.StringVar(&opts.CollabAuth.KeyFile, "tls-key", "", "PEM key of -tls-cert")
+	flag.BoolVar(&opts.CollabAuth.TLS, "tls", false, "join a session over TLS")
+	flag.StringVar(&opts.CollabAuth.CAFile, "tls-ca", "", "PEM certificates to trust when joining over TLS, besides the system ones")
+	software := flag.String("software", "", "render a PNG to this file without OpenGL and exit")
+	headless := flag.String("headless", "", "render frames in a hidden window to PNGs named by this pattern, such as frame%04d.png, and exit")
+	frames := flag.Int("frames", 1, "number of frames -headless renders")
+	var timeLapse lattice.TimeLapse
+	flag.StringVar(&timeLapse.Frames, "timelapse", "", "run -sim headless and render every -every-th of its -steps steps to PNGs named by this pattern, such as frame%05d.png, and exit")
+	sim := flag.String("sim", "", "command of -timelapse run once per step, which writes the new state to -sim-state; arguments are split at spaces")
+	flag.StringVar(&timeLapse.State, "sim-state", "", "lattice file -sim writes the state of the simulation to")
+	flag.IntVar(&timeLapse.Steps, "steps", 100, "number of steps -timelapse runs")
+	flag.IntVar(&timeLapse.Every, "every", 1, "capture every this many steps of -timelapse")
+	flag.StringVar(&timeLapse.States, "keep-states", "", "also save the states -timelapse captures as lattice files named by this pattern, such as state%05d.csv")
+	flag.StringVar(&timeLapse.Checkpoints, "checkpoint-dir", "checkpoints", "directory -timelapse saves checkpoints of the simulation to")
+	flag.IntVar(&timeLapse.CheckpointEvery, "checkpoint-every", 0, "save a checkpoint of -timelapse every this many steps, 0 for none")
+	flag.StringVar(&timeLapse.Resume, "resume", "", "resume -timelapse from this checkpoint file, such as checkpoints/step00001000.json")
+	bench := flag.Int("bench", 0, "render this many frames along a fixed camera path with vsync off, print the frame times as JSON and exit")
+	var benchGuard lattice.BenchGuard
+	flag.StringVar(&benchGuard.Baseline, "bench-baseline", "", "compare the -bench frame times with this result of an earlier run and exit with an error if they regressed")
+	flag.Float64Var(&benchGuard.Threshold, "bench-threshold", lattice.DefaultBenchThreshold, "fraction by which a -bench frame time may exceed -bench-baseline, such as 0.1 for 10%, 0 for any slowdown")
+	flag.IntVar(&opts.Panorama, "vr", 0, "make -headless render top-bottom stereo 360° frames of this width for VR video")
+	ipd := flag.Float64("vr-ipd", float64(opts.EyeSeparation), "distance between the eyes of -vr frames")
+	flag.Parse()
+	opts.TurnRate = float32(*turn)
+	opts.Displacement.Amplitude = float32(*displace)
+	if *textures != "" {
+		opts.Textures = strings.Split(*textures, ",")
+	}
+	opts.Fade.Strength = float32(*fadeStrength)
+	opts.ColorClip = float32(*colorClip)
+	opts.MinSize = float32(*minSize)
+	opts.Alpha = float32(*alpha)
+	opts.Fog.Density = float32(*fog)
+	opts.Breadcrumbs = float32(*breadcrumbs)
+	opts.Near, opts.Far = float32(*near), float32(*far)
+	if *gamma < 0 {
+		log.Fatalln("-gamma must not be negative")
+	}
+	if *ssao < 0 || *ssao > 1 {
+		log.Fatalln("-ssao must be from 0 to 1")
+	}
+	// Ambient occlusion darkens the lit colors, which bloom then reads
+	// above 1 before gamma correction.
+	if *ssao > 0 {
+		opts.PostEffects = append(opts.PostEffects, lattice.SSAO{Strength: float32(*ssao)})
+	}
+	if *bloom > 0 {
+		opts.PostEffects = append(opts.PostEffects, lattice.Bloom{Strength: float32(*bloom), Threshold: 1})
+	}
+	if *gamma > 0 {
+		opts.PostEffects = append(opts.PostEffects, lattice.Gamma{Gamma: float32(*gamma)})
+	}
+	if *vignette > 0 {
+		opts.PostEffects = append(opts.PostEffects, lattice.Vignette{Strength: float32(*vignette)})
+	}
+	if *fxaa {
+		opts.PostEffects = append(opts.PostEffects, lattice.FXAA{})
+	}
+	opts.EyeSeparation = float32(*ipd)
+	var err error
+	if opts.Supercell, err = lattice.ParseSupercell(*supercell); err != nil {
+		log.Fatalln(err)
+	}
+	for i, n := range cells {
+		if n > 0 {
+			opts.Supercell[i] = n
+		}
+	}
+	if opts.CinemaAspect, err = lattice.ParseAspect(*aspect); err != nil {
+		log.Fatalln(err)
+	}
+	if *plane != "" {
+		if opts.Plane, err = lattice.ParseMiller(*plane); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if flag.NArg() > 1 {
+		log.Fatalln("expected at most one view link argument")
+	}
+	if flag.NArg() == 1 {
+		l, err := lattice.ParseViewLink(flag.Arg(0))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts.View = &l
+	}
+	if opts.Near <= 0 || opts.Far <= opts.Near {
+		log.Fatalln("-near must be above 0 and below -far")
+	}
+	if opts.Watchdog < 0 || (opts.Watchdog > 0 && opts.Watchdog.Seconds() < 1) {
+		log.Fatalln("-watchdog must be 0 or at least a second")
+	}
+	opts.OnHang = restart
+	if !(benchGuard.Threshold >= 0) {
+		log.Fatalln("-bench-threshold must not be negative")
+	}
+	if opts.Alpha < 0 || opts.Alpha > 1 {
+		log.Fatalln("-alpha must be from 0 to 1")
+	}
+	if opts.Panorama < 0 || opts.Panorama%2 != 0 {
+		log.Fatalln("-vr must be an even width")
+	}
+	if opts.Lattice.HalfSize < 0 {
+		log.Fatalln("-size must not be negative")
+	}
+	if opts.Lattice.HalfSize > lattice.MaxHalfSize {
+		log.Fatalf("-size must be at most %v, as the cells are numbered in 32 bits", lattice.MaxHalfSize)
+	}
+	if *styles != "" {
+		if opts.Styles, err = lattice.LoadStyles(*styles); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *gamepad != "" {
+		mapping, err := lattice.LoadGamepadMapping(*gamepad)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts.Gamepad = mapping
+	}
+
+	if *software != "" {
+		if err := lattice.RenderFile(opts, *software); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if timeLapse.Frames != "" {
+		timeLapse.Command = strings.Fields(*sim)
+		if err := lattice.RunTimeLapse(opts, timeLapse); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if *bench > 0 {
+		if err := lattice.RunBenchmark(opts, *bench, benchGuard, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if *headless != "" {
+		if err := lattice.RunHeadless(opts, *frames, *headless); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if err := lattice.Run(opts); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// restart starts the program again with the same arguments and exits with
+// status 3, leaving the recovery files for the new process to restore. The
+// watchdog calls it when the frame loop hangs.
+func restart() {
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Printf("watchdog: failed to restart: %v", err)
+	} else {
+		log.Printf("watchdog: restarted as process %v", cmd.Process.Pid)
+	}
+	os.Exit(3)
+}